@@ -0,0 +1,444 @@
+// Package config defines the service configuration and loads it from a
+// file, the environment and command-line flags.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Server   Server   `yaml:"server"`
+	Database Database `yaml:"database"`
+	Auth     Auth     `yaml:"auth"`
+}
+
+type Server struct {
+	Address         string        `yaml:"address"`
+	LogLevel        string        `yaml:"log_level"`
+	CORSOrigins     []string      `yaml:"cors_origins"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type Database struct {
+	// Driver is one of mongo, sqlite, postgres or memory.
+	Driver         string        `yaml:"driver"`
+	MongoURI       string        `yaml:"mongo_uri"`
+	DSN            string        `yaml:"dsn"`
+	Name           string        `yaml:"name"`
+	ReadTimeout    time.Duration `yaml:"read_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	MigrateOnStart bool          `yaml:"migrate_on_start"`
+}
+
+type Auth struct {
+	JWTSecret string `yaml:"jwt_secret"`
+	// DevSecret lets the service run without JWTSecret or Keys by signing
+	// with DefaultJWTSecret, which anyone can read in the source. It is
+	// meant for local development only.
+	DevSecret     bool          `yaml:"dev_secret"`
+	TokenLifetime time.Duration `yaml:"token_lifetime"`
+	// RefreshTokenLifetime bounds how long a login can be renewed
+	// without entering credentials again.
+	RefreshTokenLifetime time.Duration `yaml:"refresh_token_lifetime"`
+	// Issuer and Audience are put into the iss and aud claims of access
+	// tokens and required on every token presented.
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat.
+	Leeway time.Duration `yaml:"leeway"`
+	// Keys, when set, replace JWTSecret and allow rotating signing keys.
+	Keys []SigningKey `yaml:"keys,omitempty"`
+	// Providers are the upstream OpenID Connect identity providers users
+	// may sign in with besides their password.
+	Providers []Provider `yaml:"providers,omitempty"`
+	// LDAP, when set, lets users log in with their directory password.
+	LDAP *LDAP `yaml:"ldap,omitempty"`
+	// WebAuthn, when set, lets users register passkeys and log in with
+	// them.
+	WebAuthn *WebAuthn `yaml:"webauthn,omitempty"`
+	// OIDC makes the service an OpenID Connect provider: it publishes a
+	// discovery document and issues ID tokens for the openid scope. The
+	// issuer must then be the https URL clients reach the service at, and
+	// one of the keys must sign with RS256, ES256 or EdDSA.
+	OIDC bool `yaml:"oidc"`
+}
+
+// SigningKey is one entry of auth.keys. HS256 keys take a secret inline
+// or from a file, which keeps it out of the config file itself. RS256,
+// ES256 and EdDSA keys take a PEM private key, or only the public key to
+// keep verifying tokens of a key that no longer signs.
+type SigningKey struct {
+	ID             string    `yaml:"id"`
+	Algorithm      string    `yaml:"algorithm,omitempty"`
+	Secret         string    `yaml:"secret,omitempty"`
+	SecretFile     string    `yaml:"secret_file,omitempty"`
+	PrivateKeyFile string    `yaml:"private_key_file,omitempty"`
+	PublicKeyFile  string    `yaml:"public_key_file,omitempty"`
+	NotBefore      time.Time `yaml:"not_before,omitempty"`
+	RetireAt       time.Time `yaml:"retire_at,omitempty"`
+}
+
+// Provider is one entry of auth.providers. The client secret is given
+// inline or from a file. RedirectURL defaults to the callback route on
+// the host the login started from, and Scopes to openid, email and
+// profile.
+//
+// GroupRoles maps the group names found in GroupsClaim (default
+// "groups") of the ID token to role IDs; those roles are kept in line
+// with the groups at every sign-in. CreateUsers lets a first sign-in
+// create a user, and TrustEmail links a first sign-in to the user with
+// the same email even if the provider does not mark it verified.
+type Provider struct {
+	ID               string          `yaml:"id"`
+	Name             string          `yaml:"name"`
+	Issuer           string          `yaml:"issuer"`
+	ClientID         string          `yaml:"client_id"`
+	ClientSecret     string          `yaml:"client_secret,omitempty"`
+	ClientSecretFile string          `yaml:"client_secret_file,omitempty"`
+	RedirectURL      string          `yaml:"redirect_url,omitempty"`
+	Scopes           []string        `yaml:"scopes,omitempty"`
+	GroupsClaim      string          `yaml:"groups_claim,omitempty"`
+	GroupRoles       map[string]uint `yaml:"group_roles,omitempty"`
+	CreateUsers      bool            `yaml:"create_users"`
+	TrustEmail       bool            `yaml:"trust_email"`
+}
+
+// LDAP is auth.ldap. A login looks the user up below BaseDN with
+// UserFilter, in which every %s stands for the email entered, binding as
+// BindDN to search (anonymously if it is empty); the password is then
+// checked by binding as the user found. The bind password is given inline
+// or from a file.
+//
+// The user's groups are the values of GroupsAttribute (default
+// "memberOf") or, if GroupFilter is set, the entries below GroupBaseDN
+// that it finds with every %s standing for the user's DN. GroupRoles maps
+// the groups, by DN or by their first value such as the cn, to role IDs;
+// those roles are kept in line with the groups at every login. The local
+// user is the one with the entry's email; its username and, if
+// BioAttribute is set, its bio are copied from the directory at every
+// login, and CreateUsers lets a first login create it.
+type LDAP struct {
+	URL               string          `yaml:"url"`
+	StartTLS          bool            `yaml:"start_tls"`
+	CAFile            string          `yaml:"ca_file,omitempty"`
+	BindDN            string          `yaml:"bind_dn,omitempty"`
+	BindPassword      string          `yaml:"bind_password,omitempty"`
+	BindPasswordFile  string          `yaml:"bind_password_file,omitempty"`
+	BaseDN            string          `yaml:"base_dn"`
+	UserFilter        string          `yaml:"user_filter,omitempty"`
+	UsernameAttribute string          `yaml:"username_attribute,omitempty"`
+	EmailAttribute    string          `yaml:"email_attribute,omitempty"`
+	BioAttribute      string          `yaml:"bio_attribute,omitempty"`
+	GroupsAttribute   string          `yaml:"groups_attribute,omitempty"`
+	GroupBaseDN       string          `yaml:"group_base_dn,omitempty"`
+	GroupFilter       string          `yaml:"group_filter,omitempty"`
+	GroupRoles        map[string]uint `yaml:"group_roles,omitempty"`
+	CreateUsers       bool            `yaml:"create_users"`
+	Timeout           time.Duration   `yaml:"timeout,omitempty"`
+}
+
+// WebAuthn is auth.webauthn. RPID is the domain passkeys are bound to; it
+// must be the host of every origin or a parent domain of it. Origins are
+// the exact origins, such as https://login.example.com, pages calling
+// the WebAuthn API are served from. RPName is shown by authenticators
+// and defaults to RPID. With SecondFactor set, users who registered a
+// passkey confirm password logins with it, or with TOTP if they have
+// that too.
+type WebAuthn struct {
+	RPID         string   `yaml:"rp_id"`
+	RPName       string   `yaml:"rp_name,omitempty"`
+	Origins      []string `yaml:"origins"`
+	SecondFactor bool     `yaml:"second_factor,omitempty"`
+}
+
+// DefaultJWTSecret is only good for local development. Validate refuses
+// it, set or not, unless auth.dev_secret is given, and the server warns
+// about it even then.
+const DefaultJWTSecret = "!-!SECRET!-!"
+
+// Default returns the configuration used when nothing overrides it.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Address:         "127.0.0.1:8585",
+			LogLevel:        "DEBUG",
+			CORSOrigins:     []string{"*"},
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Database: Database{
+			Driver:         "mongo",
+			Name:           "user_management_db",
+			ReadTimeout:    5 * time.Second,
+			WriteTimeout:   10 * time.Second,
+			MigrateOnStart: true,
+		},
+		Auth: Auth{
+			TokenLifetime:        15 * time.Minute,
+			RefreshTokenLifetime: 30 * 24 * time.Hour,
+			Issuer:               "user-manage",
+			Audience:             "user-manage",
+			Leeway:               30 * time.Second,
+		},
+	}
+}
+
+var logLevels = map[string]bool{"DEBUG": true, "INFO": true, "WARN": true, "ERROR": true, "OFF": true}
+
+// Validate reports every problem at once so a bad deployment can be
+// fixed in one pass.
+func (c *Config) Validate() error {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Address == "" {
+		fail("server.address must not be empty")
+	}
+	if !logLevels[strings.ToUpper(c.Server.LogLevel)] {
+		fail("server.log_level %q is not one of DEBUG, INFO, WARN, ERROR, OFF", c.Server.LogLevel)
+	}
+	if len(c.Server.CORSOrigins) == 0 {
+		fail("server.cors_origins must list at least one origin")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		fail("server.shutdown_timeout must be positive")
+	}
+
+	switch c.Database.Driver {
+	case "mongo":
+		if c.Database.MongoURI == "" {
+			fail("database.mongo_uri is required for the mongo driver")
+		}
+		if c.Database.Name == "" {
+			fail("database.name is required for the mongo driver")
+		}
+	case "sqlite", "postgres":
+		if c.Database.DSN == "" {
+			fail("database.dsn is required for the %s driver", c.Database.Driver)
+		}
+	case "memory":
+	default:
+		fail("database.driver %q is not one of mongo, sqlite, postgres, memory", c.Database.Driver)
+	}
+	if c.Database.ReadTimeout < 0 || c.Database.WriteTimeout < 0 {
+		fail("database timeouts must not be negative")
+	}
+
+	if len(c.Auth.Keys) == 0 && !c.Auth.DevSecret {
+		switch c.Auth.JWTSecret {
+		case "":
+			fail("auth.jwt_secret or auth.keys is required; set auth.dev_secret to use the built-in development secret")
+		case DefaultJWTSecret:
+			fail("auth.jwt_secret is the built-in development secret; set auth.dev_secret to allow it")
+		}
+	}
+	ids := make(map[string]bool, len(c.Auth.Keys))
+	for i, k := range c.Auth.Keys {
+		switch {
+		case k.ID == "":
+			fail("auth.keys[%d].id must not be empty", i)
+		case ids[k.ID]:
+			fail("auth.keys[%d].id %q is used twice", i, k.ID)
+		}
+		ids[k.ID] = true
+		hmac := k.Secret != "" || k.SecretFile != ""
+		pair := k.PrivateKeyFile != "" || k.PublicKeyFile != ""
+		switch k.Algorithm {
+		case "", "HS256":
+			if (k.Secret == "") == (k.SecretFile == "") || pair {
+				fail("auth.keys[%d] (HS256) needs exactly one of secret and secret_file", i)
+			}
+		case "RS256", "ES256", "EdDSA":
+			if (k.PrivateKeyFile == "") == (k.PublicKeyFile == "") || hmac {
+				fail("auth.keys[%d] (%s) needs exactly one of private_key_file and public_key_file", i, k.Algorithm)
+			}
+		default:
+			fail("auth.keys[%d].algorithm %q is not one of HS256, RS256, ES256, EdDSA", i, k.Algorithm)
+		}
+		if !k.RetireAt.IsZero() && !k.RetireAt.After(k.NotBefore) {
+			fail("auth.keys[%d].retire_at must be after not_before", i)
+		}
+	}
+	if c.Auth.TokenLifetime <= 0 {
+		fail("auth.token_lifetime must be positive")
+	}
+	if c.Auth.RefreshTokenLifetime < c.Auth.TokenLifetime {
+		fail("auth.refresh_token_lifetime must not be shorter than auth.token_lifetime")
+	}
+	if c.Auth.Issuer == "" {
+		fail("auth.issuer is required")
+	}
+	if c.Auth.Audience == "" {
+		fail("auth.audience is required")
+	}
+	if c.Auth.OIDC {
+		if !publicIssuer(c.Auth.Issuer) {
+			fail("auth.issuer must be an https URL, or http on localhost, when auth.oidc is set")
+		}
+		if !hasPublicSigningKey(c.Auth.Keys) {
+			fail("auth.keys must include an RS256, ES256 or EdDSA private key when auth.oidc is set")
+		}
+	}
+	if c.Auth.Leeway < 0 || c.Auth.Leeway >= c.Auth.TokenLifetime {
+		fail("auth.leeway must be at least 0 and shorter than auth.token_lifetime")
+	}
+	providers := make(map[string]bool, len(c.Auth.Providers))
+	for i, p := range c.Auth.Providers {
+		switch {
+		case p.ID == "":
+			fail("auth.providers[%d].id must not be empty", i)
+		case providers[p.ID]:
+			fail("auth.providers[%d].id %q is used twice", i, p.ID)
+		case url.PathEscape(p.ID) != p.ID:
+			fail("auth.providers[%d].id %q must be usable in a URL path", i, p.ID)
+		}
+		providers[p.ID] = true
+		if u, err := url.Parse(p.Issuer); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			fail("auth.providers[%d].issuer must be an http(s) URL", i)
+		}
+		if p.ClientID == "" {
+			fail("auth.providers[%d].client_id is required", i)
+		}
+		if p.ClientSecret != "" && p.ClientSecretFile != "" {
+			fail("auth.providers[%d] takes only one of client_secret and client_secret_file", i)
+		}
+		for group, role := range p.GroupRoles {
+			if role == 0 {
+				fail("auth.providers[%d].group_roles[%q] must be a role ID", i, group)
+			}
+		}
+	}
+	if l := c.Auth.LDAP; l != nil {
+		if u, err := url.Parse(l.URL); err != nil || u.Host == "" || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+			fail("auth.ldap.url must be an ldap:// or ldaps:// URL")
+		} else if l.StartTLS && u.Scheme == "ldaps" {
+			fail("auth.ldap.start_tls does not apply to ldaps:// URLs")
+		}
+		if l.BindPassword != "" && l.BindPasswordFile != "" {
+			fail("auth.ldap takes only one of bind_password and bind_password_file")
+		}
+		if l.BaseDN == "" {
+			fail("auth.ldap.base_dn is required")
+		}
+		if l.UserFilter != "" && !strings.Contains(l.UserFilter, "%s") {
+			fail("auth.ldap.user_filter must contain %%s")
+		}
+		if l.GroupFilter != "" && !strings.Contains(l.GroupFilter, "%s") {
+			fail("auth.ldap.group_filter must contain %%s")
+		}
+		for group, role := range l.GroupRoles {
+			if role == 0 {
+				fail("auth.ldap.group_roles[%q] must be a role ID", group)
+			}
+		}
+		if l.Timeout < 0 {
+			fail("auth.ldap.timeout must not be negative")
+		}
+	}
+	if w := c.Auth.WebAuthn; w != nil {
+		if w.RPID == "" || strings.ContainsAny(w.RPID, ":/") {
+			fail("auth.webauthn.rp_id must be a domain name")
+		}
+		if len(w.Origins) == 0 {
+			fail("auth.webauthn.origins must list at least one origin")
+		}
+		for i, o := range w.Origins {
+			u, err := url.Parse(o)
+			switch {
+			case err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") || u.Path != "":
+				fail("auth.webauthn.origins[%d] must be a scheme and host, such as https://example.com", i)
+			case u.Hostname() != w.RPID && !strings.HasSuffix(u.Hostname(), "."+w.RPID):
+				fail("auth.webauthn.origins[%d] is not on auth.webauthn.rp_id or below it", i)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// publicIssuer reports whether iss can be the issuer of an OpenID
+// provider: an absolute https URL without query or fragment, or http for
+// a provider on the local machine.
+func publicIssuer(iss string) bool {
+	u, err := url.Parse(iss)
+	if err != nil || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		switch u.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+			return true
+		}
+	}
+	return false
+}
+
+// hasPublicSigningKey reports whether keys include one that can sign ID
+// tokens, whose signatures anyone must be able to check.
+func hasPublicSigningKey(keys []SigningKey) bool {
+	for _, k := range keys {
+		switch k.Algorithm {
+		case "RS256", "ES256", "EdDSA":
+			if k.PrivateKeyFile != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy that is safe to print: secrets are masked and
+// passwords are stripped from connection strings.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.Server.CORSOrigins = append([]string(nil), c.Server.CORSOrigins...)
+	if r.Auth.JWTSecret != "" {
+		r.Auth.JWTSecret = redacted
+	}
+	r.Auth.Keys = append([]SigningKey(nil), c.Auth.Keys...)
+	for i := range r.Auth.Keys {
+		if r.Auth.Keys[i].Secret != "" {
+			r.Auth.Keys[i].Secret = redacted
+		}
+	}
+	r.Auth.Providers = append([]Provider(nil), c.Auth.Providers...)
+	for i := range r.Auth.Providers {
+		if r.Auth.Providers[i].ClientSecret != "" {
+			r.Auth.Providers[i].ClientSecret = redacted
+		}
+	}
+	if c.Auth.LDAP != nil {
+		l := *c.Auth.LDAP
+		if l.BindPassword != "" {
+			l.BindPassword = redacted
+		}
+		r.Auth.LDAP = &l
+	}
+	r.Database.MongoURI = redactURL(r.Database.MongoURI)
+	r.Database.DSN = redactURL(r.Database.DSN)
+	return &r
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
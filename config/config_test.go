@@ -0,0 +1,63 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSigningSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(a *Auth)
+		want   string
+	}{
+		{"nothing set", func(a *Auth) {}, "auth.jwt_secret or auth.keys is required"},
+		{"development secret", func(a *Auth) { a.JWTSecret = DefaultJWTSecret }, "built-in development secret"},
+		{"secret", func(a *Auth) { a.JWTSecret = "s3cret" }, ""},
+		{"keys", func(a *Auth) { a.Keys = []SigningKey{{ID: "k1", Secret: "s3cret"}} }, ""},
+		{"dev flag", func(a *Auth) { a.DevSecret = true }, ""},
+		{"dev flag and development secret", func(a *Auth) {
+			a.DevSecret = true
+			a.JWTSecret = DefaultJWTSecret
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.Database.Driver = "memory"
+			tt.modify(&c.Auth)
+			err := c.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("got %v, want no error", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("got %v, want an error about %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateLDAPFilters(t *testing.T) {
+	tests := []struct {
+		user, group string
+		want        string
+	}{
+		{"(mail=%s)", "(member=%s)", ""},
+		{"(|(mail=%s)(userPrincipalName=%s))", "(|(member=%s)(uniqueMember=%s))", ""},
+		{"(mail=bob)", "", "auth.ldap.user_filter"},
+		{"", "(member=cn=bob)", "auth.ldap.group_filter"},
+	}
+	for _, tt := range tests {
+		c := Default()
+		c.Database.Driver = "memory"
+		c.Auth.JWTSecret = "s3cret"
+		c.Auth.LDAP = &LDAP{URL: "ldap://ldap.example.com", BaseDN: "dc=example,dc=com", UserFilter: tt.user, GroupFilter: tt.group}
+		err := c.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%q, %q: %v", tt.user, tt.group, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%q, %q: got %v, want an error about %s", tt.user, tt.group, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
+)
+
+// setting binds one field to its flag and environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"addr", "SERVER_ADDRESS", "listen address", str(func(c *Config) *string { return &c.Server.Address })},
+	{"log-level", "LOG_LEVEL", "DEBUG, INFO, WARN, ERROR or OFF", str(func(c *Config) *string { return &c.Server.LogLevel })},
+	{"cors-origins", "CORS_ORIGINS", "comma-separated allowed CORS origins", list(func(c *Config) *[]string { return &c.Server.CORSOrigins })},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long to drain requests on shutdown", dur(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+	{"db-driver", "DB_DRIVER", "mongo, sqlite, postgres or memory", str(func(c *Config) *string { return &c.Database.Driver })},
+	{"mongo-uri", "MONGODB_URI", "MongoDB connection URI", str(func(c *Config) *string { return &c.Database.MongoURI })},
+	{"database-url", "DATABASE_URL", "DSN for the sqlite and postgres drivers", str(func(c *Config) *string { return &c.Database.DSN })},
+	{"db-name", "DB_NAME", "MongoDB database name", str(func(c *Config) *string { return &c.Database.Name })},
+	{"db-read-timeout", "DB_READ_TIMEOUT", "deadline for one read operation", dur(func(c *Config) *time.Duration { return &c.Database.ReadTimeout })},
+	{"db-write-timeout", "DB_WRITE_TIMEOUT", "deadline for one write operation", dur(func(c *Config) *time.Duration { return &c.Database.WriteTimeout })},
+	{"db-migrate-on-start", "DB_MIGRATE_ON_START", "apply pending migrations at startup", boolean(func(c *Config) *bool { return &c.Database.MigrateOnStart })},
+	{"jwt-secret", "JWT_SECRET", "HMAC secret for access tokens", str(func(c *Config) *string { return &c.Auth.JWTSecret })},
+	{"dev-secret", "DEV_SECRET", "sign tokens with the built-in development secret if no other is set", boolean(func(c *Config) *bool { return &c.Auth.DevSecret })},
+	{"token-lifetime", "TOKEN_LIFETIME", "access token lifetime", dur(func(c *Config) *time.Duration { return &c.Auth.TokenLifetime })},
+	{"refresh-token-lifetime", "REFRESH_TOKEN_LIFETIME", "refresh token lifetime", dur(func(c *Config) *time.Duration { return &c.Auth.RefreshTokenLifetime })},
+	{"jwt-issuer", "JWT_ISSUER", "iss claim of issued and accepted tokens", str(func(c *Config) *string { return &c.Auth.Issuer })},
+	{"jwt-audience", "JWT_AUDIENCE", "aud claim of issued and accepted tokens", str(func(c *Config) *string { return &c.Auth.Audience })},
+	{"jwt-leeway", "JWT_LEEWAY", "clock skew tolerated on token times", dur(func(c *Config) *time.Duration { return &c.Auth.Leeway })},
+	{"oidc", "OIDC", "act as an OpenID Connect provider", boolean(func(c *Config) *bool { return &c.Auth.OIDC })},
+}
+
+// Load builds the configuration for the command line args. Later sources
+// win: defaults, then the file named by -config or CONFIG_FILE, then the
+// environment (including a .env file), then explicitly passed flags.
+// The remaining positional arguments are returned alongside.
+func Load(name string, args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", "", "path to a YAML or JSON config file (env CONFIG_FILE)")
+	for _, s := range settings {
+		fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	cfg := Default()
+	if *path == "" {
+		*path = os.Getenv("CONFIG_FILE")
+	}
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, nil, fmt.Errorf("env %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var ferr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && ferr == nil {
+				if err := s.set(cfg, f.Value.String()); err != nil {
+					ferr = fmt.Errorf("flag -%s: %w", s.flag, err)
+				}
+			}
+		}
+	})
+	if ferr != nil {
+		return nil, nil, ferr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile overlays the file on c. YAML is a superset of JSON, so one
+// decoder handles both formats; unknown keys are rejected to catch typos.
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// YAML renders c, e.g. for dumping the effective configuration.
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+func str(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func list(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}
+}
+
+func dur(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func boolean(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a config file that is valid by itself.
+func writeFile(t *testing.T, extra string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "database:\n  driver: memory\nauth:\n  jwt_secret: file secret\n" + extra
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "server:\n  address: file:1\n  shutdown_timeout: 1s\n")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		addr string
+		wait time.Duration
+	}{
+		{"file", nil, []string{"-config", file}, "file:1", time.Second},
+		{"file from env", map[string]string{"CONFIG_FILE": file}, nil, "file:1", time.Second},
+		{"env over file", map[string]string{"SERVER_ADDRESS": "env:2"}, []string{"-config", file}, "env:2", time.Second},
+		{"flag over env", map[string]string{"SERVER_ADDRESS": "env:2", "SHUTDOWN_TIMEOUT": "2s"}, []string{"-config", file, "-addr", "flag:3"}, "flag:3", 2 * time.Second},
+		{"flag over file", nil, []string{"-config", file, "-shutdown-timeout", "3s"}, "file:1", 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, _, err := Load("test", tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Address != tt.addr || cfg.Server.ShutdownTimeout != tt.wait {
+				t.Errorf("address %q, shutdown timeout %v; want %q, %v", cfg.Server.Address, cfg.Server.ShutdownTimeout, tt.addr, tt.wait)
+			}
+			// Untouched settings keep their defaults.
+			if cfg.Auth.TokenLifetime != Default().Auth.TokenLifetime {
+				t.Errorf("token lifetime %v, want the default", cfg.Auth.TokenLifetime)
+			}
+		})
+	}
+}
+
+func TestLoadFlagOverFileFromEnv(t *testing.T) {
+	envFile := writeFile(t, "server:\n  address: env-file:1\n")
+	flagFile := writeFile(t, "server:\n  address: flag-file:2\n")
+	t.Setenv("CONFIG_FILE", envFile)
+	cfg, rest, err := Load("test", []string{"-config", flagFile, "extra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Address != "flag-file:2" {
+		t.Errorf("address %q, want the one of the -config file", cfg.Server.Address)
+	}
+	if len(rest) != 1 || rest[0] != "extra" {
+		t.Errorf("remaining args %q", rest)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := map[string]struct {
+		env  map[string]string
+		args []string
+		want string
+	}{
+		"unknown file key": {nil, []string{"-config", writeFile(t, "colour: blue\n")}, "colour"},
+		"bad env value":    {map[string]string{"TOKEN_LIFETIME": "soon"}, []string{"-config", writeFile(t, "")}, "TOKEN_LIFETIME"},
+		"bad flag value":   {nil, []string{"-config", writeFile(t, ""), "-oidc", "maybe"}, "-oidc"},
+		"invalid result":   {nil, []string{"-config", writeFile(t, ""), "-log-level", "LOUD"}, "server.log_level"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, _, err := Load("test", tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,59 +2,125 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
-	"os"
-
-	"github.com/joho/godotenv"
+	"github.com/hamed-lohi/user-manage/config"
+	"github.com/lib/pq"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
-const _dbName = "user_management_db"
-
 type Table string
 
 const (
-	Users     Table = "users"
-	Customers Table = "customers"
+	Users         Table = "users"
+	Customers     Table = "customers"
+	RefreshTokens Table = "refresh_tokens"
+	Sessions      Table = "sessions"
+	Roles         Table = "roles"
+	// PersonalAccessTokens holds the hashed tokens users mint for scripts.
+	PersonalAccessTokens Table = "personal_access_tokens"
+	// ServiceAccounts are non-human principals; APIKeys authenticate them.
+	ServiceAccounts Table = "service_accounts"
+	APIKeys         Table = "api_keys"
+	// OAuthClients are registered OAuth clients; OAuthCodes holds their
+	// pending authorization codes.
+	OAuthClients Table = "oauth_clients"
+	OAuthCodes   Table = "oauth_codes"
+	// FederatedIdentities link users to their accounts at upstream
+	// identity providers; FederationStates are sign-ins in progress.
+	FederatedIdentities Table = "federated_identities"
+	FederationStates    Table = "federation_states"
+	// TOTPSecrets holds the second factor of users who enrolled one;
+	// MFAChallenges are logins waiting for it.
+	TOTPSecrets   Table = "totp_secrets"
+	MFAChallenges Table = "mfa_challenges"
+	// RecoveryCodes are the hashed one-time codes of users who lost their
+	// second factor.
+	RecoveryCodes Table = "recovery_codes"
+	// Passkeys are users' WebAuthn credentials; PasskeySessions are
+	// registrations and logins waiting for the authenticator.
+	Passkeys        Table = "passkeys"
+	PasskeySessions Table = "passkey_sessions"
+	// RevokedTokens and UserRevocations hold access token revocations.
+	RevokedTokens   Table = "revoked_tokens"
+	UserRevocations Table = "user_revocations"
 )
 
+// Driver names the storage backend a DBProvider talks to.
+type Driver string
+
+const (
+	Mongo    Driver = "mongo"
+	SQLite   Driver = "sqlite"
+	Postgres Driver = "postgres"
+	// Memory keeps everything in process memory and opens no connection.
+	Memory Driver = "memory"
+)
+
+// DBProvider holds the connection for the configured driver. Exactly one
+// of Client (mongo) and SQL (sqlite, postgres) is set; both are nil for
+// the memory driver.
+//
+// Context lives as long as the provider and is meant for startup work;
+// request-scoped operations go through ReadContext and WriteContext.
 type DBProvider struct {
+	Driver     Driver
+	Name       string
 	Client     *mongo.Client
+	SQL        *sql.DB
 	Context    context.Context
 	CancelFunc context.CancelFunc
+	Timeouts   Timeouts
 }
 
-//var _ mongo.Client = (*MongoClient)(nil)
+// Timeouts bounds a single store operation. A zero value disables the
+// deadline for that kind of operation.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
 
-func NewDBProvider() *DBProvider {
+//var _ mongo.Client = (*MongoClient)(nil)
 
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
-	}
-	uri := os.Getenv("MONGODB_URI")
-	if uri == "" {
-		log.Fatal("You must set your 'MONGODB_URI' environmental variable.")
-	}
+// NewDBProvider connects to the backend selected by cfg.Driver. The
+// configuration is expected to be validated already.
+func NewDBProvider(cfg config.Database) *DBProvider {
 
-	// client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
-	// if err != nil {
-	// 	panic(err)
-	// }
+	driver := Driver(cfg.Driver)
 
-	// Get Client, Context, CalcelFunc and
-	// err from connect method.
-	client, ctx, cancel, err := connect(uri) // "mongodb://localhost:27017"
+	var (
+		mdb *DBProvider
+		err error
+	)
+	switch driver {
+	case Mongo:
+		// Get Client, Context, CalcelFunc and
+		// err from connect method.
+		client, ctx, cancel, cerr := connect(cfg.MongoURI) // "mongodb://localhost:27017"
+		mdb, err = &DBProvider{Driver: driver, Client: client, Context: ctx, CancelFunc: cancel}, cerr
+	case SQLite, Postgres:
+		mdb, err = openSQL(driver, cfg.DSN)
+	case Memory:
+		ctx, cancel := context.WithCancel(context.Background())
+		mdb = &DBProvider{Driver: driver, Context: ctx, CancelFunc: cancel}
+	default:
+		log.Fatalf("unknown database driver %q", driver)
+	}
 	if err != nil {
 		panic(err)
 	}
+	mdb.Name = cfg.Name
+	mdb.Timeouts = Timeouts{Read: cfg.ReadTimeout, Write: cfg.WriteTimeout}
 
-	mdb := &DBProvider{client, ctx, cancel}
-
-	// Ping mongoDB with Ping method
+	// Ping the database with Ping method
 	mdb.ping()
 
 	//Seed(mdb)
@@ -62,8 +128,26 @@ func NewDBProvider() *DBProvider {
 	return mdb
 }
 
+// ReadContext derives the context for one read operation from ctx,
+// usually the request context.
+func (dp *DBProvider) ReadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, dp.Timeouts.Read)
+}
+
+// WriteContext derives the context for one write operation from ctx.
+func (dp *DBProvider) WriteContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, dp.Timeouts.Write)
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
 func (cl *DBProvider) GetCollection(collName Table) *mongo.Collection {
-	return cl.Client.Database(_dbName).Collection(string(collName))
+	return cl.Client.Database(cl.Name).Collection(string(collName))
 }
 
 // This is a user defined method that returns mongo.Client,
@@ -84,38 +168,106 @@ func connect(uri string) (*mongo.Client, context.Context,
 	return client, ctx, cancel, err
 }
 
-// This is a user defined method to close resources.
-// This method closes mongoDB connection and cancel context.
-func (dp *DBProvider) close() {
+// openSQL opens a database/sql pool for the sqlite or postgres driver.
+func openSQL(driver Driver, dsn string) (*DBProvider, error) {
+	name := "postgres"
+	if driver == SQLite {
+		name = "sqlite"
+	}
+	sqlDB, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == SQLite {
+		// SQLite serialises writers; a single connection avoids
+		// "database is locked" errors and keeps :memory: databases shared.
+		sqlDB.SetMaxOpenConns(1)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DBProvider{Driver: driver, SQL: sqlDB, Context: ctx, CancelFunc: cancel}, nil
+}
+
+// EnsureColumn adds a column to an existing SQL table unless it is there
+// already, for schemas that grow after their table was first created.
+// SQLite has no ADD COLUMN IF NOT EXISTS, so the columns are looked up.
+func (dp *DBProvider) EnsureColumn(ctx context.Context, table Table, column, definition string) error {
+	rows, err := dp.SQL.QueryContext(ctx, `SELECT * FROM `+string(table)+` WHERE 1 = 0`)
+	if err != nil {
+		return err
+	}
+	columns, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	for _, c := range columns {
+		if c == column {
+			return nil
+		}
+	}
+	_, err = dp.SQL.ExecContext(ctx, `ALTER TABLE `+string(table)+` ADD COLUMN `+column+` `+definition)
+	return err
+}
+
+// IsUniqueViolation reports whether err was caused by a unique constraint
+// in one of the SQL drivers.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+			liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return false
+}
+
+// Close disconnects from the database and cancels the provider context.
+// ctx bounds how long the disconnect may take.
+func (dp *DBProvider) Close(ctx context.Context) error {
 
 	// CancelFunc to cancel to context
 	defer dp.CancelFunc() //cancel()
 
-	// client provides a method to close
-	// a mongoDB connection.
-	defer func() {
-
-		// client.Disconnect method also has deadline.
-		// returns error if any,
-		if err := dp.Client.Disconnect(dp.Context); err != nil {
-			panic(err)
-		}
-		fmt.Println("****** Disposing")
-	}()
+	var err error
+	switch {
+	case dp.Client != nil:
+		// client.Disconnect closes idle connections and waits for
+		// in-use ones until ctx expires.
+		err = dp.Client.Disconnect(ctx)
+	case dp.SQL != nil:
+		err = dp.SQL.Close()
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("db: %s connection closed", dp.Driver)
+	return nil
 }
 
-// This is a user defined method that accepts
-// mongo.Client and context.Context
-// This method used to ping the mongoDB, return error if any.
+// This is a user defined method that pings the configured
+// database at startup and logs the outcome.
 func (dp *DBProvider) ping() error {
-
-	// mongo.Client has Ping to ping mongoDB, deadline of
-	// the Ping method will be determined by cxt
-	// Ping method return error if any occurred, then
-	// the error can be handled.
-	if err := dp.Client.Ping(dp.Context, readpref.Primary()); err != nil {
+	if err := dp.Ping(dp.Context); err != nil {
+		log.Printf("db: ping failed: %v", err)
 		return err
 	}
 	fmt.Println("connected successfully")
 	return nil
 }
+
+// Ping checks that the database answers before ctx expires. The memory
+// driver is always reachable.
+func (dp *DBProvider) Ping(ctx context.Context) error {
+	switch {
+	case dp.Client != nil:
+		// mongo.Client has Ping to ping mongoDB, deadline of
+		// the Ping method will be determined by cxt
+		return dp.Client.Ping(ctx, readpref.Primary())
+	case dp.SQL != nil:
+		return dp.SQL.PingContext(ctx)
+	}
+	return nil
+}
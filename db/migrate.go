@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migrations is the collection that records which versions are applied.
+const Migrations Table = "schema_migrations"
+
+// Migration is one versioned, reversible change to the Mongo schema.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, database *mongo.Database) error
+	Down        func(ctx context.Context, database *mongo.Database) error
+}
+
+// MigrationStatus describes a known migration and whether it has run.
+type MigrationStatus struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
+
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrator applies and reverts migrations against the provider's database.
+type Migrator struct {
+	database   *mongo.Database
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator for the registered migrations. Only the
+// mongo driver is supported; the SQL stores create their own schema.
+func NewMigrator(dp *DBProvider) (*Migrator, error) {
+	if dp.Driver != Mongo {
+		return nil, fmt.Errorf("migrations are not supported for driver %q", dp.Driver)
+	}
+	ms := append([]Migration(nil), migrations...)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return &Migrator{
+		database:   dp.Client.Database(dp.Name),
+		migrations: ms,
+	}, nil
+}
+
+// Up applies every pending migration in version order.
+func (m *Migrator) Up(ctx context.Context) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	coll := m.database.Collection(string(Migrations))
+	for _, mg := range m.migrations {
+		if _, ok := applied[mg.Version]; ok {
+			continue
+		}
+		log.Printf("migrate: applying %d %s", mg.Version, mg.Description)
+		if err := mg.Up(ctx, m.database); err != nil {
+			return fmt.Errorf("migration %d: %w", mg.Version, err)
+		}
+		rec := appliedMigration{Version: mg.Version, Description: mg.Description, AppliedAt: time.Now().UTC()}
+		// Another instance may have raced us to the same version; the
+		// steps are idempotent, so its record is as good as ours.
+		if _, err := coll.InsertOne(ctx, rec); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down reverts the last n applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	coll := m.database.Collection(string(Migrations))
+	for i := len(m.migrations) - 1; i >= 0 && n > 0; i-- {
+		mg := m.migrations[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		log.Printf("migrate: reverting %d %s", mg.Version, mg.Description)
+		if err := mg.Down(ctx, m.database); err != nil {
+			return fmt.Errorf("migration %d: %w", mg.Version, err)
+		}
+		if _, err := coll.DeleteOne(ctx, bson.M{"_id": mg.Version}); err != nil {
+			return err
+		}
+		n--
+	}
+	return nil
+}
+
+// Status lists every known migration with its applied time, if any.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		s := MigrationStatus{Version: mg.Version, Description: mg.Description}
+		if a, ok := applied[mg.Version]; ok {
+			t := a.AppliedAt
+			s.AppliedAt = &t
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Pending returns the number of known migrations not applied yet.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, mg := range m.migrations {
+		if _, ok := applied[mg.Version]; !ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	cursor, err := m.database.Collection(string(Migrations)).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var recs []appliedMigration
+	if err := cursor.All(ctx, &recs); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]appliedMigration, len(recs))
+	for _, r := range recs {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// newTestMigrator returns a Migrator on an empty database of the server
+// TEST_MONGO_URI points at, and skips the test without one.
+func newTestMigrator(t *testing.T) (*Migrator, *mongo.Database) {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+	dp := NewDBProvider(config.Database{
+		Driver:   string(Mongo),
+		MongoURI: uri,
+		Name:     "test_migrate_" + primitive.NewObjectID().Hex(),
+	})
+	database := dp.Client.Database(dp.Name)
+	t.Cleanup(func() {
+		database.Drop(context.Background())
+		dp.Close(context.Background())
+	})
+	m, err := NewMigrator(dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, database
+}
+
+func TestMigratorNeedsMongo(t *testing.T) {
+	dp := NewDBProvider(config.Database{Driver: string(Memory)})
+	defer dp.Close(context.Background())
+	if _, err := NewMigrator(dp); err == nil {
+		t.Error("migrator for the memory driver")
+	}
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	m, database := newTestMigrator(t)
+	ctx := context.Background()
+	users := database.Collection(string(Users))
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertPending(t, m, 0)
+	for _, name := range []string{"users_email_unique", "users_username_unique"} {
+		idx := index(t, users, name)
+		if idx == nil {
+			t.Fatalf("index %s missing after Up", name)
+		}
+		if !idx.Unique || idx.Collation == nil || idx.Collation.Strength != CaseInsensitive.Strength {
+			t.Errorf("index %s: %+v, want unique and case-insensitive", name, idx)
+		}
+	}
+	if _, err := users.InsertOne(ctx, bson.M{"email": "Alice@Example.com", "username": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.InsertOne(ctx, bson.M{"email": "alice@example.COM", "username": "alice2"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("email differing in case: %v, want a duplicate key error", err)
+	}
+	if _, err := users.InsertOne(ctx, bson.M{"email": "other@example.com", "username": "ALICE"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("username differing in case: %v, want a duplicate key error", err)
+	}
+
+	// A second Up finds nothing to do and leaves the records alone.
+	before, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("second Up: %v", err)
+	}
+	after, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range after {
+		if after[i].AppliedAt == nil || !after[i].AppliedAt.Equal(*before[i].AppliedAt) {
+			t.Errorf("migration %d applied again", after[i].Version)
+		}
+	}
+
+	// Down of the newest one only.
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	assertPending(t, m, 1)
+
+	if err := m.Down(ctx, len(m.migrations)); err != nil {
+		t.Fatal(err)
+	}
+	assertPending(t, m, len(m.migrations))
+	for _, name := range []string{"users_email_unique", "users_username_unique"} {
+		if index(t, users, name) != nil {
+			t.Errorf("index %s left after Down", name)
+		}
+	}
+	if _, err := users.InsertOne(ctx, bson.M{"email": "alice@example.COM", "username": "Alice"}); err != nil {
+		t.Errorf("email differing in case after Down: %v", err)
+	}
+
+	// Down with nothing applied is a no-op as well.
+	if err := m.Down(ctx, 1); err != nil {
+		t.Errorf("Down with nothing applied: %v", err)
+	}
+}
+
+func TestMigratorUpAfterDown(t *testing.T) {
+	m, database := newTestMigrator(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		if err := m.Up(ctx); err != nil {
+			t.Fatalf("Up %d: %v", i+1, err)
+		}
+		if err := m.Down(ctx, len(m.migrations)); err != nil {
+			t.Fatalf("Down %d: %v", i+1, err)
+		}
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertPending(t, m, 0)
+	if index(t, database.Collection(string(Users)), "users_email_unique") == nil {
+		t.Error("users_email_unique missing after Up, Down, Up")
+	}
+}
+
+func assertPending(t *testing.T, m *Migrator, want int) {
+	t.Helper()
+	n, err := m.Pending(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != want {
+		t.Errorf("%d migrations pending, want %d", n, want)
+	}
+}
+
+type indexSpec struct {
+	Name      string `bson:"name"`
+	Unique    bool   `bson:"unique"`
+	Collation *struct {
+		Locale   string `bson:"locale"`
+		Strength int    `bson:"strength"`
+	} `bson:"collation"`
+}
+
+// index returns the specification of the named index of coll, or nil.
+func index(t *testing.T, coll *mongo.Collection, name string) *indexSpec {
+	t.Helper()
+	cursor, err := coll.Indexes().List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var specs []indexSpec
+	if err := cursor.All(context.Background(), &specs); err != nil {
+		t.Fatal(err)
+	}
+	for i := range specs {
+		if specs[i].Name == name {
+			return &specs[i]
+		}
+	}
+	return nil
+}
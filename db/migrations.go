@@ -0,0 +1,266 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CaseInsensitive is the collation of the unique user indexes. Queries
+// must pass the same collation for Mongo to use those indexes.
+var CaseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// migrations is the ordered history of the Mongo schema. Append new
+// versions; never edit or renumber one that has shipped.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "unique case-insensitive indexes on users.email and users.username",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(Users)).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "email", Value: 1}},
+					Options: options.Index().SetName("users_email_unique").SetUnique(true).SetCollation(CaseInsensitive),
+				},
+				{
+					Keys:    bson.D{{Key: "username", Value: 1}},
+					Options: options.Index().SetName("users_username_unique").SetUnique(true).SetCollation(CaseInsensitive),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			return dropIndexes(ctx, database.Collection(string(Users)),
+				"users_email_unique", "users_username_unique")
+		},
+	},
+	{
+		Version:     2,
+		Description: "refresh_tokens indexes: unique hash, family lookup, expiry TTL",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(RefreshTokens)).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "hash", Value: 1}},
+					Options: options.Index().SetName("refresh_tokens_hash_unique").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "family_id", Value: 1}},
+					Options: options.Index().SetName("refresh_tokens_family"),
+				},
+				{
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("refresh_tokens_expiry_ttl").SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			return dropIndexes(ctx, database.Collection(string(RefreshTokens)),
+				"refresh_tokens_hash_unique", "refresh_tokens_family", "refresh_tokens_expiry_ttl")
+		},
+	},
+	{
+		Version:     3,
+		Description: "access token revocations: revoked_tokens expiry TTL, refresh_tokens user lookup",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(RevokedTokens)).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetName("revoked_tokens_expiry_ttl").SetExpireAfterSeconds(0),
+			})
+			if err != nil {
+				return err
+			}
+			_, err = database.Collection(string(RefreshTokens)).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "user_id", Value: 1}},
+				Options: options.Index().SetName("refresh_tokens_user"),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			if err := dropIndexes(ctx, database.Collection(string(RefreshTokens)), "refresh_tokens_user"); err != nil {
+				return err
+			}
+			return dropIndexes(ctx, database.Collection(string(RevokedTokens)), "revoked_tokens_expiry_ttl")
+		},
+	},
+	{
+		Version:     4,
+		Description: "sessions indexes: user lookup, expiry TTL",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(Sessions)).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}},
+					Options: options.Index().SetName("sessions_user"),
+				},
+				{
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("sessions_expiry_ttl").SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			return dropIndexes(ctx, database.Collection(string(Sessions)), "sessions_user", "sessions_expiry_ttl")
+		},
+	},
+	{
+		Version:     5,
+		Description: "unique case-insensitive index on roles.name",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(Roles)).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "name", Value: 1}},
+				Options: options.Index().SetName("roles_name_unique").SetUnique(true).SetCollation(CaseInsensitive),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			return dropIndexes(ctx, database.Collection(string(Roles)), "roles_name_unique")
+		},
+	},
+	{
+		Version:     6,
+		Description: "personal access token indexes: user lookup, expiry TTL",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(PersonalAccessTokens)).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}},
+					Options: options.Index().SetName("personal_access_tokens_user"),
+				},
+				{
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("personal_access_tokens_expiry_ttl").SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			return dropIndexes(ctx, database.Collection(string(PersonalAccessTokens)),
+				"personal_access_tokens_user", "personal_access_tokens_expiry_ttl")
+		},
+	},
+	{
+		Version:     7,
+		Description: "service account and API key indexes: unique name, account lookup, expiry TTL",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(ServiceAccounts)).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "name", Value: 1}},
+				Options: options.Index().SetName("service_accounts_name_unique").SetUnique(true).SetCollation(CaseInsensitive),
+			})
+			if err != nil {
+				return err
+			}
+			_, err = database.Collection(string(APIKeys)).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: 1}},
+					Options: options.Index().SetName("api_keys_account"),
+				},
+				{
+					// Keys without expires_at never expire.
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("api_keys_expiry_ttl").SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			if err := dropIndexes(ctx, database.Collection(string(APIKeys)), "api_keys_account", "api_keys_expiry_ttl"); err != nil {
+				return err
+			}
+			return dropIndexes(ctx, database.Collection(string(ServiceAccounts)), "service_accounts_name_unique")
+		},
+	},
+	{
+		Version:     8,
+		Description: "oauth_codes expiry TTL",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(OAuthCodes)).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetName("oauth_codes_expiry_ttl").SetExpireAfterSeconds(0),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			return dropIndexes(ctx, database.Collection(string(OAuthCodes)), "oauth_codes_expiry_ttl")
+		},
+	},
+	{
+		Version:     9,
+		Description: "federated identity indexes: unique upstream subject, user lookup; federation state expiry TTL",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(FederatedIdentities)).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}},
+					Options: options.Index().SetName("federated_identities_subject_unique").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "user_id", Value: 1}},
+					Options: options.Index().SetName("federated_identities_user"),
+				},
+			})
+			if err != nil {
+				return err
+			}
+			_, err = database.Collection(string(FederationStates)).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetName("federation_states_expiry_ttl").SetExpireAfterSeconds(0),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			if err := dropIndexes(ctx, database.Collection(string(FederationStates)), "federation_states_expiry_ttl"); err != nil {
+				return err
+			}
+			return dropIndexes(ctx, database.Collection(string(FederatedIdentities)),
+				"federated_identities_subject_unique", "federated_identities_user")
+		},
+	},
+	{
+		Version:     10,
+		Description: "MFA challenge expiry TTL",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(MFAChallenges)).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetName("mfa_challenges_expiry_ttl").SetExpireAfterSeconds(0),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			return dropIndexes(ctx, database.Collection(string(MFAChallenges)), "mfa_challenges_expiry_ttl")
+		},
+	},
+	{
+		Version:     11,
+		Description: "passkey indexes: user lookup; passkey session expiry TTL",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection(string(Passkeys)).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "user_id", Value: 1}},
+				Options: options.Index().SetName("passkeys_user"),
+			})
+			if err != nil {
+				return err
+			}
+			_, err = database.Collection(string(PasskeySessions)).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetName("passkey_sessions_expiry_ttl").SetExpireAfterSeconds(0),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			if err := dropIndexes(ctx, database.Collection(string(PasskeySessions)), "passkey_sessions_expiry_ttl"); err != nil {
+				return err
+			}
+			return dropIndexes(ctx, database.Collection(string(Passkeys)), "passkeys_user")
+		},
+	},
+}
+
+func dropIndexes(ctx context.Context, coll *mongo.Collection, names ...string) error {
+	for _, name := range names {
+		if _, err := coll.Indexes().DropOne(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Package directory checks login passwords against an LDAP directory,
+// such as Active Directory or OpenLDAP.
+//
+// The Authenticator finds the user's entry with a search, checks the
+// password by binding as that entry and then brings the local user with
+// the entry's email in line with it: the username and bio are copied
+// over, and roles named in the group mapping follow the user's directory
+// groups. It plugs into user.Chain next to the password stored with the
+// user.
+package directory
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hamed-lohi/user-manage/entity/token"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+)
+
+// Conn is the part of an LDAP connection the Authenticator uses.
+// *ldap.Conn implements it; tests can hand an in-process stand-in to
+// Directory.Dial instead.
+type Conn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// Directory is an LDAP server and how users and their groups are found
+// in it. The fields follow the configuration.
+type Directory struct {
+	URL       string
+	StartTLS  bool
+	TLSConfig *tls.Config
+	// BindDN and BindPassword are the account searches run as; an empty
+	// BindDN searches anonymously.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds a user's entry; every %s stands for the escaped
+	// email.
+	UserFilter        string
+	UsernameAttribute string
+	EmailAttribute    string
+	BioAttribute      string
+	// GroupsAttribute lists the DNs of the user's groups. It is not used
+	// if GroupFilter is set, which instead finds the groups below
+	// GroupBaseDN with every %s standing for the escaped DN of the user.
+	GroupsAttribute string
+	GroupBaseDN     string
+	GroupFilter     string
+	GroupRoles      map[string]identity.Role
+	CreateUsers     bool
+	Timeout         time.Duration
+	// Dial connects to the directory; nil dials URL.
+	Dial func(ctx context.Context) (Conn, error)
+}
+
+// Authenticator is a user.Authenticator backed by a Directory.
+type Authenticator struct {
+	dir     Directory
+	groups  map[string]identity.Role
+	users   user.Store
+	refresh *token.Manager
+}
+
+// Verify Interface Compliance
+var _ user.Authenticator = (*Authenticator)(nil)
+
+// NewAuthenticator fills in the defaults of d: a 10 second timeout, users
+// found by mail, uid as the username and groups read from memberOf.
+func NewAuthenticator(d Directory, us user.Store, rm *token.Manager) *Authenticator {
+	if d.Timeout == 0 {
+		d.Timeout = 10 * time.Second
+	}
+	if d.UserFilter == "" {
+		d.UserFilter = "(mail=%s)"
+	}
+	if d.UsernameAttribute == "" {
+		d.UsernameAttribute = "uid"
+	}
+	if d.EmailAttribute == "" {
+		d.EmailAttribute = "mail"
+	}
+	if d.GroupsAttribute == "" {
+		d.GroupsAttribute = "memberOf"
+	}
+	if d.GroupBaseDN == "" {
+		d.GroupBaseDN = d.BaseDN
+	}
+	a := &Authenticator{
+		dir:     d,
+		groups:  make(map[string]identity.Role, len(d.GroupRoles)),
+		users:   us,
+		refresh: rm,
+	}
+	if a.dir.Dial == nil {
+		a.dir.Dial = a.dial
+	}
+	// DNs and attribute values compare without regard to case.
+	for g, r := range d.GroupRoles {
+		a.groups[strings.ToLower(g)] = r
+	}
+	return a
+}
+
+// entry is what a login learns about the user from the directory.
+type entry struct {
+	DN       string
+	Username string
+	Email    string
+	Bio      string
+	Groups   []string
+}
+
+// Authenticate returns nil for emails the directory has no entry for and
+// for wrong passwords, and also for users that do not exist locally yet
+// unless CreateUsers is set.
+func (a *Authenticator) Authenticate(ctx context.Context, email, password string) (*user.User, error) {
+	// A bind with an empty password is an unauthenticated bind, which
+	// many servers let through.
+	if email == "" || password == "" {
+		return nil, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, err := a.dir.Dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("directory: %w", err)
+	}
+	defer conn.Close()
+
+	e, err := a.lookup(conn, email)
+	if err != nil || e == nil {
+		return nil, err
+	}
+	if err := conn.Bind(e.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("directory: %w", err)
+	}
+	if e.Email == "" {
+		e.Email = email
+	}
+	return a.sync(ctx, e)
+}
+
+// lookup finds the entry and groups of the user email belongs to.
+func (a *Authenticator) lookup(conn Conn, email string) (*entry, error) {
+	if a.dir.BindDN != "" {
+		if err := conn.Bind(a.dir.BindDN, a.dir.BindPassword); err != nil {
+			return nil, fmt.Errorf("directory: binding as %s: %w", a.dir.BindDN, err)
+		}
+	}
+	attrs := []string{a.dir.UsernameAttribute, a.dir.EmailAttribute}
+	if a.dir.BioAttribute != "" {
+		attrs = append(attrs, a.dir.BioAttribute)
+	}
+	if a.dir.GroupFilter == "" {
+		attrs = append(attrs, a.dir.GroupsAttribute)
+	}
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.dir.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.dir.Timeout.Seconds()), false,
+		strings.ReplaceAll(a.dir.UserFilter, "%s", ldap.EscapeFilter(email)), attrs, nil))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) || (err == nil && len(res.Entries) > 1) {
+		return nil, fmt.Errorf("directory: more than one entry matches %s", email)
+	}
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) || (err == nil && len(res.Entries) == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("directory: %w", err)
+	}
+
+	found := res.Entries[0]
+	e := &entry{
+		DN:       found.DN,
+		Username: found.GetAttributeValue(a.dir.UsernameAttribute),
+		Email:    found.GetAttributeValue(a.dir.EmailAttribute),
+		Groups:   found.GetAttributeValues(a.dir.GroupsAttribute),
+	}
+	if a.dir.BioAttribute != "" {
+		e.Bio = found.GetAttributeValue(a.dir.BioAttribute)
+	}
+	if a.dir.GroupFilter != "" {
+		res, err := conn.Search(ldap.NewSearchRequest(
+			a.dir.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(a.dir.Timeout.Seconds()), false,
+			strings.ReplaceAll(a.dir.GroupFilter, "%s", ldap.EscapeFilter(found.DN)), []string{"1.1"}, nil))
+		if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("directory: searching groups: %w", err)
+		}
+		e.Groups = nil
+		if res != nil {
+			for _, g := range res.Entries {
+				e.Groups = append(e.Groups, g.DN)
+			}
+		}
+	}
+	return e, nil
+}
+
+// groupNames lists every name a group mapping may use for groups: the
+// DN, and the value of its first RDN such as the cn.
+func groupNames(groups []string) []string {
+	names := make([]string, 0, 2*len(groups))
+	for _, g := range groups {
+		names = append(names, strings.ToLower(g))
+		dn, err := ldap.ParseDN(g)
+		if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+			continue
+		}
+		names = append(names, strings.ToLower(dn.RDNs[0].Attributes[0].Value))
+	}
+	return names
+}
+
+func (a *Authenticator) dial(ctx context.Context) (Conn, error) {
+	d := &net.Dialer{Timeout: a.dir.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		d.Deadline = deadline
+	}
+	conn, err := ldap.DialURL(a.dir.URL, ldap.DialWithDialer(d), ldap.DialWithTLSConfig(a.dir.TLSConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.dir.Timeout)
+	if a.dir.StartTLS {
+		cfg := &tls.Config{}
+		if a.dir.TLSConfig != nil {
+			cfg = a.dir.TLSConfig.Clone()
+		}
+		if u, err := url.Parse(a.dir.URL); err == nil && cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		if err := conn.StartTLS(cfg); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
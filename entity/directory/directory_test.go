@@ -0,0 +1,300 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hamed-lohi/user-manage/entity/token"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+)
+
+const (
+	baseDN    = "dc=example,dc=com"
+	serviceDN = "cn=search,dc=example,dc=com"
+	bobDN     = "uid=robert,ou=people,dc=example,dc=com"
+	adminsDN  = "cn=admins,ou=groups,dc=example,dc=com"
+)
+
+// fakeDirectory stands in for an LDAP server: it holds entries and their
+// passwords and records the binds made to it.
+type fakeDirectory struct {
+	entries   []*ldap.Entry
+	passwords map[string]string
+	binds     []string
+	dials     int
+}
+
+func (d *fakeDirectory) dial(ctx context.Context) (Conn, error) {
+	d.dials++
+	return &fakeConn{dir: d}, nil
+}
+
+type fakeConn struct {
+	dir *fakeDirectory
+}
+
+func (c *fakeConn) Bind(dn, password string) error {
+	c.dir.binds = append(c.dir.binds, dn)
+	if want, ok := c.dir.passwords[dn]; !ok || want != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+// term is one (attribute=value) of a filter. The fake takes only such
+// terms, joined by & if there are several.
+var term = regexp.MustCompile(`\(([\w-]+)=([^()]*)\)`)
+
+// Search compares values escaped the way the filter should be, with *
+// as a wildcard like on a server, so a filter that was not escaped
+// finds too much.
+func (c *fakeConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	res := &ldap.SearchResult{}
+	for _, e := range c.dir.entries {
+		if !strings.HasSuffix(strings.ToLower(e.DN), strings.ToLower(req.BaseDN)) || !matches(e, req.Filter) {
+			continue
+		}
+		if req.SizeLimit > 0 && len(res.Entries) == req.SizeLimit {
+			return res, ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("size limit exceeded"))
+		}
+		res.Entries = append(res.Entries, e)
+	}
+	return res, nil
+}
+
+func matches(e *ldap.Entry, filter string) bool {
+	terms := term.FindAllStringSubmatch(filter, -1)
+	if len(terms) == 0 {
+		return false
+	}
+	for _, t := range terms {
+		parts := strings.Split(t[2], "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		value := regexp.MustCompile("(?i)^" + strings.Join(parts, ".*") + "$")
+		found := false
+		for _, v := range e.GetAttributeValues(t[1]) {
+			found = found || value.MatchString(ldap.EscapeFilter(v))
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+// newTestDirectory has bob in a directory that maps its admins group to
+// Moderator, and bob as a local Member.
+func newTestDirectory(t *testing.T, change func(d *Directory)) (*Authenticator, *fakeDirectory, user.Store, *token.Manager) {
+	t.Helper()
+	fake := &fakeDirectory{
+		entries: []*ldap.Entry{
+			ldap.NewEntry(bobDN, map[string][]string{
+				"uid":         {"robert"},
+				"mail":        {"bob@example.com"},
+				"description": {"from the directory"},
+				"memberOf":    {adminsDN},
+			}),
+			ldap.NewEntry(adminsDN, map[string][]string{"cn": {"admins"}, "member": {bobDN}}),
+		},
+		passwords: map[string]string{serviceDN: "search-secret", bobDN: "directory-secret"},
+	}
+	us := user.NewMemoryStore()
+	bob := &user.User{Username: "bob", Email: "bob@example.com", Roles: []identity.Role{identity.Member}}
+	bob.SetPassword("local-secret")
+	if err := us.Create(context.Background(), bob); err != nil {
+		t.Fatal(err)
+	}
+	rm := token.NewManager(token.NewMemoryStore(), time.Hour)
+
+	d := Directory{
+		BindDN:       serviceDN,
+		BindPassword: "search-secret",
+		BaseDN:       baseDN,
+		BioAttribute: "description",
+		GroupRoles:   map[string]identity.Role{"Admins": identity.Moderator},
+		Dial:         fake.dial,
+	}
+	if change != nil {
+		change(&d)
+	}
+	return NewAuthenticator(d, us, rm), fake, us, rm
+}
+
+func TestAuthenticateBindsAsTheEntryFound(t *testing.T) {
+	a, fake, _, _ := newTestDirectory(t, nil)
+	ctx := context.Background()
+
+	u, err := a.Authenticate(ctx, "bob@example.com", "directory-secret")
+	if err != nil || u == nil {
+		t.Fatalf("directory password: %v, %v", u, err)
+	}
+	if want := []string{serviceDN, bobDN}; strings.Join(fake.binds, "|") != strings.Join(want, "|") {
+		t.Errorf("binds %q, want %q", fake.binds, want)
+	}
+	if u.Username != "robert" || u.Bio == nil || *u.Bio != "from the directory" {
+		t.Errorf("profile not copied: %q, %v", u.Username, u.Bio)
+	}
+	if !hasRole(u.Roles, identity.Member) || !hasRole(u.Roles, identity.Moderator) {
+		t.Errorf("roles %v, want Member and Moderator from the admins group", u.Roles)
+	}
+
+	for _, tc := range []struct{ name, email, password string }{
+		{"wrong password", "bob@example.com", "local-secret"},
+		{"unknown email", "eve@example.com", "directory-secret"},
+		{"wildcard email", "*", "directory-secret"},
+		{"filter injection", "*)(uid=robert", "directory-secret"},
+	} {
+		if u, err := a.Authenticate(ctx, tc.email, tc.password); err != nil || u != nil {
+			t.Errorf("%s: %v, %v; want neither user nor error", tc.name, u, err)
+		}
+	}
+
+	// An empty password would be an unauthenticated bind, which servers
+	// often accept; the directory is not even asked.
+	dials := fake.dials
+	if u, err := a.Authenticate(ctx, "bob@example.com", ""); err != nil || u != nil || fake.dials != dials {
+		t.Errorf("empty password: %v, %v after %d dials", u, err, fake.dials-dials)
+	}
+}
+
+func TestAuthenticateFailsOnAmbiguousEmail(t *testing.T) {
+	a, fake, _, _ := newTestDirectory(t, nil)
+	fake.entries = append(fake.entries, ldap.NewEntry("uid=bobby,ou=people,dc=example,dc=com", map[string][]string{
+		"uid": {"bobby"}, "mail": {"bob@example.com"},
+	}))
+	if u, err := a.Authenticate(context.Background(), "bob@example.com", "directory-secret"); err == nil || u != nil {
+		t.Errorf("two entries for one email: %v, %v; want an error", u, err)
+	}
+}
+
+func TestAuthenticateWithGroupFilter(t *testing.T) {
+	a, fake, _, _ := newTestDirectory(t, func(d *Directory) {
+		d.GroupBaseDN = "ou=groups," + baseDN
+		d.GroupFilter = "(member=%s)"
+		d.GroupRoles = map[string]identity.Role{adminsDN: identity.Moderator}
+	})
+	// memberOf is not read when a group filter is set.
+	fake.entries[0] = ldap.NewEntry(bobDN, map[string][]string{
+		"uid": {"robert"}, "mail": {"bob@example.com"}, "memberOf": {"cn=ignored," + baseDN},
+	})
+
+	u, err := a.Authenticate(context.Background(), "bob@example.com", "directory-secret")
+	if err != nil || u == nil {
+		t.Fatalf("directory password: %v, %v", u, err)
+	}
+	if !hasRole(u.Roles, identity.Moderator) {
+		t.Errorf("roles %v, want Moderator through the group found by DN", u.Roles)
+	}
+}
+
+// TestFiltersWithSeveralPlaceholders checks that every %s of a filter is
+// filled in, as in filters that match more than one attribute.
+func TestFiltersWithSeveralPlaceholders(t *testing.T) {
+	a, fake, _, _ := newTestDirectory(t, func(d *Directory) {
+		d.UserFilter = "(&(mail=%s)(userPrincipalName=%s))"
+		d.GroupBaseDN = "ou=groups," + baseDN
+		d.GroupFilter = "(&(member=%s)(uniqueMember=%s))"
+		d.GroupRoles = map[string]identity.Role{adminsDN: identity.Moderator}
+	})
+	fake.entries = []*ldap.Entry{
+		ldap.NewEntry(bobDN, map[string][]string{
+			"uid": {"robert"}, "mail": {"bob@example.com"}, "userPrincipalName": {"bob@example.com"},
+		}),
+		ldap.NewEntry(adminsDN, map[string][]string{"cn": {"admins"}, "member": {bobDN}, "uniqueMember": {bobDN}}),
+	}
+
+	u, err := a.Authenticate(context.Background(), "bob@example.com", "directory-secret")
+	if err != nil || u == nil {
+		t.Fatalf("directory password: %v, %v", u, err)
+	}
+	if !hasRole(u.Roles, identity.Moderator) {
+		t.Errorf("roles %v, want Moderator through the group filter", u.Roles)
+	}
+}
+
+func TestLeavingGroupDropsRoleAndLogsOut(t *testing.T) {
+	a, fake, us, rm := newTestDirectory(t, nil)
+	ctx := context.Background()
+	u, err := a.Authenticate(ctx, "bob@example.com", "directory-secret")
+	if err != nil || u == nil {
+		t.Fatalf("directory password: %v, %v", u, err)
+	}
+	if _, _, err := rm.Issue(ctx, u.ID, token.Client{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.entries[0] = ldap.NewEntry(bobDN, map[string][]string{"uid": {"robert"}, "mail": {"bob@example.com"}})
+	if u, err = a.Authenticate(ctx, "bob@example.com", "directory-secret"); err != nil || u == nil {
+		t.Fatalf("directory password: %v, %v", u, err)
+	}
+	if hasRole(u.Roles, identity.Moderator) || !hasRole(u.Roles, identity.Member) {
+		t.Errorf("roles %v, want only Member once out of the admins group", u.Roles)
+	}
+	if stored, _ := us.GetByID(ctx, u.ID); stored == nil || hasRole(stored.Roles, identity.Moderator) {
+		t.Errorf("stored roles not updated: %v", stored)
+	}
+	if sessions, err := rm.Sessions(ctx, u.ID); err != nil || len(sessions) != 0 {
+		t.Errorf("%d sessions left after losing a role: %v", len(sessions), err)
+	}
+}
+
+func TestCreateUsers(t *testing.T) {
+	for _, create := range []bool{false, true} {
+		a, fake, us, _ := newTestDirectory(t, func(d *Directory) { d.CreateUsers = create })
+		fake.entries = append(fake.entries, ldap.NewEntry("uid=carol,ou=people,dc=example,dc=com", map[string][]string{
+			"uid": {"carol"}, "mail": {"carol@example.com"}, "memberOf": {adminsDN},
+		}))
+		fake.passwords["uid=carol,ou=people,dc=example,dc=com"] = "carols-secret"
+
+		u, err := a.Authenticate(context.Background(), "carol@example.com", "carols-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !create {
+			if u != nil {
+				t.Errorf("without create_users: got user %s", u.Username)
+			}
+			continue
+		}
+		if u == nil || u.Username != "carol" || !hasRole(u.Roles, identity.Guest) || !hasRole(u.Roles, identity.Moderator) {
+			t.Fatalf("with create_users: %+v", u)
+		}
+		if stored, _ := us.GetByEmail(context.Background(), "carol@example.com"); stored == nil || stored.CheckPassword("carols-secret") {
+			t.Error("created user is missing or takes the directory password locally")
+		}
+	}
+}
+
+func TestChainOutlivesUnreachableDirectory(t *testing.T) {
+	a, _, us, _ := newTestDirectory(t, func(d *Directory) {
+		d.Dial = func(ctx context.Context) (Conn, error) { return nil, errors.New("connection refused") }
+	})
+	chain := user.Chain{user.NewPasswordAuthenticator(us), a}
+	ctx := context.Background()
+
+	if u, err := chain.Authenticate(ctx, "bob@example.com", "local-secret"); err != nil || u == nil {
+		t.Errorf("local password with the directory down: %v, %v", u, err)
+	}
+	if _, err := chain.Authenticate(ctx, "bob@example.com", "directory-secret"); err == nil {
+		t.Error("directory password with the directory down: no error")
+	}
+}
+
+func hasRole(roles []identity.Role, want identity.Role) bool {
+	for _, r := range roles {
+		if r == want {
+			return true
+		}
+	}
+	return false
+}
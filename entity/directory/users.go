@@ -0,0 +1,96 @@
+package directory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+)
+
+// sync returns the local user of e after copying the directory's profile
+// and group roles into it, creating the user if CreateUsers allows it.
+// Losing a role logs the user out everywhere else, since issued tokens
+// carry roles.
+func (a *Authenticator) sync(ctx context.Context, e *entry) (*user.User, error) {
+	u, err := a.users.GetByEmail(ctx, e.Email)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		if !a.dir.CreateUsers {
+			return nil, nil
+		}
+		return a.create(ctx, e)
+	}
+
+	before := *u
+	if e.Username != "" {
+		u.Username = e.Username
+	}
+	if a.dir.BioAttribute != "" {
+		u.Bio = &e.Bio
+	}
+	roles, rolesChanged, removed := identity.SyncGroupRoles(u.Roles, a.groups, groupNames(e.Groups))
+	u.Roles = roles
+	if !rolesChanged && u.Username == before.Username && sameBio(u.Bio, before.Bio) {
+		return u, nil
+	}
+
+	err = a.users.Update(ctx, u)
+	if err == user.ErrDuplicate && u.Username != before.Username {
+		// Another user has the directory's username; keep the local one.
+		u.Username = before.Username
+		err = a.users.Update(ctx, u)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if removed {
+		if err := a.refresh.RevokeUser(ctx, u.ID); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+func (a *Authenticator) create(ctx context.Context, e *entry) (*user.User, error) {
+	roles, _, _ := identity.SyncGroupRoles([]identity.Role{identity.Guest}, a.groups, groupNames(e.Groups))
+	u := &user.User{
+		Username: e.Username,
+		Email:    e.Email,
+		Bio:      &e.Bio,
+		Roles:    roles,
+	}
+	if u.Username == "" {
+		u.Username = strings.SplitN(e.Email, "@", 2)[0]
+	}
+	// The directory checks the password; nobody knows this one.
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	if err := u.SetPassword(base64.RawURLEncoding.EncodeToString(b)); err != nil {
+		return nil, err
+	}
+	err := a.users.Create(ctx, u)
+	if err == user.ErrDuplicate {
+		// The email was checked by sync, so the username is taken.
+		u.Username += "-" + hex.EncodeToString(b[:3])
+		err = a.users.Create(ctx, u)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func sameBio(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
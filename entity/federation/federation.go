@@ -0,0 +1,126 @@
+// Package federation lets users sign in through upstream OpenID Connect
+// identity providers.
+//
+// A sign-in starts at /auth/{provider}/login, which redirects to the
+// provider with a state, a nonce and a PKCE challenge. The provider sends
+// the user back to /auth/{provider}/callback, where the code is exchanged
+// and the ID token verified against the provider's published keys. The
+// upstream subject is then resolved to a user: through an existing link,
+// by linking the user with the same verified email, or by creating a user
+// if the provider allows it. The callback answers like a password login,
+// so users who enrolled in a second factor still have to give it.
+//
+// Roles named in a provider's group mapping follow the user's upstream
+// groups at every sign-in; other roles are left alone.
+package federation
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/entity/token"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stateLifetime bounds how long a user may take at the provider.
+const stateLifetime = 10 * time.Minute
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrState           = errors.New("the sign-in expired or was started in another browser")
+	ErrNoAccount       = errors.New("no user is linked to this upstream account")
+	ErrNoEmail         = errors.New("the identity provider did not share an email address")
+	ErrEmailInUse      = errors.New("a user with this email already exists, but the identity provider did not verify the email")
+)
+
+var (
+	store     Store
+	users     user.Store
+	refresh   *token.Manager
+	providers map[string]*Provider
+	order     []*Provider
+	complete  func(c echo.Context, u *user.User) error
+	now       = time.Now
+)
+
+// Provider is an upstream OpenID Connect identity provider. The exported
+// fields come from the configuration; the provider's metadata and keys
+// are fetched on first use.
+type Provider struct {
+	ID           string
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is registered with the provider; empty means the
+	// callback route on the host the sign-in started from.
+	RedirectURL string
+	Scopes      []string
+	GroupsClaim string
+	GroupRoles  map[string]identity.Role
+	CreateUsers bool
+	TrustEmail  bool
+	// Client makes the requests to the provider.
+	Client *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     map[string]identity.JWK
+	fetched  time.Time
+}
+
+// Link ties a user to their subject at a provider.
+type Link struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Provider  string             `bson:"provider" json:"provider"`
+	Subject   string             `bson:"subject" json:"subject"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// State is a sign-in in progress. Only the SHA-256 of the state parameter
+// is stored; the PKCE verifier and nonce are needed once the provider
+// sends the user back.
+type State struct {
+	Hash        string    `bson:"_id"`
+	Provider    string    `bson:"provider"`
+	Nonce       string    `bson:"nonce"`
+	Verifier    string    `bson:"verifier"`
+	RedirectURL string    `bson:"redirect_url"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+// RegisterHandlers mounts the sign-in routes of ps. done finishes a
+// sign-in once the user is known, normally user.ContinueLogin so that a
+// second factor is still asked for.
+func RegisterHandlers(v1 *echo.Group, s Store, us user.Store, rm *token.Manager, ps []*Provider, done func(c echo.Context, u *user.User) error) {
+
+	store = s
+	users = us
+	refresh = rm
+	complete = done
+	order = ps
+	providers = make(map[string]*Provider, len(ps))
+	for _, p := range ps {
+		if p.Client == nil {
+			p.Client = &http.Client{Timeout: 10 * time.Second}
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+		if p.GroupsClaim == "" {
+			p.GroupsClaim = "groups"
+		}
+		providers[p.ID] = p
+	}
+
+	a := v1.Group("/auth")
+	a.GET("/providers", ListProviders)
+	a.GET("/:provider/login", Login).Name = "federation-login"
+	a.GET("/:provider/callback", Callback).Name = "federation-callback"
+}
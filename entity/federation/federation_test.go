@@ -0,0 +1,316 @@
+package federation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/hamed-lohi/user-manage/entity/mfa"
+	"github.com/hamed-lohi/user-manage/entity/role"
+	"github.com/hamed-lohi/user-manage/entity/token"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+)
+
+const clientID = "user-manage"
+
+// mockIdP is an upstream OpenID provider: it serves discovery, its
+// published keys and a token endpoint that returns IDToken for any code.
+type mockIdP struct {
+	*httptest.Server
+	// Issuer is what discovery names, the server's URL unless changed.
+	Issuer string
+
+	mu      sync.Mutex
+	keys    *identity.KeySet
+	signer  identity.Key
+	IDToken string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	idp := &mockIdP{}
+	idp.rotate(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &metadata{
+			Issuer:                idp.Issuer,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		writeJSON(w, idp.keys.JWKS())
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.FormValue("code") != "code" || r.FormValue("code_verifier") == "" || id != clientID || secret != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		writeJSON(w, map[string]string{"token_type": "Bearer", "access_token": "at", "id_token": idp.IDToken})
+	})
+	idp.Server = httptest.NewServer(mux)
+	idp.Issuer = idp.URL
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// rotate replaces the published key with a new one that signs from now
+// on.
+func (idp *mockIdP) rotate(t *testing.T, kid string) identity.Key {
+	t.Helper()
+	k := newKey(t, kid)
+	ks, err := identity.NewKeySet(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.keys, idp.signer = ks, k
+	return k
+}
+
+// claims are those of a valid ID token for sub.
+func (idp *mockIdP) claims(nonce, sub, email string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            idp.URL,
+		"aud":            clientID,
+		"sub":            sub,
+		"email":          email,
+		"email_verified": true,
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+	}
+}
+
+func newKey(t *testing.T, kid string) identity.Key {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity.Key{ID: kid, Algorithm: identity.ES256, PrivateKey: priv, PublicKey: &priv.PublicKey}
+}
+
+func sign(t *testing.T, k identity.Key, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tok.Header["kid"] = k.ID
+	raw, err := tok.SignedString(k.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// testServer is the sign-in routes of one provider, the mock IdP, on
+// memory stores with one user, bob.
+type testServer struct {
+	e   *echo.Echo
+	idp *mockIdP
+	mfa *mfa.MemoryStore
+	bob *user.User
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ctx := context.Background()
+	ts := &testServer{e: echo.New(), idp: newMockIdP(t), mfa: mfa.NewMemoryStore()}
+
+	us := user.NewMemoryStore()
+	ts.bob = &user.User{Username: "bob", Email: "bob@example.com", Roles: []identity.Role{identity.Member}}
+	ts.bob.SetPassword("secret")
+	if err := us.Create(ctx, ts.bob); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := token.NewManager(token.NewMemoryStore(), time.Hour)
+	mm := mfa.NewManager(ts.mfa, us, "test")
+	auth := identity.JWTWithConfig(identity.JWTConfig{Keys: identity.Keys, Revocations: rm})
+	v1 := ts.e.Group("/api")
+	user.RegisterHandlers(v1, auth, us, role.NewMemoryStore(), rm, nil, mm)
+	p := &Provider{ID: "idp", Name: "IdP", Issuer: ts.idp.URL, ClientID: clientID, ClientSecret: "secret"}
+	RegisterHandlers(v1, NewMemoryStore(), us, rm, []*Provider{p}, user.ContinueLogin)
+
+	now = time.Now
+	t.Cleanup(func() { now = time.Now })
+	return ts
+}
+
+// signIn runs a sign-in through the mock IdP, which returns the ID token
+// idToken makes for the nonce of the sign-in, and returns the callback's
+// response.
+func (ts *testServer) signIn(t *testing.T, idToken func(nonce string) string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	ts.e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/auth/idp/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+	}
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := loc.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != clientID {
+		t.Fatalf("authorization request: %s", loc)
+	}
+
+	ts.idp.mu.Lock()
+	ts.idp.IDToken = idToken(q.Get("nonce"))
+	ts.idp.mu.Unlock()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/idp/callback?code=code&state="+url.QueryEscape(q.Get("state")), nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	ts.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// signedBy makes valid ID tokens for sub and email signed with k.
+func (ts *testServer) signedBy(t *testing.T, k identity.Key, sub, email string) func(string) string {
+	return func(nonce string) string {
+		return sign(t, k, ts.idp.claims(nonce, sub, email))
+	}
+}
+
+func loggedInAs(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var res struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res.Token == "" {
+		t.Fatalf("callback: no login in %s", rec.Body)
+	}
+	return res.ID
+}
+
+func TestSignInLinksVerifiedEmail(t *testing.T) {
+	ts := newTestServer(t)
+	k := ts.idp.signer
+
+	if id := loggedInAs(t, ts.signIn(t, ts.signedBy(t, k, "upstream-bob", "bob@example.com"))); id != ts.bob.ID.Hex() {
+		t.Errorf("first sign-in logged in %s, want bob", id)
+	}
+	// The link holds even after the email changes upstream.
+	if id := loggedInAs(t, ts.signIn(t, ts.signedBy(t, k, "upstream-bob", "robert@example.com"))); id != ts.bob.ID.Hex() {
+		t.Errorf("linked sign-in logged in %s, want bob", id)
+	}
+	// Without create_users nobody else gets in.
+	if rec := ts.signIn(t, ts.signedBy(t, k, "upstream-eve", "eve@example.com")); rec.Code != http.StatusForbidden {
+		t.Errorf("unknown upstream account: status %d, want 403", rec.Code)
+	}
+}
+
+func TestDiscoveryMustNameIssuer(t *testing.T) {
+	ts := newTestServer(t)
+	ts.idp.Issuer = "https://elsewhere.example"
+
+	rec := httptest.NewRecorder()
+	ts.e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/auth/idp/login", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("login: status %d, want 502", rec.Code)
+	}
+}
+
+func TestSignInAsksForSecondFactor(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	if _, err := ts.mfa.CreatePendingTOTP(ctx, &mfa.TOTP{UserID: ts.bob.ID, Secret: secret}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.mfa.EnableTOTP(ctx, ts.bob.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := ts.signIn(t, ts.signedBy(t, ts.idp.signer, "upstream-bob", "bob@example.com"))
+	var res map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	if rec.Code != http.StatusAccepted || res["challenge"] == nil || res["token"] != nil {
+		t.Errorf("sign-in of a user with TOTP: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSignInRejectsBadIDTokens(t *testing.T) {
+	ts := newTestServer(t)
+	k := ts.idp.signer
+	stranger := newKey(t, k.ID)
+
+	for _, tc := range []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+		key    identity.Key
+	}{
+		{"bad nonce", func(c jwt.MapClaims) { c["nonce"] = "replayed" }, k},
+		{"missing nonce", func(c jwt.MapClaims) { delete(c, "nonce") }, k},
+		{"bad audience", func(c jwt.MapClaims) { c["aud"] = "another-client" }, k},
+		{"extra audience without azp", func(c jwt.MapClaims) { c["aud"] = []string{clientID, "another-client"} }, k},
+		{"bad issuer", func(c jwt.MapClaims) { c["iss"] = "https://elsewhere.example" }, k},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, k},
+		{"unknown key", func(c jwt.MapClaims) {}, stranger},
+	} {
+		rec := ts.signIn(t, func(nonce string) string {
+			claims := ts.idp.claims(nonce, "upstream-bob", "bob@example.com")
+			tc.change(claims)
+			return sign(t, tc.key, claims)
+		})
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", tc.name, rec.Code)
+		}
+	}
+	l, err := store.GetLink(context.Background(), "idp", "upstream-bob")
+	if err != nil || l != nil {
+		t.Errorf("rejected sign-ins linked bob: %v, %v", l, err)
+	}
+}
+
+func TestSignInFollowsKeyRotation(t *testing.T) {
+	ts := newTestServer(t)
+	old := ts.idp.signer
+	loggedInAs(t, ts.signIn(t, ts.signedBy(t, old, "upstream-bob", "bob@example.com")))
+
+	k := ts.idp.rotate(t, "key-2")
+	// An unknown kid right after the keys were fetched does not make them
+	// be fetched again...
+	if rec := ts.signIn(t, ts.signedBy(t, k, "upstream-bob", "bob@example.com")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("new key within a minute: status %d, want 401", rec.Code)
+	}
+	// ...but it does a minute later, and the retired key stops working.
+	now = func() time.Time { return time.Now().Add(keyRefetch) }
+	loggedInAs(t, ts.signIn(t, ts.signedBy(t, k, "upstream-bob", "bob@example.com")))
+	if rec := ts.signIn(t, ts.signedBy(t, old, "upstream-bob", "bob@example.com")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("retired key: status %d, want 401", rec.Code)
+	}
+}
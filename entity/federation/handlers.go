@@ -0,0 +1,198 @@
+package federation
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+)
+
+// stateCookie binds a sign-in to the browser that started it, so that a
+// callback URL cannot be used to sign someone else in.
+const stateCookie = "federation_state"
+
+// ListProviders godoc
+// @Summary List identity providers
+// @Description List the upstream identity providers users can sign in with
+// @ID list-identity-providers
+// @Tags auth
+// @Produce  json
+// @Success 200 {object} providerListResponse
+// @Router /auth/providers [get]
+func ListProviders(c echo.Context) error {
+	return c.JSON(http.StatusOK, newProviderListResponse(c, order))
+}
+
+// Login godoc
+// @Summary Sign in with an identity provider
+// @Description Redirect the browser to the identity provider to sign in. The provider sends it back to the callback.
+// @ID federated-login
+// @Tags auth
+// @Param        provider   path      string  true  "Provider ID"
+// @Success 302
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Failure 502 {object} customerror.Error
+// @Router /auth/{provider}/login [get]
+func Login(c echo.Context) error {
+	p := providers[c.Param("provider")]
+	if p == nil {
+		return c.JSON(http.StatusNotFound, customerror.NewError(ErrUnknownProvider))
+	}
+	ctx := c.Request().Context()
+	m, err := p.discover(ctx)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, customerror.NewError(err))
+	}
+
+	st := &State{
+		Provider:    p.ID,
+		RedirectURL: p.callbackURL(c),
+		ExpiresAt:   now().Add(stateLifetime).UTC(),
+	}
+	var state string
+	for _, v := range []*string{&state, &st.Nonce, &st.Verifier} {
+		if *v, err = randomString(); err != nil {
+			return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+		}
+	}
+	st.Hash = identity.HashToken(state)
+	if err := store.CreateState(ctx, st); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	setStateCookie(c, p, state, int(stateLifetime.Seconds()))
+
+	sum := sha256.Sum256([]byte(st.Verifier))
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, customerror.NewError(err))
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", st.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", st.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return c.Redirect(http.StatusFound, u.String())
+}
+
+// Callback godoc
+// @Summary Finish signing in with an identity provider
+// @Description The identity provider redirects here. The user linked to the upstream account is signed in; on the first sign-in the account is linked to the user with the same verified email, or a user is created if the provider allows it. The response is the same as for a password login: users with two-factor authentication get 202 and a challenge to finish the login with at /users/login/mfa.
+// @ID federated-callback
+// @Tags auth
+// @Produce  json
+// @Param        provider   path      string  true  "Provider ID"
+// @Param code query string false "Authorization code"
+// @Param state query string true "State of the sign-in"
+// @Success 200 {object} user.userResponse
+// @Success 202 {object} mfa.challengeResponse
+// @Failure 400 {object} customerror.Error
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Failure 502 {object} customerror.Error
+// @Router /auth/{provider}/callback [get]
+func Callback(c echo.Context) error {
+	p := providers[c.Param("provider")]
+	if p == nil {
+		return c.JSON(http.StatusNotFound, customerror.NewError(ErrUnknownProvider))
+	}
+	ctx := c.Request().Context()
+
+	state := c.QueryParam("state")
+	cookie, err := c.Cookie(stateCookie)
+	if state == "" || err != nil || cookie.Value != state {
+		return c.JSON(http.StatusBadRequest, customerror.NewError(ErrState))
+	}
+	setStateCookie(c, p, "", -1)
+	st, err := store.ConsumeState(ctx, identity.HashToken(state))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if st == nil || st.Provider != p.ID || !now().Before(st.ExpiresAt) {
+		return c.JSON(http.StatusBadRequest, customerror.NewError(ErrState))
+	}
+	if e := c.QueryParam("error"); e != "" {
+		return c.JSON(http.StatusUnauthorized, customerror.NewError(echo.NewHTTPError(http.StatusUnauthorized,
+			strings.TrimSpace("identity provider: "+e+" "+c.QueryParam("error_description")))))
+	}
+
+	m, err := p.discover(ctx)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, customerror.NewError(err))
+	}
+	raw, err := p.exchange(ctx, m, c.QueryParam("code"), st)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, customerror.NewError(err))
+	}
+	claims, err := p.verify(ctx, m, raw, st.Nonce)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, customerror.NewError(err))
+	}
+
+	u, err := resolveUser(ctx, p, claims)
+	switch err {
+	case nil:
+	case ErrNoAccount, ErrNoEmail, ErrEmailInUse:
+		return c.JSON(http.StatusForbidden, customerror.NewError(err))
+	default:
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if err := syncRoles(ctx, p, u, claims.Groups); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return complete(c, u)
+}
+
+// callbackURL is the redirect_uri sent to the provider.
+func (p *Provider) callbackURL(c echo.Context) string {
+	if p.RedirectURL != "" {
+		return p.RedirectURL
+	}
+	return c.Scheme() + "://" + c.Request().Host + c.Echo().Reverse("federation-callback", p.ID)
+}
+
+func setStateCookie(c echo.Context, p *Provider, value string, maxAge int) {
+	path := c.Echo().Reverse("federation-callback", p.ID)
+	if u, err := url.Parse(p.RedirectURL); err == nil && p.RedirectURL != "" {
+		path = u.Path
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     stateCookie,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		// Lax still sends the cookie on the provider's top-level redirect
+		// back to the callback.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func randomSuffix() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
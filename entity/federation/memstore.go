@@ -0,0 +1,101 @@
+package federation
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps links and sign-in states in process memory; nothing
+// survives a restart.
+type MemoryStore struct {
+	mu     sync.Mutex
+	links  map[primitive.ObjectID]Link
+	states map[string]State
+}
+
+// Verify Interface Compliance
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		links:  make(map[primitive.ObjectID]Link),
+		states: make(map[string]State),
+	}
+}
+
+func (fs *MemoryStore) GetLink(ctx context.Context, provider, subject string) (*Link, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, l := range fs.links {
+		if l.Provider == provider && l.Subject == subject {
+			return &l, nil
+		}
+	}
+	return nil, nil
+}
+
+func (fs *MemoryStore) CreateLink(ctx context.Context, l *Link) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, other := range fs.links {
+		if other.Provider == l.Provider && other.Subject == l.Subject {
+			return ErrLinked
+		}
+	}
+	fs.links[l.ID] = *l
+	return nil
+}
+
+func (fs *MemoryStore) DeleteLink(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	delete(fs.links, id)
+	return nil
+}
+
+func (fs *MemoryStore) CreateState(ctx context.Context, st *State) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// Sweep states whose users never came back.
+	t := now()
+	for hash, other := range fs.states {
+		if !t.Before(other.ExpiresAt) {
+			delete(fs.states, hash)
+		}
+	}
+	fs.states[st.Hash] = *st
+	return nil
+}
+
+func (fs *MemoryStore) ConsumeState(ctx context.Context, hash string) (*State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	st, ok := fs.states[hash]
+	if !ok {
+		return nil, nil
+	}
+	delete(fs.states, hash)
+	return &st, nil
+}
@@ -0,0 +1,26 @@
+package federation
+
+import "github.com/labstack/echo/v4"
+
+type providerResponse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// LoginURL starts a sign-in with the provider.
+	LoginURL string `json:"login_url"`
+}
+
+type providerListResponse struct {
+	Providers []providerResponse `json:"providers"`
+}
+
+func newProviderListResponse(c echo.Context, ps []*Provider) *providerListResponse {
+	r := &providerListResponse{Providers: make([]providerResponse, 0, len(ps))}
+	for _, p := range ps {
+		r.Providers = append(r.Providers, providerResponse{
+			ID:       p.ID,
+			Name:     p.Name,
+			LoginURL: c.Echo().Reverse("federation-login", p.ID),
+		})
+	}
+	return r
+}
@@ -0,0 +1,135 @@
+package federation
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlSchema is shared by SQLite and PostgreSQL.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS federated_identities (
+		id         TEXT PRIMARY KEY,
+		provider   TEXT NOT NULL,
+		subject    TEXT NOT NULL,
+		user_id    TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		UNIQUE (provider, subject)
+	)`,
+	`CREATE INDEX IF NOT EXISTS federated_identities_user ON federated_identities (user_id)`,
+	`CREATE TABLE IF NOT EXISTS federation_states (
+		hash         TEXT PRIMARY KEY,
+		provider     TEXT NOT NULL,
+		nonce        TEXT NOT NULL,
+		verifier     TEXT NOT NULL,
+		redirect_url TEXT NOT NULL,
+		expires_at   TIMESTAMP NOT NULL
+	)`,
+}
+
+type SQLStore struct {
+	dbProvider *db.DBProvider
+	db         *sql.DB
+}
+
+// Verify Interface Compliance
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore creates the federation schema if it does not exist yet.
+func NewSQLStore(dp *db.DBProvider) (*SQLStore, error) {
+	for _, stmt := range sqlSchema {
+		if _, err := dp.SQL.ExecContext(dp.Context, stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLStore{
+		dbProvider: dp,
+		db:         dp.SQL,
+	}, nil
+}
+
+func (fs *SQLStore) GetLink(ctx context.Context, provider, subject string) (*Link, error) {
+	ctx, cancel := fs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var (
+		l        Link
+		id, user string
+	)
+	err := fs.db.QueryRowContext(ctx,
+		`SELECT id, provider, subject, user_id, created_at FROM federated_identities
+			WHERE provider = $1 AND subject = $2`, provider, subject).
+		Scan(&id, &l.Provider, &l.Subject, &user, &l.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if l.UserID, err = primitive.ObjectIDFromHex(user); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (fs *SQLStore) CreateLink(ctx context.Context, l *Link) error {
+	ctx, cancel := fs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := fs.db.ExecContext(ctx,
+		`INSERT INTO federated_identities (id, provider, subject, user_id, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		l.ID.Hex(), l.Provider, l.Subject, l.UserID.Hex(), l.CreatedAt)
+	if db.IsUniqueViolation(err) {
+		return ErrLinked
+	}
+	return err
+}
+
+func (fs *SQLStore) DeleteLink(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := fs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := fs.db.ExecContext(ctx, `DELETE FROM federated_identities WHERE id = $1`, id.Hex())
+	return err
+}
+
+func (fs *SQLStore) CreateState(ctx context.Context, st *State) error {
+	ctx, cancel := fs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	// There is no TTL in SQL, so states whose users never came back are
+	// swept here.
+	if _, err := fs.db.ExecContext(ctx,
+		`DELETE FROM federation_states WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := fs.db.ExecContext(ctx,
+		`INSERT INTO federation_states (hash, provider, nonce, verifier, redirect_url, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+		st.Hash, st.Provider, st.Nonce, st.Verifier, st.RedirectURL, st.ExpiresAt)
+	return err
+}
+
+func (fs *SQLStore) ConsumeState(ctx context.Context, hash string) (*State, error) {
+	ctx, cancel := fs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	var st State
+	err := fs.db.QueryRowContext(ctx,
+		`DELETE FROM federation_states WHERE hash = $1
+			RETURNING hash, provider, nonce, verifier, redirect_url, expires_at`, hash).
+		Scan(&st.Hash, &st.Provider, &st.Nonce, &st.Verifier, &st.RedirectURL, &st.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
@@ -0,0 +1,119 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrLinked is returned by CreateLink when the upstream subject is
+// already linked to a user.
+var ErrLinked = errors.New("this upstream account is already linked")
+
+// Store is the persistence contract for federated identities and
+// sign-ins in progress. Lookups return (nil, nil) when nothing matches.
+// A provider and subject are linked to at most one user. ConsumeState
+// deletes the state and returns it, so a callback can only be used once;
+// it may return expired states that were not swept yet.
+type Store interface {
+	GetLink(ctx context.Context, provider, subject string) (*Link, error)
+	CreateLink(ctx context.Context, l *Link) error
+	DeleteLink(ctx context.Context, id primitive.ObjectID) error
+
+	CreateState(ctx context.Context, st *State) error
+	ConsumeState(ctx context.Context, hash string) (*State, error)
+}
+
+// NewStore returns the Store implementation for the provider's driver.
+func NewStore(dp *db.DBProvider) (Store, error) {
+	switch dp.Driver {
+	case db.Mongo:
+		return NewMongoStore(dp), nil
+	case db.SQLite, db.Postgres:
+		return NewSQLStore(dp)
+	case db.Memory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("federation: unsupported driver %q", dp.Driver)
+}
+
+// MongoStore relies on a unique index on federated_identities (provider,
+// subject) and a TTL index on federation_states.expires_at.
+type MongoStore struct {
+	dbProvider *db.DBProvider
+	links      *mongo.Collection
+	states     *mongo.Collection
+}
+
+// Verify Interface Compliance
+var _ Store = (*MongoStore)(nil)
+
+func NewMongoStore(dp *db.DBProvider) *MongoStore {
+	return &MongoStore{
+		dbProvider: dp,
+		links:      dp.GetCollection(db.FederatedIdentities),
+		states:     dp.GetCollection(db.FederationStates),
+	}
+}
+
+func (fs *MongoStore) GetLink(ctx context.Context, provider, subject string) (*Link, error) {
+	ctx, cancel := fs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var l Link
+	if err := fs.links.FindOne(ctx, bson.M{"provider": provider, "subject": subject}).Decode(&l); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (fs *MongoStore) CreateLink(ctx context.Context, l *Link) error {
+	ctx, cancel := fs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	if _, err := fs.links.InsertOne(ctx, l); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrLinked
+		}
+		return err
+	}
+	return nil
+}
+
+func (fs *MongoStore) DeleteLink(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := fs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := fs.links.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (fs *MongoStore) CreateState(ctx context.Context, st *State) error {
+	ctx, cancel := fs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := fs.states.InsertOne(ctx, st)
+	return err
+}
+
+func (fs *MongoStore) ConsumeState(ctx context.Context, hash string) (*State, error) {
+	ctx, cancel := fs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	var st State
+	if err := fs.states.FindOneAndDelete(ctx, bson.M{"_id": hash}).Decode(&st); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &st, nil
+}
@@ -0,0 +1,254 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/hamed-lohi/user-manage/identity"
+)
+
+// keyRefetch limits how often an unknown kid makes the provider's keys
+// be fetched again.
+const keyRefetch = time.Minute
+
+var errIDToken = errors.New("the identity provider returned an invalid ID token")
+
+// metadata is the part of the provider's discovery document a sign-in
+// needs.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// upstreamClaims are the claims of a verified upstream ID token.
+type upstreamClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Groups            []string
+}
+
+// discover returns the provider's metadata, fetching it on first use.
+// The document must name the configured issuer, as OpenID Connect
+// Discovery section 4.3 requires.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	var m metadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, err
+	}
+	if m.Issuer != p.Issuer {
+		return nil, fmt.Errorf("identity provider %s: discovery names issuer %q", p.ID, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("identity provider %s: discovery document is incomplete", p.ID)
+	}
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// key looks up a signing key of the provider by kid. Keys are fetched
+// again when kid is unknown, so the provider can rotate them; an empty
+// kid matches the only key if there is just one.
+func (p *Provider) key(ctx context.Context, m *metadata, kid string) (identity.JWK, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	if p.keys != nil && now().Sub(p.fetched) < keyRefetch {
+		return identity.JWK{}, identity.ErrUnknownKey
+	}
+	var set identity.JWKS
+	if err := p.getJSON(ctx, m.JWKSURI, &set); err != nil {
+		return identity.JWK{}, err
+	}
+	p.keys = make(map[string]identity.JWK, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use == "" || k.Use == "sig" {
+			p.keys[k.KeyID] = k
+		}
+	}
+	p.fetched = now()
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	return identity.JWK{}, identity.ErrUnknownKey
+}
+
+func (p *Provider) lookupKey(kid string) (identity.JWK, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+// exchange redeems an authorization code at the token endpoint and
+// returns the ID token. Confidential clients authenticate with HTTP
+// Basic, public ones send only client_id.
+func (p *Provider) exchange(ctx context.Context, m *metadata, code string, st *State) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {st.RedirectURL},
+		"code_verifier": {st.Verifier},
+	}
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("identity provider %s: token response: %w", p.ID, err)
+	}
+	if res.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("identity provider %s: token request failed: %s %s", p.ID, body.Error, body.Description)
+	}
+	if body.IDToken == "" {
+		return "", errIDToken
+	}
+	return body.IDToken, nil
+}
+
+// verify checks an ID token as OpenID Connect Core section 3.1.3.7
+// describes and returns its claims. Times get identity.Leeway.
+func (p *Provider) verify(ctx context.Context, m *metadata, raw, nonce string) (*upstreamClaims, error) {
+	parser := &jwt.Parser{
+		ValidMethods:         []string{identity.RS256, identity.ES256, identity.EdDSA},
+		SkipClaimsValidation: true,
+	}
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, err := p.key(ctx, m, kid)
+		if err != nil {
+			return nil, err
+		}
+		if k.Algorithm != "" && k.Algorithm != t.Method.Alg() {
+			return nil, identity.ErrUnknownKey
+		}
+		return k.PublicKey()
+	})
+	if err != nil {
+		return nil, errIDToken
+	}
+
+	t := now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || !t.Before(time.Unix(int64(exp), 0).Add(identity.Leeway)) {
+		return nil, errIDToken
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(t.Add(identity.Leeway)) {
+		return nil, errIDToken
+	}
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return nil, errIDToken
+	}
+	aud := stringList(claims["aud"])
+	if !contains(aud, p.ClientID) {
+		return nil, errIDToken
+	}
+	if azp, ok := claims["azp"].(string); (ok || len(aud) > 1) && azp != p.ClientID {
+		return nil, errIDToken
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errIDToken
+	}
+
+	uc := &upstreamClaims{Groups: stringList(claims[p.GroupsClaim])}
+	uc.Subject, _ = claims["sub"].(string)
+	uc.Email, _ = claims["email"].(string)
+	uc.PreferredUsername, _ = claims["preferred_username"].(string)
+	// Some providers send email_verified as a string.
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		uc.EmailVerified = v
+	case string:
+		uc.EmailVerified = v == "true"
+	}
+	if uc.Subject == "" {
+		return nil, errIDToken
+	}
+	return uc, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("identity provider %s: GET %s: %s", p.ID, u, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// stringList reads a claim that holds a string or a list of strings.
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
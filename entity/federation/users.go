@@ -0,0 +1,127 @@
+package federation
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// resolveUser finds the user an upstream account belongs to: the linked
+// user, else the user with the same email if the provider vouches for
+// it, else a new user if the provider may create them. A link to a
+// deleted user is dropped.
+func resolveUser(ctx context.Context, p *Provider, claims *upstreamClaims) (*user.User, error) {
+	l, err := store.GetLink(ctx, p.ID, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if l != nil {
+		u, err := users.GetByID(ctx, l.UserID)
+		if err != nil || u != nil {
+			return u, err
+		}
+		if err := store.DeleteLink(ctx, l.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if claims.Email != "" {
+		u, err := users.GetByEmail(ctx, claims.Email)
+		if err != nil {
+			return nil, err
+		}
+		if u != nil {
+			if !claims.EmailVerified && !p.TrustEmail {
+				return nil, ErrEmailInUse
+			}
+			return link(ctx, p, claims, u)
+		}
+	}
+	if !p.CreateUsers {
+		return nil, ErrNoAccount
+	}
+	if claims.Email == "" {
+		return nil, ErrNoEmail
+	}
+
+	u := &user.User{
+		Username: username(claims),
+		Email:    claims.Email,
+		Bio:      new(string),
+		Roles:    []identity.Role{identity.Guest},
+	}
+	// The user signs in upstream; nobody knows this password.
+	secret, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	if err := u.SetPassword(secret); err != nil {
+		return nil, err
+	}
+	err = users.Create(ctx, u)
+	if err == user.ErrDuplicate {
+		// Usernames are only a suggestion from upstream; the email was
+		// checked above.
+		u.Username += "-" + randomSuffix()
+		err = users.Create(ctx, u)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return link(ctx, p, claims, u)
+}
+
+// link records that the upstream account belongs to u. If another
+// sign-in linked it first, that link wins.
+func link(ctx context.Context, p *Provider, claims *upstreamClaims, u *user.User) (*user.User, error) {
+	err := store.CreateLink(ctx, &Link{
+		ID:        primitive.NewObjectID(),
+		Provider:  p.ID,
+		Subject:   claims.Subject,
+		UserID:    u.ID,
+		CreatedAt: now().UTC(),
+	})
+	if err == ErrLinked {
+		l, err := store.GetLink(ctx, p.ID, claims.Subject)
+		if err != nil || l == nil {
+			return nil, err
+		}
+		return users.GetByID(ctx, l.UserID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// username suggests a username for a new user.
+func username(claims *upstreamClaims) string {
+	if claims.PreferredUsername != "" {
+		return claims.PreferredUsername
+	}
+	if i := strings.IndexByte(claims.Email, '@'); i > 0 {
+		return claims.Email[:i]
+	}
+	return claims.Email
+}
+
+// syncRoles keeps the roles the provider maps groups to in line with the
+// user's upstream groups. Losing a role logs the user out everywhere
+// else, since issued tokens carry roles.
+func syncRoles(ctx context.Context, p *Provider, u *user.User, groups []string) error {
+	roles, changed, removed := identity.SyncGroupRoles(u.Roles, p.GroupRoles, groups)
+	if !changed {
+		return nil
+	}
+	u.Roles = roles
+	if err := users.Update(ctx, u); err != nil {
+		return err
+	}
+	if removed {
+		return refresh.RevokeUser(ctx, u.ID)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package mfa
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// VerifyLogin godoc
+// @Summary Finish a login with a second factor
+// @Description Send the challenge a login answered with and the answer of one of its methods: a current code of the user's authenticator app for totp, an unused recovery code for recovery, or the PublicKeyCredential of a passkey for passkey. The response is the same as for a login without a second factor. A challenge lasts five minutes and takes five answers.
+// @ID login-mfa
+// @Tags mfa
+// @Accept  json
+// @Produce  json
+// @Param login body loginRequest true "Challenge and answer"
+// @Success 200 {object} user.userResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Router /users/login/mfa [post]
+func VerifyLogin(c echo.Context) error {
+	req := &loginRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	u, err := manager.Finish(c.Request().Context(), req.Challenge, req.answer())
+	switch err {
+	case nil:
+	case ErrChallenge, ErrCode:
+		return c.JSON(http.StatusUnauthorized, customerror.NewError(err))
+	case ErrMethod:
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	default:
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return complete(c, u)
+}
+
+// GetStatus godoc
+// @Summary Show own two-factor authentication
+// @Description Tell whether TOTP is disabled, waiting to be enabled or enabled for the current user, which second factors logins ask for and how many recovery codes are left.
+// @ID get-mfa
+// @Tags mfa
+// @Produce  json
+// @Success 200 {object} statusResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/mfa [get]
+func GetStatus(c echo.Context) error {
+	return status(c, userIDFromToken(c))
+}
+
+func status(c echo.Context, userID primitive.ObjectID) error {
+	ctx := c.Request().Context()
+	t, err := manager.Status(ctx, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	methods, err := manager.Methods(ctx, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	left, err := manager.RecoveryCodesLeft(ctx, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, newStatusResponse(t, methods, left))
+}
+
+// Enroll godoc
+// @Summary Start TOTP enrollment
+// @Description Create a new TOTP secret for the current user, replacing one that was not enabled yet. Add it to an authenticator app by its otpauth:// URI or QR code, then enable it with the first code the app shows.
+// @ID enroll-totp
+// @Tags mfa
+// @Produce  json
+// @Success 201 {object} enrollmentResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 409 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/mfa/totp [post]
+func Enroll(c echo.Context) error {
+	ctx := c.Request().Context()
+	u, err := manager.users.GetByID(ctx, userIDFromToken(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if u == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	secret, uri, err := manager.Enroll(ctx, u)
+	if err == ErrEnrolled {
+		return c.JSON(http.StatusConflict, customerror.NewError(err))
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusCreated, newEnrollmentResponse(secret, uri, png))
+}
+
+// EnrollmentQRCode godoc
+// @Summary Show the QR code of a pending TOTP secret
+// @Description The QR code of the secret waiting to be enabled, as a PNG image.
+// @ID totp-qr-code
+// @Tags mfa
+// @Produce  png
+// @Success 200 {file} binary
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/mfa/totp/qr [get]
+func EnrollmentQRCode(c echo.Context) error {
+	ctx := c.Request().Context()
+	u, err := manager.users.GetByID(ctx, userIDFromToken(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	t, err := manager.Status(ctx, userIDFromToken(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	// The secret is only shown while it waits to be enabled.
+	if u == nil || t == nil || t.Enabled {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	png, err := qrcode.Encode(manager.keyURI(u.Email, t.Secret), qrcode.Medium, 256)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Stream(http.StatusOK, "image/png", bytes.NewReader(png))
+}
+
+// Enable godoc
+// @Summary Enable TOTP
+// @Description Turn on the pending TOTP secret of the current user with a code it generated. From then on, logins ask for a code after the password.
+// @ID enable-totp
+// @Tags mfa
+// @Accept  json
+// @Produce  json
+// @Param code body codeRequest true "Current code"
+// @Success 200 {object} statusResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 409 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/mfa/totp/enable [post]
+func Enable(c echo.Context) error {
+	req := &codeRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	switch err := manager.Enable(c.Request().Context(), userIDFromToken(c), req.Code); err {
+	case nil:
+	case ErrCode:
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	case ErrEnrolled, ErrNotPending:
+		return c.JSON(http.StatusConflict, customerror.NewError(err))
+	default:
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return status(c, userIDFromToken(c))
+}
+
+// Disable godoc
+// @Summary Disable TOTP
+// @Description Turn off TOTP for the current user with a current code. Recovery codes are removed as well unless another second factor remains. Users who lost their device ask an admin for a reset instead.
+// @ID disable-totp
+// @Tags mfa
+// @Accept  json
+// @Param code body codeRequest true "Current code"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 409 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/mfa/totp/disable [post]
+func Disable(c echo.Context) error {
+	req := &codeRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	switch err := manager.Disable(c.Request().Context(), userIDFromToken(c), req.Code); err {
+	case nil:
+	case ErrCode:
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	case ErrNotEnrolled:
+		return c.JSON(http.StatusConflict, customerror.NewError(err))
+	default:
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GenerateRecoveryCodes godoc
+// @Summary Generate recovery codes
+// @Description Create ten single-use recovery codes for the current user, who must have a second factor, and invalidate any earlier ones. A code stands in for the second factor at /users/login/mfa. The codes are only shown now; store them somewhere safe.
+// @ID generate-recovery-codes
+// @Tags mfa
+// @Produce  json
+// @Success 201 {object} recoveryCodesResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 409 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/mfa/recovery-codes [post]
+func GenerateRecoveryCodes(c echo.Context) error {
+	codes, err := manager.GenerateRecoveryCodes(c.Request().Context(), userIDFromToken(c))
+	if err == ErrNotEnrolled {
+		return c.JSON(http.StatusConflict, customerror.NewError(err))
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusCreated, &recoveryCodesResponse{Codes: codes})
+}
+
+// Reset godoc
+// @Summary Reset a user's two-factor authentication
+// @Description Remove the TOTP secret and recovery codes of a user, for example one who lost their device, and their passkeys where passkeys count as a second factor. Their next login needs only the password. The user must hold no permission the caller lacks.
+// @ID reset-mfa
+// @Tags mfa
+// @Param        id   path      string  true  "User ID"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/{id}/mfa [delete]
+func Reset(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	ctx := c.Request().Context()
+	u, err := manager.users.GetByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if u == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if done, err := user.RequireAccess(c, identity.MFAReset, u); done {
+		return err
+	}
+	if err := manager.Reset(ctx, id); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func userIDFromToken(c echo.Context) primitive.ObjectID {
+	id, _ := c.Get("user").(primitive.ObjectID)
+	return id
+}
@@ -0,0 +1,195 @@
+package mfa
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps TOTP secrets, challenges and recovery codes in
+// process memory; nothing survives a restart.
+type MemoryStore struct {
+	mu            sync.Mutex
+	secrets       map[primitive.ObjectID]TOTP
+	challenges    map[string]Challenge
+	recoveryCodes map[primitive.ObjectID]map[string]bool
+}
+
+// Verify Interface Compliance
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		secrets:       make(map[primitive.ObjectID]TOTP),
+		challenges:    make(map[string]Challenge),
+		recoveryCodes: make(map[primitive.ObjectID]map[string]bool),
+	}
+}
+
+func (ms *MemoryStore) GetTOTP(ctx context.Context, userID primitive.ObjectID) (*TOTP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	t, ok := ms.secrets[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+func (ms *MemoryStore) CreatePendingTOTP(ctx context.Context, t *TOTP) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.secrets[t.UserID].Enabled {
+		return false, nil
+	}
+	ms.secrets[t.UserID] = *t
+	return true, nil
+}
+
+func (ms *MemoryStore) EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	t, ok := ms.secrets[userID]
+	if !ok || t.Enabled || t.Secret != secret {
+		return false, nil
+	}
+	t.Enabled = true
+	ms.secrets[userID] = t
+	return true, nil
+}
+
+func (ms *MemoryStore) UseStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	t, ok := ms.secrets[userID]
+	if !ok || t.LastStep >= step {
+		return false, nil
+	}
+	t.LastStep = step
+	ms.secrets[userID] = t
+	return true, nil
+}
+
+func (ms *MemoryStore) DeleteTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.secrets, userID)
+	return nil
+}
+
+func (ms *MemoryStore) CreateChallenge(ctx context.Context, ch *Challenge) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	// Sweep challenges nobody answered.
+	now := time.Now()
+	for hash, other := range ms.challenges {
+		if !now.Before(other.ExpiresAt) {
+			delete(ms.challenges, hash)
+		}
+	}
+	ms.challenges[ch.Hash] = *ch
+	return nil
+}
+
+func (ms *MemoryStore) AttemptChallenge(ctx context.Context, hash string) (*Challenge, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ch, ok := ms.challenges[hash]
+	if !ok {
+		return nil, nil
+	}
+	ch.Attempts++
+	ms.challenges[hash] = ch
+	return &ch, nil
+}
+
+func (ms *MemoryStore) DeleteChallenge(ctx context.Context, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.challenges, hash)
+	return nil
+}
+
+func (ms *MemoryStore) SetRecoveryCodes(ctx context.Context, userID primitive.ObjectID, hashes []string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	codes := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		codes[h] = true
+	}
+	ms.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (ms *MemoryStore) UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if !ms.recoveryCodes[userID][hash] {
+		return false, nil
+	}
+	delete(ms.recoveryCodes[userID], hash)
+	return true, nil
+}
+
+func (ms *MemoryStore) CountRecoveryCodes(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return len(ms.recoveryCodes[userID]), nil
+}
+
+func (ms *MemoryStore) DeleteRecoveryCodes(ctx context.Context, userID primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.recoveryCodes, userID)
+	return nil
+}
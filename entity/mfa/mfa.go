@@ -0,0 +1,435 @@
+// Package mfa adds time-based one-time passwords (TOTP, RFC 6238) as a
+// second step of password logins.
+//
+// A user enrolls by fetching a new secret, as text, as an otpauth:// URI
+// and as a QR code for authenticator apps, and turns it on by sending the
+// first code it generates. From then on a correct password only earns a
+// short-lived challenge; the login finishes at /users/login/mfa with the
+// challenge and a current code. Each code is accepted once, and a
+// challenge gives up after a few wrong codes. Admins can reset the second
+// factor of users who lost their device.
+//
+// Other second factors, such as passkeys, plug in as a Method. A
+// challenge lists every method the user can answer with and the options
+// each needs; the answer names the method it is for. Users with a second
+// factor can also keep a set of single-use recovery codes for when they
+// lose it; using one is recorded as an audit event.
+package mfa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// challengeLifetime bounds how long the second step may take.
+	challengeLifetime = 5 * time.Minute
+	// maxAttempts is the number of codes a challenge takes.
+	maxAttempts = 5
+)
+
+var (
+	ErrEnrolled    = errors.New("two-factor authentication is already enabled")
+	ErrNotEnrolled = errors.New("two-factor authentication is not set up")
+	ErrNotPending  = errors.New("start a new enrollment first")
+	ErrCode        = errors.New("the code is wrong or was already used")
+	ErrChallenge   = errors.New("the login expired or took too many wrong codes; log in again")
+	ErrMethod      = errors.New("this second factor is not available for the user")
+)
+
+// MethodTOTP names TOTP among the methods of a challenge.
+const MethodTOTP = "totp"
+
+var (
+	manager  *Manager
+	complete func(c echo.Context, u *user.User) error
+)
+
+// TOTP is a user's TOTP secret. It takes codes once Enabled is set, which
+// happens when the user proves their app generates them. LastStep is the
+// time step of the last code accepted; no code of that step or an earlier
+// one is accepted again.
+type TOTP struct {
+	UserID    primitive.ObjectID `bson:"_id"`
+	Secret    string             `bson:"secret"`
+	Enabled   bool               `bson:"enabled"`
+	LastStep  int64              `bson:"last_step"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// Challenge is a login that got the password right and waits for a code.
+// Only the SHA-256 of the challenge token is stored. Used names the method
+// that took the first step instead of a password, such as a passkey; it
+// cannot answer the challenge as well.
+type Challenge struct {
+	Hash      string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Used      string             `bson:"used,omitempty"`
+	Attempts  int                `bson:"attempts"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// Method is a second factor other than TOTP. Options returns what a
+// client needs to answer a challenge with the method, Verify checks that
+// answer, and Reset removes the user's credentials of the method.
+type Method interface {
+	Name() string
+	Enabled(ctx context.Context, userID primitive.ObjectID) (bool, error)
+	Options(ctx context.Context, u *user.User) (interface{}, error)
+	Verify(ctx context.Context, u *user.User, answer json.RawMessage) (bool, error)
+	Reset(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// Answer is the second step of a login: a TOTP code or recovery code, or
+// the answer of another method in Response. An empty Method means TOTP.
+type Answer struct {
+	Method   string
+	Code     string
+	Response json.RawMessage
+}
+
+// Manager enrolls users and checks their codes. It is the
+// user.SecondFactor of password logins.
+type Manager struct {
+	store   Store
+	users   user.Store
+	methods []Method
+	// Issuer names the service in authenticator apps.
+	issuer string
+	now    func() time.Time
+}
+
+// Verify Interface Compliance
+var _ user.SecondFactor = (*Manager)(nil)
+
+func NewManager(s Store, us user.Store, issuer string, methods ...Method) *Manager {
+	return &Manager{store: s, users: us, methods: methods, issuer: issuer, now: time.Now}
+}
+
+// Required is true once the user enabled TOTP or another method.
+func (m *Manager) Required(ctx context.Context, u *user.User) (bool, error) {
+	return m.RequiredAfter(ctx, u, "")
+}
+
+// RequiredAfter is Required for a login whose first step was the method
+// used: that method does not count.
+func (m *Manager) RequiredAfter(ctx context.Context, u *user.User, used string) (bool, error) {
+	names, err := m.factors(ctx, u.ID, used)
+	return len(names) > 0, err
+}
+
+// Methods lists the methods the user can answer a challenge with: their
+// second factors and, if they have any of those, recovery codes while
+// some are left.
+func (m *Manager) Methods(ctx context.Context, userID primitive.ObjectID) ([]string, error) {
+	return m.methodsAfter(ctx, userID, "")
+}
+
+// methodsAfter is Methods without the method used for the first step.
+func (m *Manager) methodsAfter(ctx context.Context, userID primitive.ObjectID, used string) ([]string, error) {
+	names, err := m.factors(ctx, userID, used)
+	if err != nil || len(names) == 0 {
+		return names, err
+	}
+	left, err := m.store.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if left > 0 {
+		names = append(names, MethodRecovery)
+	}
+	return names, nil
+}
+
+// factors lists the second factors the user enabled, but for the one
+// called used.
+func (m *Manager) factors(ctx context.Context, userID primitive.ObjectID, used string) ([]string, error) {
+	var names []string
+	t, err := m.store.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil && t.Enabled {
+		names = append(names, MethodTOTP)
+	}
+	for _, mt := range m.methods {
+		ok, err := mt.Enabled(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if ok && mt.Name() != used {
+			names = append(names, mt.Name())
+		}
+	}
+	return names, nil
+}
+
+// Challenge answers the login with 202, a challenge token to send with
+// the answer and the options of each method the user can answer with.
+func (m *Manager) Challenge(c echo.Context, u *user.User) error {
+	return m.ChallengeAfter(c, u, "")
+}
+
+// ChallengeAfter is Challenge for a login whose first step was the method
+// used, such as a passwordless passkey login. The challenge leaves that
+// method out, so the user proves another factor.
+func (m *Manager) ChallengeAfter(c echo.Context, u *user.User, used string) error {
+	raw, names, options, err := m.begin(c.Request().Context(), u, used)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusAccepted, newChallengeResponse(raw, names, options))
+}
+
+// Begin starts a challenge for u. It returns the challenge token, the
+// methods u can answer with and, by method, the options of those that
+// need any. Finish takes the answer.
+func (m *Manager) Begin(ctx context.Context, u *user.User) (raw string, methods []string, options map[string]interface{}, err error) {
+	return m.begin(ctx, u, "")
+}
+
+func (m *Manager) begin(ctx context.Context, u *user.User, used string) (raw string, methods []string, options map[string]interface{}, err error) {
+	methods, err = m.methodsAfter(ctx, u.ID, used)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	options = make(map[string]interface{})
+	for _, name := range methods {
+		mt := m.method(name)
+		if mt == nil {
+			continue
+		}
+		if options[name], err = mt.Options(ctx, u); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	if raw, err = newChallengeToken(); err != nil {
+		return "", nil, nil, err
+	}
+	ch := &Challenge{
+		Hash:      identity.HashToken(raw),
+		UserID:    u.ID,
+		Used:      used,
+		ExpiresAt: m.now().Add(challengeLifetime).UTC(),
+	}
+	if err := m.store.CreateChallenge(ctx, ch); err != nil {
+		return "", nil, nil, err
+	}
+	return raw, methods, options, nil
+}
+
+// method returns the Method called name, or nil for TOTP, recovery codes
+// and unknown names.
+func (m *Manager) method(name string) Method {
+	for _, mt := range m.methods {
+		if mt.Name() == name {
+			return mt
+		}
+	}
+	return nil
+}
+
+// Verify checks code against the user's enabled TOTP.
+func (m *Manager) Verify(ctx context.Context, u *user.User, code string) (bool, error) {
+	t, err := m.store.GetTOTP(ctx, u.ID)
+	if err != nil || t == nil || !t.Enabled {
+		return false, err
+	}
+	return m.check(ctx, t, code)
+}
+
+// RegisterHandlers mounts enrollment, the second login step and the admin
+// reset. done finishes a login once the code is right, normally
+// user.CompleteLogin.
+func RegisterHandlers(v1 *echo.Group, auth echo.MiddlewareFunc, m *Manager, done func(c echo.Context, u *user.User) error) {
+
+	manager = m
+	complete = done
+
+	v1.POST("/users/login/mfa", VerifyLogin)
+
+	own := v1.Group("/user/mfa", auth, identity.RequireLogin())
+	own.GET("", GetStatus)
+	own.POST("/totp", Enroll)
+	own.GET("/totp/qr", EnrollmentQRCode)
+	own.POST("/totp/enable", Enable)
+	own.POST("/totp/disable", Disable)
+	own.POST("/recovery-codes", GenerateRecoveryCodes)
+
+	v1.DELETE("/user/:id/mfa", Reset, auth, identity.RequirePermission(identity.MFAReset))
+}
+
+// Status returns the user's TOTP secret, or nil if there is none.
+func (m *Manager) Status(ctx context.Context, userID primitive.ObjectID) (*TOTP, error) {
+	return m.store.GetTOTP(ctx, userID)
+}
+
+// Enroll gives u a new pending secret in place of any earlier pending
+// one. It fails with ErrEnrolled once TOTP is enabled.
+func (m *Manager) Enroll(ctx context.Context, u *user.User) (secret, uri string, err error) {
+	if secret, err = newSecret(); err != nil {
+		return "", "", err
+	}
+	ok, err := m.store.CreatePendingTOTP(ctx, &TOTP{
+		UserID:    u.ID,
+		Secret:    secret,
+		CreatedAt: m.now().UTC(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if !ok {
+		return "", "", ErrEnrolled
+	}
+	return secret, m.keyURI(u.Email, secret), nil
+}
+
+// Enable turns on the pending secret of the user if code comes from it.
+func (m *Manager) Enable(ctx context.Context, userID primitive.ObjectID, code string) error {
+	t, err := m.store.GetTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case t == nil:
+		return ErrNotPending
+	case t.Enabled:
+		return ErrEnrolled
+	}
+	ok, err := m.check(ctx, t, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCode
+	}
+	if ok, err = m.store.EnableTOTP(ctx, userID, t.Secret); err != nil {
+		return err
+	}
+	if !ok {
+		// The user started another enrollment meanwhile.
+		return ErrNotPending
+	}
+	return nil
+}
+
+// Disable turns TOTP off for the user if code is current. Recovery codes
+// go with it unless another second factor remains for them to stand in
+// for.
+func (m *Manager) Disable(ctx context.Context, userID primitive.ObjectID, code string) error {
+	t, err := m.store.GetTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if t == nil || !t.Enabled {
+		return ErrNotEnrolled
+	}
+	ok, err := m.check(ctx, t, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCode
+	}
+	if err := m.store.DeleteTOTP(ctx, userID); err != nil {
+		return err
+	}
+	others, err := m.factors(ctx, userID, "")
+	if err != nil || len(others) > 0 {
+		return err
+	}
+	return m.store.DeleteRecoveryCodes(ctx, userID)
+}
+
+// Reset removes the user's TOTP secret, enabled or not, their recovery
+// codes and their credentials of every other method, without a code.
+func (m *Manager) Reset(ctx context.Context, userID primitive.ObjectID) error {
+	if err := m.store.DeleteTOTP(ctx, userID); err != nil {
+		return err
+	}
+	if err := m.store.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return err
+	}
+	for _, mt := range m.methods {
+		if err := mt.Reset(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finish checks the answer to a challenge and returns the user that may
+// log in. A challenge ends when it is answered, when it expires and after
+// maxAttempts answers; ErrChallenge says that it did, ErrCode that the
+// answer was wrong but the challenge takes another.
+func (m *Manager) Finish(ctx context.Context, raw string, a *Answer) (*user.User, error) {
+	hash := identity.HashToken(raw)
+	ch, err := m.store.AttemptChallenge(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if ch == nil {
+		return nil, ErrChallenge
+	}
+	if ch.Attempts > maxAttempts || !m.now().Before(ch.ExpiresAt) {
+		return nil, m.endChallenge(ctx, hash, ErrChallenge)
+	}
+	u, err := m.users.GetByID(ctx, ch.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, m.endChallenge(ctx, hash, ErrChallenge)
+	}
+	if ch.Used != "" && a.Method == ch.Used {
+		return nil, ErrMethod
+	}
+	ok, err := m.verifyAnswer(ctx, u, a)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if ch.Attempts >= maxAttempts {
+			return nil, m.endChallenge(ctx, hash, ErrChallenge)
+		}
+		return nil, ErrCode
+	}
+	return u, m.endChallenge(ctx, hash, nil)
+}
+
+func (m *Manager) verifyAnswer(ctx context.Context, u *user.User, a *Answer) (bool, error) {
+	switch a.Method {
+	case "", MethodTOTP:
+		return m.Verify(ctx, u, a.Code)
+	case MethodRecovery:
+		return m.useRecoveryCode(ctx, u, a.Code)
+	}
+	mt := m.method(a.Method)
+	if mt == nil {
+		return false, ErrMethod
+	}
+	ok, err := mt.Enabled(ctx, u.ID)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, ErrMethod
+	}
+	return mt.Verify(ctx, u, a.Response)
+}
+
+func (m *Manager) endChallenge(ctx context.Context, hash string, reason error) error {
+	if err := m.store.DeleteChallenge(ctx, hash); err != nil {
+		return err
+	}
+	return reason
+}
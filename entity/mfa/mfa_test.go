@@ -0,0 +1,311 @@
+package mfa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/audit"
+	"github.com/hamed-lohi/user-manage/entity/role"
+	"github.com/hamed-lohi/user-manage/entity/token"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+type testValidator struct{ v *validator.Validate }
+
+func (tv testValidator) Validate(i interface{}) error { return tv.v.Struct(i) }
+
+// stubMethod is a second factor that users have once enabled is set for
+// them and that takes "yes" as its answer.
+type stubMethod struct {
+	enabled map[primitive.ObjectID]bool
+}
+
+func (s *stubMethod) Name() string { return "stub" }
+
+func (s *stubMethod) Enabled(ctx context.Context, userID primitive.ObjectID) (bool, error) {
+	return s.enabled[userID], nil
+}
+
+func (s *stubMethod) Options(ctx context.Context, u *user.User) (interface{}, error) {
+	return nil, nil
+}
+
+func (s *stubMethod) Verify(ctx context.Context, u *user.User, answer json.RawMessage) (bool, error) {
+	return string(answer) == `"yes"`, nil
+}
+
+func (s *stubMethod) Reset(ctx context.Context, userID primitive.ObjectID) error {
+	delete(s.enabled, userID)
+	return nil
+}
+
+// testServer is the login and second factor routes on memory stores, with
+// alice, whose password is "secret", as the only user. Its clock stands
+// still at the start of a TOTP step until a test moves it.
+type testServer struct {
+	e      *echo.Echo
+	m      *Manager
+	store  *MemoryStore
+	stub   *stubMethod
+	alice  *user.User
+	clock  time.Time
+	secret []byte
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ts := &testServer{
+		e:      echo.New(),
+		store:  NewMemoryStore(),
+		stub:   &stubMethod{enabled: make(map[primitive.ObjectID]bool)},
+		clock:  time.Unix(time.Now().Unix()/period*period, 0),
+		secret: []byte("12345678901234567890"),
+	}
+	ts.e.Validator = testValidator{validator.New()}
+
+	us := user.NewMemoryStore()
+	ts.alice = &user.User{Username: "alice", Email: "alice@example.com", Roles: []identity.Role{identity.Member}}
+	ts.alice.SetPassword("secret")
+	if err := us.Create(context.Background(), ts.alice); err != nil {
+		t.Fatal(err)
+	}
+	ts.m = NewManager(ts.store, us, "test", ts.stub)
+	ts.m.now = func() time.Time { return ts.clock }
+	rm := token.NewManager(token.NewMemoryStore(), time.Hour)
+	auth := identity.JWTWithConfig(identity.JWTConfig{Keys: identity.Keys, Revocations: rm})
+
+	v1 := ts.e.Group("/api")
+	user.RegisterHandlers(v1, auth, us, role.NewMemoryStore(), rm, user.NewPasswordAuthenticator(us), ts.m)
+	RegisterHandlers(v1, auth, ts.m, user.CompleteLogin)
+	return ts
+}
+
+// call sends a JSON request and decodes the JSON response into out, if
+// given. It returns the status code.
+func (ts *testServer) call(t *testing.T, method, path, token string, body, out interface{}) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	ts.e.ServeHTTP(rec, req)
+	if out != nil && rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, path, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+// enableTOTP gives alice an enabled TOTP secret.
+func (ts *testServer) enableTOTP(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	secret := encoding.EncodeToString(ts.secret)
+	if _, err := ts.store.CreatePendingTOTP(ctx, &TOTP{UserID: ts.alice.ID, Secret: secret}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.store.EnableTOTP(ctx, ts.alice.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// code is alice's TOTP code for the step steps away from the clock.
+func (ts *testServer) code(steps int64) string {
+	return hotp(ts.secret, ts.clock.Unix()/period+steps)
+}
+
+type loginResult struct {
+	Token     string   `json:"token"`
+	Challenge string   `json:"challenge"`
+	Methods   []string `json:"methods"`
+}
+
+// login sends alice's password.
+func (ts *testServer) login(t *testing.T) *loginResult {
+	t.Helper()
+	res := &loginResult{}
+	body := map[string]interface{}{"user": map[string]string{"email": "alice@example.com", "password": "secret"}}
+	if code := ts.call(t, http.MethodPost, "/api/users/login", "", body, res); code != http.StatusOK && code != http.StatusAccepted {
+		t.Fatalf("login: status %d", code)
+	}
+	return res
+}
+
+// answer sends the second step of a login.
+func (ts *testServer) answer(t *testing.T, challenge string, body map[string]interface{}) (int, *loginResult) {
+	t.Helper()
+	body["challenge"] = challenge
+	res := &loginResult{}
+	return ts.call(t, http.MethodPost, "/api/users/login/mfa", "", body, res), res
+}
+
+// status returns alice's second factor status.
+func (ts *testServer) status(t *testing.T, token string) *statusResponse {
+	t.Helper()
+	res := &statusResponse{}
+	if code := ts.call(t, http.MethodGet, "/api/user/mfa", token, nil, res); code != http.StatusOK {
+		t.Fatalf("status: status %d", code)
+	}
+	return res
+}
+
+// recoveryCodes gives alice a new set of recovery codes.
+func (ts *testServer) recoveryCodes(t *testing.T, token string) []string {
+	t.Helper()
+	res := &recoveryCodesResponse{}
+	if code := ts.call(t, http.MethodPost, "/api/user/mfa/recovery-codes", token, nil, res); code != http.StatusCreated {
+		t.Fatalf("recovery codes: status %d", code)
+	}
+	return res.Codes
+}
+
+func TestDisableDropsRecoveryCodes(t *testing.T) {
+	for _, other := range []bool{false, true} {
+		ts := newTestServer(t)
+		token := ts.login(t).Token
+		ts.enableTOTP(t)
+		ts.stub.enabled[ts.alice.ID] = other
+		ts.recoveryCodes(t, token)
+
+		if code := ts.call(t, http.MethodPost, "/api/user/mfa/totp/disable", token, map[string]string{"code": ts.code(0)}, nil); code != http.StatusNoContent {
+			t.Fatalf("disable: status %d", code)
+		}
+		s := ts.status(t, token)
+		switch {
+		case s.TOTP != "disabled":
+			t.Errorf("TOTP %s after disabling it", s.TOTP)
+		case !other && s.RecoveryCodes != 0:
+			t.Errorf("%d recovery codes left without a second factor", s.RecoveryCodes)
+		case other && s.RecoveryCodes != recoveryCodeCount:
+			t.Errorf("%d recovery codes left with another second factor, want %d", s.RecoveryCodes, recoveryCodeCount)
+		}
+	}
+}
+
+func TestTOTPCodeWorksOnce(t *testing.T) {
+	ts := newTestServer(t)
+	ts.enableTOTP(t)
+
+	ch := ts.login(t)
+	if len(ch.Methods) != 1 || ch.Methods[0] != MethodTOTP || ch.Token != "" {
+		t.Fatalf("login with TOTP: %+v, want a challenge for totp", ch)
+	}
+	if code, res := ts.answer(t, ch.Challenge, map[string]interface{}{"code": ts.code(0)}); code != http.StatusOK || res.Token == "" {
+		t.Fatalf("current code: status %d", code)
+	}
+	// The same code, and any of an earlier step, fail within the step
+	// even on a new challenge; the next step's code does not.
+	ch = ts.login(t)
+	for _, steps := range []int64{0, -1} {
+		if code, _ := ts.answer(t, ch.Challenge, map[string]interface{}{"code": ts.code(steps)}); code != http.StatusUnauthorized {
+			t.Errorf("code of step %+d after step 0 was used: status %d, want 401", steps, code)
+		}
+	}
+	if code, _ := ts.answer(t, ch.Challenge, map[string]interface{}{"code": ts.code(1)}); code != http.StatusOK {
+		t.Errorf("code of the next step: status %d, want 200", code)
+	}
+}
+
+func TestChallengeTakesFiveAnswers(t *testing.T) {
+	for _, wrong := range []int{maxAttempts - 1, maxAttempts} {
+		ts := newTestServer(t)
+		ts.enableTOTP(t)
+		ch := ts.login(t)
+		for i := 0; i < wrong; i++ {
+			if code, _ := ts.answer(t, ch.Challenge, map[string]interface{}{"code": "000000"}); code != http.StatusUnauthorized {
+				t.Fatalf("wrong code %d: status %d, want 401", i+1, code)
+			}
+		}
+		code, _ := ts.answer(t, ch.Challenge, map[string]interface{}{"code": ts.code(0)})
+		if wrong < maxAttempts && code != http.StatusOK {
+			t.Errorf("current code after %d wrong ones: status %d, want 200", wrong, code)
+		}
+		if wrong == maxAttempts && code != http.StatusUnauthorized {
+			t.Errorf("current code after %d wrong ones: status %d, want 401", wrong, code)
+		}
+	}
+}
+
+func TestChallengeExpires(t *testing.T) {
+	for _, late := range []time.Duration{challengeLifetime - time.Second, challengeLifetime} {
+		ts := newTestServer(t)
+		ts.enableTOTP(t)
+		ch := ts.login(t)
+		ts.clock = ts.clock.Add(late)
+		code, _ := ts.answer(t, ch.Challenge, map[string]interface{}{"code": ts.code(0)})
+		if late < challengeLifetime && code != http.StatusOK {
+			t.Errorf("answer after %s: status %d, want 200", late, code)
+		}
+		if late == challengeLifetime && code != http.StatusUnauthorized {
+			t.Errorf("answer after %s: status %d, want 401", late, code)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.login(t).Token
+	ts.enableTOTP(t)
+
+	var events []audit.Event
+	log := audit.Log
+	audit.Log = func(e audit.Event) { events = append(events, e) }
+	t.Cleanup(func() { audit.Log = log })
+
+	old := ts.recoveryCodes(t, token)
+	codes := ts.recoveryCodes(t, token)
+	if len(codes) != recoveryCodeCount || ts.status(t, token).RecoveryCodes != recoveryCodeCount {
+		t.Fatalf("%d codes, want %d and no more left", len(codes), recoveryCodeCount)
+	}
+
+	ch := ts.login(t)
+	if len(ch.Methods) != 2 || ch.Methods[1] != MethodRecovery {
+		t.Fatalf("methods %v, want totp and recovery", ch.Methods)
+	}
+	if code, _ := ts.answer(t, ch.Challenge, map[string]interface{}{"method": MethodRecovery, "code": old[0]}); code != http.StatusUnauthorized {
+		t.Errorf("code of the replaced set: status %d, want 401", code)
+	}
+	// Codes may be typed without dashes and in capitals.
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))
+	if code, res := ts.answer(t, ch.Challenge, map[string]interface{}{"method": MethodRecovery, "code": typed}); code != http.StatusOK || res.Token == "" {
+		t.Fatalf("recovery code: status %d", code)
+	}
+	if code, _ := ts.answer(t, ts.login(t).Challenge, map[string]interface{}{"method": MethodRecovery, "code": codes[0]}); code != http.StatusUnauthorized {
+		t.Errorf("used recovery code: status %d, want 401", code)
+	}
+	if left := ts.status(t, token).RecoveryCodes; left != recoveryCodeCount-1 {
+		t.Errorf("%d codes left, want %d", left, recoveryCodeCount-1)
+	}
+
+	var used []audit.Event
+	for _, e := range events {
+		if e.Type == audit.RecoveryCodeUsed {
+			used = append(used, e)
+		}
+	}
+	if len(used) != 1 {
+		t.Fatalf("%d events for a used recovery code, want 1", len(used))
+	}
+	if e := used[0]; e.UserID != ts.alice.ID || !e.Time.Equal(ts.clock) || e.Details["remaining"] != recoveryCodeCount-1 {
+		t.Errorf("event %+v", e)
+	}
+}
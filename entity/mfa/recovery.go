@@ -0,0 +1,104 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+
+	"github.com/hamed-lohi/user-manage/audit"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MethodRecovery names recovery codes among the methods of a challenge.
+// They are offered to users who have a second factor and codes left, and
+// never make a second step required by themselves.
+const MethodRecovery = "recovery"
+
+const (
+	// recoveryCodeCount is the number of codes a set has.
+	recoveryCodeCount = 10
+	// recoveryCodeBytes makes codes of 16 base32 characters, shown in
+	// groups of four.
+	recoveryCodeBytes = 10
+)
+
+// GenerateRecoveryCodes gives the user a new set of recovery codes in place
+// of any earlier one and returns them; only their hashes are kept. It
+// fails with ErrNotEnrolled for users without a second factor.
+func (m *Manager) GenerateRecoveryCodes(ctx context.Context, userID primitive.ObjectID) ([]string, error) {
+	factors, err := m.factors(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(factors) == 0 {
+		return nil, ErrNotEnrolled
+	}
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = hashRecoveryCode(userID, codes[i])
+	}
+	now := m.now().UTC()
+	if err := m.store.SetRecoveryCodes(ctx, userID, hashes, now); err != nil {
+		return nil, err
+	}
+	audit.Record(audit.Event{
+		Time:    now,
+		Type:    audit.RecoveryCodesGenerated,
+		UserID:  userID,
+		Details: map[string]interface{}{"count": len(codes)},
+	})
+	return codes, nil
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes of the
+// user.
+func (m *Manager) RecoveryCodesLeft(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	return m.store.CountRecoveryCodes(ctx, userID)
+}
+
+// useRecoveryCode spends code if it is one of u's unused recovery codes.
+func (m *Manager) useRecoveryCode(ctx context.Context, u *user.User, code string) (bool, error) {
+	ok, err := m.store.UseRecoveryCode(ctx, u.ID, hashRecoveryCode(u.ID, code))
+	if err != nil || !ok {
+		return false, err
+	}
+	left, err := m.store.CountRecoveryCodes(ctx, u.ID)
+	if err != nil {
+		return false, err
+	}
+	audit.Record(audit.Event{
+		Time:    m.now().UTC(),
+		Type:    audit.RecoveryCodeUsed,
+		UserID:  u.ID,
+		Details: map[string]interface{}{"remaining": left},
+	})
+	return true, nil
+}
+
+// newRecoveryCode returns a random code such as "abcd-efgh-ijkl-mnop".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(encoding.EncodeToString(b))
+	groups := make([]string, 0, len(s)/4)
+	for i := 0; i < len(s); i += 4 {
+		groups = append(groups, s[i:i+4])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// hashRecoveryCode hashes a code the way users may type it: with or
+// without dashes and spaces, in either case. The user ID keeps equal
+// codes of different users apart.
+func hashRecoveryCode(userID primitive.ObjectID, code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return identity.HashToken(userID.Hex() + ":" + code)
+}
@@ -0,0 +1,40 @@
+package mfa
+
+import (
+	"encoding/json"
+
+	"github.com/labstack/echo/v4"
+)
+
+type codeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+func (r *codeRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+type loginRequest struct {
+	Challenge string `json:"challenge" validate:"required"`
+	// Method is one of the methods of the challenge; it defaults to totp.
+	Method string `json:"method"`
+	// Code answers the totp and recovery methods.
+	Code string `json:"code"`
+	// Credential answers other methods, such as the PublicKeyCredential
+	// of a passkey.
+	Credential json.RawMessage `json:"credential" swaggertype:"object"`
+}
+
+func (r *loginRequest) answer() *Answer {
+	return &Answer{Method: r.Method, Code: r.Code, Response: r.Credential}
+}
+
+func (r *loginRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
@@ -0,0 +1,70 @@
+package mfa
+
+import "encoding/base64"
+
+// challengeResponse answers a login that needs a second factor. Methods
+// lists the ones the user can answer with; Options holds what the client
+// needs for those other than totp, by method.
+type challengeResponse struct {
+	MFARequired bool                   `json:"mfa_required"`
+	Challenge   string                 `json:"challenge"`
+	ExpiresIn   int                    `json:"expires_in"`
+	Methods     []string               `json:"methods"`
+	Options     map[string]interface{} `json:"options,omitempty"`
+}
+
+func newChallengeResponse(raw string, methods []string, options map[string]interface{}) *challengeResponse {
+	return &challengeResponse{
+		MFARequired: true,
+		Challenge:   raw,
+		ExpiresIn:   int(challengeLifetime.Seconds()),
+		Methods:     methods,
+		Options:     options,
+	}
+}
+
+// enrollmentResponse carries a new secret in the forms apps take: typed
+// in, as an otpauth:// URI and as a base64 encoded PNG of its QR code.
+type enrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qr_code"`
+}
+
+func newEnrollmentResponse(secret, uri string, png []byte) *enrollmentResponse {
+	return &enrollmentResponse{
+		Secret: secret,
+		URI:    uri,
+		QRCode: base64.StdEncoding.EncodeToString(png),
+	}
+}
+
+type statusResponse struct {
+	// TOTP is one of disabled, pending or enabled.
+	TOTP string `json:"totp" enums:"disabled,pending,enabled"`
+	// Methods lists what the second step of a login takes, if there is
+	// one.
+	Methods []string `json:"methods"`
+	// RecoveryCodes is the number of unused recovery codes.
+	RecoveryCodes int `json:"recovery_codes"`
+}
+
+func newStatusResponse(t *TOTP, methods []string, recoveryCodes int) *statusResponse {
+	r := &statusResponse{TOTP: "pending", Methods: methods, RecoveryCodes: recoveryCodes}
+	switch {
+	case t == nil:
+		r.TOTP = "disabled"
+	case t.Enabled:
+		r.TOTP = "enabled"
+	}
+	if r.Methods == nil {
+		r.Methods = []string{}
+	}
+	return r
+}
+
+// recoveryCodesResponse carries a new set of recovery codes, which are
+// not shown again.
+type recoveryCodesResponse struct {
+	Codes []string `json:"codes"`
+}
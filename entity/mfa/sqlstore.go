@@ -0,0 +1,223 @@
+package mfa
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlSchema is shared by SQLite and PostgreSQL.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS totp_secrets (
+		user_id    TEXT PRIMARY KEY,
+		secret     TEXT NOT NULL,
+		enabled    BOOLEAN NOT NULL DEFAULT FALSE,
+		last_step  BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS mfa_challenges (
+		hash       TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		used       TEXT NOT NULL DEFAULT '',
+		attempts   INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS recovery_codes (
+		user_id    TEXT NOT NULL,
+		hash       TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, hash)
+	)`,
+}
+
+type SQLStore struct {
+	dbProvider *db.DBProvider
+	db         *sql.DB
+}
+
+// Verify Interface Compliance
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore creates the MFA schema if it does not exist yet.
+func NewSQLStore(dp *db.DBProvider) (*SQLStore, error) {
+	for _, stmt := range sqlSchema {
+		if _, err := dp.SQL.ExecContext(dp.Context, stmt); err != nil {
+			return nil, err
+		}
+	}
+	if err := dp.EnsureColumn(dp.Context, db.MFAChallenges, "used", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return nil, err
+	}
+	return &SQLStore{
+		dbProvider: dp,
+		db:         dp.SQL,
+	}, nil
+}
+
+func (ms *SQLStore) GetTOTP(ctx context.Context, userID primitive.ObjectID) (*TOTP, error) {
+	ctx, cancel := ms.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	t := TOTP{UserID: userID}
+	err := ms.db.QueryRowContext(ctx,
+		`SELECT secret, enabled, last_step, created_at FROM totp_secrets WHERE user_id = $1`, userID.Hex()).
+		Scan(&t.Secret, &t.Enabled, &t.LastStep, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (ms *SQLStore) CreatePendingTOTP(ctx context.Context, t *TOTP) (bool, error) {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ms.db.ExecContext(ctx,
+		`INSERT INTO totp_secrets (user_id, secret, enabled, last_step, created_at) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id) DO UPDATE SET
+				secret = excluded.secret, enabled = excluded.enabled,
+				last_step = excluded.last_step, created_at = excluded.created_at
+			WHERE NOT totp_secrets.enabled`,
+		t.UserID.Hex(), t.Secret, t.Enabled, t.LastStep, t.CreatedAt)
+	return applied(res, err)
+}
+
+func (ms *SQLStore) EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret string) (bool, error) {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ms.db.ExecContext(ctx,
+		`UPDATE totp_secrets SET enabled = TRUE WHERE user_id = $1 AND secret = $2 AND NOT enabled`,
+		userID.Hex(), secret)
+	return applied(res, err)
+}
+
+func (ms *SQLStore) UseStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ms.db.ExecContext(ctx,
+		`UPDATE totp_secrets SET last_step = $1 WHERE user_id = $2 AND last_step < $1`, step, userID.Hex())
+	return applied(res, err)
+}
+
+func (ms *SQLStore) DeleteTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ms.db.ExecContext(ctx, `DELETE FROM totp_secrets WHERE user_id = $1`, userID.Hex())
+	return err
+}
+
+func (ms *SQLStore) CreateChallenge(ctx context.Context, ch *Challenge) error {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	// There is no TTL in SQL, so challenges nobody answered are swept
+	// here.
+	if _, err := ms.db.ExecContext(ctx,
+		`DELETE FROM mfa_challenges WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := ms.db.ExecContext(ctx,
+		`INSERT INTO mfa_challenges (hash, user_id, used, attempts, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		ch.Hash, ch.UserID.Hex(), ch.Used, ch.Attempts, ch.ExpiresAt)
+	return err
+}
+
+func (ms *SQLStore) AttemptChallenge(ctx context.Context, hash string) (*Challenge, error) {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	var (
+		ch   = Challenge{Hash: hash}
+		user string
+	)
+	err := ms.db.QueryRowContext(ctx,
+		`UPDATE mfa_challenges SET attempts = attempts + 1 WHERE hash = $1
+			RETURNING user_id, used, attempts, expires_at`, hash).
+		Scan(&user, &ch.Used, &ch.Attempts, &ch.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ch.UserID, err = primitive.ObjectIDFromHex(user); err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+func (ms *SQLStore) DeleteChallenge(ctx context.Context, hash string) error {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ms.db.ExecContext(ctx, `DELETE FROM mfa_challenges WHERE hash = $1`, hash)
+	return err
+}
+
+func (ms *SQLStore) SetRecoveryCodes(ctx context.Context, userID primitive.ObjectID, hashes []string, at time.Time) error {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	// The old codes and the new ones must not be valid side by side.
+	tx, err := ms.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID.Hex()); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes (user_id, hash, created_at) VALUES ($1, $2, $3)`,
+			userID.Hex(), h, at); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (ms *SQLStore) UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) (bool, error) {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ms.db.ExecContext(ctx,
+		`DELETE FROM recovery_codes WHERE user_id = $1 AND hash = $2`, userID.Hex(), hash)
+	return applied(res, err)
+}
+
+func (ms *SQLStore) CountRecoveryCodes(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	ctx, cancel := ms.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var n int
+	err := ms.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1`, userID.Hex()).Scan(&n)
+	return n, err
+}
+
+func (ms *SQLStore) DeleteRecoveryCodes(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ms.db.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID.Hex())
+	return err
+}
+
+// applied reports whether a conditional write changed a row.
+func applied(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,220 @@
+package mfa
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store is the persistence contract for TOTP secrets and login
+// challenges. Lookups return (nil, nil) when nothing matches.
+//
+// The writes are conditional so that concurrent requests cannot undo each
+// other: CreatePendingTOTP replaces a pending secret but never an enabled
+// one, EnableTOTP only enables the secret the code was checked against,
+// and UseStep only moves LastStep forward. Each reports whether it
+// applied. AttemptChallenge counts an attempt and returns the challenge
+// with the count included; it may return expired challenges that were
+// not swept yet.
+//
+// SetRecoveryCodes replaces all recovery codes of a user at once, and
+// UseRecoveryCode removes one and reports whether it was there, so a code
+// works once even when sent twice at the same time.
+type Store interface {
+	GetTOTP(ctx context.Context, userID primitive.ObjectID) (*TOTP, error)
+	CreatePendingTOTP(ctx context.Context, t *TOTP) (bool, error)
+	EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret string) (bool, error)
+	UseStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error)
+	DeleteTOTP(ctx context.Context, userID primitive.ObjectID) error
+
+	CreateChallenge(ctx context.Context, ch *Challenge) error
+	AttemptChallenge(ctx context.Context, hash string) (*Challenge, error)
+	DeleteChallenge(ctx context.Context, hash string) error
+
+	SetRecoveryCodes(ctx context.Context, userID primitive.ObjectID, hashes []string, at time.Time) error
+	UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID primitive.ObjectID) (int, error)
+	DeleteRecoveryCodes(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// NewStore returns the Store implementation for the provider's driver.
+func NewStore(dp *db.DBProvider) (Store, error) {
+	switch dp.Driver {
+	case db.Mongo:
+		return NewMongoStore(dp), nil
+	case db.SQLite, db.Postgres:
+		return NewSQLStore(dp)
+	case db.Memory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("mfa: unsupported driver %q", dp.Driver)
+}
+
+// MongoStore keys TOTP secrets by user ID and relies on a TTL index on
+// mfa_challenges.expires_at. The recovery codes of a user are one
+// document, keyed by user ID as well.
+type MongoStore struct {
+	dbProvider    *db.DBProvider
+	secrets       *mongo.Collection
+	challenges    *mongo.Collection
+	recoveryCodes *mongo.Collection
+}
+
+// Verify Interface Compliance
+var _ Store = (*MongoStore)(nil)
+
+func NewMongoStore(dp *db.DBProvider) *MongoStore {
+	return &MongoStore{
+		dbProvider:    dp,
+		secrets:       dp.GetCollection(db.TOTPSecrets),
+		challenges:    dp.GetCollection(db.MFAChallenges),
+		recoveryCodes: dp.GetCollection(db.RecoveryCodes),
+	}
+}
+
+func (ms *MongoStore) GetTOTP(ctx context.Context, userID primitive.ObjectID) (*TOTP, error) {
+	ctx, cancel := ms.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var t TOTP
+	if err := ms.secrets.FindOne(ctx, bson.M{"_id": userID}).Decode(&t); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (ms *MongoStore) CreatePendingTOTP(ctx context.Context, t *TOTP) (bool, error) {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	// An enabled secret does not match the filter, so the upsert tries
+	// to insert a second document with its _id and fails.
+	_, err := ms.secrets.ReplaceOne(ctx, bson.M{"_id": t.UserID, "enabled": false}, t, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (ms *MongoStore) EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret string) (bool, error) {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ms.secrets.UpdateOne(ctx,
+		bson.M{"_id": userID, "secret": secret, "enabled": false},
+		bson.M{"$set": bson.M{"enabled": true}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (ms *MongoStore) UseStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ms.secrets.UpdateOne(ctx,
+		bson.M{"_id": userID, "last_step": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"last_step": step}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (ms *MongoStore) DeleteTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ms.secrets.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+func (ms *MongoStore) CreateChallenge(ctx context.Context, ch *Challenge) error {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ms.challenges.InsertOne(ctx, ch)
+	return err
+}
+
+func (ms *MongoStore) AttemptChallenge(ctx context.Context, hash string) (*Challenge, error) {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	var ch Challenge
+	err := ms.challenges.FindOneAndUpdate(ctx,
+		bson.M{"_id": hash},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&ch)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+func (ms *MongoStore) DeleteChallenge(ctx context.Context, hash string) error {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ms.challenges.DeleteOne(ctx, bson.M{"_id": hash})
+	return err
+}
+
+func (ms *MongoStore) SetRecoveryCodes(ctx context.Context, userID primitive.ObjectID, hashes []string, at time.Time) error {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ms.recoveryCodes.ReplaceOne(ctx, bson.M{"_id": userID},
+		bson.M{"_id": userID, "hashes": hashes, "created_at": at}, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (ms *MongoStore) UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) (bool, error) {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ms.recoveryCodes.UpdateOne(ctx,
+		bson.M{"_id": userID, "hashes": hash},
+		bson.M{"$pull": bson.M{"hashes": hash}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (ms *MongoStore) CountRecoveryCodes(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	ctx, cancel := ms.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var doc struct {
+		Hashes []string `bson:"hashes"`
+	}
+	if err := ms.recoveryCodes.FindOne(ctx, bson.M{"_id": userID}).Decode(&doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(doc.Hashes), nil
+}
+
+func (ms *MongoStore) DeleteRecoveryCodes(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := ms.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ms.recoveryCodes.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
@@ -0,0 +1,97 @@
+package mfa
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The parameters every authenticator app supports: SHA-1, six digits and
+// 30 second steps. A code of the step before or after is accepted too, for
+// clocks that are a little off and users who type slowly.
+const (
+	period = 30
+	digits = 6
+	skew   = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newSecret returns a random 160-bit secret in base32, as apps expect it.
+func newSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+func newChallengeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hotp is the code of secret for counter (RFC 4226).
+func hotp(secret []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, value%1000000)
+}
+
+// check accepts code if it belongs to a step around now that is later
+// than the last one used, and records its step so it cannot be used
+// again.
+func (m *Manager) check(ctx context.Context, t *TOTP, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != digits {
+		return false, nil
+	}
+	secret, err := encoding.DecodeString(t.Secret)
+	if err != nil {
+		return false, err
+	}
+	now := m.now().Unix() / period
+	for step := now - skew; step <= now+skew; step++ {
+		if step <= t.LastStep {
+			continue
+		}
+		if hmac.Equal([]byte(hotp(secret, step)), []byte(code)) {
+			// Two requests with the same code race here; only one of
+			// them moves LastStep forward.
+			return m.store.UseStep(ctx, t.UserID, step)
+		}
+	}
+	return false, nil
+}
+
+// keyURI is the otpauth:// URI apps import the secret from.
+func (m *Manager) keyURI(account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", m.issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(digits))
+	q.Set("period", fmt.Sprint(period))
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + m.issuer + ":" + account,
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
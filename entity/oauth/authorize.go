@@ -0,0 +1,369 @@
+package oauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/hamed-lohi/user-manage/entity/mfa"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// authRequest is a checked authorization request. RedirectURI is where
+// the user agent is sent back to; Requested is the redirect_uri parameter
+// as it was given, which the token request has to repeat. Nonce is passed
+// on to the ID token.
+type authRequest struct {
+	Client      *Client
+	RedirectURI string
+	Requested   string
+	Scopes      []string
+	State       string
+	Nonce       string
+	Challenge   string
+}
+
+// authError is an error that is reported to the client by redirecting
+// back to it, as RFC 6749 section 4.1.2.1 describes.
+type authError struct {
+	Code        string
+	Description string
+}
+
+func (e *authError) Error() string { return e.Code + ": " + e.Description }
+
+// requestFields carries the authorization request from one page to the
+// next.
+const requestFields = `{{define "request"}}
+<input type="hidden" name="response_type" value="code">
+<input type="hidden" name="client_id" value="{{.Client.ID.Hex}}">
+<input type="hidden" name="redirect_uri" value="{{.Requested}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="nonce" value="{{.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Challenge}}">
+<input type="hidden" name="code_challenge_method" value="S256">
+{{end}}`
+
+var consentPage = template.Must(template.Must(template.New("consent").Parse(requestFields)).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize {{.Client.Name}}</title></head>
+<body>
+<h1>{{.Client.Name}} wants to access your account</h1>
+<p>It will be allowed to:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post">
+{{template "request" .}}
+<p><label>Email <input type="email" name="email" value="{{.Email}}" required></label></p>
+<p><label>Password <input type="password" name="password" required></label></p>
+<p><button type="submit" name="action" value="approve">Approve</button>
+<button type="submit" name="action" value="deny" formnovalidate>Deny</button></p>
+</form>
+</body>
+</html>
+`))
+
+// secondFactorPage asks users who enrolled in a second factor for it,
+// with the methods of an mfa challenge. A passkey is answered in the
+// browser and sent as JSON in the credential field.
+var secondFactorPage = template.Must(template.Must(template.New("second-factor").Parse(requestFields)).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize {{.Client.Name}}</title></head>
+<body>
+<h1>Confirm it is you</h1>
+<p>{{.Client.Name}} wants to access your account. Confirm with your second factor to approve.</p>
+<form method="post" id="second-factor">
+{{template "request" .}}
+<input type="hidden" name="action" value="approve">
+<input type="hidden" name="email" value="{{.Email}}">
+<input type="hidden" name="mfa_challenge" value="{{.MFAChallenge}}">
+<input type="hidden" name="credential">
+{{if or .Has.totp .Has.recovery}}<p><label>Code <input type="text" name="code" autocomplete="one-time-code"></label></p>
+<p>{{if .Has.totp}}<button type="submit" name="mfa_method" value="totp">Use the code from my app</button>{{end}}
+{{if .Has.recovery}}<button type="submit" name="mfa_method" value="recovery">Use a recovery code</button>{{end}}</p>{{end}}
+{{with index .Options "passkey"}}<p><button type="submit" name="mfa_method" value="passkey" id="passkey">Use a passkey</button></p>
+<script>
+(function () {
+  var options = {{.}};
+  function bytes(s) {
+    return Uint8Array.from(atob(s.replace(/-/g, "+").replace(/_/g, "/")), function (c) { return c.charCodeAt(0); });
+  }
+  function text(b) {
+    return btoa(String.fromCharCode.apply(null, new Uint8Array(b))).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+  }
+  var button = document.getElementById("passkey");
+  button.addEventListener("click", function (ev) {
+    ev.preventDefault();
+    var pk = options.publicKey;
+    pk.challenge = bytes(pk.challenge);
+    (pk.allowCredentials || []).forEach(function (c) { c.id = bytes(c.id); });
+    navigator.credentials.get({publicKey: pk}).then(function (cred) {
+      var r = cred.response, form = document.getElementById("second-factor");
+      form.credential.value = JSON.stringify({
+        id: cred.id, rawId: text(cred.rawId), type: cred.type,
+        response: {
+          clientDataJSON: text(r.clientDataJSON),
+          authenticatorData: text(r.authenticatorData),
+          signature: text(r.signature),
+          userHandle: r.userHandle ? text(r.userHandle) : undefined
+        }
+      });
+      form.requestSubmit(button);
+    });
+  }, {once: true});
+})();
+</script>{{end}}
+</form>
+<form method="post">
+{{template "request" .}}
+<p><button type="submit" name="action" value="deny">Deny</button></p>
+</form>
+</body>
+</html>
+`))
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorization failed</title></head>
+<body>
+<h1>Authorization failed</h1>
+<p>{{.}}</p>
+</body>
+</html>
+`))
+
+// Authorize godoc
+// @Summary Start an authorization
+// @Description Show the consent page of the authorization code grant. The request must carry a PKCE code_challenge with code_challenge_method S256. An unknown client or redirect URI is reported on the page; other errors are sent to the redirect URI.
+// @ID oauth-authorize
+// @Tags oauth
+// @Produce  html
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string false "A registered redirect URI; may be omitted if the client has only one"
+// @Param scope query string false "Space separated scopes; defaults to all of the client's scopes"
+// @Param state query string false "Opaque value returned to the client"
+// @Param nonce query string false "OpenID Connect nonce, copied into the ID token"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "Must be S256"
+// @Success 200 {string} string "Consent page"
+// @Failure 302 {string} string "Redirect to the client with an error"
+// @Failure 400 {string} string "Error page"
+// @Router /oauth/authorize [get]
+func Authorize(c echo.Context) error {
+	req, err := readAuthRequest(c)
+	if err != nil {
+		return authFailed(c, req, err)
+	}
+	return renderConsent(c, http.StatusOK, req, "", "")
+}
+
+// Approve godoc
+// @Summary Answer an authorization
+// @Description Submitted by the consent page. On approval the user's credentials are checked and the user agent is redirected to the client with an authorization code; on denial it is redirected with access_denied. Users with a second factor are shown a page that asks for it, which is submitted here with the challenge and the answer.
+// @ID oauth-approve
+// @Tags oauth
+// @Accept  x-www-form-urlencoded
+// @Produce  html
+// @Param action formData string true "approve or deny"
+// @Param email formData string false "Email of the user"
+// @Param password formData string false "Password of the user"
+// @Param mfa_challenge formData string false "Challenge of the second factor page"
+// @Param mfa_method formData string false "Method the second factor is answered with: totp, recovery or passkey"
+// @Param code formData string false "TOTP or recovery code"
+// @Param credential formData string false "Passkey assertion as JSON"
+// @Success 200 {string} string "Second factor page"
+// @Success 302 {string} string "Redirect to the client"
+// @Failure 400 {string} string "Error page"
+// @Failure 401 {string} string "Consent page with an error"
+// @Router /oauth/authorize [post]
+func Approve(c echo.Context) error {
+	req, err := readAuthRequest(c)
+	if err != nil {
+		return authFailed(c, req, err)
+	}
+	if c.FormValue("action") != "approve" {
+		return authFailed(c, req, &authError{"access_denied", "the user denied the request"})
+	}
+
+	ctx := c.Request().Context()
+	email := c.FormValue("email")
+	var u *user.User
+	if raw := c.FormValue("mfa_challenge"); raw != "" && secondFactor != nil {
+		answer := &mfa.Answer{Method: c.FormValue("mfa_method"), Code: c.FormValue("code")}
+		if cred := c.FormValue("credential"); cred != "" {
+			answer.Response = json.RawMessage(cred)
+		}
+		u, err = secondFactor.Finish(ctx, raw, answer)
+		switch err {
+		case nil:
+		case mfa.ErrCode, mfa.ErrChallenge, mfa.ErrMethod:
+			return renderConsent(c, http.StatusUnauthorized, req, email, "The second factor was wrong or took too long. Please log in again.")
+		default:
+			return authFailed(c, req, &authError{"server_error", "the second factor could not be checked"})
+		}
+	} else {
+		u, err = user.Authenticate(ctx, email, c.FormValue("password"))
+		if err != nil {
+			return authFailed(c, req, &authError{"server_error", "the user could not be loaded"})
+		}
+		if u == nil {
+			return renderConsent(c, http.StatusUnauthorized, req, email, "Wrong email or password.")
+		}
+		if secondFactor != nil {
+			required, err := secondFactor.Required(ctx, u)
+			if err != nil {
+				return authFailed(c, req, &authError{"server_error", "the second factor could not be checked"})
+			}
+			if required {
+				return renderSecondFactor(c, req, u, email)
+			}
+		}
+	}
+
+	raw, err := newSecret()
+	if err != nil {
+		return authFailed(c, req, &authError{"server_error", "no code could be issued"})
+	}
+	code := &Code{
+		Hash:        identity.HashToken(raw),
+		ClientID:    req.Client.ID,
+		UserID:      u.ID,
+		RedirectURI: req.Requested,
+		Scopes:      req.Scopes,
+		Challenge:   req.Challenge,
+		Nonce:       req.Nonce,
+		AuthTime:    now().UTC(),
+		ExpiresAt:   now().Add(codeLifetime).UTC(),
+	}
+	if err := store.CreateCode(ctx, code); err != nil {
+		return authFailed(c, req, &authError{"server_error", "no code could be issued"})
+	}
+	return c.Redirect(http.StatusFound, redirectTo(req, url.Values{"code": {raw}}))
+}
+
+// readAuthRequest checks the parameters of an authorization request. The
+// request is returned with the error once the redirect URI is known, so
+// that the error can be sent there.
+func readAuthRequest(c echo.Context) (*authRequest, error) {
+	id, err := primitive.ObjectIDFromHex(c.FormValue("client_id"))
+	if err != nil {
+		return nil, errUnknownClient
+	}
+	cl, err := store.GetClient(c.Request().Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if cl == nil || !cl.Allows(GrantAuthorizationCode) {
+		return nil, errUnknownClient
+	}
+	req := &authRequest{
+		Client:    cl,
+		Requested: c.FormValue("redirect_uri"),
+		State:     c.FormValue("state"),
+		Nonce:     c.FormValue("nonce"),
+		Challenge: c.FormValue("code_challenge"),
+	}
+	var ok bool
+	if req.RedirectURI, ok = cl.redirectURI(req.Requested); !ok {
+		return nil, errBadRedirect
+	}
+
+	if c.FormValue("response_type") != "code" {
+		return req, &authError{"unsupported_response_type", "response_type must be code"}
+	}
+	if req.Scopes, ok = cl.grant(c.FormValue("scope")); !ok {
+		return req, &authError{"invalid_scope", "the client may not request these scopes"}
+	}
+	if req.Challenge == "" || c.FormValue("code_challenge_method") != "S256" {
+		return req, &authError{"invalid_request", "PKCE with code_challenge_method S256 is required"}
+	}
+	return req, nil
+}
+
+// Errors that must not be redirected, since the redirect URI cannot be
+// trusted.
+var (
+	errUnknownClient = &pageError{"Unknown client."}
+	errBadRedirect   = &pageError{"The redirect URI is not registered for this client."}
+)
+
+type pageError struct{ message string }
+
+func (e *pageError) Error() string { return e.message }
+
+func authFailed(c echo.Context, req *authRequest, err error) error {
+	if ae, ok := err.(*authError); ok && req != nil {
+		return c.Redirect(http.StatusFound, redirectTo(req, url.Values{
+			"error":             {ae.Code},
+			"error_description": {ae.Description},
+		}))
+	}
+	if pe, ok := err.(*pageError); ok {
+		return render(c, http.StatusBadRequest, errorPage, pe.message)
+	}
+	c.Logger().Error(err)
+	return render(c, http.StatusInternalServerError, errorPage, "Something went wrong, please try again.")
+}
+
+// redirectTo adds params and the request's state to its redirect URI.
+func redirectTo(req *authRequest, params url.Values) string {
+	u, _ := url.Parse(req.RedirectURI)
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func renderConsent(c echo.Context, status int, req *authRequest, email, message string) error {
+	return render(c, status, consentPage, struct {
+		*authRequest
+		Scope string
+		Email string
+		Error string
+	}{req, c.FormValue("scope"), email, message})
+}
+
+// renderSecondFactor starts an mfa challenge for u and shows the page
+// that answers it.
+func renderSecondFactor(c echo.Context, req *authRequest, u *user.User, email string) error {
+	raw, methods, options, err := secondFactor.Begin(c.Request().Context(), u)
+	if err != nil {
+		return authFailed(c, req, &authError{"server_error", "the second factor could not be started"})
+	}
+	has := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		has[m] = true
+	}
+	return render(c, http.StatusOK, secondFactorPage, struct {
+		*authRequest
+		Scope        string
+		Email        string
+		MFAChallenge string
+		Has          map[string]bool
+		Options      map[string]interface{}
+	}{req, c.FormValue("scope"), email, raw, has, options})
+}
+
+func render(c echo.Context, status int, t *template.Template, data interface{}) error {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return err
+	}
+	// The pages take credentials, so they must not be framed by another
+	// site.
+	c.Response().Header().Set("X-Frame-Options", "DENY")
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.HTMLBlob(status, buf.Bytes())
+}
@@ -0,0 +1,177 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListClients godoc
+// @Summary List OAuth clients
+// @Description List every registered OAuth client
+// @ID list-oauth-clients
+// @Tags oauth
+// @Produce  json
+// @Success 200 {object} clientListResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /oauth/clients [get]
+func ListClients(c echo.Context) error {
+	clients, err := store.ListClients(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, &clientListResponse{Clients: clients})
+}
+
+// GetClient godoc
+// @Summary Get an OAuth client
+// @Description Get one registered OAuth client
+// @ID get-oauth-client
+// @Tags oauth
+// @Produce  json
+// @Param        id   path      string  true  "Client ID"
+// @Success 200 {object} clientResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /oauth/clients/{id} [get]
+func GetClient(c echo.Context) error {
+	cl, err := clientFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if cl == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	return c.JSON(http.StatusOK, &clientResponse{Client: *cl})
+}
+
+// CreateClient godoc
+// @Summary Register an OAuth client
+// @Description Register an OAuth client owned by the caller. A confidential client's secret is returned only in this response. The client credentials grant needs a confidential client linked to a service account; linking one needs the same rights as issuing its API keys.
+// @ID create-oauth-client
+// @Tags oauth
+// @Accept  json
+// @Produce  json
+// @Param client body clientCreateRequest true "Client to register"
+// @Success 201 {object} clientCreatedResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /oauth/clients [post]
+func CreateClient(c echo.Context) error {
+	cl := &Client{
+		ID:      primitive.NewObjectID(),
+		OwnerID: userIDFromToken(c),
+	}
+	req := &clientCreateRequest{}
+	if err := req.bind(c, cl); err != nil {
+		return c.JSON(bindStatus(err), customerror.NewError(err))
+	}
+	secret := ""
+	if req.Client.Confidential {
+		var err error
+		if secret, err = newSecret(); err != nil {
+			return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+		}
+		cl.SecretHash = identity.HashToken(secret)
+	}
+	if err := cl.validate(); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	cl.CreatedAt = now().UTC()
+	if err := store.CreateClient(c.Request().Context(), cl); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusCreated, &clientCreatedResponse{Client: *cl, ClientSecret: secret})
+}
+
+// UpdateClient godoc
+// @Summary Update an OAuth client
+// @Description Change the name, redirect URIs, grant types, scopes or service account of a client. Whether it is confidential cannot change. A client linked to a service account may only be changed with the same rights as issuing the account's API keys.
+// @ID update-oauth-client
+// @Tags oauth
+// @Accept  json
+// @Produce  json
+// @Param        id   path      string  true  "Client ID"
+// @Param client body clientUpdateRequest true "Client fields"
+// @Success 200 {object} clientResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /oauth/clients/{id} [put]
+func UpdateClient(c echo.Context) error {
+	cl, err := clientFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if cl == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	req := &clientUpdateRequest{}
+	req.populate(cl)
+	if err := req.bind(c, cl); err != nil {
+		return c.JSON(bindStatus(err), customerror.NewError(err))
+	}
+	if err := cl.validate(); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	if err := store.UpdateClient(c.Request().Context(), cl); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, &clientResponse{Client: *cl})
+}
+
+// DeleteClient godoc
+// @Summary Delete an OAuth client
+// @Description Delete a client; its pending codes can no longer be exchanged. Tokens already issued stay valid until they expire.
+// @ID delete-oauth-client
+// @Tags oauth
+// @Produce  json
+// @Param        id   path      string  true  "Client ID"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /oauth/clients/{id} [delete]
+func DeleteClient(c echo.Context) error {
+	cl, err := clientFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if cl == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if err := store.DeleteClient(c.Request().Context(), cl.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func clientFromParam(c echo.Context) (*Client, error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, nil
+	}
+	return store.GetClient(c.Request().Context(), id)
+}
+
+func userIDFromToken(c echo.Context) primitive.ObjectID {
+	id, _ := c.Get("user").(primitive.ObjectID)
+	return id
+}
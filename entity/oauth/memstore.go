@@ -0,0 +1,131 @@
+package oauth
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps OAuth clients and codes in process memory; nothing
+// survives a restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	clients map[primitive.ObjectID]Client
+	codes   map[string]Code
+}
+
+// Verify Interface Compliance
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		clients: make(map[primitive.ObjectID]Client),
+		codes:   make(map[string]Code),
+	}
+}
+
+func (cs *MemoryStore) ListClients(ctx context.Context) ([]Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	clients := make([]Client, 0, len(cs.clients))
+	for _, cl := range cs.clients {
+		clients = append(clients, copyClient(cl))
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].CreatedAt.Before(clients[j].CreatedAt) })
+	return clients, nil
+}
+
+func (cs *MemoryStore) GetClient(ctx context.Context, id primitive.ObjectID) (*Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cl, ok := cs.clients[id]
+	if !ok {
+		return nil, nil
+	}
+	c := copyClient(cl)
+	return &c, nil
+}
+
+func (cs *MemoryStore) CreateClient(ctx context.Context, cl *Client) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.clients[cl.ID] = copyClient(*cl)
+	return nil
+}
+
+func (cs *MemoryStore) UpdateClient(ctx context.Context, cl *Client) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if _, ok := cs.clients[cl.ID]; ok {
+		cs.clients[cl.ID] = copyClient(*cl)
+	}
+	return nil
+}
+
+func (cs *MemoryStore) DeleteClient(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	delete(cs.clients, id)
+	return nil
+}
+
+func (cs *MemoryStore) CreateCode(ctx context.Context, code *Code) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	c := *code
+	c.Scopes = append([]string{}, code.Scopes...)
+	cs.codes[code.Hash] = c
+	return nil
+}
+
+func (cs *MemoryStore) ConsumeCode(ctx context.Context, hash string) (*Code, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	code, ok := cs.codes[hash]
+	if !ok {
+		return nil, nil
+	}
+	delete(cs.codes, hash)
+	return &code, nil
+}
+
+func copyClient(cl Client) Client {
+	cl.RedirectURIs = append([]string{}, cl.RedirectURIs...)
+	cl.GrantTypes = append([]string{}, cl.GrantTypes...)
+	cl.Scopes = append([]string{}, cl.Scopes...)
+	if cl.ServiceAccountID != nil {
+		id := *cl.ServiceAccountID
+		cl.ServiceAccountID = &id
+	}
+	return cl
+}
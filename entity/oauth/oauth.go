@@ -0,0 +1,226 @@
+// Package oauth makes this service an OAuth 2.0 authorization server
+// (RFC 6749) for registered clients.
+//
+// A client acts for a user through the authorization code grant, which
+// always requires PKCE (RFC 7636) with S256: the user signs in and
+// approves the client on a server-rendered consent page, then gives their
+// second factor on another page if they have one; the client is
+// redirected back with a single-use code and exchanges it at the token
+// endpoint. A confidential client linked to a service account can also
+// use the client credentials grant to act for that account.
+//
+// Access tokens are the JWTs the API already accepts, with the client in
+// client_id and the granted scopes in scope. Scopes are permission names,
+// and a token can only use the permissions its scopes name. No refresh
+// tokens are issued; clients run the flow again when a token expires.
+//
+// With auth.oidc set, the server is also an OpenID Connect provider: an
+// authorization with the openid scope yields an ID token next to the
+// access token, and the access token can read the user's claims from
+// /oauth/userinfo. The profile and email scopes add preferred_username
+// and email. ID tokens are signed with an asymmetric key, which the
+// configuration must then include; without auth.oidc those scopes are
+// unknown.
+package oauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/entity/mfa"
+	"github.com/hamed-lohi/user-manage/entity/serviceaccount"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Grant types a client may be registered for.
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+)
+
+// codeLifetime bounds how long an authorization code can be exchanged.
+const codeLifetime = 5 * time.Minute
+
+var (
+	ErrUnknownScope    = errors.New("unknown scope")
+	ErrRedirectURI     = errors.New("redirect URIs must be absolute http(s) URLs without a fragment")
+	ErrNoRedirectURI   = errors.New("the authorization code grant needs at least one redirect URI")
+	ErrGrantType       = errors.New("unsupported grant type")
+	ErrNeedsAccount    = errors.New("the client credentials grant needs a confidential client linked to a service account")
+	ErrAccountNotFound = errors.New("service account not found")
+)
+
+var (
+	store    Store
+	users    user.Store
+	accounts serviceaccount.Store
+	// secondFactor, if not nil, challenges users who enrolled in a second
+	// factor before they approve.
+	secondFactor *mfa.Manager
+	// oidc tells whether the OpenID Connect scopes and user info are
+	// served.
+	oidc bool
+	now  = time.Now
+)
+
+// Client is a registered OAuth client. Public clients, such as single
+// page and native apps, have no secret.
+type Client struct {
+	ID           primitive.ObjectID `bson:"_id" json:"client_id"`
+	Name         string             `bson:"name" json:"name"`
+	SecretHash   string             `bson:"secret_hash,omitempty" json:"-"`
+	RedirectURIs []string           `bson:"redirect_uris" json:"redirect_uris"`
+	GrantTypes   []string           `bson:"grant_types" json:"grant_types"`
+	// Scopes are the most a client may be granted.
+	Scopes []string `bson:"scopes" json:"scopes"`
+	// ServiceAccountID is the account the client acts for under the
+	// client credentials grant.
+	ServiceAccountID *primitive.ObjectID `bson:"service_account_id,omitempty" json:"service_account_id,omitempty"`
+	OwnerID          primitive.ObjectID  `bson:"owner_id" json:"owner_id"`
+	CreatedAt        time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// Confidential reports whether the client authenticates with a secret.
+func (cl *Client) Confidential() bool {
+	return cl.SecretHash != ""
+}
+
+// Allows reports whether the client is registered for grant.
+func (cl *Client) Allows(grant string) bool {
+	return contains(cl.GrantTypes, grant)
+}
+
+// redirectURI resolves the redirect_uri of an authorization request: it
+// must be registered, and may be omitted if only one is.
+func (cl *Client) redirectURI(requested string) (string, bool) {
+	if requested == "" {
+		if len(cl.RedirectURIs) == 1 {
+			return cl.RedirectURIs[0], true
+		}
+		return "", false
+	}
+	return requested, contains(cl.RedirectURIs, requested)
+}
+
+// grant returns the scopes granted for a request: all of the client's
+// known scopes if none were requested, or the requested ones if the
+// client may have them all.
+func (cl *Client) grant(requested string) ([]string, bool) {
+	scopes := parseScope(requested)
+	if len(scopes) == 0 {
+		for _, s := range cl.Scopes {
+			if knownScope(s) {
+				scopes = append(scopes, s)
+			}
+		}
+		return scopes, len(scopes) > 0
+	}
+	for _, s := range scopes {
+		if !cl.allowsScope(s) {
+			return nil, false
+		}
+	}
+	return scopes, true
+}
+
+// allowsScope reports whether the client may still be granted s; OpenID
+// Connect scopes lapse when OIDC is turned off.
+func (cl *Client) allowsScope(s string) bool {
+	return contains(cl.Scopes, s) && knownScope(s)
+}
+
+// Code is a pending authorization code. Only the SHA-256 of the code is
+// stored. RedirectURI is the one the authorization request named, empty
+// if it named none. Nonce and AuthTime go into the ID token.
+type Code struct {
+	Hash        string             `bson:"_id"`
+	ClientID    primitive.ObjectID `bson:"client_id"`
+	UserID      primitive.ObjectID `bson:"user_id"`
+	RedirectURI string             `bson:"redirect_uri"`
+	Scopes      []string           `bson:"scopes"`
+	Challenge   string             `bson:"challenge"`
+	Nonce       string             `bson:"nonce,omitempty"`
+	AuthTime    time.Time          `bson:"auth_time"`
+	ExpiresAt   time.Time          `bson:"expires_at"`
+}
+
+func RegisterHandlers(v1 *echo.Group, auth echo.MiddlewareFunc, s Store, us user.Store, as serviceaccount.Store, mm *mfa.Manager, withOIDC bool) {
+
+	store = s
+	users = us
+	accounts = as
+	secondFactor = mm
+	oidc = withOIDC
+
+	// Discovery finds these routes by name.
+	o := v1.Group("/oauth")
+	o.GET("/authorize", Authorize).Name = "oauth-authorize"
+	o.POST("/authorize", Approve)
+	o.POST("/token", Token).Name = "oauth-token"
+	if oidc {
+		o.GET("/userinfo", UserInfo, auth).Name = "oauth-userinfo"
+		o.POST("/userinfo", UserInfo, auth)
+	}
+
+	clients := v1.Group("/oauth/clients", auth)
+	clients.GET("", ListClients, identity.RequirePermission(identity.ClientsRead))
+	clients.GET("/:id", GetClient, identity.RequirePermission(identity.ClientsRead))
+	clients.POST("", CreateClient, identity.RequirePermission(identity.ClientsWrite))
+	clients.PUT("/:id", UpdateClient, identity.RequirePermission(identity.ClientsWrite))
+	clients.DELETE("/:id", DeleteClient, identity.RequirePermission(identity.ClientsWrite))
+}
+
+// knownScope reports whether s may be registered and requested: a
+// permission or, with OIDC on, one of the OpenID Connect scopes.
+func knownScope(s string) bool {
+	switch s {
+	case identity.ScopeOpenID, identity.ScopeProfile, identity.ScopeEmail:
+		return oidc
+	}
+	return identity.IsPermission(identity.Permission(s))
+}
+
+// parseScope splits a scope parameter and drops repeated scopes.
+func parseScope(scope string) []string {
+	scopes := make([]string, 0)
+	for _, s := range strings.Fields(scope) {
+		if !contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// validRedirectURI accepts absolute http and https URLs without a
+// fragment, as RFC 6749 section 3.1.2 requires.
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+	return u.Scheme == "https" || u.Scheme == "http"
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
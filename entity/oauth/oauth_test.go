@@ -0,0 +1,343 @@
+package oauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/entity/mfa"
+	"github.com/hamed-lohi/user-manage/entity/role"
+	"github.com/hamed-lohi/user-manage/entity/serviceaccount"
+	"github.com/hamed-lohi/user-manage/entity/token"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const redirectURI = "https://app.example/callback"
+
+type testValidator struct{ v *validator.Validate }
+
+func (tv testValidator) Validate(i interface{}) error { return tv.v.Struct(i) }
+
+// testServer is the OAuth routes on memory stores, with a user, a public
+// client for the authorization code grant and a confidential one linked
+// to a service account.
+type testServer struct {
+	e       *echo.Echo
+	mfa     *mfa.MemoryStore
+	mm      *mfa.Manager
+	alice   *user.User
+	public  *Client
+	service *Client
+	secret  string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ctx := context.Background()
+	ts := &testServer{e: echo.New(), mfa: mfa.NewMemoryStore()}
+	ts.e.Validator = testValidator{validator.New()}
+
+	us := user.NewMemoryStore()
+	ts.alice = &user.User{Username: "alice", Email: "alice@example.com", Roles: []identity.Role{identity.Member}}
+	ts.alice.SetPassword("secret")
+	if err := us.Create(ctx, ts.alice); err != nil {
+		t.Fatal(err)
+	}
+
+	ss := serviceaccount.NewMemoryStore()
+	account := &serviceaccount.Account{ID: primitive.NewObjectID(), Name: "deployer", Roles: []identity.Role{identity.Moderator}}
+	if err := ss.CreateAccount(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := NewMemoryStore()
+	ts.public = &Client{
+		ID:           primitive.NewObjectID(),
+		Name:         "app",
+		RedirectURIs: []string{redirectURI},
+		GrantTypes:   []string{GrantAuthorizationCode},
+		Scopes:       []string{string(identity.UsersRead)},
+	}
+	var err error
+	if ts.secret, err = newSecret(); err != nil {
+		t.Fatal(err)
+	}
+	ts.service = &Client{
+		ID:               primitive.NewObjectID(),
+		Name:             "deployer",
+		SecretHash:       identity.HashToken(ts.secret),
+		GrantTypes:       []string{GrantClientCredentials},
+		Scopes:           []string{string(identity.UsersRead)},
+		ServiceAccountID: &account.ID,
+	}
+	for _, cl := range []*Client{ts.public, ts.service} {
+		if err := cs.CreateClient(ctx, cl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rm := token.NewManager(token.NewMemoryStore(), time.Hour)
+	ts.mm = mfa.NewManager(ts.mfa, us, "test")
+	auth := identity.JWTWithConfig(identity.JWTConfig{Keys: identity.Keys, Revocations: rm})
+	v1 := ts.e.Group("/api")
+	user.RegisterHandlers(v1, auth, us, role.NewMemoryStore(), rm, nil, ts.mm)
+	RegisterHandlers(v1, auth, cs, us, ss, ts.mm, true)
+	// whoami shows the claims of a token the API accepts.
+	ts.e.GET("/whoami", func(c echo.Context) error { return c.JSON(http.StatusOK, identity.ClaimsFrom(c)) }, auth)
+	return ts
+}
+
+// whoami returns the claims of an access token, failing if the API does
+// not accept it.
+func (ts *testServer) whoami(t *testing.T, accessToken string) *identity.Claims {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+	rec := httptest.NewRecorder()
+	ts.e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("access token rejected: status %d, %s", rec.Code, rec.Body)
+	}
+	claims := &identity.Claims{}
+	if err := json.Unmarshal(rec.Body.Bytes(), claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func (ts *testServer) post(path string, form url.Values, setup func(r *http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if setup != nil {
+		setup(req)
+	}
+	rec := httptest.NewRecorder()
+	ts.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// authorizeForm is the consent page's form for the public client, with a
+// PKCE challenge for verifier.
+func (ts *testServer) authorizeForm(verifier string) url.Values {
+	sum := sha256.Sum256([]byte(verifier))
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {ts.public.ID.Hex()},
+		"redirect_uri":          {redirectURI},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+		"action":                {"approve"},
+		"email":                 {"alice@example.com"},
+		"password":              {"secret"},
+	}
+}
+
+// redirected returns the query of the redirect to the client.
+func redirected(t *testing.T, rec *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+	if rec.Code != http.StatusFound {
+		t.Fatalf("authorize: status %d, want 302; body %s", rec.Code, rec.Body)
+	}
+	loc, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc.String(), redirectURI+"?") {
+		t.Fatalf("redirected to %s", loc)
+	}
+	return loc.Query()
+}
+
+// approve runs the consent page for a user without a second factor and
+// returns the code.
+func (ts *testServer) approve(t *testing.T, verifier string) string {
+	t.Helper()
+	q := redirected(t, ts.post("/api/oauth/authorize", ts.authorizeForm(verifier), nil))
+	if q.Get("state") != "xyz" || q.Get("code") == "" {
+		t.Fatalf("redirect query %v", q)
+	}
+	return q.Get("code")
+}
+
+// exchange redeems code at the token endpoint and decodes the response.
+func (ts *testServer) exchange(code, verifier string) (int, map[string]interface{}) {
+	rec := ts.post("/api/oauth/token", url.Values{
+		"grant_type":    {GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {ts.public.ID.Hex()},
+		"code_verifier": {verifier},
+	}, nil)
+	var body map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec.Code, body
+}
+
+const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func TestAuthorizationCodeWithPKCE(t *testing.T) {
+	ts := newTestServer(t)
+	code := ts.approve(t, verifier)
+
+	status, body := ts.exchange(code, verifier)
+	if status != http.StatusOK {
+		t.Fatalf("exchange: status %d, %v", status, body)
+	}
+	if body["token_type"] != "Bearer" || body["scope"] != string(identity.UsersRead) {
+		t.Errorf("token response %v", body)
+	}
+	claims := ts.whoami(t, body["access_token"].(string))
+	if claims.Subject != ts.alice.ID.Hex() || claims.ClientID != ts.public.ID.Hex() {
+		t.Errorf("access token for %s via %s", claims.Subject, claims.ClientID)
+	}
+
+	// A code is good for one exchange only.
+	if status, body := ts.exchange(code, verifier); status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("reused code: status %d, %v", status, body)
+	}
+}
+
+func TestAuthorizationCodeWrongVerifier(t *testing.T) {
+	ts := newTestServer(t)
+	code := ts.approve(t, verifier)
+
+	wrong := strings.Repeat("a", 43)
+	if status, body := ts.exchange(code, wrong); status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("wrong verifier: status %d, %v", status, body)
+	}
+	// The failed attempt used the code up.
+	if status, body := ts.exchange(code, verifier); status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("right verifier after a wrong one: status %d, %v", status, body)
+	}
+}
+
+func TestAuthorizationRequiresS256(t *testing.T) {
+	ts := newTestServer(t)
+	form := ts.authorizeForm(verifier)
+	form.Set("code_challenge_method", "plain")
+	if q := redirected(t, ts.post("/api/oauth/authorize", form, nil)); q.Get("error") != "invalid_request" || q.Get("code") != "" {
+		t.Errorf("plain PKCE: redirect query %v", q)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	ts := newTestServer(t)
+	form := url.Values{"grant_type": {GrantClientCredentials}}
+
+	rec := ts.post("/api/oauth/token", form, func(r *http.Request) {
+		r.SetBasicAuth(ts.service.ID.Hex(), ts.secret)
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("client credentials: status %d, %s", rec.Code, rec.Body)
+	}
+	var res tokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if claims := ts.whoami(t, res.AccessToken); claims.Subject != ts.service.ServiceAccountID.Hex() {
+		t.Errorf("token for %s, want the service account", claims.Subject)
+	}
+
+	rec = ts.post("/api/oauth/token", form, func(r *http.Request) {
+		r.SetBasicAuth(ts.service.ID.Hex(), "wrong")
+	})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: status %d, want 401", rec.Code)
+	}
+	form.Set("client_id", ts.public.ID.Hex())
+	if rec := ts.post("/api/oauth/token", form, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("public client: status %d, want 400", rec.Code)
+	}
+}
+
+var challengeField = regexp.MustCompile(`name="mfa_challenge" value="([^"]+)"`)
+
+// secondFactorPage posts the consent page and returns the challenge of
+// the second factor page it must lead to.
+func (ts *testServer) secondFactorPage(t *testing.T, form url.Values) string {
+	t.Helper()
+	rec := ts.post("/api/oauth/authorize", form, nil)
+	m := challengeField.FindStringSubmatch(rec.Body.String())
+	if rec.Code != http.StatusOK || m == nil {
+		t.Fatalf("consent with a second factor: status %d, %s", rec.Code, rec.Body)
+	}
+	return m[1]
+}
+
+func (ts *testServer) answer(challenge, method, code string) *httptest.ResponseRecorder {
+	form := ts.authorizeForm(verifier)
+	form.Del("password")
+	form.Set("mfa_challenge", challenge)
+	form.Set("mfa_method", method)
+	form.Set("code", code)
+	return ts.post("/api/oauth/authorize", form, nil)
+}
+
+func TestApproveAsksForSecondFactor(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	secret := []byte("12345678901234567890")
+	totp := &mfa.TOTP{UserID: ts.alice.ID, Secret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)}
+	if _, err := ts.mfa.CreatePendingTOTP(ctx, totp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.mfa.EnableTOTP(ctx, ts.alice.ID, totp.Secret); err != nil {
+		t.Fatal(err)
+	}
+	codes, err := ts.mm.GenerateRecoveryCodes(ctx, ts.alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The password alone, or with a one-time code in the old otp field,
+	// only leads to the second factor page.
+	form := ts.authorizeForm(verifier)
+	form.Set("otp", hotp(secret, time.Now().Unix()/30))
+	challenge := ts.secondFactorPage(t, form)
+
+	if rec := ts.answer(challenge, mfa.MethodTOTP, "000000"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code: status %d, want 401", rec.Code)
+	}
+	q := redirected(t, ts.answer(challenge, mfa.MethodTOTP, hotp(secret, time.Now().Unix()/30)))
+	code := q.Get("code")
+	if status, body := ts.exchange(code, verifier); status != http.StatusOK {
+		t.Errorf("exchange after the second factor: status %d, %v", status, body)
+	}
+	// The challenge was used up.
+	if rec := ts.answer(challenge, mfa.MethodTOTP, hotp(secret, time.Now().Unix()/30+1)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused challenge: status %d, want 401", rec.Code)
+	}
+
+	// Users without their device approve with a recovery code.
+	challenge = ts.secondFactorPage(t, ts.authorizeForm(verifier))
+	redirected(t, ts.answer(challenge, mfa.MethodRecovery, codes[0]))
+}
+
+// hotp is the one-time code of RFC 4226 for counter.
+func hotp(secret []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:])&0x7fffffff)%1000000)
+}
@@ -0,0 +1,110 @@
+package oauth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// discoveryDocument is the OpenID Provider Metadata of OpenID Connect
+// Discovery section 3.
+type discoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Discovery serves the OpenID Provider configuration at
+// /.well-known/openid-configuration. Endpoints are absolute URLs on the
+// issuer's host when the issuer is an http(s) URL, as OpenID Connect
+// clients require, and on the requested host otherwise.
+func Discovery(c echo.Context) error {
+	base := publicURL(c)
+	scopes := []string{identity.ScopeOpenID, identity.ScopeProfile, identity.ScopeEmail}
+	for _, p := range identity.AllPermissions {
+		scopes = append(scopes, string(p))
+	}
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, &discoveryDocument{
+		Issuer:                            identity.Issuer,
+		AuthorizationEndpoint:             base + c.Echo().Reverse("oauth-authorize"),
+		TokenEndpoint:                     base + c.Echo().Reverse("oauth-token"),
+		UserInfoEndpoint:                  base + c.Echo().Reverse("oauth-userinfo"),
+		JWKSURI:                           base + "/.well-known/jwks.json",
+		ScopesSupported:                   scopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{GrantAuthorizationCode, GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  identity.Keys.SigningAlgorithms(),
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "preferred_username"},
+	})
+}
+
+// UserInfo godoc
+// @Summary OpenID Connect user info
+// @Description Return the claims about the user that the access token's scopes cover. The token must have been issued with the openid scope.
+// @ID oidc-userinfo
+// @Tags oauth
+// @Produce  json
+// @Success 200 {object} identity.UserInfo
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /oauth/userinfo [get]
+func UserInfo(c echo.Context) error {
+	claims := identity.ClaimsFrom(c)
+	if claims == nil || !contains(claims.Scopes(), identity.ScopeOpenID) {
+		c.Response().Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		return c.JSON(http.StatusForbidden, customerror.AccessForbidden())
+	}
+	id, _ := c.Get("user").(primitive.ObjectID)
+	u, err := users.GetByID(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if u == nil {
+		c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return c.JSON(http.StatusUnauthorized, customerror.NewError(identity.ErrTokenInvalid))
+	}
+	return c.JSON(http.StatusOK, userInfo(u, claims.Scopes()))
+}
+
+// userInfo returns the claims about u that scopes cover: email for the
+// email scope and preferred_username for profile.
+func userInfo(u *user.User, scopes []string) identity.UserInfo {
+	info := identity.UserInfo{Subject: u.ID.Hex()}
+	if contains(scopes, identity.ScopeEmail) {
+		info.Email = u.Email
+	}
+	if contains(scopes, identity.ScopeProfile) {
+		info.PreferredUsername = u.Username
+	}
+	return info
+}
+
+// publicURL is the scheme and host clients reach the service at.
+func publicURL(c echo.Context) string {
+	if u, err := url.Parse(identity.Issuer); err == nil && u.Host != "" && (u.Scheme == "https" || u.Scheme == "http") {
+		return u.Scheme + "://" + u.Host
+	}
+	return c.Scheme() + "://" + strings.TrimSuffix(c.Request().Host, "/")
+}
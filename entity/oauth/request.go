@@ -0,0 +1,147 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/hamed-lohi/user-manage/entity/serviceaccount"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrAccountLink = errors.New("linking a service account requires the service_accounts:write permission")
+
+type clientCreateRequest struct {
+	Client struct {
+		Name         string   `json:"name" validate:"required"`
+		RedirectURIs []string `json:"redirect_uris"`
+		// GrantTypes defaults to the authorization code grant.
+		GrantTypes []string `json:"grant_types"`
+		Scopes     []string `json:"scopes" validate:"required,min=1"`
+		// Confidential clients get a secret; public ones must not be
+		// trusted to keep one.
+		Confidential     bool   `json:"confidential"`
+		ServiceAccountID string `json:"service_account_id"`
+	} `json:"client"`
+}
+
+func (r *clientCreateRequest) bind(c echo.Context, cl *Client) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := c.Validate(r); err != nil {
+		return err
+	}
+	cl.Name = r.Client.Name
+	cl.RedirectURIs = append([]string{}, r.Client.RedirectURIs...)
+	cl.GrantTypes = append([]string{}, r.Client.GrantTypes...)
+	cl.Scopes = parseScope(strings.Join(r.Client.Scopes, " "))
+	return setServiceAccount(c, cl, r.Client.ServiceAccountID)
+}
+
+type clientUpdateRequest struct {
+	Client struct {
+		Name             string   `json:"name" validate:"required"`
+		RedirectURIs     []string `json:"redirect_uris"`
+		GrantTypes       []string `json:"grant_types"`
+		Scopes           []string `json:"scopes" validate:"required,min=1"`
+		ServiceAccountID string   `json:"service_account_id"`
+	} `json:"client"`
+}
+
+func (r *clientUpdateRequest) populate(cl *Client) {
+	r.Client.Name = cl.Name
+	// Copies, so that binding the body does not overwrite the client.
+	r.Client.RedirectURIs = append([]string{}, cl.RedirectURIs...)
+	r.Client.GrantTypes = append([]string{}, cl.GrantTypes...)
+	r.Client.Scopes = append([]string{}, cl.Scopes...)
+	if cl.ServiceAccountID != nil {
+		r.Client.ServiceAccountID = cl.ServiceAccountID.Hex()
+	}
+}
+
+func (r *clientUpdateRequest) bind(c echo.Context, cl *Client) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := c.Validate(r); err != nil {
+		return err
+	}
+	cl.Name = r.Client.Name
+	cl.RedirectURIs = append([]string{}, r.Client.RedirectURIs...)
+	cl.GrantTypes = append([]string{}, r.Client.GrantTypes...)
+	cl.Scopes = parseScope(strings.Join(r.Client.Scopes, " "))
+	return setServiceAccount(c, cl, r.Client.ServiceAccountID)
+}
+
+// setServiceAccount links cl to the service account id, or unlinks it if
+// id is empty. The client can act for the account, so the requester must
+// manage service accounts and be allowed to issue the account's keys, see
+// serviceaccount.CheckControl; this holds for changes to a client that
+// stays linked, too.
+func setServiceAccount(c echo.Context, cl *Client, id string) error {
+	if id == "" {
+		cl.ServiceAccountID = nil
+		return nil
+	}
+	aid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrAccountNotFound
+	}
+	if !identity.HasPermission(c, identity.ServiceAccountsWrite) {
+		return ErrAccountLink
+	}
+	a, err := accounts.GetAccount(c.Request().Context(), aid)
+	if err != nil {
+		return err
+	}
+	if a == nil {
+		return ErrAccountNotFound
+	}
+	if err := serviceaccount.CheckControl(c, a); err != nil {
+		return err
+	}
+	cl.ServiceAccountID = &aid
+	return nil
+}
+
+// bindStatus is the status to answer a failed bind of a client with: 403
+// if the requester may not link its service account, 422 otherwise.
+func bindStatus(err error) int {
+	switch err {
+	case ErrAccountLink, serviceaccount.ErrNotOwner, serviceaccount.ErrNotGrantable:
+		return http.StatusForbidden
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// validate checks a client after its fields were bound.
+func (cl *Client) validate() error {
+	if len(cl.GrantTypes) == 0 {
+		cl.GrantTypes = []string{GrantAuthorizationCode}
+	}
+	for _, g := range cl.GrantTypes {
+		if g != GrantAuthorizationCode && g != GrantClientCredentials {
+			return ErrGrantType
+		}
+	}
+	for _, u := range cl.RedirectURIs {
+		if !validRedirectURI(u) {
+			return ErrRedirectURI
+		}
+	}
+	if cl.Allows(GrantAuthorizationCode) && len(cl.RedirectURIs) == 0 {
+		return ErrNoRedirectURI
+	}
+	if cl.Allows(GrantClientCredentials) && (!cl.Confidential() || cl.ServiceAccountID == nil) {
+		return ErrNeedsAccount
+	}
+	for _, s := range cl.Scopes {
+		if !knownScope(s) {
+			return ErrUnknownScope
+		}
+	}
+	return nil
+}
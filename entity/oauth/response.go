@@ -0,0 +1,33 @@
+package oauth
+
+type clientResponse struct {
+	Client Client `json:"client"`
+}
+
+// clientCreatedResponse is the only response that carries the secret of
+// a confidential client.
+type clientCreatedResponse struct {
+	Client       Client `json:"client"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type clientListResponse struct {
+	Clients []Client `json:"clients"`
+}
+
+// tokenResponse is the successful token endpoint response of RFC 6749
+// section 5.1, with the ID token of OpenID Connect when one was issued.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+	IDToken     string `json:"id_token,omitempty"`
+}
+
+// tokenError is the token endpoint error response of RFC 6749 section
+// 5.2.
+type tokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
@@ -0,0 +1,248 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlSchema is shared by SQLite and PostgreSQL. List fields are kept as
+// JSON arrays.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS oauth_clients (
+		id                 TEXT PRIMARY KEY,
+		name               TEXT NOT NULL,
+		secret_hash        TEXT NOT NULL DEFAULT '',
+		redirect_uris      TEXT NOT NULL DEFAULT '[]',
+		grant_types        TEXT NOT NULL DEFAULT '[]',
+		scopes             TEXT NOT NULL DEFAULT '[]',
+		service_account_id TEXT,
+		owner_id           TEXT NOT NULL,
+		created_at         TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS oauth_codes (
+		hash         TEXT PRIMARY KEY,
+		client_id    TEXT NOT NULL,
+		user_id      TEXT NOT NULL,
+		redirect_uri TEXT NOT NULL,
+		scopes       TEXT NOT NULL DEFAULT '[]',
+		challenge    TEXT NOT NULL,
+		nonce        TEXT NOT NULL DEFAULT '',
+		auth_time    TIMESTAMP,
+		expires_at   TIMESTAMP NOT NULL
+	)`,
+}
+
+const (
+	clientColumns = `id, name, secret_hash, redirect_uris, grant_types, scopes, service_account_id, owner_id, created_at`
+	codeColumns   = `hash, client_id, user_id, redirect_uri, scopes, challenge, nonce, auth_time, expires_at`
+)
+
+type SQLStore struct {
+	dbProvider *db.DBProvider
+	db         *sql.DB
+}
+
+// Verify Interface Compliance
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore creates the OAuth schema if it does not exist yet and adds
+// the columns introduced since.
+func NewSQLStore(dp *db.DBProvider) (*SQLStore, error) {
+	for _, stmt := range sqlSchema {
+		if _, err := dp.SQL.ExecContext(dp.Context, stmt); err != nil {
+			return nil, err
+		}
+	}
+	if err := dp.EnsureColumn(dp.Context, db.OAuthCodes, "nonce", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return nil, err
+	}
+	if err := dp.EnsureColumn(dp.Context, db.OAuthCodes, "auth_time", `TIMESTAMP`); err != nil {
+		return nil, err
+	}
+	return &SQLStore{
+		dbProvider: dp,
+		db:         dp.SQL,
+	}, nil
+}
+
+func (cs *SQLStore) ListClients(ctx context.Context) ([]Client, error) {
+	ctx, cancel := cs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := cs.db.QueryContext(ctx, `SELECT `+clientColumns+` FROM oauth_clients ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := make([]Client, 0)
+	for rows.Next() {
+		cl, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *cl)
+	}
+	return clients, rows.Err()
+}
+
+func (cs *SQLStore) GetClient(ctx context.Context, id primitive.ObjectID) (*Client, error) {
+	ctx, cancel := cs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	cl, err := scanClient(cs.db.QueryRowContext(ctx,
+		`SELECT `+clientColumns+` FROM oauth_clients WHERE id = $1`, id.Hex()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return cl, err
+}
+
+func (cs *SQLStore) CreateClient(ctx context.Context, cl *Client) error {
+	ctx, cancel := cs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	args, err := clientArgs(cl)
+	if err != nil {
+		return err
+	}
+	_, err = cs.db.ExecContext(ctx,
+		`INSERT INTO oauth_clients (`+clientColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`, args...)
+	return err
+}
+
+func (cs *SQLStore) UpdateClient(ctx context.Context, cl *Client) error {
+	ctx, cancel := cs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	args, err := clientArgs(cl)
+	if err != nil {
+		return err
+	}
+	_, err = cs.db.ExecContext(ctx,
+		`UPDATE oauth_clients SET name = $2, secret_hash = $3, redirect_uris = $4, grant_types = $5,
+			scopes = $6, service_account_id = $7 WHERE id = $1`, args[:7]...)
+	return err
+}
+
+func (cs *SQLStore) DeleteClient(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := cs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := cs.db.ExecContext(ctx, `DELETE FROM oauth_clients WHERE id = $1`, id.Hex())
+	return err
+}
+
+func (cs *SQLStore) CreateCode(ctx context.Context, code *Code) error {
+	ctx, cancel := cs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	scopes, err := json.Marshal(code.Scopes)
+	if err != nil {
+		return err
+	}
+	// There is no TTL in SQL, so codes that were never exchanged are
+	// swept here.
+	if _, err := cs.db.ExecContext(ctx,
+		`DELETE FROM oauth_codes WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err = cs.db.ExecContext(ctx,
+		`INSERT INTO oauth_codes (`+codeColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		code.Hash, code.ClientID.Hex(), code.UserID.Hex(), code.RedirectURI, string(scopes), code.Challenge,
+		code.Nonce, code.AuthTime, code.ExpiresAt)
+	return err
+}
+
+func (cs *SQLStore) ConsumeCode(ctx context.Context, hash string) (*Code, error) {
+	ctx, cancel := cs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	var (
+		code                 Code
+		client, user, scopes string
+		authTime             sql.NullTime
+	)
+	err := cs.db.QueryRowContext(ctx,
+		`DELETE FROM oauth_codes WHERE hash = $1 RETURNING `+codeColumns, hash).
+		Scan(&code.Hash, &client, &user, &code.RedirectURI, &scopes, &code.Challenge, &code.Nonce, &authTime, &code.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	code.AuthTime = authTime.Time
+	if code.ClientID, err = primitive.ObjectIDFromHex(client); err != nil {
+		return nil, err
+	}
+	if code.UserID, err = primitive.ObjectIDFromHex(user); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(scopes), &code.Scopes); err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// clientArgs lists the columns of cl in clientColumns order.
+func clientArgs(cl *Client) ([]interface{}, error) {
+	lists := make([]string, 0, 3)
+	for _, l := range [][]string{cl.RedirectURIs, cl.GrantTypes, cl.Scopes} {
+		b, err := json.Marshal(l)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, string(b))
+	}
+	var account sql.NullString
+	if cl.ServiceAccountID != nil {
+		account = sql.NullString{String: cl.ServiceAccountID.Hex(), Valid: true}
+	}
+	return []interface{}{
+		cl.ID.Hex(), cl.Name, cl.SecretHash, lists[0], lists[1], lists[2], account, cl.OwnerID.Hex(), cl.CreatedAt,
+	}, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClient(row rowScanner) (*Client, error) {
+	var (
+		cl                                   Client
+		id, owner, redirects, grants, scopes string
+		account                              sql.NullString
+	)
+	err := row.Scan(&id, &cl.Name, &cl.SecretHash, &redirects, &grants, &scopes, &account, &owner, &cl.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if cl.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if cl.OwnerID, err = primitive.ObjectIDFromHex(owner); err != nil {
+		return nil, err
+	}
+	if account.Valid {
+		aid, err := primitive.ObjectIDFromHex(account.String)
+		if err != nil {
+			return nil, err
+		}
+		cl.ServiceAccountID = &aid
+	}
+	for _, l := range []struct {
+		raw string
+		dst *[]string
+	}{{redirects, &cl.RedirectURIs}, {grants, &cl.GrantTypes}, {scopes, &cl.Scopes}} {
+		if err := json.Unmarshal([]byte(l.raw), l.dst); err != nil {
+			return nil, err
+		}
+	}
+	return &cl, nil
+}
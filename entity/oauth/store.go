@@ -0,0 +1,135 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store is the persistence contract for OAuth clients and authorization
+// codes. Lookups return (nil, nil) when nothing matches. ListClients is
+// ordered by creation time. ConsumeCode deletes the code and returns it,
+// so that of two concurrent exchanges only one gets it; it may return
+// expired codes that were not swept yet.
+type Store interface {
+	ListClients(ctx context.Context) ([]Client, error)
+	GetClient(ctx context.Context, id primitive.ObjectID) (*Client, error)
+	CreateClient(ctx context.Context, cl *Client) error
+	UpdateClient(ctx context.Context, cl *Client) error
+	DeleteClient(ctx context.Context, id primitive.ObjectID) error
+
+	CreateCode(ctx context.Context, code *Code) error
+	ConsumeCode(ctx context.Context, hash string) (*Code, error)
+}
+
+// NewStore returns the Store implementation for the provider's driver.
+func NewStore(dp *db.DBProvider) (Store, error) {
+	switch dp.Driver {
+	case db.Mongo:
+		return NewMongoStore(dp), nil
+	case db.SQLite, db.Postgres:
+		return NewSQLStore(dp)
+	case db.Memory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("oauth: unsupported driver %q", dp.Driver)
+}
+
+// MongoStore relies on a TTL index on oauth_codes.expires_at to remove
+// codes that were never exchanged.
+type MongoStore struct {
+	dbProvider *db.DBProvider
+	clients    *mongo.Collection
+	codes      *mongo.Collection
+}
+
+// Verify Interface Compliance
+var _ Store = (*MongoStore)(nil)
+
+func NewMongoStore(dp *db.DBProvider) *MongoStore {
+	return &MongoStore{
+		dbProvider: dp,
+		clients:    dp.GetCollection(db.OAuthClients),
+		codes:      dp.GetCollection(db.OAuthCodes),
+	}
+}
+
+func (cs *MongoStore) ListClients(ctx context.Context) ([]Client, error) {
+	ctx, cancel := cs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	cursor, err := cs.clients.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]Client, 0)
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (cs *MongoStore) GetClient(ctx context.Context, id primitive.ObjectID) (*Client, error) {
+	ctx, cancel := cs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var cl Client
+	if err := cs.clients.FindOne(ctx, bson.M{"_id": id}).Decode(&cl); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cl, nil
+}
+
+func (cs *MongoStore) CreateClient(ctx context.Context, cl *Client) error {
+	ctx, cancel := cs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := cs.clients.InsertOne(ctx, cl)
+	return err
+}
+
+func (cs *MongoStore) UpdateClient(ctx context.Context, cl *Client) error {
+	ctx, cancel := cs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := cs.clients.ReplaceOne(ctx, bson.M{"_id": cl.ID}, cl)
+	return err
+}
+
+func (cs *MongoStore) DeleteClient(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := cs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := cs.clients.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (cs *MongoStore) CreateCode(ctx context.Context, code *Code) error {
+	ctx, cancel := cs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := cs.codes.InsertOne(ctx, code)
+	return err
+}
+
+func (cs *MongoStore) ConsumeCode(ctx context.Context, hash string) (*Code, error) {
+	ctx, cancel := cs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	var code Code
+	if err := cs.codes.FindOneAndDelete(ctx, bson.M{"_id": hash}).Decode(&code); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &code, nil
+}
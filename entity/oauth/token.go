@@ -0,0 +1,233 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Token godoc
+// @Summary Issue an access token
+// @Description The token endpoint of RFC 6749 for the authorization_code and client_credentials grants. Confidential clients authenticate with HTTP Basic or client_id and client_secret in the body; public clients send only client_id. The authorization code grant needs the PKCE code_verifier, and returns an OpenID Connect ID token as well if the openid scope was granted.
+// @ID oauth-token
+// @Tags oauth
+// @Accept  x-www-form-urlencoded
+// @Produce  json
+// @Param grant_type formData string true "authorization_code or client_credentials"
+// @Param client_id formData string false "Client ID, unless sent with HTTP Basic"
+// @Param client_secret formData string false "Client secret, unless sent with HTTP Basic"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "The redirect_uri of the authorization request, if it had one"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param scope formData string false "Space separated scopes for client_credentials; defaults to all of the client's scopes"
+// @Success 200 {object} tokenResponse
+// @Failure 400 {object} tokenError
+// @Failure 401 {object} tokenError
+// @Failure 500 {object} tokenError
+// @Router /oauth/token [post]
+func Token(c echo.Context) error {
+	// RFC 6749 section 5.1: token responses must not be cached.
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Pragma", "no-cache")
+
+	cl, err := authenticateClient(c)
+	if err != nil {
+		return tokenFailed(c, err)
+	}
+	grant := c.FormValue("grant_type")
+	if grant != GrantAuthorizationCode && grant != GrantClientCredentials {
+		return tokenFailed(c, &tokenError{"unsupported_grant_type", ""})
+	}
+	if !cl.Allows(grant) {
+		return tokenFailed(c, &tokenError{"unauthorized_client", "the client may not use this grant type"})
+	}
+
+	var g *granted
+	if grant == GrantAuthorizationCode {
+		g, err = exchangeCode(c, cl)
+	} else {
+		g, err = clientCredentials(c, cl)
+	}
+	if err != nil {
+		return tokenFailed(c, err)
+	}
+
+	res := &tokenResponse{
+		AccessToken: identity.GenerateJWTWithOptions(g.Subject, g.Roles, identity.TokenOptions{
+			ClientID: cl.ID.Hex(),
+			Scopes:   g.Scopes,
+		}),
+		TokenType: "Bearer",
+		ExpiresIn: int64(identity.TokenLifetime.Seconds()),
+		Scope:     strings.Join(g.Scopes, " "),
+	}
+	if res.AccessToken == "" {
+		return tokenFailed(c, &tokenError{"server_error", "no signing key is active"})
+	}
+	if g.User != nil {
+		g.IDToken.ClientID = cl.ID.Hex()
+		g.IDToken.AccessToken = res.AccessToken
+		res.IDToken = identity.GenerateIDToken(userInfo(g.User, g.Scopes), g.IDToken)
+		if res.IDToken == "" {
+			return tokenFailed(c, &tokenError{"server_error", "no asymmetric signing key is active for ID tokens"})
+		}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// granted is what a grant yields: the subject and scopes of the access
+// token, and the user and ID token details when an ID token is due.
+type granted struct {
+	Subject primitive.ObjectID
+	Roles   []identity.Role
+	Scopes  []string
+	User    *user.User
+	IDToken identity.IDTokenOptions
+}
+
+// errInvalidClient is answered with 401, as RFC 6749 section 5.2 asks.
+var errInvalidClient = &tokenError{"invalid_client", "client authentication failed"}
+
+func (e *tokenError) Error() string { return e.Code + ": " + e.Description }
+
+// authenticateClient finds the client of a token request. Confidential
+// clients must present their secret; public clients must not send one.
+func authenticateClient(c echo.Context) (*Client, error) {
+	id, secret, basic := c.Request().BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes both before Basic encoding.
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return nil, errInvalidClient
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, errInvalidClient
+		}
+	} else {
+		id, secret = c.FormValue("client_id"), c.FormValue("client_secret")
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+	cl, err := store.GetClient(c.Request().Context(), oid)
+	if err != nil {
+		return nil, err
+	}
+	if cl == nil {
+		return nil, errInvalidClient
+	}
+	if cl.Confidential() {
+		if !identity.TokenMatches(secret, cl.SecretHash) {
+			return nil, errInvalidClient
+		}
+	} else if secret != "" {
+		return nil, errInvalidClient
+	}
+	return cl, nil
+}
+
+// exchangeCode redeems an authorization code for the user who approved
+// it. A code is consumed by the first attempt, successful or not.
+func exchangeCode(c echo.Context, cl *Client) (*granted, error) {
+	ctx := c.Request().Context()
+	invalid := &tokenError{"invalid_grant", "the code is invalid, expired or was issued to another client"}
+
+	raw := c.FormValue("code")
+	if raw == "" {
+		return nil, &tokenError{"invalid_request", "code is required"}
+	}
+	code, err := store.ConsumeCode(ctx, identity.HashToken(raw))
+	if err != nil {
+		return nil, err
+	}
+	if code == nil || !now().Before(code.ExpiresAt) || code.ClientID != cl.ID {
+		return nil, invalid
+	}
+	if c.FormValue("redirect_uri") != code.RedirectURI {
+		return nil, invalid
+	}
+	if !verifyChallenge(c.FormValue("code_verifier"), code.Challenge) {
+		return nil, &tokenError{"invalid_grant", "the code verifier does not match"}
+	}
+
+	// The client may have lost scopes since the code was issued.
+	scopes := make([]string, 0, len(code.Scopes))
+	for _, s := range code.Scopes {
+		if cl.allowsScope(s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, &tokenError{"invalid_scope", "the client may no longer have the granted scopes"}
+	}
+
+	u, err := users.GetByID(ctx, code.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, invalid
+	}
+	g := &granted{Subject: u.ID, Roles: u.Roles, Scopes: scopes}
+	if contains(scopes, identity.ScopeOpenID) {
+		g.User = u
+		g.IDToken = identity.IDTokenOptions{Nonce: code.Nonce, AuthTime: code.AuthTime}
+	}
+	return g, nil
+}
+
+// clientCredentials issues a token for the service account the client is
+// linked to.
+func clientCredentials(c echo.Context, cl *Client) (*granted, error) {
+	if !cl.Confidential() || cl.ServiceAccountID == nil {
+		return nil, &tokenError{"unauthorized_client", "the client is not linked to a service account"}
+	}
+	scopes, ok := cl.grant(c.FormValue("scope"))
+	if !ok {
+		return nil, &tokenError{"invalid_scope", "the client may not request these scopes"}
+	}
+	a, err := accounts.GetAccount(c.Request().Context(), *cl.ServiceAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil || a.Disabled {
+		return nil, &tokenError{"unauthorized_client", "the service account is disabled or was deleted"}
+	}
+	return &granted{Subject: a.ID, Roles: a.Roles, Scopes: scopes}, nil
+}
+
+// verifyChallenge checks an S256 PKCE code verifier (RFC 7636 section
+// 4.6).
+func verifyChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func tokenFailed(c echo.Context, err error) error {
+	te, ok := err.(*tokenError)
+	if !ok {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusInternalServerError, &tokenError{"server_error", ""})
+	}
+	if te.Code == "invalid_client" {
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		return c.JSON(http.StatusUnauthorized, te)
+	}
+	if te.Code == "server_error" {
+		return c.JSON(http.StatusInternalServerError, te)
+	}
+	return c.JSON(http.StatusBadRequest, te)
+}
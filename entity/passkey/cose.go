@@ -0,0 +1,118 @@
+package passkey
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// The COSE algorithms passkeys are accepted with, in order of preference.
+const (
+	algES256 = -7
+	algEdDSA = -8
+	algRS256 = -257
+)
+
+var algorithms = []int{algES256, algEdDSA, algRS256}
+
+var ErrAlgorithm = errors.New("the passkey uses an unsupported algorithm")
+
+// COSE_Key labels (RFC 9053). Labels below zero mean different things per
+// key type.
+const (
+	labelKeyType   = 1
+	labelAlgorithm = 3
+	labelCurve     = -1 // EC2 and OKP
+	labelX         = -2 // EC2 and OKP
+	labelY         = -3 // EC2
+	labelN         = -1 // RSA
+	labelE         = -2 // RSA
+)
+
+// Key types and curves of the supported algorithms.
+const (
+	keyTypeOKP   = 1
+	keyTypeEC2   = 2
+	keyTypeRSA   = 3
+	curveP256    = 1
+	curveEd25519 = 6
+)
+
+// publicKey decodes a COSE key into its algorithm and a crypto key.
+func publicKey(raw []byte) (int, crypto.PublicKey, error) {
+	var k map[int]cbor.RawMessage
+	if err := cbor.Unmarshal(raw, &k); err != nil {
+		return 0, nil, ErrCredential
+	}
+	var kty, alg, crv int
+	if cbor.Unmarshal(k[labelKeyType], &kty) != nil || cbor.Unmarshal(k[labelAlgorithm], &alg) != nil {
+		return 0, nil, ErrCredential
+	}
+	bytesAt := func(label int) []byte {
+		var b []byte
+		cbor.Unmarshal(k[label], &b)
+		return b
+	}
+
+	switch {
+	case kty == keyTypeEC2 && alg == algES256:
+		if cbor.Unmarshal(k[labelCurve], &crv) != nil || crv != curveP256 {
+			return 0, nil, ErrAlgorithm
+		}
+		xb, yb := bytesAt(labelX), bytesAt(labelY)
+		if len(xb) != 32 || len(yb) != 32 {
+			return 0, nil, ErrCredential
+		}
+		x, y := new(big.Int).SetBytes(xb), new(big.Int).SetBytes(yb)
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return 0, nil, ErrCredential
+		}
+		return alg, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case kty == keyTypeOKP && alg == algEdDSA:
+		if cbor.Unmarshal(k[labelCurve], &crv) != nil || crv != curveEd25519 {
+			return 0, nil, ErrAlgorithm
+		}
+		x := bytesAt(labelX)
+		if len(x) != ed25519.PublicKeySize {
+			return 0, nil, ErrCredential
+		}
+		return alg, ed25519.PublicKey(x), nil
+	case kty == keyTypeRSA && alg == algRS256:
+		n, e := bytesAt(labelN), new(big.Int).SetBytes(bytesAt(labelE))
+		if len(n) < 256 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return 0, nil, ErrCredential
+		}
+		return alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(e.Int64())}, nil
+	}
+	return 0, nil, ErrAlgorithm
+}
+
+// verifySignature checks sig over data with a stored COSE key.
+func verifySignature(rawKey, data, sig []byte) error {
+	_, key, err := publicKey(rawKey)
+	if err != nil {
+		return err
+	}
+	ok := false
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, sum[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	}
+	if !ok {
+		return ErrSignature
+	}
+	return nil
+}
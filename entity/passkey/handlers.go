@@ -0,0 +1,250 @@
+package passkey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/entity/mfa"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrConfirm refuses a registration of a user with a second factor who
+// did not confirm it with their password or a current code.
+var ErrConfirm = errors.New("confirm with your password or a current code to add a passkey")
+
+var (
+	manager      *Manager
+	secondFactor *mfa.Manager
+	complete     func(c echo.Context, u *user.User) error
+)
+
+// RegisterHandlers mounts passkey management and passwordless login. sf
+// holds back passkey logins of users with another second factor and
+// registrations that were not confirmed; done finishes a login, normally
+// user.CompleteLogin.
+func RegisterHandlers(v1 *echo.Group, auth echo.MiddlewareFunc, m *Manager, sf *mfa.Manager, done func(c echo.Context, u *user.User) error) {
+
+	manager = m
+	secondFactor = sf
+	complete = done
+
+	v1.POST("/users/login/passkey/begin", BeginLogin)
+	v1.POST("/users/login/passkey/finish", FinishLogin)
+
+	own := v1.Group("/user/passkeys", auth, identity.RequireLogin())
+	own.GET("", ListPasskeys)
+	own.POST("/register/begin", BeginRegistration)
+	own.POST("/register/finish", FinishRegistration)
+	own.DELETE("/:id", DeletePasskey)
+}
+
+// BeginRegistration godoc
+// @Summary Start registering a passkey
+// @Description Return the options to pass to navigator.credentials.create for a new passkey of the current user. Send the resulting credential to /user/passkeys/register/finish within five minutes. Users with a second factor confirm with their password or a current TOTP code, so that a stolen access token cannot add a passkey.
+// @ID begin-passkey-registration
+// @Tags passkey
+// @Accept  json
+// @Produce  json
+// @Param confirmation body confirmationRequest false "Password or code, for users with a second factor"
+// @Success 200 {object} creationOptionsResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/passkeys/register/begin [post]
+func BeginRegistration(c echo.Context) error {
+	req := &confirmationRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	ctx := c.Request().Context()
+	u, err := manager.users.GetByID(ctx, userIDFromToken(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if u == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	ok, err := confirmed(ctx, u, req)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if !ok {
+		return c.JSON(http.StatusForbidden, customerror.NewError(ErrConfirm))
+	}
+	// FinishRegistration takes only the challenge issued here, so the
+	// confirmation covers it as well.
+	o, err := manager.BeginRegistration(ctx, u)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, &creationOptionsResponse{PublicKey: o})
+}
+
+// FinishRegistration godoc
+// @Summary Finish registering a passkey
+// @Description Store the credential navigator.credentials.create returned, under an optional name.
+// @ID finish-passkey-registration
+// @Tags passkey
+// @Accept  json
+// @Produce  json
+// @Param passkey body registrationRequest true "Name and credential"
+// @Success 201 {object} credentialResponse
+// @Failure 400 {object} customerror.Error
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 409 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/passkeys/register/finish [post]
+func FinishRegistration(c echo.Context) error {
+	req := &registrationRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	cred, err := manager.FinishRegistration(c.Request().Context(), userIDFromToken(c), req.Name, &req.Credential)
+	switch {
+	case err == nil:
+	case err == ErrExists:
+		return c.JSON(http.StatusConflict, customerror.NewError(err))
+	case rejected(err):
+		return c.JSON(http.StatusBadRequest, customerror.NewError(err))
+	default:
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusCreated, newCredentialResponse(cred))
+}
+
+// ListPasskeys godoc
+// @Summary List own passkeys
+// @Description List the passkeys of the current user. Public keys are not shown.
+// @ID list-passkeys
+// @Tags passkey
+// @Produce  json
+// @Success 200 {object} credentialListResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/passkeys [get]
+func ListPasskeys(c echo.Context) error {
+	creds, err := manager.List(c.Request().Context(), userIDFromToken(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, newCredentialListResponse(creds))
+}
+
+// DeletePasskey godoc
+// @Summary Remove a passkey
+// @Description Remove a passkey of the current user. It can no longer be used to log in.
+// @ID delete-passkey
+// @Tags passkey
+// @Param        id   path      string  true  "Credential ID"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/passkeys/{id} [delete]
+func DeletePasskey(c echo.Context) error {
+	ok, err := manager.Delete(c.Request().Context(), userIDFromToken(c), c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if !ok {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// BeginLogin godoc
+// @Summary Start a passkey login
+// @Description Return the options to pass to navigator.credentials.get for a login without a password. The authenticator offers the passkeys it holds for this site; send the assertion to /users/login/passkey/finish within five minutes.
+// @ID begin-passkey-login
+// @Tags passkey
+// @Produce  json
+// @Success 200 {object} requestOptionsResponse
+// @Failure 500 {object} customerror.Error
+// @Router /users/login/passkey/begin [post]
+func BeginLogin(c echo.Context) error {
+	o, err := manager.BeginLogin(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, &requestOptionsResponse{PublicKey: o})
+}
+
+// FinishLogin godoc
+// @Summary Finish a passkey login
+// @Description Log in with the assertion navigator.credentials.get returned. The authenticator must have verified the user. The response is the same as for a password login: users with a second factor other than their passkeys, such as TOTP, get a challenge to answer at /users/login/mfa, which passkeys cannot answer.
+// @ID finish-passkey-login
+// @Tags passkey
+// @Accept  json
+// @Produce  json
+// @Param login body loginRequest true "Assertion"
+// @Success 200 {object} user.userResponse
+// @Success 202 {object} mfa.challengeResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Router /users/login/passkey/finish [post]
+func FinishLogin(c echo.Context) error {
+	req := &loginRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	u, err := manager.FinishLogin(c.Request().Context(), &req.Credential)
+	switch {
+	case err == nil:
+	case rejected(err):
+		return c.JSON(http.StatusUnauthorized, customerror.NewError(err))
+	default:
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	required, err := secondFactor.RequiredAfter(c.Request().Context(), u, manager.Name())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if required {
+		return secondFactor.ChallengeAfter(c, u, manager.Name())
+	}
+	return complete(c, u)
+}
+
+// confirmed reports whether req proves that u is at hand: always for
+// users without a second factor, otherwise with their password or a
+// current TOTP code.
+func confirmed(ctx context.Context, u *user.User, req *confirmationRequest) (bool, error) {
+	required, err := secondFactor.Required(ctx, u)
+	if err != nil || !required {
+		return !required, err
+	}
+	if req.Password != "" {
+		pu, err := user.Authenticate(ctx, u.Email, req.Password)
+		if err != nil {
+			return false, err
+		}
+		if pu != nil && pu.ID == u.ID {
+			return true, nil
+		}
+	}
+	if req.Code != "" {
+		return secondFactor.Verify(ctx, u, req.Code)
+	}
+	return false, nil
+}
+
+func userIDFromToken(c echo.Context) primitive.ObjectID {
+	id, _ := c.Get("user").(primitive.ObjectID)
+	return id
+}
@@ -0,0 +1,154 @@
+package passkey
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps passkeys and ceremonies in process memory; nothing
+// survives a restart.
+type MemoryStore struct {
+	mu          sync.Mutex
+	credentials map[string]Credential
+	sessions    map[string]Session
+}
+
+// Verify Interface Compliance
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		credentials: make(map[string]Credential),
+		sessions:    make(map[string]Session),
+	}
+}
+
+func (ps *MemoryStore) List(ctx context.Context, userID primitive.ObjectID) ([]*Credential, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var creds []*Credential
+	for _, c := range ps.credentials {
+		if c.UserID == userID {
+			c := c
+			creds = append(creds, &c)
+		}
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].CreatedAt.Before(creds[j].CreatedAt) })
+	return creds, nil
+}
+
+func (ps *MemoryStore) Get(ctx context.Context, id string) (*Credential, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	c, ok := ps.credentials[id]
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (ps *MemoryStore) Create(ctx context.Context, c *Credential) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, ok := ps.credentials[c.ID]; ok {
+		return ErrExists
+	}
+	ps.credentials[c.ID] = *c
+	return nil
+}
+
+func (ps *MemoryStore) UseCredential(ctx context.Context, id string, signCount uint32, at time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	c, ok := ps.credentials[id]
+	if !ok || (signCount <= c.SignCount && (signCount != 0 || c.SignCount != 0)) {
+		return false, nil
+	}
+	c.SignCount = signCount
+	c.LastUsedAt = &at
+	ps.credentials[id] = c
+	return true, nil
+}
+
+func (ps *MemoryStore) Delete(ctx context.Context, userID primitive.ObjectID, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	c, ok := ps.credentials[id]
+	if !ok || c.UserID != userID {
+		return false, nil
+	}
+	delete(ps.credentials, id)
+	return true, nil
+}
+
+func (ps *MemoryStore) DeleteAll(ctx context.Context, userID primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for id, c := range ps.credentials {
+		if c.UserID == userID {
+			delete(ps.credentials, id)
+		}
+	}
+	return nil
+}
+
+func (ps *MemoryStore) CreateSession(ctx context.Context, s *Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	// Sweep ceremonies nobody finished.
+	now := time.Now()
+	for hash, other := range ps.sessions {
+		if !now.Before(other.ExpiresAt) {
+			delete(ps.sessions, hash)
+		}
+	}
+	ps.sessions[s.Hash] = *s
+	return nil
+}
+
+func (ps *MemoryStore) ConsumeSession(ctx context.Context, hash string) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	s, ok := ps.sessions[hash]
+	if !ok {
+		return nil, nil
+	}
+	delete(ps.sessions, hash)
+	return &s, nil
+}
@@ -0,0 +1,317 @@
+// Package passkey lets users register WebAuthn credentials (passkeys) and
+// log in with them instead of a password.
+//
+// Both ceremonies take two requests. The begin request returns the
+// options for navigator.credentials.create or .get with a fresh
+// challenge; the finish request takes the PublicKeyCredential the browser
+// returned. Challenges are single use and expire after a few minutes.
+// Registration stores the credential's public key; a login checks the
+// assertion signature with it and requires the signature counter to move
+// forward, so that cloned authenticators stand out. A passkey login
+// stands in for the password only: users with another second factor,
+// such as TOTP, still answer its challenge, and users with any second
+// factor confirm a new passkey with their password or a code.
+//
+// Passkeys can also be a second factor after the password: Manager is an
+// mfa.Method, and login challenges then carry request options limited to
+// the user's own passkeys.
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/hamed-lohi/user-manage/entity/mfa"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// ceremonyLifetime bounds how long the user may take at the
+	// authenticator.
+	ceremonyLifetime = 5 * time.Minute
+	challengeBytes   = 32
+)
+
+// The purposes of a ceremony. A challenge only finishes the ceremony it
+// was issued for.
+const (
+	purposeRegister = "register"
+	purposeLogin    = "login"
+	purposeMFA      = "mfa"
+)
+
+// Credential is a registered passkey. ID is the base64url encoded
+// credential ID and PublicKey its COSE encoded key. SignCount is the last
+// signature counter seen; authenticators that do not count report zero.
+type Credential struct {
+	ID             string             `bson:"_id"`
+	UserID         primitive.ObjectID `bson:"user_id"`
+	Name           string             `bson:"name"`
+	PublicKey      []byte             `bson:"public_key"`
+	Algorithm      int                `bson:"algorithm"`
+	SignCount      uint32             `bson:"sign_count"`
+	Transports     []string           `bson:"transports,omitempty"`
+	AAGUID         string             `bson:"aaguid"`
+	BackupEligible bool               `bson:"backup_eligible"`
+	BackedUp       bool               `bson:"backed_up"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	LastUsedAt     *time.Time         `bson:"last_used_at,omitempty"`
+}
+
+// Session is a ceremony waiting for the authenticator. Only the SHA-256
+// of the challenge is stored. UserID is zero for passwordless logins,
+// where the passkey names the user.
+type Session struct {
+	Hash      string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Purpose   string             `bson:"purpose"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// Manager runs the ceremonies of one relying party.
+type Manager struct {
+	rp    RelyingParty
+	store Store
+	users user.Store
+	now   func() time.Time
+}
+
+// Verify Interface Compliance
+var _ mfa.Method = (*Manager)(nil)
+
+func NewManager(rp RelyingParty, s Store, us user.Store) *Manager {
+	return &Manager{rp: rp, store: s, users: us, now: time.Now}
+}
+
+// BeginRegistration returns the options to create a passkey for u,
+// excluding the passkeys u already has.
+func (m *Manager) BeginRegistration(ctx context.Context, u *user.User) (*creationOptions, error) {
+	creds, err := m.store.List(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := m.newSession(ctx, u.ID, purposeRegister)
+	if err != nil {
+		return nil, err
+	}
+	return newCreationOptions(&m.rp, u, challenge, creds), nil
+}
+
+// FinishRegistration checks the new credential against the registration
+// the user began and stores it.
+func (m *Manager) FinishRegistration(ctx context.Context, userID primitive.ObjectID, name string, r *attestationResponse) (*Credential, error) {
+	challenge, err := m.rp.checkClientData(r.Response.ClientDataJSON, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.session(ctx, challenge, purposeRegister, userID); err != nil {
+		return nil, err
+	}
+	var ao attestationObject
+	if err := cbor.Unmarshal(r.Response.AttestationObject, &ao); err != nil {
+		return nil, ErrCredential
+	}
+	ad, err := m.rp.checkAuthData(ao.AuthData, false)
+	if err != nil {
+		return nil, err
+	}
+	if ad.Flags&flagAttestedData == 0 || !bytes.Equal(ad.CredentialID, r.RawID) {
+		return nil, ErrCredential
+	}
+	alg, _, err := publicKey(ad.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+	now := m.now().UTC()
+	cred := &Credential{
+		ID:             b64.EncodeToString(ad.CredentialID),
+		UserID:         userID,
+		Name:           name,
+		PublicKey:      append([]byte(nil), ad.PublicKey...),
+		Algorithm:      alg,
+		SignCount:      ad.SignCount,
+		Transports:     r.Response.Transports,
+		AAGUID:         hex.EncodeToString(ad.AAGUID),
+		BackupEligible: ad.Flags&flagBackupEligible != 0,
+		BackedUp:       ad.Flags&flagBackedUp != 0,
+		CreatedAt:      now,
+	}
+	if err := m.store.Create(ctx, cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// BeginLogin returns the options for a passwordless login. They name no
+// credentials, so the authenticator offers the passkeys it holds for
+// this site.
+func (m *Manager) BeginLogin(ctx context.Context) (*requestOptions, error) {
+	challenge, err := m.newSession(ctx, primitive.NilObjectID, purposeLogin)
+	if err != nil {
+		return nil, err
+	}
+	return newRequestOptions(&m.rp, challenge, nil, "required"), nil
+}
+
+// FinishLogin checks an assertion of a passwordless login and returns the
+// passkey's user. The authenticator must have verified the user, by PIN
+// or biometrics, as the passkey replaces the password.
+func (m *Manager) FinishLogin(ctx context.Context, r *assertionResponse) (*user.User, error) {
+	cred, err := m.assert(ctx, r, purposeLogin, primitive.NilObjectID, true)
+	if err != nil {
+		return nil, err
+	}
+	u, err := m.users.GetByID(ctx, cred.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUnknown
+	}
+	return u, nil
+}
+
+// List returns the passkeys of the user.
+func (m *Manager) List(ctx context.Context, userID primitive.ObjectID) ([]*Credential, error) {
+	return m.store.List(ctx, userID)
+}
+
+// Delete removes a passkey of the user and reports whether there was one.
+func (m *Manager) Delete(ctx context.Context, userID primitive.ObjectID, id string) (bool, error) {
+	return m.store.Delete(ctx, userID, id)
+}
+
+// Name is the name of passkeys among second factors.
+func (m *Manager) Name() string {
+	return "passkey"
+}
+
+// Enabled is true when the user has a passkey.
+func (m *Manager) Enabled(ctx context.Context, userID primitive.ObjectID) (bool, error) {
+	creds, err := m.store.List(ctx, userID)
+	return len(creds) > 0, err
+}
+
+// Options returns the request options of a second factor assertion, which
+// only the user's passkeys can answer.
+func (m *Manager) Options(ctx context.Context, u *user.User) (interface{}, error) {
+	creds, err := m.store.List(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := m.newSession(ctx, u.ID, purposeMFA)
+	if err != nil {
+		return nil, err
+	}
+	return &requestOptionsResponse{PublicKey: newRequestOptions(&m.rp, challenge, creds, "discouraged")}, nil
+}
+
+// Verify checks a second factor assertion of u. Answers that fail the
+// checks are wrong answers, not errors.
+func (m *Manager) Verify(ctx context.Context, u *user.User, answer json.RawMessage) (bool, error) {
+	var r assertionResponse
+	if err := json.Unmarshal(answer, &r); err != nil {
+		return false, nil
+	}
+	_, err := m.assert(ctx, &r, purposeMFA, u.ID, false)
+	if rejected(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Reset removes all passkeys of the user.
+func (m *Manager) Reset(ctx context.Context, userID primitive.ObjectID) error {
+	return m.store.DeleteAll(ctx, userID)
+}
+
+// assert checks an assertion against the ceremony it answers and the
+// stored passkey, and records the new signature counter. userID is the
+// user the ceremony was started for, or zero if the passkey names them.
+func (m *Manager) assert(ctx context.Context, r *assertionResponse, purpose string, userID primitive.ObjectID, requireUV bool) (*Credential, error) {
+	challenge, err := m.rp.checkClientData(r.Response.ClientDataJSON, "webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.session(ctx, challenge, purpose, userID); err != nil {
+		return nil, err
+	}
+	cred, err := m.store.Get(ctx, b64.EncodeToString(r.RawID))
+	if err != nil {
+		return nil, err
+	}
+	if cred == nil || (!userID.IsZero() && cred.UserID != userID) {
+		return nil, ErrUnknown
+	}
+	// Discoverable credentials return the user handle they were created
+	// with; it must name the passkey's owner.
+	if len(r.Response.UserHandle) > 0 && !bytes.Equal(r.Response.UserHandle, cred.UserID[:]) {
+		return nil, ErrUnknown
+	}
+	ad, err := m.rp.checkAuthData(r.Response.AuthenticatorData, requireUV)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(cred.PublicKey, signedData(r.Response.AuthenticatorData, r.Response.ClientDataJSON), r.Response.Signature); err != nil {
+		return nil, err
+	}
+	ok, err := m.store.UseCredential(ctx, cred.ID, ad.SignCount, m.now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSignCount
+	}
+	return cred, nil
+}
+
+// newSession starts a ceremony and returns its challenge.
+func (m *Manager) newSession(ctx context.Context, userID primitive.ObjectID, purpose string) ([]byte, error) {
+	challenge := make([]byte, challengeBytes)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	err := m.store.CreateSession(ctx, &Session{
+		Hash:      identity.HashToken(b64.EncodeToString(challenge)),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: m.now().Add(ceremonyLifetime).UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// session ends the ceremony challenge belongs to. It must be for purpose
+// and userID and not have expired.
+func (m *Manager) session(ctx context.Context, challenge, purpose string, userID primitive.ObjectID) (*Session, error) {
+	s, err := m.store.ConsumeSession(ctx, identity.HashToken(trimPadding(challenge)))
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.Purpose != purpose || s.UserID != userID || !m.now().Before(s.ExpiresAt) {
+		return nil, ErrCeremony
+	}
+	return s, nil
+}
+
+// rejected tells errors of a failed check from failures of the service.
+func rejected(err error) bool {
+	switch err {
+	case ErrCeremony, ErrCredential, ErrOrigin, ErrRPID, ErrPresence, ErrVerified,
+		ErrSignature, ErrSignCount, ErrUnknown, ErrAlgorithm:
+		return true
+	}
+	return false
+}
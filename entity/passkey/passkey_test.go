@@ -0,0 +1,579 @@
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/hamed-lohi/user-manage/entity/mfa"
+	"github.com/hamed-lohi/user-manage/entity/role"
+	"github.com/hamed-lohi/user-manage/entity/token"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const origin = "https://login.example.com"
+
+var rp = RelyingParty{ID: "example.com", Name: "Example", Origins: []string{origin}}
+
+// authenticator is a software authenticator holding one passkey. It
+// counts signatures unless counting is off, and verifies the user unless
+// told otherwise.
+type authenticator struct {
+	rpID       string
+	origin     string
+	key        crypto.Signer
+	id         []byte
+	userHandle []byte
+	count      uint32
+	counting   bool
+	skipUV     bool
+}
+
+func newAuthenticator(t *testing.T, key crypto.Signer) *authenticator {
+	t.Helper()
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{rpID: rp.ID, origin: origin, key: key, id: id, counting: true}
+}
+
+func newES256(t *testing.T) crypto.Signer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// coseKey encodes the public half of the passkey.
+func (a *authenticator) coseKey(t *testing.T) []byte {
+	t.Helper()
+	var key map[int]interface{}
+	switch pub := a.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		key = map[int]interface{}{
+			labelKeyType: keyTypeEC2, labelAlgorithm: algES256, labelCurve: curveP256,
+			labelX: pad32(pub.X.Bytes()), labelY: pad32(pub.Y.Bytes()),
+		}
+	case ed25519.PublicKey:
+		key = map[int]interface{}{
+			labelKeyType: keyTypeOKP, labelAlgorithm: algEdDSA, labelCurve: curveEd25519, labelX: []byte(pub),
+		}
+	}
+	raw, err := cbor.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (a *authenticator) authData(attested []byte) []byte {
+	flags := byte(flagUserPresent)
+	if !a.skipUV {
+		flags |= flagUserVerified
+	}
+	if attested != nil {
+		flags |= flagAttestedData
+	}
+	rpHash := sha256.Sum256([]byte(a.rpID))
+	ad := append(rpHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(ad[33:], a.count)
+	return append(ad, attested...)
+}
+
+func (a *authenticator) clientData(typ string, challenge []byte) []byte {
+	cd, _ := json.Marshal(map[string]interface{}{"type": typ, "challenge": b64.EncodeToString(challenge), "origin": a.origin})
+	return cd
+}
+
+// create answers creation options as navigator.credentials.create does.
+func (a *authenticator) create(t *testing.T, o *creationOptions) *attestationResponse {
+	t.Helper()
+	a.userHandle = o.User.ID
+	if a.counting {
+		a.count = 1
+	}
+	attested := append(make([]byte, 16), byte(len(a.id)>>8), byte(len(a.id)))
+	attested = append(append(attested, a.id...), a.coseKey(t)...)
+	att, err := cbor.Marshal(map[string]interface{}{"fmt": "none", "attStmt": map[string]interface{}{}, "authData": a.authData(attested)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &attestationResponse{ID: b64.EncodeToString(a.id), RawID: a.id, Type: "public-key"}
+	r.Response.ClientDataJSON = a.clientData("webauthn.create", o.Challenge)
+	r.Response.AttestationObject = att
+	r.Response.Transports = []string{"internal"}
+	return r
+}
+
+// get answers request options as navigator.credentials.get does.
+func (a *authenticator) get(t *testing.T, challenge []byte) *assertionResponse {
+	t.Helper()
+	if a.counting {
+		a.count++
+	}
+	ad := a.authData(nil)
+	cd := a.clientData("webauthn.get", challenge)
+	data := signedData(ad, cd)
+	var (
+		sig []byte
+		err error
+	)
+	if _, ok := a.key.(ed25519.PrivateKey); ok {
+		sig, err = a.key.Sign(rand.Reader, data, crypto.Hash(0))
+	} else {
+		sum := sha256.Sum256(data)
+		sig, err = a.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &assertionResponse{ID: b64.EncodeToString(a.id), RawID: a.id, Type: "public-key"}
+	r.Response.ClientDataJSON = cd
+	r.Response.AuthenticatorData = ad
+	r.Response.Signature = sig
+	r.Response.UserHandle = a.userHandle
+	return r
+}
+
+func pad32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+// newTestManager has alice and bob on memory stores.
+func newTestManager(t *testing.T) (*Manager, *user.User, *user.User) {
+	t.Helper()
+	us := user.NewMemoryStore()
+	alice := &user.User{Username: "alice", Email: "alice@example.com", Roles: []identity.Role{identity.Member}}
+	bob := &user.User{Username: "bob", Email: "bob@example.com", Roles: []identity.Role{identity.Member}}
+	for _, u := range []*user.User{alice, bob} {
+		if err := us.Create(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewManager(rp, NewMemoryStore(), us), alice, bob
+}
+
+// register gives u the passkey of a.
+func register(t *testing.T, m *Manager, u *user.User, a *authenticator) *Credential {
+	t.Helper()
+	ctx := context.Background()
+	o, err := m.BeginRegistration(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := m.FinishRegistration(ctx, u.ID, "", a.create(t, o))
+	if err != nil {
+		t.Fatalf("registration: %v", err)
+	}
+	return cred
+}
+
+// logIn runs a passwordless login with a.
+func logIn(t *testing.T, m *Manager, a *authenticator) (*user.User, error) {
+	t.Helper()
+	o, err := m.BeginLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.FinishLogin(context.Background(), a.get(t, o.Challenge))
+}
+
+func TestRegisterAndLogIn(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		key  func(t *testing.T) crypto.Signer
+		alg  int
+	}{
+		{"ES256", newES256, algES256},
+		{"EdDSA", func(t *testing.T) crypto.Signer {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return key
+		}, algEdDSA},
+	} {
+		m, alice, _ := newTestManager(t)
+		a := newAuthenticator(t, tc.key(t))
+		cred := register(t, m, alice, a)
+		if cred.ID != b64.EncodeToString(a.id) || cred.Algorithm != tc.alg || cred.SignCount != 1 || cred.Name != "Passkey" {
+			t.Errorf("%s: stored %+v", tc.name, cred)
+		}
+
+		u, err := logIn(t, m, a)
+		if err != nil || u == nil || u.ID != alice.ID {
+			t.Errorf("%s: login: %v, %v; want alice", tc.name, u, err)
+		}
+	}
+}
+
+func TestRegistrationChecks(t *testing.T) {
+	m, alice, bob := newTestManager(t)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name   string
+		setup  func(a *authenticator)
+		change func(a *authenticator, r *attestationResponse)
+		want   error
+	}{
+		{"other origin", func(a *authenticator) { a.origin = "https://evil.example" }, nil, ErrOrigin},
+		{"other site", func(a *authenticator) { a.rpID = "evil.example" }, nil, ErrRPID},
+		{"assertion instead", nil, func(a *authenticator, r *attestationResponse) {
+			r.Response.ClientDataJSON = a.clientData("webauthn.get", challengeOf(t, r))
+		}, ErrCredential},
+		{"raw ID differs", nil, func(a *authenticator, r *attestationResponse) { r.RawID = []byte("another") }, ErrCredential},
+	} {
+		a := newAuthenticator(t, newES256(t))
+		if tc.setup != nil {
+			tc.setup(a)
+		}
+		o, err := m.BeginRegistration(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := a.create(t, o)
+		if tc.change != nil {
+			tc.change(a, r)
+		}
+		if _, err := m.FinishRegistration(ctx, alice.ID, "", r); err != tc.want {
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	// A registration bob began cannot add a passkey to alice, and no
+	// challenge is good twice.
+	a := newAuthenticator(t, newES256(t))
+	o, err := m.BeginRegistration(ctx, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := a.create(t, o)
+	if _, err := m.FinishRegistration(ctx, alice.ID, "", r); err != ErrCeremony {
+		t.Errorf("another user's registration: %v, want %v", err, ErrCeremony)
+	}
+	if _, err := m.FinishRegistration(ctx, bob.ID, "", r); err != ErrCeremony {
+		t.Errorf("used challenge: %v, want %v", err, ErrCeremony)
+	}
+
+	register(t, m, alice, a)
+	o, err = m.BeginRegistration(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.ExcludeCredentials) != 1 {
+		t.Errorf("creation options exclude %d passkeys, want alice's one", len(o.ExcludeCredentials))
+	}
+	if _, err := m.FinishRegistration(ctx, alice.ID, "", a.create(t, o)); err != ErrExists {
+		t.Errorf("same passkey twice: %v, want %v", err, ErrExists)
+	}
+}
+
+func challengeOf(t *testing.T, r *attestationResponse) []byte {
+	t.Helper()
+	var cd clientData
+	if err := json.Unmarshal(r.Response.ClientDataJSON, &cd); err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := b64.DecodeString(cd.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func TestLoginChecks(t *testing.T) {
+	m, alice, bob := newTestManager(t)
+	ctx := context.Background()
+	a := newAuthenticator(t, newES256(t))
+	register(t, m, alice, a)
+
+	o, err := m.BeginLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := a.get(t, o.Challenge)
+	if _, err := m.FinishLogin(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.FinishLogin(ctx, r); err != ErrCeremony {
+		t.Errorf("replayed assertion: %v, want %v", err, ErrCeremony)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(r *assertionResponse)
+		want   error
+	}{
+		{"forged signature", func(r *assertionResponse) { r.Response.Signature[len(r.Response.Signature)-1] ^= 1 }, ErrSignature},
+		{"signed data changed", func(r *assertionResponse) { r.Response.AuthenticatorData[36]++ }, ErrSignature},
+		{"other user handle", func(r *assertionResponse) { r.Response.UserHandle = bob.ID[:] }, ErrUnknown},
+		{"unregistered passkey", func(r *assertionResponse) { r.RawID = []byte("unregistered") }, ErrUnknown},
+	} {
+		o, err := m.BeginLogin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := a.get(t, o.Challenge)
+		tc.change(r)
+		if _, err := m.FinishLogin(ctx, r); err != tc.want {
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	// A passkey replaces the password only if the authenticator verified
+	// the user; as a second factor presence is enough.
+	a.skipUV = true
+	if _, err := logIn(t, m, a); err != ErrVerified {
+		t.Errorf("passwordless login without user verification: %v, want %v", err, ErrVerified)
+	}
+	if ok, err := verifySecondFactor(t, m, alice, a); !ok || err != nil {
+		t.Errorf("second factor without user verification: %t, %v", ok, err)
+	}
+	// Only the user's own passkey answers their second factor.
+	if ok, err := verifySecondFactor(t, m, bob, a); ok || err != nil {
+		t.Errorf("alice's passkey for bob's second factor: %t, %v", ok, err)
+	}
+}
+
+// verifySecondFactor answers the second factor challenge of u with a.
+func verifySecondFactor(t *testing.T, m *Manager, u *user.User, a *authenticator) (bool, error) {
+	t.Helper()
+	ctx := context.Background()
+	o, err := m.Options(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := json.Marshal(a.get(t, o.(*requestOptionsResponse).PublicKey.Challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.Verify(ctx, u, answer)
+}
+
+func TestSignCountMustIncrease(t *testing.T) {
+	m, alice, _ := newTestManager(t)
+	a := newAuthenticator(t, newES256(t))
+	register(t, m, alice, a)
+
+	for i := 0; i < 2; i++ {
+		if _, err := logIn(t, m, a); err != nil {
+			t.Fatalf("login %d: %v", i+1, err)
+		}
+	}
+	// A clone of the authenticator goes on from an older count, 2 and
+	// then 3, which the original already used.
+	a.count = 1
+	if _, err := logIn(t, m, a); err != ErrSignCount {
+		t.Errorf("count went backwards: %v, want %v", err, ErrSignCount)
+	}
+	if _, err := logIn(t, m, a); err != ErrSignCount {
+		t.Errorf("count repeated: %v, want %v", err, ErrSignCount)
+	}
+	creds, _ := m.List(context.Background(), alice.ID)
+	if len(creds) != 1 || creds[0].SignCount != 3 || creds[0].LastUsedAt == nil {
+		t.Errorf("stored credential after a rejected count: %+v", creds[0])
+	}
+
+	// Authenticators that do not count report zero every time.
+	m, alice, _ = newTestManager(t)
+	a = newAuthenticator(t, newES256(t))
+	a.counting = false
+	register(t, m, alice, a)
+	for i := 0; i < 2; i++ {
+		if _, err := logIn(t, m, a); err != nil {
+			t.Errorf("login %d without a counter: %v", i+1, err)
+		}
+	}
+}
+
+func TestCeremonyExpires(t *testing.T) {
+	m, alice, _ := newTestManager(t)
+	a := newAuthenticator(t, newES256(t))
+	o, err := m.BeginRegistration(context.Background(), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.now = func() time.Time { return time.Now().Add(ceremonyLifetime) }
+	if _, err := m.FinishRegistration(context.Background(), alice.ID, "", a.create(t, o)); err != ErrCeremony {
+		t.Errorf("late registration: %v, want %v", err, ErrCeremony)
+	}
+}
+
+type testValidator struct{ v *validator.Validate }
+
+func (tv testValidator) Validate(i interface{}) error { return tv.v.Struct(i) }
+
+// testServer is the login, second factor and passkey routes on memory
+// stores, with passkeys as a second factor and alice, whose password is
+// "secret", as the only user.
+type testServer struct {
+	e     *echo.Echo
+	m     *Manager
+	mfa   *mfa.MemoryStore
+	alice *user.User
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ts := &testServer{e: echo.New(), mfa: mfa.NewMemoryStore()}
+	ts.e.Validator = testValidator{validator.New()}
+
+	us := user.NewMemoryStore()
+	ts.alice = &user.User{Username: "alice", Email: "alice@example.com", Roles: []identity.Role{identity.Member}}
+	ts.alice.SetPassword("secret")
+	if err := us.Create(context.Background(), ts.alice); err != nil {
+		t.Fatal(err)
+	}
+	ts.m = NewManager(rp, NewMemoryStore(), us)
+	mm := mfa.NewManager(ts.mfa, us, "test", ts.m)
+	rm := token.NewManager(token.NewMemoryStore(), time.Hour)
+	auth := identity.JWTWithConfig(identity.JWTConfig{Keys: identity.Keys, Revocations: rm})
+
+	v1 := ts.e.Group("/api")
+	user.RegisterHandlers(v1, auth, us, role.NewMemoryStore(), rm, user.NewPasswordAuthenticator(us), mm)
+	mfa.RegisterHandlers(v1, auth, mm, user.CompleteLogin)
+	RegisterHandlers(v1, auth, ts.m, mm, user.CompleteLogin)
+	return ts
+}
+
+// call sends a JSON request and decodes the JSON response into out. It
+// returns the status code.
+func (ts *testServer) call(t *testing.T, path, token string, body, out interface{}) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	ts.e.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s: %v in %s", path, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+// enableTOTP gives alice TOTP and returns a function for her codes, by
+// steps from now.
+func (ts *testServer) enableTOTP(t *testing.T) func(steps int64) string {
+	t.Helper()
+	ctx := context.Background()
+	key := []byte("12345678901234567890")
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	if _, err := ts.mfa.CreatePendingTOTP(ctx, &mfa.TOTP{UserID: ts.alice.ID, Secret: secret}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.mfa.EnableTOTP(ctx, ts.alice.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+	return func(steps int64) string {
+		msg := make([]byte, 8)
+		binary.BigEndian.PutUint64(msg, uint64(time.Now().Unix()/30+steps))
+		mac := hmac.New(sha1.New, key)
+		mac.Write(msg)
+		sum := mac.Sum(nil)
+		offset := sum[len(sum)-1] & 0x0f
+		return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:])&0x7fffffff)%1000000)
+	}
+}
+
+type loginResult struct {
+	Token     string   `json:"token"`
+	Challenge string   `json:"challenge"`
+	Methods   []string `json:"methods"`
+}
+
+// passkeyLogin runs a passwordless login with a through the API.
+func (ts *testServer) passkeyLogin(t *testing.T, a *authenticator) (int, *loginResult) {
+	t.Helper()
+	var o requestOptionsResponse
+	if code := ts.call(t, "/api/users/login/passkey/begin", "", nil, &o); code != http.StatusOK {
+		t.Fatalf("begin login: status %d", code)
+	}
+	res := &loginResult{}
+	code := ts.call(t, "/api/users/login/passkey/finish", "", &loginRequest{Credential: *a.get(t, o.PublicKey.Challenge)}, res)
+	return code, res
+}
+
+func TestPasskeyLoginOfTOTPUser(t *testing.T) {
+	ts := newTestServer(t)
+	a := newAuthenticator(t, newES256(t))
+	register(t, ts.m, ts.alice, a)
+
+	// The passkey does not ask for itself again...
+	if code, res := ts.passkeyLogin(t, a); code != http.StatusOK || res.Token == "" {
+		t.Fatalf("passkey login without TOTP: status %d", code)
+	}
+
+	// ...but it does not replace TOTP.
+	totp := ts.enableTOTP(t)
+	code, res := ts.passkeyLogin(t, a)
+	if code != http.StatusAccepted || res.Token != "" || strings.Join(res.Methods, ",") != mfa.MethodTOTP {
+		t.Fatalf("passkey login with TOTP: status %d, methods %v; want a challenge for totp only", code, res.Methods)
+	}
+	o, err := ts.m.Options(context.Background(), ts.alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := map[string]interface{}{
+		"challenge": res.Challenge, "method": ts.m.Name(),
+		"credential": a.get(t, o.(*requestOptionsResponse).PublicKey.Challenge),
+	}
+	if code := ts.call(t, "/api/users/login/mfa", "", again, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("passkey answering its own challenge: status %d, want 422", code)
+	}
+	var done loginResult
+	answer := map[string]string{"challenge": res.Challenge, "code": totp(0)}
+	if code := ts.call(t, "/api/users/login/mfa", "", answer, &done); code != http.StatusOK || done.Token == "" {
+		t.Fatalf("TOTP after the passkey: status %d", code)
+	}
+
+	// Adding a passkey takes the password or a code as well as the token.
+	for _, tc := range []struct {
+		name string
+		body interface{}
+		want int
+	}{
+		{"nothing", nil, http.StatusForbidden},
+		{"wrong password", map[string]string{"password": "wrong"}, http.StatusForbidden},
+		{"used code", map[string]string{"code": totp(0)}, http.StatusForbidden},
+		{"password", map[string]string{"password": "secret"}, http.StatusOK},
+		{"code", map[string]string{"code": totp(1)}, http.StatusOK},
+	} {
+		if code := ts.call(t, "/api/user/passkeys/register/begin", done.Token, tc.body, &struct{}{}); code != tc.want {
+			t.Errorf("registration with %s: status %d, want %d", tc.name, code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package passkey
+
+import "github.com/labstack/echo/v4"
+
+// attestationResponse is the PublicKeyCredential navigator.credentials.create
+// returns, as its toJSON method encodes it.
+type attestationResponse struct {
+	ID       string      `json:"id" validate:"required"`
+	RawID    binaryValue `json:"rawId" validate:"required" swaggertype:"string" format:"base64url"`
+	Type     string      `json:"type" validate:"eq=public-key"`
+	Response struct {
+		ClientDataJSON    binaryValue `json:"clientDataJSON" validate:"required" swaggertype:"string" format:"base64url"`
+		AttestationObject binaryValue `json:"attestationObject" validate:"required" swaggertype:"string" format:"base64url"`
+		Transports        []string    `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// assertionResponse is the PublicKeyCredential navigator.credentials.get
+// returns.
+type assertionResponse struct {
+	ID       string      `json:"id" validate:"required"`
+	RawID    binaryValue `json:"rawId" validate:"required" swaggertype:"string" format:"base64url"`
+	Type     string      `json:"type" validate:"eq=public-key"`
+	Response struct {
+		ClientDataJSON    binaryValue `json:"clientDataJSON" validate:"required" swaggertype:"string" format:"base64url"`
+		AuthenticatorData binaryValue `json:"authenticatorData" validate:"required" swaggertype:"string" format:"base64url"`
+		Signature         binaryValue `json:"signature" validate:"required" swaggertype:"string" format:"base64url"`
+		UserHandle        binaryValue `json:"userHandle,omitempty" swaggertype:"string" format:"base64url"`
+	} `json:"response"`
+}
+
+// confirmationRequest is what users with a second factor send to begin a
+// registration: their password or a current TOTP code.
+type confirmationRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+func (r *confirmationRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+type registrationRequest struct {
+	// Name tells the user's passkeys apart, such as "Laptop".
+	Name       string              `json:"name" validate:"max=64"`
+	Credential attestationResponse `json:"credential"`
+}
+
+func (r *registrationRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+type loginRequest struct {
+	Credential assertionResponse `json:"credential"`
+}
+
+func (r *loginRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
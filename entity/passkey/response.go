@@ -0,0 +1,145 @@
+package passkey
+
+import (
+	"time"
+
+	"github.com/hamed-lohi/user-manage/entity/user"
+)
+
+// The options below are PublicKeyCredentialCreationOptions and
+// PublicKeyCredentialRequestOptions in their JSON form, with binary
+// values base64url encoded as PublicKeyCredential.parseCreationOptionsFromJSON
+// and parseRequestOptionsFromJSON take them.
+
+type relyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          binaryValue `json:"id" swaggertype:"string" format:"base64url"`
+	Name        string      `json:"name"`
+	DisplayName string      `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type       string      `json:"type"`
+	ID         binaryValue `json:"id" swaggertype:"string" format:"base64url"`
+	Transports []string    `json:"transports,omitempty"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type creationOptions struct {
+	Challenge              binaryValue            `json:"challenge" swaggertype:"string" format:"base64url"`
+	RP                     relyingPartyEntity     `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+func newCreationOptions(rp *RelyingParty, u *user.User, challenge []byte, existing []*Credential) *creationOptions {
+	o := &creationOptions{
+		Challenge: challenge,
+		RP:        relyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		// The user handle is the user ID, which says nothing about the
+		// user, as the specification asks.
+		User:               userEntity{ID: binaryValue(u.ID[:]), Name: u.Email, DisplayName: u.Username},
+		Timeout:            ceremonyLifetime.Milliseconds(),
+		ExcludeCredentials: descriptors(existing),
+		// Discoverable credentials allow logins without an email.
+		AuthenticatorSelection: authenticatorSelection{ResidentKey: "preferred", UserVerification: "preferred"},
+		Attestation:            "none",
+	}
+	for _, alg := range algorithms {
+		o.PubKeyCredParams = append(o.PubKeyCredParams, credentialParameter{Type: "public-key", Alg: alg})
+	}
+	return o
+}
+
+type requestOptions struct {
+	Challenge        binaryValue            `json:"challenge" swaggertype:"string" format:"base64url"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+func newRequestOptions(rp *RelyingParty, challenge []byte, allowed []*Credential, uv string) *requestOptions {
+	return &requestOptions{
+		Challenge:        challenge,
+		Timeout:          ceremonyLifetime.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allowed),
+		UserVerification: uv,
+	}
+}
+
+func descriptors(creds []*Credential) []credentialDescriptor {
+	list := make([]credentialDescriptor, 0, len(creds))
+	for _, c := range creds {
+		id, err := b64.DecodeString(c.ID)
+		if err != nil {
+			continue
+		}
+		list = append(list, credentialDescriptor{Type: "public-key", ID: id, Transports: c.Transports})
+	}
+	return list
+}
+
+// creationOptionsResponse and requestOptionsResponse wrap the options the
+// way navigator.credentials.create and .get take them.
+type creationOptionsResponse struct {
+	PublicKey *creationOptions `json:"publicKey"`
+}
+
+type requestOptionsResponse struct {
+	PublicKey *requestOptions `json:"publicKey"`
+}
+
+type credentialResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Transports []string   `json:"transports"`
+	BackedUp   bool       `json:"backed_up"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func newCredentialResponse(c *Credential) *credentialResponse {
+	r := &credentialResponse{
+		ID:         c.ID,
+		Name:       c.Name,
+		Transports: c.Transports,
+		BackedUp:   c.BackedUp,
+		CreatedAt:  c.CreatedAt,
+		LastUsedAt: c.LastUsedAt,
+	}
+	if r.Transports == nil {
+		r.Transports = []string{}
+	}
+	return r
+}
+
+type credentialListResponse struct {
+	Passkeys []*credentialResponse `json:"passkeys"`
+}
+
+func newCredentialListResponse(creds []*Credential) *credentialListResponse {
+	r := &credentialListResponse{Passkeys: make([]*credentialResponse, 0, len(creds))}
+	for _, c := range creds {
+		r.Passkeys = append(r.Passkeys, newCredentialResponse(c))
+	}
+	return r
+}
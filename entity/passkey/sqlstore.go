@@ -0,0 +1,219 @@
+package passkey
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlSchema is shared by SQLite and PostgreSQL. Public keys are stored
+// base64url encoded, as both take TEXT alike.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS passkeys (
+		id              TEXT PRIMARY KEY,
+		user_id         TEXT NOT NULL,
+		name            TEXT NOT NULL,
+		public_key      TEXT NOT NULL,
+		algorithm       INTEGER NOT NULL,
+		sign_count      BIGINT NOT NULL DEFAULT 0,
+		transports      TEXT NOT NULL DEFAULT '[]',
+		aaguid          TEXT NOT NULL DEFAULT '',
+		backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+		backed_up       BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at      TIMESTAMP NOT NULL,
+		last_used_at    TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS passkeys_user ON passkeys (user_id)`,
+	`CREATE TABLE IF NOT EXISTS passkey_sessions (
+		hash       TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		purpose    TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+}
+
+const credentialColumns = `id, user_id, name, public_key, algorithm, sign_count, transports, aaguid,
+	backup_eligible, backed_up, created_at, last_used_at`
+
+type SQLStore struct {
+	dbProvider *db.DBProvider
+	db         *sql.DB
+}
+
+// Verify Interface Compliance
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore creates the passkey schema if it does not exist yet.
+func NewSQLStore(dp *db.DBProvider) (*SQLStore, error) {
+	for _, stmt := range sqlSchema {
+		if _, err := dp.SQL.ExecContext(dp.Context, stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLStore{
+		dbProvider: dp,
+		db:         dp.SQL,
+	}, nil
+}
+
+func (ps *SQLStore) List(ctx context.Context, userID primitive.ObjectID) ([]*Credential, error) {
+	ctx, cancel := ps.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := ps.db.QueryContext(ctx,
+		`SELECT `+credentialColumns+` FROM passkeys WHERE user_id = $1 ORDER BY created_at`, userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []*Credential
+	for rows.Next() {
+		c, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, c)
+	}
+	return creds, rows.Err()
+}
+
+func (ps *SQLStore) Get(ctx context.Context, id string) (*Credential, error) {
+	ctx, cancel := ps.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	c, err := scanCredential(ps.db.QueryRowContext(ctx,
+		`SELECT `+credentialColumns+` FROM passkeys WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+func (ps *SQLStore) Create(ctx context.Context, c *Credential) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	transports, err := json.Marshal(c.Transports)
+	if err != nil {
+		return err
+	}
+	_, err = ps.db.ExecContext(ctx,
+		`INSERT INTO passkeys (`+credentialColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		c.ID, c.UserID.Hex(), c.Name, b64.EncodeToString(c.PublicKey), c.Algorithm, int64(c.SignCount),
+		string(transports), c.AAGUID, c.BackupEligible, c.BackedUp, c.CreatedAt, c.LastUsedAt)
+	if db.IsUniqueViolation(err) {
+		return ErrExists
+	}
+	return err
+}
+
+func (ps *SQLStore) UseCredential(ctx context.Context, id string, signCount uint32, at time.Time) (bool, error) {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ps.db.ExecContext(ctx,
+		`UPDATE passkeys SET sign_count = $1, last_used_at = $2
+			WHERE id = $3 AND (sign_count < $1 OR (sign_count = 0 AND $1 = 0))`,
+		int64(signCount), at, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (ps *SQLStore) Delete(ctx context.Context, userID primitive.ObjectID, id string) (bool, error) {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ps.db.ExecContext(ctx, `DELETE FROM passkeys WHERE id = $1 AND user_id = $2`, id, userID.Hex())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (ps *SQLStore) DeleteAll(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ps.db.ExecContext(ctx, `DELETE FROM passkeys WHERE user_id = $1`, userID.Hex())
+	return err
+}
+
+func (ps *SQLStore) CreateSession(ctx context.Context, s *Session) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	// There is no TTL in SQL, so ceremonies nobody finished are swept
+	// here.
+	if _, err := ps.db.ExecContext(ctx,
+		`DELETE FROM passkey_sessions WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := ps.db.ExecContext(ctx,
+		`INSERT INTO passkey_sessions (hash, user_id, purpose, expires_at) VALUES ($1, $2, $3, $4)`,
+		s.Hash, s.UserID.Hex(), s.Purpose, s.ExpiresAt)
+	return err
+}
+
+func (ps *SQLStore) ConsumeSession(ctx context.Context, hash string) (*Session, error) {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	var (
+		s    Session
+		user string
+	)
+	err := ps.db.QueryRowContext(ctx,
+		`DELETE FROM passkey_sessions WHERE hash = $1 RETURNING hash, user_id, purpose, expires_at`, hash).
+		Scan(&s.Hash, &user, &s.Purpose, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.UserID, err = primitive.ObjectIDFromHex(user); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCredential(row rowScanner) (*Credential, error) {
+	var (
+		c                     Credential
+		user, key, transports string
+		signCount             int64
+		lastUsedAt            sql.NullTime
+	)
+	err := row.Scan(&c.ID, &user, &c.Name, &key, &c.Algorithm, &signCount, &transports, &c.AAGUID,
+		&c.BackupEligible, &c.BackedUp, &c.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID, err = primitive.ObjectIDFromHex(user); err != nil {
+		return nil, err
+	}
+	if c.PublicKey, err = b64.DecodeString(key); err != nil {
+		return nil, err
+	}
+	c.SignCount = uint32(signCount)
+	if err := json.Unmarshal([]byte(transports), &c.Transports); err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		c.LastUsedAt = &lastUsedAt.Time
+	}
+	return &c, nil
+}
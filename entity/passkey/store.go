@@ -0,0 +1,163 @@
+package passkey
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store is the persistence contract for passkeys and ceremonies. Lookups
+// return (nil, nil) when nothing matches.
+//
+// Create fails with ErrExists if the credential ID is taken. UseCredential
+// records a login only if the signature counter moved forward, or if it
+// stays at zero for authenticators that do not count, and reports whether
+// it did. ConsumeSession deletes the session and returns it, so a
+// challenge can only be answered once; it may return expired sessions
+// that were not swept yet.
+type Store interface {
+	List(ctx context.Context, userID primitive.ObjectID) ([]*Credential, error)
+	Get(ctx context.Context, id string) (*Credential, error)
+	Create(ctx context.Context, c *Credential) error
+	UseCredential(ctx context.Context, id string, signCount uint32, at time.Time) (bool, error)
+	Delete(ctx context.Context, userID primitive.ObjectID, id string) (bool, error)
+	DeleteAll(ctx context.Context, userID primitive.ObjectID) error
+
+	CreateSession(ctx context.Context, s *Session) error
+	ConsumeSession(ctx context.Context, hash string) (*Session, error)
+}
+
+// NewStore returns the Store implementation for the provider's driver.
+func NewStore(dp *db.DBProvider) (Store, error) {
+	switch dp.Driver {
+	case db.Mongo:
+		return NewMongoStore(dp), nil
+	case db.SQLite, db.Postgres:
+		return NewSQLStore(dp)
+	case db.Memory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("passkey: unsupported driver %q", dp.Driver)
+}
+
+// MongoStore keys passkeys by credential ID and relies on a TTL index on
+// passkey_sessions.expires_at.
+type MongoStore struct {
+	dbProvider  *db.DBProvider
+	credentials *mongo.Collection
+	sessions    *mongo.Collection
+}
+
+// Verify Interface Compliance
+var _ Store = (*MongoStore)(nil)
+
+func NewMongoStore(dp *db.DBProvider) *MongoStore {
+	return &MongoStore{
+		dbProvider:  dp,
+		credentials: dp.GetCollection(db.Passkeys),
+		sessions:    dp.GetCollection(db.PasskeySessions),
+	}
+}
+
+func (ps *MongoStore) List(ctx context.Context, userID primitive.ObjectID) ([]*Credential, error) {
+	ctx, cancel := ps.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	cur, err := ps.credentials.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var creds []*Credential
+	if err := cur.All(ctx, &creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+func (ps *MongoStore) Get(ctx context.Context, id string) (*Credential, error) {
+	ctx, cancel := ps.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var c Credential
+	if err := ps.credentials.FindOne(ctx, bson.M{"_id": id}).Decode(&c); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (ps *MongoStore) Create(ctx context.Context, c *Credential) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ps.credentials.InsertOne(ctx, c)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrExists
+	}
+	return err
+}
+
+func (ps *MongoStore) UseCredential(ctx context.Context, id string, signCount uint32, at time.Time) (bool, error) {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "sign_count": bson.M{"$lt": signCount}}
+	if signCount == 0 {
+		filter["sign_count"] = 0
+	}
+	res, err := ps.credentials.UpdateOne(ctx, filter,
+		bson.M{"$set": bson.M{"sign_count": signCount, "last_used_at": at}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+func (ps *MongoStore) Delete(ctx context.Context, userID primitive.ObjectID, id string) (bool, error) {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ps.credentials.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+func (ps *MongoStore) DeleteAll(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ps.credentials.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+func (ps *MongoStore) CreateSession(ctx context.Context, s *Session) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ps.sessions.InsertOne(ctx, s)
+	return err
+}
+
+func (ps *MongoStore) ConsumeSession(ctx context.Context, hash string) (*Session, error) {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	var s Session
+	if err := ps.sessions.FindOneAndDelete(ctx, bson.M{"_id": hash}).Decode(&s); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,181 @@
+package passkey
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Flags of the authenticator data.
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagBackedUp       = 0x10
+	flagAttestedData   = 0x40
+)
+
+var (
+	ErrCeremony   = errors.New("the passkey ceremony expired or was already used; start again")
+	ErrCredential = errors.New("the passkey response is malformed")
+	ErrOrigin     = errors.New("the passkey response comes from an origin this service does not accept")
+	ErrRPID       = errors.New("the passkey is bound to another site")
+	ErrPresence   = errors.New("the authenticator did not confirm the user's presence")
+	ErrVerified   = errors.New("the authenticator did not verify the user")
+	ErrSignature  = errors.New("the passkey signature is not valid")
+	ErrSignCount  = errors.New("the passkey's signature counter went backwards; it may have been cloned")
+	ErrUnknown    = errors.New("this passkey is not registered")
+	ErrExists     = errors.New("this passkey is already registered")
+)
+
+// RelyingParty is this service as authenticators see it.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// clientData is the part of clientDataJSON that is checked.
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// authenticatorData is the parsed authenticator data (WebAuthn §6.1).
+type authenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// Set on registration only.
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// attestationObject is what the authenticator returns on registration.
+// The attestation statement is not checked: the service takes passkeys
+// of any make, as with the "none" conveyance it asks for.
+type attestationObject struct {
+	Format   string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+// checkClientData parses clientDataJSON and checks its type and origin.
+// It returns the challenge for the caller to look up.
+func (rp *RelyingParty) checkClientData(raw []byte, typ string) (string, error) {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return "", ErrCredential
+	}
+	if cd.Type != typ || cd.Challenge == "" {
+		return "", ErrCredential
+	}
+	if cd.CrossOrigin {
+		return "", ErrOrigin
+	}
+	for _, o := range rp.Origins {
+		if cd.Origin == o {
+			return cd.Challenge, nil
+		}
+	}
+	return "", ErrOrigin
+}
+
+// checkAuthData parses authenticator data and checks that it is meant for
+// this relying party and that the user was present and, if required,
+// verified.
+func (rp *RelyingParty) checkAuthData(raw []byte, requireUV bool) (*authenticatorData, error) {
+	ad, err := parseAuthData(raw)
+	if err != nil {
+		return nil, err
+	}
+	want := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(ad.RPIDHash, want[:]) != 1 {
+		return nil, ErrRPID
+	}
+	if ad.Flags&flagUserPresent == 0 {
+		return nil, ErrPresence
+	}
+	if requireUV && ad.Flags&flagUserVerified == 0 {
+		return nil, ErrVerified
+	}
+	return ad, nil
+}
+
+func parseAuthData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, ErrCredential
+	}
+	ad := &authenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if ad.Flags&flagAttestedData == 0 {
+		return ad, nil
+	}
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, ErrCredential
+	}
+	ad.AAGUID = rest[:16]
+	n := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if n == 0 || len(rest) < n {
+		return nil, ErrCredential
+	}
+	ad.CredentialID = rest[:n]
+	// The public key is a COSE key; extensions may follow it.
+	var key cbor.RawMessage
+	after, err := cbor.UnmarshalFirst(rest[n:], &key)
+	if err != nil {
+		return nil, ErrCredential
+	}
+	ad.PublicKey = rest[n : len(rest)-len(after)]
+	return ad, nil
+}
+
+// signedData is what an assertion signature covers.
+func signedData(authData, clientDataJSON []byte) []byte {
+	sum := sha256.Sum256(clientDataJSON)
+	return append(append([]byte(nil), authData...), sum[:]...)
+}
+
+// b64 is the encoding of binary values in WebAuthn JSON.
+var b64 = base64.RawURLEncoding
+
+// binaryValue decodes base64url, with or without padding, as browsers and
+// libraries differ.
+type binaryValue []byte
+
+func (b *binaryValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := b64.DecodeString(trimPadding(s))
+	if err != nil {
+		return ErrCredential
+	}
+	*b = v
+	return nil
+}
+
+func (b binaryValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b64.EncodeToString(b))
+}
+
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
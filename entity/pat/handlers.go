@@ -0,0 +1,93 @@
+package pat
+
+import (
+	"net/http"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListTokens godoc
+// @Summary List own personal access tokens
+// @Description List the unexpired personal access tokens of the current user. Token values are never returned.
+// @ID list-tokens
+// @Tags token
+// @Produce  json
+// @Success 200 {object} tokenListResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/tokens [get]
+func ListTokens(c echo.Context) error {
+	tokens, err := manager.Tokens(c.Request().Context(), userIDFromToken(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, &tokenListResponse{Tokens: tokens})
+}
+
+// CreateToken godoc
+// @Summary Create a personal access token
+// @Description Create a named token limited to the given scopes. Its value is returned only in this response; send it as "Authorization: Token <value>". It can never use a permission the user's roles do not grant.
+// @ID create-token
+// @Tags token
+// @Accept  json
+// @Produce  json
+// @Param token body tokenCreateRequest true "Token to create"
+// @Success 201 {object} tokenCreatedResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/tokens [post]
+func CreateToken(c echo.Context) error {
+	req := &tokenCreateRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	raw, t, err := manager.Issue(c.Request().Context(), userIDFromToken(c), req.Token.Name, req.Token.Scopes, req.lifetime())
+	if err == ErrUnknownScope || err == ErrNoScopes {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusCreated, &tokenCreatedResponse{Token: *t, Value: raw})
+}
+
+// RevokeToken godoc
+// @Summary Revoke a personal access token
+// @Description Delete one personal access token of the current user; it stops working at once.
+// @ID revoke-token
+// @Tags token
+// @Produce  json
+// @Param        id   path      string  true  "Token ID"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/tokens/{id} [delete]
+func RevokeToken(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	err = manager.Revoke(c.Request().Context(), userIDFromToken(c), id)
+	if err == ErrNotFound {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func userIDFromToken(c echo.Context) primitive.ObjectID {
+	id, _ := c.Get("user").(primitive.ObjectID)
+	return id
+}
@@ -0,0 +1,106 @@
+package pat
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps personal access tokens in process memory; nothing
+// survives a restart.
+type MemoryStore struct {
+	mu     sync.Mutex
+	tokens map[primitive.ObjectID]Token
+}
+
+// Verify Interface Compliance
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens: make(map[primitive.ObjectID]Token),
+	}
+}
+
+func (ps *MemoryStore) Create(ctx context.Context, t *Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.tokens[t.ID] = copyToken(*t)
+	return nil
+}
+
+func (ps *MemoryStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	t, ok := ps.tokens[id]
+	if !ok {
+		return nil, nil
+	}
+	c := copyToken(t)
+	return &c, nil
+}
+
+func (ps *MemoryStore) List(ctx context.Context, userID primitive.ObjectID) ([]Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	tokens := make([]Token, 0)
+	for _, t := range ps.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, copyToken(t))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (ps *MemoryStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.tokens, id)
+	return nil
+}
+
+func (ps *MemoryStore) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	t, ok := ps.tokens[id]
+	if !ok {
+		return nil
+	}
+	t.LastUsedAt = &at
+	ps.tokens[id] = t
+	return nil
+}
+
+func copyToken(t Token) Token {
+	t.Scopes = append([]identity.Permission{}, t.Scopes...)
+	if t.LastUsedAt != nil {
+		at := *t.LastUsedAt
+		t.LastUsedAt = &at
+	}
+	return t
+}
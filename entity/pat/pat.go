@@ -0,0 +1,190 @@
+// Package pat manages personal access tokens: named, scoped and expiring
+// credentials that users mint for scripts instead of logging in with
+// their password.
+//
+// A token reads "pat_<id>_<secret>". Only the SHA-256 of the whole token
+// is stored, so it is shown once, when it is created. The JWT middleware
+// accepts tokens with this prefix through Manager.Authenticate; their
+// permissions are those of the user's current roles that are also among
+// the token's scopes. Logging the user out everywhere, which a password
+// or role change does as well, ends the tokens created before.
+package pat
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Prefix starts every personal access token.
+const Prefix = "pat_"
+
+const (
+	// DefaultLifetime applies when a token is created without one.
+	DefaultLifetime = 30 * 24 * time.Hour
+	// MaxLifetime bounds how long a token may live.
+	MaxLifetime = 365 * 24 * time.Hour
+	// touchInterval bounds how often use of a token rewrites LastUsedAt.
+	touchInterval = time.Minute
+)
+
+var (
+	ErrNotFound     = errors.New("personal access token not found")
+	ErrUnknownScope = errors.New("unknown scope")
+	ErrNoScopes     = errors.New("a token needs at least one scope")
+)
+
+var manager *Manager
+
+// Token is the stored form of a personal access token.
+type Token struct {
+	ID         primitive.ObjectID    `bson:"_id" json:"id"`
+	UserID     primitive.ObjectID    `bson:"user_id" json:"-"`
+	Name       string                `bson:"name" json:"name"`
+	Hash       string                `bson:"hash" json:"-"`
+	Scopes     []identity.Permission `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time             `bson:"created_at" json:"created_at"`
+	ExpiresAt  time.Time             `bson:"expires_at" json:"expires_at"`
+	LastUsedAt *time.Time            `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+}
+
+// RoleLookup returns the current roles of a user; ok is false if the user
+// no longer exists.
+type RoleLookup func(ctx context.Context, userID primitive.ObjectID) (roles []identity.Role, ok bool, err error)
+
+// Manager issues, lists, revokes and verifies personal access tokens.
+type Manager struct {
+	store Store
+	roles RoleLookup
+	now   func() time.Time
+}
+
+// Verify Interface Compliance
+var _ identity.TokenAuthenticator = (*Manager)(nil)
+
+func NewManager(s Store, roles RoleLookup) *Manager {
+	return &Manager{store: s, roles: roles, now: time.Now}
+}
+
+func RegisterHandlers(v1 *echo.Group, auth echo.MiddlewareFunc, m *Manager) {
+
+	manager = m
+
+	tokens := v1.Group("/user/tokens", auth, identity.RequireLogin())
+	tokens.GET("", ListTokens)
+	tokens.POST("", CreateToken)
+	tokens.DELETE("/:id", RevokeToken)
+}
+
+// Issue creates a token for userID and returns the raw token, which is
+// not stored, together with its record. Scopes must be known permissions;
+// they need not be held by the user, since the token can never use more
+// than the user's roles grant at the time of use.
+func (m *Manager) Issue(ctx context.Context, userID primitive.ObjectID, name string, scopes []identity.Permission, lifetime time.Duration) (string, *Token, error) {
+	if len(scopes) == 0 {
+		return "", nil, ErrNoScopes
+	}
+	for _, s := range scopes {
+		if !identity.IsPermission(s) {
+			return "", nil, ErrUnknownScope
+		}
+	}
+	if lifetime <= 0 {
+		lifetime = DefaultLifetime
+	}
+	if lifetime > MaxLifetime {
+		lifetime = MaxLifetime
+	}
+	now := m.now().UTC()
+	t := &Token{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      name,
+		Scopes:    append([]identity.Permission{}, scopes...),
+		CreatedAt: now,
+		ExpiresAt: now.Add(lifetime),
+	}
+	raw, err := identity.NewOpaqueToken(Prefix, t.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	t.Hash = identity.HashToken(raw)
+	if err := m.store.Create(ctx, t); err != nil {
+		return "", nil, err
+	}
+	return raw, t, nil
+}
+
+// Tokens returns the unexpired tokens of a user, oldest first.
+func (m *Manager) Tokens(ctx context.Context, userID primitive.ObjectID) ([]Token, error) {
+	all, err := m.store.List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	active := make([]Token, 0, len(all))
+	for _, t := range all {
+		if now.Before(t.ExpiresAt) {
+			active = append(active, t)
+		}
+	}
+	return active, nil
+}
+
+// Revoke deletes a token of userID. It returns ErrNotFound for tokens of
+// other users.
+func (m *Manager) Revoke(ctx context.Context, userID, id primitive.ObjectID) error {
+	t, err := m.store.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if t == nil || t.UserID != userID {
+		return ErrNotFound
+	}
+	return m.store.Delete(ctx, id)
+}
+
+// Authenticate verifies raw and returns the user it acts for, limited to
+// the token's scopes. Tokens whose user was deleted are rejected.
+func (m *Manager) Authenticate(ctx context.Context, raw string) (*identity.Principal, error) {
+	t, err := m.lookup(ctx, raw)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	roles, ok, err := m.roles(ctx, t.UserID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	now := m.now().UTC()
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= touchInterval {
+		if err := m.store.Touch(ctx, t.ID, now); err != nil {
+			return nil, err
+		}
+	}
+	return &identity.Principal{
+		UserID:   t.UserID,
+		Roles:    roles,
+		Scopes:   append([]identity.Permission{}, t.Scopes...),
+		IssuedAt: t.CreatedAt,
+	}, nil
+}
+
+// lookup finds the unexpired token raw stands for, or nil.
+func (m *Manager) lookup(ctx context.Context, raw string) (*Token, error) {
+	id, ok := identity.ParseOpaqueToken(Prefix, raw)
+	if !ok {
+		return nil, nil
+	}
+	t, err := m.store.GetByID(ctx, id)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	if !identity.TokenMatches(raw, t.Hash) || !m.now().Before(t.ExpiresAt) {
+		return nil, nil
+	}
+	return t, nil
+}
@@ -0,0 +1,28 @@
+package pat
+
+import (
+	"time"
+
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+)
+
+type tokenCreateRequest struct {
+	Token struct {
+		Name   string                `json:"name" validate:"required"`
+		Scopes []identity.Permission `json:"scopes" validate:"required,min=1"`
+		// ExpiresInDays defaults to 30 and may be at most 365.
+		ExpiresInDays int `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+	} `json:"token"`
+}
+
+func (r *tokenCreateRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+func (r *tokenCreateRequest) lifetime() time.Duration {
+	return time.Duration(r.Token.ExpiresInDays) * 24 * time.Hour
+}
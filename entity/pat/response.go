@@ -0,0 +1,11 @@
+package pat
+
+// tokenCreatedResponse is the only response that carries the raw token.
+type tokenCreatedResponse struct {
+	Token Token  `json:"token"`
+	Value string `json:"value"`
+}
+
+type tokenListResponse struct {
+	Tokens []Token `json:"tokens"`
+}
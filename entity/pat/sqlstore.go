@@ -0,0 +1,150 @@
+package pat
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlSchema is shared by SQLite and PostgreSQL. Scopes are kept as a JSON
+// array, like the permissions of a role.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS personal_access_tokens (
+		id           TEXT PRIMARY KEY,
+		user_id      TEXT NOT NULL,
+		name         TEXT NOT NULL,
+		hash         TEXT NOT NULL UNIQUE,
+		scopes       TEXT NOT NULL DEFAULT '[]',
+		created_at   TIMESTAMP NOT NULL,
+		expires_at   TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS personal_access_tokens_user_idx ON personal_access_tokens (user_id, created_at)`,
+}
+
+const tokenColumns = `id, user_id, name, hash, scopes, created_at, expires_at, last_used_at`
+
+type SQLStore struct {
+	dbProvider *db.DBProvider
+	db         *sql.DB
+}
+
+// Verify Interface Compliance
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore creates the personal access token schema if it does not
+// exist yet.
+func NewSQLStore(dp *db.DBProvider) (*SQLStore, error) {
+	for _, stmt := range sqlSchema {
+		if _, err := dp.SQL.ExecContext(dp.Context, stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLStore{
+		dbProvider: dp,
+		db:         dp.SQL,
+	}, nil
+}
+
+func (ps *SQLStore) Create(ctx context.Context, t *Token) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	scopes, err := json.Marshal(t.Scopes)
+	if err != nil {
+		return err
+	}
+	// There is no TTL in SQL, so expired tokens are swept here.
+	if _, err := ps.db.ExecContext(ctx,
+		`DELETE FROM personal_access_tokens WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err = ps.db.ExecContext(ctx,
+		`INSERT INTO personal_access_tokens (`+tokenColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		t.ID.Hex(), t.UserID.Hex(), t.Name, t.Hash, string(scopes), t.CreatedAt, t.ExpiresAt, t.LastUsedAt)
+	return err
+}
+
+func (ps *SQLStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Token, error) {
+	ctx, cancel := ps.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	t, err := scanToken(ps.db.QueryRowContext(ctx,
+		`SELECT `+tokenColumns+` FROM personal_access_tokens WHERE id = $1`, id.Hex()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (ps *SQLStore) List(ctx context.Context, userID primitive.ObjectID) ([]Token, error) {
+	ctx, cancel := ps.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := ps.db.QueryContext(ctx,
+		`SELECT `+tokenColumns+` FROM personal_access_tokens WHERE user_id = $1 ORDER BY created_at`, userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]Token, 0)
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+func (ps *SQLStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ps.db.ExecContext(ctx, `DELETE FROM personal_access_tokens WHERE id = $1`, id.Hex())
+	return err
+}
+
+func (ps *SQLStore) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ps.db.ExecContext(ctx,
+		`UPDATE personal_access_tokens SET last_used_at = $2 WHERE id = $1`, id.Hex(), at)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanToken(row rowScanner) (*Token, error) {
+	var (
+		t                Token
+		id, user, scopes string
+		lastUsedAt       sql.NullTime
+	)
+	err := row.Scan(&id, &user, &t.Name, &t.Hash, &scopes, &t.CreatedAt, &t.ExpiresAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	if t.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if t.UserID, err = primitive.ObjectIDFromHex(user); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(scopes), &t.Scopes); err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return &t, nil
+}
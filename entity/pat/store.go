@@ -0,0 +1,109 @@
+package pat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store is the persistence contract for personal access tokens.
+// GetByID returns (nil, nil) when no token matches. List is ordered by
+// creation time and may include expired tokens that were not swept yet.
+type Store interface {
+	Create(ctx context.Context, t *Token) error
+	GetByID(ctx context.Context, id primitive.ObjectID) (*Token, error)
+	List(ctx context.Context, userID primitive.ObjectID) ([]Token, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+// NewStore returns the Store implementation for the provider's driver.
+func NewStore(dp *db.DBProvider) (Store, error) {
+	switch dp.Driver {
+	case db.Mongo:
+		return NewMongoStore(dp), nil
+	case db.SQLite, db.Postgres:
+		return NewSQLStore(dp)
+	case db.Memory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("pat: unsupported driver %q", dp.Driver)
+}
+
+// MongoStore relies on a TTL index on expires_at to remove expired
+// tokens.
+type MongoStore struct {
+	dbProvider *db.DBProvider
+	collection *mongo.Collection
+}
+
+// Verify Interface Compliance
+var _ Store = (*MongoStore)(nil)
+
+func NewMongoStore(dp *db.DBProvider) *MongoStore {
+	return &MongoStore{
+		dbProvider: dp,
+		collection: dp.GetCollection(db.PersonalAccessTokens),
+	}
+}
+
+func (ps *MongoStore) Create(ctx context.Context, t *Token) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ps.collection.InsertOne(ctx, t)
+	return err
+}
+
+func (ps *MongoStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Token, error) {
+	ctx, cancel := ps.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var t Token
+	err := ps.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (ps *MongoStore) List(ctx context.Context, userID primitive.ObjectID) ([]Token, error) {
+	ctx, cancel := ps.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	cursor, err := ps.collection.Find(ctx, bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]Token, 0)
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (ps *MongoStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ps.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (ps *MongoStore) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ps.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ps.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
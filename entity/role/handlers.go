@@ -0,0 +1,163 @@
+package role
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+)
+
+// ListRoles godoc
+// @Summary List roles
+// @Description List every role with the permissions it grants
+// @ID list-roles
+// @Tags role
+// @Produce  json
+// @Success 200 {object} roleListResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /roles [get]
+func ListRoles(c echo.Context) error {
+	roles, err := store.List(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, &roleListResponse{Roles: roles})
+}
+
+// GetRole godoc
+// @Summary Get a role
+// @Description Get one role with the permissions it grants
+// @ID get-role
+// @Tags role
+// @Produce  json
+// @Param        id   path      int  true  "Role ID"
+// @Success 200 {object} roleResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /roles/{id} [get]
+func GetRole(c echo.Context) error {
+	r, err := roleFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if r == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	return c.JSON(http.StatusOK, &roleResponse{Role: *r})
+}
+
+// CreateRole godoc
+// @Summary Create a role
+// @Description Create a custom role. It may only grant permissions the caller holds.
+// @ID create-role
+// @Tags role
+// @Accept  json
+// @Produce  json
+// @Param role body roleCreateRequest true "Role to create"
+// @Success 201 {object} roleResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /roles [post]
+func CreateRole(c echo.Context) error {
+	var r Role
+	req := &roleCreateRequest{}
+	if err := req.bind(c, &r); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	if err := store.Create(c.Request().Context(), &r); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	resolver.Invalidate()
+	return c.JSON(http.StatusCreated, &roleResponse{Role: r})
+}
+
+// UpdateRole godoc
+// @Summary Update a role
+// @Description Rename a role or change the permissions it grants. Changes apply to existing tokens of its holders. The permissions of Admin cannot be changed.
+// @ID update-role
+// @Tags role
+// @Accept  json
+// @Produce  json
+// @Param        id   path      int  true  "Role ID"
+// @Param role body roleUpdateRequest true "New name, description and permissions"
+// @Success 200 {object} roleResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /roles/{id} [put]
+func UpdateRole(c echo.Context) error {
+	r, err := roleFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if r == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	req := &roleUpdateRequest{}
+	req.populate(r)
+	if err := req.bind(c, r); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	if err := store.Update(c.Request().Context(), r); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	resolver.Invalidate()
+	return c.JSON(http.StatusOK, &roleResponse{Role: *r})
+}
+
+// DeleteRole godoc
+// @Summary Delete a role
+// @Description Delete a custom role. Users that hold it keep its ID, which then grants nothing.
+// @ID delete-role
+// @Tags role
+// @Produce  json
+// @Param        id   path      int  true  "Role ID"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /roles/{id} [delete]
+func DeleteRole(c echo.Context) error {
+	r, err := roleFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if r == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if r.BuiltIn {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(ErrBuiltIn))
+	}
+	if err := store.Delete(c.Request().Context(), r.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	resolver.Invalidate()
+	return c.NoContent(http.StatusNoContent)
+}
+
+// roleFromParam loads the role named by the id path parameter. An ID
+// that is not a number matches no role.
+func roleFromParam(c echo.Context) (*Role, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return nil, nil
+	}
+	return store.GetByID(c.Request().Context(), identity.Role(id))
+}
@@ -0,0 +1,121 @@
+package role
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hamed-lohi/user-manage/identity"
+)
+
+// MemoryStore keeps roles in process memory; nothing survives a restart.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	roles map[identity.Role]Role
+}
+
+// Verify Interface Compliance
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		roles: make(map[identity.Role]Role),
+	}
+}
+
+func (ms *MemoryStore) List(ctx context.Context) ([]Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	roles := make([]Role, 0, len(ms.roles))
+	for _, r := range ms.roles {
+		roles = append(roles, copyRole(r))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].ID < roles[j].ID })
+	return roles, nil
+}
+
+func (ms *MemoryStore) GetByID(ctx context.Context, id identity.Role) (*Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	r, ok := ms.roles[id]
+	if !ok {
+		return nil, nil
+	}
+	c := copyRole(r)
+	return &c, nil
+}
+
+func (ms *MemoryStore) Create(ctx context.Context, r *Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if r.ID == 0 {
+		for id := range ms.roles {
+			if id > r.ID {
+				r.ID = id
+			}
+		}
+		r.ID++
+	}
+	if _, ok := ms.roles[r.ID]; ok || ms.nameTaken(r) {
+		return ErrDuplicate
+	}
+	ms.roles[r.ID] = copyRole(*r)
+	return nil
+}
+
+func (ms *MemoryStore) Update(ctx context.Context, r *Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.roles[r.ID]; !ok {
+		return nil
+	}
+	if ms.nameTaken(r) {
+		return ErrDuplicate
+	}
+	ms.roles[r.ID] = copyRole(*r)
+	return nil
+}
+
+func (ms *MemoryStore) Delete(ctx context.Context, id identity.Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.roles, id)
+	return nil
+}
+
+// nameTaken reports whether another role has the name of r. The caller
+// must hold the lock.
+func (ms *MemoryStore) nameTaken(r *Role) bool {
+	for id, other := range ms.roles {
+		if id != r.ID && strings.EqualFold(other.Name, r.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func copyRole(r Role) Role {
+	r.Permissions = append([]identity.Permission{}, r.Permissions...)
+	return r
+}
@@ -0,0 +1,80 @@
+package role
+
+import (
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+)
+
+type roleCreateRequest struct {
+	Role struct {
+		Name        string                `json:"name" validate:"required"`
+		Description string                `json:"description"`
+		Permissions []identity.Permission `json:"permissions"`
+	} `json:"role"`
+}
+
+func (r *roleCreateRequest) bind(c echo.Context, role *Role) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := c.Validate(r); err != nil {
+		return err
+	}
+	if err := checkPermissions(c, r.Role.Permissions); err != nil {
+		return err
+	}
+	role.Name = r.Role.Name
+	role.Description = r.Role.Description
+	role.Permissions = append([]identity.Permission{}, r.Role.Permissions...)
+	return nil
+}
+
+type roleUpdateRequest struct {
+	Role struct {
+		Name        string                `json:"name" validate:"required"`
+		Description string                `json:"description"`
+		Permissions []identity.Permission `json:"permissions"`
+	} `json:"role"`
+}
+
+func (r *roleUpdateRequest) populate(role *Role) {
+	r.Role.Name = role.Name
+	r.Role.Description = role.Description
+	// A copy, so that binding the body does not overwrite role.Permissions.
+	r.Role.Permissions = append([]identity.Permission{}, role.Permissions...)
+}
+
+func (r *roleUpdateRequest) bind(c echo.Context, role *Role) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := c.Validate(r); err != nil {
+		return err
+	}
+	if role.ID == identity.Admin && !samePermissions(r.Role.Permissions, role.Permissions) {
+		return ErrAdminFixed
+	}
+	if err := checkPermissions(c, r.Role.Permissions); err != nil {
+		return err
+	}
+	role.Name = r.Role.Name
+	role.Description = r.Role.Description
+	role.Permissions = append([]identity.Permission{}, r.Role.Permissions...)
+	return nil
+}
+
+func samePermissions(a, b []identity.Permission) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[identity.Permission]bool, len(a))
+	for _, p := range a {
+		set[p] = true
+	}
+	for _, p := range b {
+		if !set[p] {
+			return false
+		}
+	}
+	return true
+}
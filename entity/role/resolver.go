@@ -0,0 +1,64 @@
+package role
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/identity"
+)
+
+// Resolver implements identity.RoleResolver on top of a Store. It keeps
+// all roles in memory and reloads them once they are older than ttl, so
+// edits made through another instance apply within ttl; edits made
+// through this one apply at once via Invalidate.
+type Resolver struct {
+	store  Store
+	ttl    time.Duration
+	now    func() time.Time
+	mu     sync.Mutex
+	defs   map[identity.Role][]identity.Permission
+	loaded time.Time
+}
+
+// Verify Interface Compliance
+var _ identity.RoleResolver = (*Resolver)(nil)
+
+func NewResolver(s Store, ttl time.Duration) *Resolver {
+	return &Resolver{store: s, ttl: ttl, now: time.Now}
+}
+
+func (r *Resolver) Permissions(ctx context.Context, roles []identity.Role) (map[identity.Permission]bool, error) {
+	defs, err := r.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return identity.Grant(roles, defs), nil
+}
+
+// Invalidate drops the cached roles; the next lookup reloads them.
+func (r *Resolver) Invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.defs = nil
+}
+
+func (r *Resolver) load(ctx context.Context) (map[identity.Role][]identity.Permission, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.defs != nil && r.now().Sub(r.loaded) < r.ttl {
+		return r.defs, nil
+	}
+	roles, err := r.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defs := make(map[identity.Role][]identity.Permission, len(roles))
+	for _, role := range roles {
+		defs[role.ID] = role.Permissions
+	}
+	r.defs, r.loaded = defs, r.now()
+	return defs, nil
+}
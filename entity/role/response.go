@@ -0,0 +1,9 @@
+package role
+
+type roleResponse struct {
+	Role Role `json:"role"`
+}
+
+type roleListResponse struct {
+	Roles []Role `json:"roles"`
+}
@@ -0,0 +1,83 @@
+// Package role stores the roles users can hold and the permissions each
+// role grants. Built-in roles are seeded from identity.BuiltinRoles and
+// administrators can add their own at runtime.
+package role
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrBuiltIn      = errors.New("built-in roles cannot be deleted")
+	ErrAdminFixed   = errors.New("the Admin role always grants every permission")
+	ErrUnknownPerm  = errors.New("unknown permission")
+	ErrNotGrantable = errors.New("a role cannot grant permissions its author does not hold")
+)
+
+var (
+	store    Store
+	resolver *Resolver
+)
+
+type Role struct {
+	ID          identity.Role         `bson:"_id" json:"id"`
+	Name        string                `bson:"name" json:"name"`
+	Description string                `bson:"description" json:"description"`
+	Permissions []identity.Permission `bson:"permissions" json:"permissions"`
+	BuiltIn     bool                  `bson:"built_in" json:"built_in"`
+}
+
+func RegisterHandlers(v1 *echo.Group, auth echo.MiddlewareFunc, s Store, r *Resolver) {
+
+	store = s
+	resolver = r
+
+	roles := v1.Group("/roles", auth)
+	roles.GET("", ListRoles, identity.RequirePermission(identity.RolesRead))
+	roles.GET("/:id", GetRole, identity.RequirePermission(identity.RolesRead))
+	roles.POST("", CreateRole, identity.RequirePermission(identity.RolesWrite))
+	roles.PUT("/:id", UpdateRole, identity.RequirePermission(identity.RolesWrite))
+	roles.DELETE("/:id", DeleteRole, identity.RequirePermission(identity.RolesWrite))
+}
+
+// Seed creates the built-in roles that are missing. Roles that exist are
+// left alone so that edits made through the API survive restarts.
+func Seed(ctx context.Context, s Store) {
+	for _, d := range identity.BuiltinRoles {
+		r, err := s.GetByID(ctx, d.ID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if r != nil {
+			continue
+		}
+		r = &Role{
+			ID:          d.ID,
+			Name:        d.Name,
+			Permissions: d.Permissions,
+			BuiltIn:     true,
+		}
+		if err := s.Create(ctx, r); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// checkPermissions rejects unknown permissions and, to prevent privilege
+// escalation, permissions the requester does not hold.
+func checkPermissions(c echo.Context, perms []identity.Permission) error {
+	for _, p := range perms {
+		if !identity.IsPermission(p) {
+			return ErrUnknownPerm
+		}
+	}
+	if !identity.HasPermission(c, perms...) {
+		return ErrNotGrantable
+	}
+	return nil
+}
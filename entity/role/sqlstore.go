@@ -0,0 +1,178 @@
+package role
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"github.com/hamed-lohi/user-manage/identity"
+)
+
+// sqlSchema is shared by SQLite and PostgreSQL. Permissions are kept as
+// a JSON array, like the roles of a user.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS roles (
+		id          INTEGER PRIMARY KEY,
+		name        TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		permissions TEXT NOT NULL DEFAULT '[]',
+		built_in    BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS roles_name_key ON roles (lower(name))`,
+}
+
+const roleColumns = `id, name, description, permissions, built_in`
+
+type SQLStore struct {
+	dbProvider *db.DBProvider
+	db         *sql.DB
+}
+
+// Verify Interface Compliance
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore creates the roles schema if it does not exist yet.
+func NewSQLStore(dp *db.DBProvider) (*SQLStore, error) {
+	for _, stmt := range sqlSchema {
+		if _, err := dp.SQL.ExecContext(dp.Context, stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLStore{
+		dbProvider: dp,
+		db:         dp.SQL,
+	}, nil
+}
+
+func (rs *SQLStore) List(ctx context.Context) ([]Role, error) {
+	ctx, cancel := rs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := rs.db.QueryContext(ctx, `SELECT `+roleColumns+` FROM roles ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]Role, 0)
+	for rows.Next() {
+		r, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *r)
+	}
+	return roles, rows.Err()
+}
+
+func (rs *SQLStore) GetByID(ctx context.Context, id identity.Role) (*Role, error) {
+	return rs.findOne(ctx, `id = $1`, id)
+}
+
+func (rs *SQLStore) findOne(ctx context.Context, where string, arg interface{}) (*Role, error) {
+	ctx, cancel := rs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	r, err := scanRole(rs.db.QueryRowContext(ctx, `SELECT `+roleColumns+` FROM roles WHERE `+where, arg))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+func (rs *SQLStore) Create(ctx context.Context, r *Role) error {
+	if r.ID != 0 {
+		return rs.insert(ctx, r)
+	}
+	for i := 0; ; i++ {
+		last, err := rs.lastID(ctx)
+		if err != nil {
+			return err
+		}
+		r.ID = last + 1
+		err = rs.insert(ctx, r)
+		if err != ErrDuplicate || i == createAttempts-1 {
+			return err
+		}
+		// The key that clashed is either the name or the ID; only the
+		// latter is worth another attempt.
+		if taken, err := rs.findOne(ctx, `lower(name) = lower($1)`, r.Name); err != nil || taken != nil {
+			r.ID = 0
+			if err != nil {
+				return err
+			}
+			return ErrDuplicate
+		}
+	}
+}
+
+func (rs *SQLStore) lastID(ctx context.Context) (identity.Role, error) {
+	ctx, cancel := rs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var last identity.Role
+	err := rs.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM roles`).Scan(&last)
+	return last, err
+}
+
+func (rs *SQLStore) insert(ctx context.Context, r *Role) error {
+	ctx, cancel := rs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	perms, err := json.Marshal(r.Permissions)
+	if err != nil {
+		return err
+	}
+	_, err = rs.db.ExecContext(ctx,
+		`INSERT INTO roles (`+roleColumns+`) VALUES ($1, $2, $3, $4, $5)`,
+		r.ID, r.Name, r.Description, string(perms), r.BuiltIn)
+	return translateSQLError(err)
+}
+
+func (rs *SQLStore) Update(ctx context.Context, r *Role) error {
+	ctx, cancel := rs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	perms, err := json.Marshal(r.Permissions)
+	if err != nil {
+		return err
+	}
+	_, err = rs.db.ExecContext(ctx,
+		`UPDATE roles SET name = $2, description = $3, permissions = $4, built_in = $5 WHERE id = $1`,
+		r.ID, r.Name, r.Description, string(perms), r.BuiltIn)
+	return translateSQLError(err)
+}
+
+func (rs *SQLStore) Delete(ctx context.Context, id identity.Role) error {
+	ctx, cancel := rs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := rs.db.ExecContext(ctx, `DELETE FROM roles WHERE id = $1`, id)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRole(row rowScanner) (*Role, error) {
+	var (
+		r     Role
+		perms string
+	)
+	if err := row.Scan(&r.ID, &r.Name, &r.Description, &perms, &r.BuiltIn); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(perms), &r.Permissions); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func translateSQLError(err error) error {
+	if db.IsUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
@@ -0,0 +1,171 @@
+package role
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"github.com/hamed-lohi/user-manage/identity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrDuplicate is returned by Create and Update when another role already
+// has the same name.
+var ErrDuplicate = errors.New("a role with this name already exists")
+
+// createAttempts bounds the retries of Create when a concurrent Create
+// took the ID it picked.
+const createAttempts = 3
+
+// Store is the persistence contract for roles.
+// GetByID returns (nil, nil) when no role matches. Names are unique and
+// compared case-insensitively. List is ordered by ID. Create assigns the
+// next free ID to a role whose ID is zero.
+type Store interface {
+	List(ctx context.Context) ([]Role, error)
+	GetByID(ctx context.Context, id identity.Role) (*Role, error)
+	Create(ctx context.Context, r *Role) error
+	Update(ctx context.Context, r *Role) error
+	Delete(ctx context.Context, id identity.Role) error
+}
+
+// NewStore returns the Store implementation for the provider's driver.
+func NewStore(dp *db.DBProvider) (Store, error) {
+	switch dp.Driver {
+	case db.Mongo:
+		return NewMongoStore(dp), nil
+	case db.SQLite, db.Postgres:
+		return NewSQLStore(dp)
+	case db.Memory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("role: unsupported driver %q", dp.Driver)
+}
+
+type MongoStore struct {
+	dbProvider *db.DBProvider
+	collection *mongo.Collection
+}
+
+// Verify Interface Compliance
+var _ Store = (*MongoStore)(nil)
+
+func NewMongoStore(dp *db.DBProvider) *MongoStore {
+	return &MongoStore{
+		dbProvider: dp,
+		collection: dp.GetCollection(db.Roles),
+	}
+}
+
+func (rs *MongoStore) List(ctx context.Context) ([]Role, error) {
+	ctx, cancel := rs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	cursor, err := rs.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]Role, 0)
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (rs *MongoStore) GetByID(ctx context.Context, id identity.Role) (*Role, error) {
+	return rs.findOne(ctx, bson.M{"_id": id})
+}
+
+func (rs *MongoStore) findOne(ctx context.Context, filter bson.M) (*Role, error) {
+	ctx, cancel := rs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var r Role
+	opts := options.FindOne().SetCollation(db.CaseInsensitive)
+	if err := rs.collection.FindOne(ctx, filter, opts).Decode(&r); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (rs *MongoStore) Create(ctx context.Context, r *Role) error {
+	if r.ID != 0 {
+		return rs.insert(ctx, r)
+	}
+	for i := 0; ; i++ {
+		last, err := rs.lastID(ctx)
+		if err != nil {
+			return err
+		}
+		r.ID = last + 1
+		err = rs.insert(ctx, r)
+		if err != ErrDuplicate || i == createAttempts-1 {
+			return err
+		}
+		// The key that clashed is either the name or the ID; only the
+		// latter is worth another attempt.
+		if taken, err := rs.findOne(ctx, bson.M{"name": r.Name}); err != nil || taken != nil {
+			r.ID = 0
+			if err != nil {
+				return err
+			}
+			return ErrDuplicate
+		}
+	}
+}
+
+func (rs *MongoStore) lastID(ctx context.Context) (identity.Role, error) {
+	ctx, cancel := rs.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var r Role
+	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})
+	if err := rs.collection.FindOne(ctx, bson.M{}, opts).Decode(&r); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return r.ID, nil
+}
+
+func (rs *MongoStore) insert(ctx context.Context, r *Role) error {
+	ctx, cancel := rs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	if _, err := rs.collection.InsertOne(ctx, r); err != nil {
+		return translateMongoError(err)
+	}
+	return nil
+}
+
+func (rs *MongoStore) Update(ctx context.Context, r *Role) error {
+	ctx, cancel := rs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	if _, err := rs.collection.ReplaceOne(ctx, bson.M{"_id": r.ID}, r); err != nil {
+		return translateMongoError(err)
+	}
+	return nil
+}
+
+func (rs *MongoStore) Delete(ctx context.Context, id identity.Role) error {
+	ctx, cancel := rs.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := rs.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func translateMongoError(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
@@ -0,0 +1,338 @@
+package serviceaccount
+
+import (
+	"net/http"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListAccounts godoc
+// @Summary List service accounts
+// @Description List every service account
+// @ID list-service-accounts
+// @Tags service-account
+// @Produce  json
+// @Success 200 {object} accountListResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /service-accounts [get]
+func ListAccounts(c echo.Context) error {
+	accounts, err := store.ListAccounts(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, &accountListResponse{Accounts: accounts})
+}
+
+// GetAccount godoc
+// @Summary Get a service account
+// @Description Get one service account
+// @ID get-service-account
+// @Tags service-account
+// @Produce  json
+// @Param        id   path      string  true  "Service account ID"
+// @Success 200 {object} accountResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /service-accounts/{id} [get]
+func GetAccount(c echo.Context) error {
+	a, err := accountFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if a == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	return c.JSON(http.StatusOK, &accountResponse{Account: *a})
+}
+
+// CreateAccount godoc
+// @Summary Create a service account
+// @Description Create a service account owned by the caller. It may only hold roles whose permissions the caller holds.
+// @ID create-service-account
+// @Tags service-account
+// @Accept  json
+// @Produce  json
+// @Param account body accountCreateRequest true "Service account to create"
+// @Success 201 {object} accountResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /service-accounts [post]
+func CreateAccount(c echo.Context) error {
+	a := &Account{
+		ID:      primitive.NewObjectID(),
+		OwnerID: userIDFromToken(c),
+	}
+	req := &accountCreateRequest{}
+	if err := req.bind(c, a); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	a.CreatedAt = manager.now().UTC()
+	if err := store.CreateAccount(c.Request().Context(), a); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusCreated, &accountResponse{Account: *a})
+}
+
+// UpdateAccount godoc
+// @Summary Update a service account
+// @Description Rename, describe, change the roles of, or disable a service account. Keys of a disabled account are rejected, and changing the roles or disabling the account ends the access tokens issued to it. Only the owner or an admin who holds every permission of the account may change it.
+// @ID update-service-account
+// @Tags service-account
+// @Accept  json
+// @Produce  json
+// @Param        id   path      string  true  "Service account ID"
+// @Param account body accountUpdateRequest true "Service account fields"
+// @Success 200 {object} accountResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /service-accounts/{id} [put]
+func UpdateAccount(c echo.Context) error {
+	a, err := accountFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if a == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if done, err := requireControl(c, a); done {
+		return err
+	}
+	accountRoles, disabled := a.Roles, a.Disabled
+	req := &accountUpdateRequest{}
+	req.populate(a)
+	if err := req.bind(c, a); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	if err := store.UpdateAccount(c.Request().Context(), a); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	// Access tokens of the account carry the roles they were issued with.
+	if !sameRoles(a.Roles, accountRoles) || a.Disabled && !disabled {
+		if err := refresh.RevokeUser(c.Request().Context(), a.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+		}
+	}
+	return c.JSON(http.StatusOK, &accountResponse{Account: *a})
+}
+
+// DeleteAccount godoc
+// @Summary Delete a service account
+// @Description Delete a service account together with its API keys and end the access tokens issued to it. Only the owner or an admin who holds every permission of the account may delete it.
+// @ID delete-service-account
+// @Tags service-account
+// @Produce  json
+// @Param        id   path      string  true  "Service account ID"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /service-accounts/{id} [delete]
+func DeleteAccount(c echo.Context) error {
+	a, err := accountFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if a == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if done, err := requireControl(c, a); done {
+		return err
+	}
+	if err := store.DeleteAccount(c.Request().Context(), a.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if err := refresh.RevokeUser(c.Request().Context(), a.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListKeys godoc
+// @Summary List API keys
+// @Description List the active API keys of a service account with when each was last used. Key values are never returned.
+// @ID list-api-keys
+// @Tags service-account
+// @Produce  json
+// @Param        id   path      string  true  "Service account ID"
+// @Success 200 {object} keyListResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /service-accounts/{id}/keys [get]
+func ListKeys(c echo.Context) error {
+	a, err := accountFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if a == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	keys, err := manager.Keys(c.Request().Context(), a.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, &keyListResponse{Keys: keys})
+}
+
+// CreateKey godoc
+// @Summary Create an API key
+// @Description Add an API key to a service account. Its value is returned only in this response; send it in the X-API-Key header. Only the owner or an admin who holds every permission of the account may add keys, and only from a login.
+// @ID create-api-key
+// @Tags service-account
+// @Accept  json
+// @Produce  json
+// @Param        id   path      string  true  "Service account ID"
+// @Param key body keyCreateRequest false "Key options"
+// @Success 201 {object} keyCreatedResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /service-accounts/{id}/keys [post]
+func CreateKey(c echo.Context) error {
+	a, err := accountFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if a == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if done, err := requireControl(c, a); done {
+		return err
+	}
+	req := &keyCreateRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	raw, k, err := manager.IssueKey(c.Request().Context(), a.ID, req.lifetime())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusCreated, &keyCreatedResponse{Key: *k, Value: raw})
+}
+
+// RotateKeys godoc
+// @Summary Rotate API keys
+// @Description Create a new API key and let every other key of the service account expire after an overlap, a day unless overlap_minutes says otherwise. The same rules as for creating a key apply.
+// @ID rotate-api-keys
+// @Tags service-account
+// @Accept  json
+// @Produce  json
+// @Param        id   path      string  true  "Service account ID"
+// @Param key body keyRotateRequest false "Rotation options"
+// @Success 201 {object} keyCreatedResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /service-accounts/{id}/keys/rotate [post]
+func RotateKeys(c echo.Context) error {
+	a, err := accountFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if a == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if done, err := requireControl(c, a); done {
+		return err
+	}
+	req := &keyRotateRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	raw, k, err := manager.Rotate(c.Request().Context(), a.ID, req.overlap(), req.lifetime())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusCreated, &keyCreatedResponse{Key: *k, Value: raw})
+}
+
+// RevokeKey godoc
+// @Summary Revoke an API key
+// @Description Delete one API key of a service account; it stops working at once. Only the owner or an admin who holds every permission of the account may revoke keys.
+// @ID revoke-api-key
+// @Tags service-account
+// @Produce  json
+// @Param        id   path      string  true  "Service account ID"
+// @Param        kid   path      string  true  "Key ID"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /service-accounts/{id}/keys/{kid} [delete]
+func RevokeKey(c echo.Context) error {
+	a, err := accountFromParam(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if a == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if done, err := requireControl(c, a); done {
+		return err
+	}
+	kid, err := primitive.ObjectIDFromHex(c.Param("kid"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	err = manager.RevokeKey(c.Request().Context(), a.ID, kid)
+	if err == ErrKeyNotFound {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// requireControl answers the request with 403 or 500 unless CheckControl
+// lets the requester manage a; done reports whether it did.
+func requireControl(c echo.Context, a *Account) (done bool, err error) {
+	switch err := CheckControl(c, a); err {
+	case nil:
+		return false, nil
+	case ErrNotOwner, ErrNotGrantable:
+		return true, c.JSON(http.StatusForbidden, customerror.NewError(err))
+	default:
+		return true, c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+}
+
+func accountFromParam(c echo.Context) (*Account, error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, nil
+	}
+	return store.GetAccount(c.Request().Context(), id)
+}
+
+func userIDFromToken(c echo.Context) primitive.ObjectID {
+	id, _ := c.Get("user").(primitive.ObjectID)
+	return id
+}
@@ -0,0 +1,221 @@
+package serviceaccount
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps service accounts and API keys in process memory;
+// nothing survives a restart.
+type MemoryStore struct {
+	mu       sync.Mutex
+	accounts map[primitive.ObjectID]Account
+	keys     map[primitive.ObjectID]Key
+}
+
+// Verify Interface Compliance
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		accounts: make(map[primitive.ObjectID]Account),
+		keys:     make(map[primitive.ObjectID]Key),
+	}
+}
+
+func (ss *MemoryStore) ListAccounts(ctx context.Context) ([]Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	accounts := make([]Account, 0, len(ss.accounts))
+	for _, a := range ss.accounts {
+		accounts = append(accounts, copyAccount(a))
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].CreatedAt.Before(accounts[j].CreatedAt) })
+	return accounts, nil
+}
+
+func (ss *MemoryStore) GetAccount(ctx context.Context, id primitive.ObjectID) (*Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	a, ok := ss.accounts[id]
+	if !ok {
+		return nil, nil
+	}
+	c := copyAccount(a)
+	return &c, nil
+}
+
+func (ss *MemoryStore) CreateAccount(ctx context.Context, a *Account) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.accounts[a.ID]; ok || ss.nameTaken(a) {
+		return ErrDuplicate
+	}
+	ss.accounts[a.ID] = copyAccount(*a)
+	return nil
+}
+
+func (ss *MemoryStore) UpdateAccount(ctx context.Context, a *Account) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.accounts[a.ID]; !ok {
+		return nil
+	}
+	if ss.nameTaken(a) {
+		return ErrDuplicate
+	}
+	ss.accounts[a.ID] = copyAccount(*a)
+	return nil
+}
+
+// nameTaken reports whether another account has a's name; callers hold mu.
+func (ss *MemoryStore) nameTaken(a *Account) bool {
+	for id, other := range ss.accounts {
+		if id != a.ID && strings.EqualFold(other.Name, a.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ss *MemoryStore) DeleteAccount(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for kid, k := range ss.keys {
+		if k.AccountID == id {
+			delete(ss.keys, kid)
+		}
+	}
+	delete(ss.accounts, id)
+	return nil
+}
+
+func (ss *MemoryStore) CreateKey(ctx context.Context, k *Key) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.keys[k.ID] = copyKey(*k)
+	return nil
+}
+
+func (ss *MemoryStore) GetKey(ctx context.Context, id primitive.ObjectID) (*Key, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	k, ok := ss.keys[id]
+	if !ok {
+		return nil, nil
+	}
+	c := copyKey(k)
+	return &c, nil
+}
+
+func (ss *MemoryStore) ListKeys(ctx context.Context, accountID primitive.ObjectID) ([]Key, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	keys := make([]Key, 0)
+	for _, k := range ss.keys {
+		if k.AccountID == accountID {
+			keys = append(keys, copyKey(k))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (ss *MemoryStore) ExpireKeys(ctx context.Context, accountID primitive.ObjectID, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for id, k := range ss.keys {
+		if k.AccountID == accountID && (k.ExpiresAt == nil || k.ExpiresAt.After(at)) {
+			exp := at
+			k.ExpiresAt = &exp
+			ss.keys[id] = k
+		}
+	}
+	return nil
+}
+
+func (ss *MemoryStore) DeleteKey(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	delete(ss.keys, id)
+	return nil
+}
+
+func (ss *MemoryStore) TouchKey(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	k, ok := ss.keys[id]
+	if !ok {
+		return nil
+	}
+	k.LastUsedAt = &at
+	ss.keys[id] = k
+	return nil
+}
+
+func copyAccount(a Account) Account {
+	a.Roles = append([]identity.Role{}, a.Roles...)
+	return a
+}
+
+func copyKey(k Key) Key {
+	if k.ExpiresAt != nil {
+		exp := *k.ExpiresAt
+		k.ExpiresAt = &exp
+	}
+	if k.LastUsedAt != nil {
+		at := *k.LastUsedAt
+		k.LastUsedAt = &at
+	}
+	return k
+}
@@ -0,0 +1,128 @@
+package serviceaccount
+
+import (
+	"time"
+
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+)
+
+type accountCreateRequest struct {
+	Account struct {
+		Name        string          `json:"name" validate:"required"`
+		Description string          `json:"description"`
+		Roles       []identity.Role `json:"roles"`
+	} `json:"account"`
+}
+
+func (r *accountCreateRequest) bind(c echo.Context, a *Account) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := c.Validate(r); err != nil {
+		return err
+	}
+	if err := checkGrantable(c, r.Account.Roles); err != nil {
+		return err
+	}
+	a.Name = r.Account.Name
+	a.Description = r.Account.Description
+	a.Roles = append([]identity.Role{}, r.Account.Roles...)
+	return nil
+}
+
+type accountUpdateRequest struct {
+	Account struct {
+		Name        string          `json:"name" validate:"required"`
+		Description string          `json:"description"`
+		Roles       []identity.Role `json:"roles"`
+		Disabled    bool            `json:"disabled"`
+	} `json:"account"`
+}
+
+func (r *accountUpdateRequest) populate(a *Account) {
+	r.Account.Name = a.Name
+	r.Account.Description = a.Description
+	// A copy, so that binding the body does not overwrite a.Roles.
+	r.Account.Roles = append([]identity.Role{}, a.Roles...)
+	r.Account.Disabled = a.Disabled
+}
+
+func (r *accountUpdateRequest) bind(c echo.Context, a *Account) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := c.Validate(r); err != nil {
+		return err
+	}
+	// Roles the account already holds need not be grantable by whoever
+	// renames or disables it.
+	if !sameRoles(r.Account.Roles, a.Roles) {
+		if err := checkGrantable(c, r.Account.Roles); err != nil {
+			return err
+		}
+	}
+	a.Name = r.Account.Name
+	a.Description = r.Account.Description
+	a.Roles = append([]identity.Role{}, r.Account.Roles...)
+	a.Disabled = r.Account.Disabled
+	return nil
+}
+
+func sameRoles(a, b []identity.Role) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type keyCreateRequest struct {
+	Key struct {
+		// ExpiresInDays, if set, limits the life of the key; keys do not
+		// expire by default.
+		ExpiresInDays int `json:"expires_in_days" validate:"omitempty,min=1,max=3650"`
+	} `json:"key"`
+}
+
+func (r *keyCreateRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+func (r *keyCreateRequest) lifetime() time.Duration {
+	return time.Duration(r.Key.ExpiresInDays) * 24 * time.Hour
+}
+
+type keyRotateRequest struct {
+	Key struct {
+		ExpiresInDays int `json:"expires_in_days" validate:"omitempty,min=1,max=3650"`
+		// OverlapMinutes is how long the previous keys keep working;
+		// it defaults to a day and 0 cuts them off at once.
+		OverlapMinutes *int `json:"overlap_minutes" validate:"omitempty,min=0,max=43200"`
+	} `json:"key"`
+}
+
+func (r *keyRotateRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+func (r *keyRotateRequest) lifetime() time.Duration {
+	return time.Duration(r.Key.ExpiresInDays) * 24 * time.Hour
+}
+
+func (r *keyRotateRequest) overlap() time.Duration {
+	if r.Key.OverlapMinutes == nil {
+		return DefaultOverlap
+	}
+	return time.Duration(*r.Key.OverlapMinutes) * time.Minute
+}
@@ -0,0 +1,19 @@
+package serviceaccount
+
+type accountResponse struct {
+	Account Account `json:"account"`
+}
+
+type accountListResponse struct {
+	Accounts []Account `json:"accounts"`
+}
+
+// keyCreatedResponse is the only response that carries the raw key.
+type keyCreatedResponse struct {
+	Key   Key    `json:"key"`
+	Value string `json:"value"`
+}
+
+type keyListResponse struct {
+	Keys []Key `json:"keys"`
+}
@@ -0,0 +1,259 @@
+// Package serviceaccount manages service accounts, principals for other
+// systems rather than people, and the API keys they authenticate with.
+//
+// A service account is created by an administrator, who is recorded as
+// its owner, and holds roles like a user. Only the owner, or an admin,
+// may change the account or issue its keys, and only while holding every
+// permission the account has. An API key reads
+// "sak_<id>_<secret>" and is sent in the X-API-Key header; only its
+// SHA-256 is stored. Rotating keys issues a new key and lets the old ones
+// work for an overlap period, so clients can switch without downtime.
+// Access tokens issued to the account through an OAuth client end when
+// its roles change, when it is disabled and when it is deleted.
+package serviceaccount
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/entity/role"
+	"github.com/hamed-lohi/user-manage/entity/token"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Prefix starts every API key.
+const Prefix = "sak_"
+
+const (
+	// DefaultOverlap is how long old keys keep working after a rotation
+	// that does not say.
+	DefaultOverlap = 24 * time.Hour
+	// touchInterval bounds how often use of a key rewrites LastUsedAt.
+	touchInterval = time.Minute
+)
+
+var (
+	ErrKeyNotFound  = errors.New("api key not found")
+	ErrRoleNotFound = errors.New("role not found")
+	ErrNotGrantable = errors.New("cannot grant a role with permissions you do not hold")
+	ErrNotOwner     = errors.New("only the owner of a service account or an admin may manage it")
+)
+
+var (
+	store   Store
+	roles   role.Store
+	manager *Manager
+	refresh *token.Manager
+)
+
+// Account is a service account. Disabled accounts keep their keys, but
+// the keys are rejected until the account is enabled again.
+type Account struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description" json:"description"`
+	OwnerID     primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	Roles       []identity.Role    `bson:"roles" json:"roles"`
+	Disabled    bool               `bson:"disabled" json:"disabled"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Key is the stored form of an API key. A nil ExpiresAt never expires.
+type Key struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	AccountID  primitive.ObjectID `bson:"account_id" json:"account_id"`
+	Hash       string             `bson:"hash" json:"-"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+}
+
+// Active reports whether the key can still be used at now.
+func (k *Key) Active(now time.Time) bool {
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// Manager issues, rotates and verifies API keys.
+type Manager struct {
+	store Store
+	now   func() time.Time
+}
+
+// Verify Interface Compliance
+var _ identity.TokenAuthenticator = (*Manager)(nil)
+
+func NewManager(s Store) *Manager {
+	return &Manager{store: s, now: time.Now}
+}
+
+func RegisterHandlers(v1 *echo.Group, auth echo.MiddlewareFunc, s Store, rs role.Store, m *Manager, rm *token.Manager) {
+
+	store = s
+	roles = rs
+	manager = m
+	refresh = rm
+
+	accounts := v1.Group("/service-accounts", auth)
+	accounts.GET("", ListAccounts, identity.RequirePermission(identity.ServiceAccountsRead))
+	accounts.GET("/:id", GetAccount, identity.RequirePermission(identity.ServiceAccountsRead))
+	accounts.POST("", CreateAccount, identity.RequirePermission(identity.ServiceAccountsWrite))
+	// Changes are further limited to the owner or an admin holding every
+	// permission of the account; see CheckControl.
+	accounts.PUT("/:id", UpdateAccount, identity.RequirePermission(identity.ServiceAccountsWrite))
+	accounts.DELETE("/:id", DeleteAccount, identity.RequirePermission(identity.ServiceAccountsWrite))
+	// Keys are handed out from a login, so a scoped or expiring token
+	// cannot trade itself for an API key.
+	keys := accounts.Group("/:id/keys", identity.RequireLogin())
+	keys.GET("", ListKeys, identity.RequirePermission(identity.ServiceAccountsRead))
+	keys.POST("", CreateKey, identity.RequirePermission(identity.ServiceAccountsWrite))
+	keys.POST("/rotate", RotateKeys, identity.RequirePermission(identity.ServiceAccountsWrite))
+	keys.DELETE("/:kid", RevokeKey, identity.RequirePermission(identity.ServiceAccountsWrite))
+}
+
+// IssueKey adds a key to an account and returns the raw key, which is not
+// stored, together with its record. A zero lifetime never expires.
+func (m *Manager) IssueKey(ctx context.Context, accountID primitive.ObjectID, lifetime time.Duration) (string, *Key, error) {
+	now := m.now().UTC()
+	k := &Key{
+		ID:        primitive.NewObjectID(),
+		AccountID: accountID,
+		CreatedAt: now,
+	}
+	if lifetime > 0 {
+		exp := now.Add(lifetime)
+		k.ExpiresAt = &exp
+	}
+	raw, err := identity.NewOpaqueToken(Prefix, k.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	k.Hash = identity.HashToken(raw)
+	if err := m.store.CreateKey(ctx, k); err != nil {
+		return "", nil, err
+	}
+	return raw, k, nil
+}
+
+// Rotate issues a new key and makes every other key of the account
+// expire once overlap has passed, unless it expires sooner anyway.
+func (m *Manager) Rotate(ctx context.Context, accountID primitive.ObjectID, overlap, lifetime time.Duration) (string, *Key, error) {
+	if err := m.store.ExpireKeys(ctx, accountID, m.now().UTC().Add(overlap)); err != nil {
+		return "", nil, err
+	}
+	return m.IssueKey(ctx, accountID, lifetime)
+}
+
+// Keys returns the active keys of an account, oldest first.
+func (m *Manager) Keys(ctx context.Context, accountID primitive.ObjectID) ([]Key, error) {
+	all, err := m.store.ListKeys(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	active := make([]Key, 0, len(all))
+	for _, k := range all {
+		if k.Active(now) {
+			active = append(active, k)
+		}
+	}
+	return active, nil
+}
+
+// RevokeKey deletes a key of an account at once. It returns
+// ErrKeyNotFound for keys of other accounts.
+func (m *Manager) RevokeKey(ctx context.Context, accountID, id primitive.ObjectID) error {
+	k, err := m.store.GetKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if k == nil || k.AccountID != accountID {
+		return ErrKeyNotFound
+	}
+	return m.store.DeleteKey(ctx, id)
+}
+
+// Authenticate verifies an API key and returns the service account it
+// belongs to, with the account's current roles. Keys of disabled or
+// deleted accounts are rejected.
+func (m *Manager) Authenticate(ctx context.Context, raw string) (*identity.Principal, error) {
+	id, ok := identity.ParseOpaqueToken(Prefix, raw)
+	if !ok {
+		return nil, nil
+	}
+	k, err := m.store.GetKey(ctx, id)
+	if err != nil || k == nil {
+		return nil, err
+	}
+	now := m.now().UTC()
+	if !identity.TokenMatches(raw, k.Hash) || !k.Active(now) {
+		return nil, nil
+	}
+	a, err := m.store.GetAccount(ctx, k.AccountID)
+	if err != nil || a == nil || a.Disabled {
+		return nil, err
+	}
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= touchInterval {
+		if err := m.store.TouchKey(ctx, k.ID, now); err != nil {
+			return nil, err
+		}
+	}
+	return &identity.Principal{
+		UserID: a.ID,
+		Roles:  a.Roles,
+	}, nil
+}
+
+// CheckControl returns ErrNotOwner unless the requester owns a or is an
+// admin, and ErrNotGrantable if a holds a permission the requester does
+// not. Changing an account, issuing its keys and linking it to an OAuth
+// client all let the requester act as the account, so each needs both.
+func CheckControl(c echo.Context, a *Account) error {
+	if a.OwnerID != userIDFromToken(c) && !isAdmin(c) {
+		return ErrNotOwner
+	}
+	return checkHeld(c, a.Roles)
+}
+
+// checkGrantable rejects unknown roles and, to prevent privilege
+// escalation, roles that grant a permission the requester does not hold.
+func checkGrantable(c echo.Context, ids []identity.Role) error {
+	ctx := c.Request().Context()
+	for _, id := range ids {
+		r, err := roles.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return ErrRoleNotFound
+		}
+	}
+	return checkHeld(c, ids)
+}
+
+// checkHeld returns ErrNotGrantable if ids grant a permission the
+// requester does not hold.
+func checkHeld(c echo.Context, ids []identity.Role) error {
+	perms, err := identity.Roles.Permissions(c.Request().Context(), ids)
+	if err != nil {
+		return err
+	}
+	for p := range perms {
+		if !identity.HasPermission(c, p) {
+			return ErrNotGrantable
+		}
+	}
+	return nil
+}
+
+func isAdmin(c echo.Context) bool {
+	rs, _ := c.Get("roles").([]identity.Role)
+	for _, r := range rs {
+		if r == identity.Admin {
+			return true
+		}
+	}
+	return false
+}
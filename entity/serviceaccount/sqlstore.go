@@ -0,0 +1,266 @@
+package serviceaccount
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlSchema is shared by SQLite and PostgreSQL. Roles are kept as a JSON
+// array, like the roles of a user.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS service_accounts (
+		id          TEXT PRIMARY KEY,
+		name        TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		owner_id    TEXT NOT NULL,
+		roles       TEXT NOT NULL DEFAULT '[]',
+		disabled    BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at  TIMESTAMP NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS service_accounts_name_key ON service_accounts (lower(name))`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id           TEXT PRIMARY KEY,
+		account_id   TEXT NOT NULL,
+		hash         TEXT NOT NULL UNIQUE,
+		created_at   TIMESTAMP NOT NULL,
+		expires_at   TIMESTAMP,
+		last_used_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS api_keys_account_idx ON api_keys (account_id, created_at)`,
+}
+
+const (
+	accountColumns = `id, name, description, owner_id, roles, disabled, created_at`
+	keyColumns     = `id, account_id, hash, created_at, expires_at, last_used_at`
+)
+
+type SQLStore struct {
+	dbProvider *db.DBProvider
+	db         *sql.DB
+}
+
+// Verify Interface Compliance
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore creates the service account schema if it does not exist
+// yet.
+func NewSQLStore(dp *db.DBProvider) (*SQLStore, error) {
+	for _, stmt := range sqlSchema {
+		if _, err := dp.SQL.ExecContext(dp.Context, stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLStore{
+		dbProvider: dp,
+		db:         dp.SQL,
+	}, nil
+}
+
+func (ss *SQLStore) ListAccounts(ctx context.Context) ([]Account, error) {
+	ctx, cancel := ss.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := ss.db.QueryContext(ctx, `SELECT `+accountColumns+` FROM service_accounts ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make([]Account, 0)
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *a)
+	}
+	return accounts, rows.Err()
+}
+
+func (ss *SQLStore) GetAccount(ctx context.Context, id primitive.ObjectID) (*Account, error) {
+	ctx, cancel := ss.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	a, err := scanAccount(ss.db.QueryRowContext(ctx,
+		`SELECT `+accountColumns+` FROM service_accounts WHERE id = $1`, id.Hex()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+func (ss *SQLStore) CreateAccount(ctx context.Context, a *Account) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	roles, err := json.Marshal(a.Roles)
+	if err != nil {
+		return err
+	}
+	_, err = ss.db.ExecContext(ctx,
+		`INSERT INTO service_accounts (`+accountColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		a.ID.Hex(), a.Name, a.Description, a.OwnerID.Hex(), string(roles), a.Disabled, a.CreatedAt)
+	return translateSQLError(err)
+}
+
+func (ss *SQLStore) UpdateAccount(ctx context.Context, a *Account) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	roles, err := json.Marshal(a.Roles)
+	if err != nil {
+		return err
+	}
+	_, err = ss.db.ExecContext(ctx,
+		`UPDATE service_accounts SET name = $2, description = $3, roles = $4, disabled = $5 WHERE id = $1`,
+		a.ID.Hex(), a.Name, a.Description, string(roles), a.Disabled)
+	return translateSQLError(err)
+}
+
+func (ss *SQLStore) DeleteAccount(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	if _, err := ss.db.ExecContext(ctx, `DELETE FROM api_keys WHERE account_id = $1`, id.Hex()); err != nil {
+		return err
+	}
+	_, err := ss.db.ExecContext(ctx, `DELETE FROM service_accounts WHERE id = $1`, id.Hex())
+	return err
+}
+
+func (ss *SQLStore) CreateKey(ctx context.Context, k *Key) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	// There is no TTL in SQL, so expired keys are swept here.
+	if _, err := ss.db.ExecContext(ctx,
+		`DELETE FROM api_keys WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := ss.db.ExecContext(ctx,
+		`INSERT INTO api_keys (`+keyColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		k.ID.Hex(), k.AccountID.Hex(), k.Hash, k.CreatedAt, k.ExpiresAt, k.LastUsedAt)
+	return err
+}
+
+func (ss *SQLStore) GetKey(ctx context.Context, id primitive.ObjectID) (*Key, error) {
+	ctx, cancel := ss.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	k, err := scanKey(ss.db.QueryRowContext(ctx,
+		`SELECT `+keyColumns+` FROM api_keys WHERE id = $1`, id.Hex()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+func (ss *SQLStore) ListKeys(ctx context.Context, accountID primitive.ObjectID) ([]Key, error) {
+	ctx, cancel := ss.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := ss.db.QueryContext(ctx,
+		`SELECT `+keyColumns+` FROM api_keys WHERE account_id = $1 ORDER BY created_at`, accountID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]Key, 0)
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+func (ss *SQLStore) ExpireKeys(ctx context.Context, accountID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ss.db.ExecContext(ctx,
+		`UPDATE api_keys SET expires_at = $2 WHERE account_id = $1 AND (expires_at IS NULL OR expires_at > $2)`,
+		accountID.Hex(), at)
+	return err
+}
+
+func (ss *SQLStore) DeleteKey(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ss.db.ExecContext(ctx, `DELETE FROM api_keys WHERE id = $1`, id.Hex())
+	return err
+}
+
+func (ss *SQLStore) TouchKey(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ss.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, id.Hex(), at)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAccount(row rowScanner) (*Account, error) {
+	var (
+		a                Account
+		id, owner, roles string
+	)
+	err := row.Scan(&id, &a.Name, &a.Description, &owner, &roles, &a.Disabled, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if a.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if a.OwnerID, err = primitive.ObjectIDFromHex(owner); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(roles), &a.Roles); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func scanKey(row rowScanner) (*Key, error) {
+	var (
+		k                     Key
+		id, account           string
+		expiresAt, lastUsedAt sql.NullTime
+	)
+	err := row.Scan(&id, &account, &k.Hash, &k.CreatedAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	if k.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if k.AccountID, err = primitive.ObjectIDFromHex(account); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	return &k, nil
+}
+
+func translateSQLError(err error) error {
+	if db.IsUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+package serviceaccount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrDuplicate is returned by CreateAccount and UpdateAccount when another
+// account already has the same name.
+var ErrDuplicate = errors.New("a service account with this name already exists")
+
+// Store is the persistence contract for service accounts and their keys.
+// Lookups return (nil, nil) when nothing matches. Account names are
+// unique and compared case-insensitively. Lists are ordered by creation
+// time; ListKeys may include expired keys that were not swept yet.
+// DeleteAccount deletes the account's keys too. ExpireKeys makes every key
+// of the account that would outlive at expire at at.
+type Store interface {
+	ListAccounts(ctx context.Context) ([]Account, error)
+	GetAccount(ctx context.Context, id primitive.ObjectID) (*Account, error)
+	CreateAccount(ctx context.Context, a *Account) error
+	UpdateAccount(ctx context.Context, a *Account) error
+	DeleteAccount(ctx context.Context, id primitive.ObjectID) error
+
+	CreateKey(ctx context.Context, k *Key) error
+	GetKey(ctx context.Context, id primitive.ObjectID) (*Key, error)
+	ListKeys(ctx context.Context, accountID primitive.ObjectID) ([]Key, error)
+	ExpireKeys(ctx context.Context, accountID primitive.ObjectID, at time.Time) error
+	DeleteKey(ctx context.Context, id primitive.ObjectID) error
+	TouchKey(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+// NewStore returns the Store implementation for the provider's driver.
+func NewStore(dp *db.DBProvider) (Store, error) {
+	switch dp.Driver {
+	case db.Mongo:
+		return NewMongoStore(dp), nil
+	case db.SQLite, db.Postgres:
+		return NewSQLStore(dp)
+	case db.Memory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("serviceaccount: unsupported driver %q", dp.Driver)
+}
+
+// MongoStore relies on a TTL index on api_keys.expires_at to remove
+// expired keys.
+type MongoStore struct {
+	dbProvider *db.DBProvider
+	accounts   *mongo.Collection
+	keys       *mongo.Collection
+}
+
+// Verify Interface Compliance
+var _ Store = (*MongoStore)(nil)
+
+func NewMongoStore(dp *db.DBProvider) *MongoStore {
+	return &MongoStore{
+		dbProvider: dp,
+		accounts:   dp.GetCollection(db.ServiceAccounts),
+		keys:       dp.GetCollection(db.APIKeys),
+	}
+}
+
+func (ss *MongoStore) ListAccounts(ctx context.Context) ([]Account, error) {
+	ctx, cancel := ss.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	cursor, err := ss.accounts.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]Account, 0)
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func (ss *MongoStore) GetAccount(ctx context.Context, id primitive.ObjectID) (*Account, error) {
+	ctx, cancel := ss.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var a Account
+	if err := ss.accounts.FindOne(ctx, bson.M{"_id": id}).Decode(&a); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (ss *MongoStore) CreateAccount(ctx context.Context, a *Account) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ss.accounts.InsertOne(ctx, a)
+	return translateMongoError(err)
+}
+
+func (ss *MongoStore) UpdateAccount(ctx context.Context, a *Account) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ss.accounts.ReplaceOne(ctx, bson.M{"_id": a.ID}, a)
+	return translateMongoError(err)
+}
+
+func (ss *MongoStore) DeleteAccount(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	if _, err := ss.keys.DeleteMany(ctx, bson.M{"account_id": id}); err != nil {
+		return err
+	}
+	_, err := ss.accounts.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (ss *MongoStore) CreateKey(ctx context.Context, k *Key) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ss.keys.InsertOne(ctx, k)
+	return err
+}
+
+func (ss *MongoStore) GetKey(ctx context.Context, id primitive.ObjectID) (*Key, error) {
+	ctx, cancel := ss.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var k Key
+	if err := ss.keys.FindOne(ctx, bson.M{"_id": id}).Decode(&k); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (ss *MongoStore) ListKeys(ctx context.Context, accountID primitive.ObjectID) ([]Key, error) {
+	ctx, cancel := ss.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	cursor, err := ss.keys.Find(ctx, bson.M{"account_id": accountID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0)
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (ss *MongoStore) ExpireKeys(ctx context.Context, accountID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ss.keys.UpdateMany(ctx,
+		bson.M{
+			"account_id": accountID,
+			"$or": bson.A{
+				bson.M{"expires_at": bson.M{"$exists": false}},
+				bson.M{"expires_at": bson.M{"$gt": at}},
+			},
+		},
+		bson.M{"$set": bson.M{"expires_at": at}})
+	return err
+}
+
+func (ss *MongoStore) DeleteKey(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ss.keys.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (ss *MongoStore) TouchKey(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ss.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ss.keys.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+func translateMongoError(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
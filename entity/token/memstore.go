@@ -0,0 +1,226 @@
+package token
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps refresh tokens, sessions and revocations in process
+// memory; nothing survives a restart.
+type MemoryStore struct {
+	mu          sync.Mutex
+	tokens      map[primitive.ObjectID]*RefreshToken
+	sessions    map[primitive.ObjectID]*Session
+	revoked     map[string]time.Time
+	revocations map[primitive.ObjectID]time.Time
+}
+
+// Verify Interface Compliance
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens:      make(map[primitive.ObjectID]*RefreshToken),
+		sessions:    make(map[primitive.ObjectID]*Session),
+		revoked:     make(map[string]time.Time),
+		revocations: make(map[primitive.ObjectID]time.Time),
+	}
+}
+
+func (ts *MemoryStore) Create(ctx context.Context, t *RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	c := *t
+	ts.tokens[t.ID] = &c
+	return nil
+}
+
+func (ts *MemoryStore) GetByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, t := range ts.tokens {
+		if t.Hash == hash {
+			c := *t
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (ts *MemoryStore) MarkUsed(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t, ok := ts.tokens[id]
+	if !ok || t.UsedAt != nil {
+		return false, nil
+	}
+	t.UsedAt = &at
+	return true, nil
+}
+
+func (ts *MemoryStore) RevokeFamily(ctx context.Context, familyID primitive.ObjectID, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, t := range ts.tokens {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &at
+		}
+	}
+	if s, ok := ts.sessions[familyID]; ok && s.RevokedAt == nil {
+		s.RevokedAt = &at
+	}
+	return nil
+}
+
+func (ts *MemoryStore) RevokeUser(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, t := range ts.tokens {
+		if t.UserID == userID && t.RevokedAt == nil {
+			t.RevokedAt = &at
+		}
+	}
+	for _, s := range ts.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			s.RevokedAt = &at
+		}
+	}
+	return nil
+}
+
+func (ts *MemoryStore) CreateSession(ctx context.Context, s *Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	c := *s
+	ts.sessions[s.ID] = &c
+	return nil
+}
+
+func (ts *MemoryStore) GetSession(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	s, ok := ts.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *s
+	return &c, nil
+}
+
+func (ts *MemoryStore) ListSessions(ctx context.Context, userID primitive.ObjectID) ([]Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	sessions := make([]Session, 0)
+	for _, s := range ts.sessions {
+		if s.UserID == userID {
+			sessions = append(sessions, *s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (ts *MemoryStore) TouchSession(ctx context.Context, s *Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if stored, ok := ts.sessions[s.ID]; ok {
+		stored.LastUsedAt = s.LastUsedAt
+		stored.UserAgent = s.UserAgent
+		stored.IP = s.IP
+		stored.ExpiresAt = s.ExpiresAt
+	}
+	return nil
+}
+
+func (ts *MemoryStore) RevokeAccess(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range ts.revoked {
+		if exp.Before(now) {
+			delete(ts.revoked, id)
+		}
+	}
+	ts.revoked[jti] = expiresAt
+	return nil
+}
+
+func (ts *MemoryStore) IsAccessRevoked(ctx context.Context, jti string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	_, ok := ts.revoked[jti]
+	return ok, nil
+}
+
+func (ts *MemoryStore) SetRevokedBefore(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if at.After(ts.revocations[userID]) {
+		ts.revocations[userID] = at
+	}
+	return nil
+}
+
+func (ts *MemoryStore) RevokedBefore(ctx context.Context, userID primitive.ObjectID) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.revocations[userID], nil
+}
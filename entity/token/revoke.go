@@ -0,0 +1,67 @@
+package token
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RevokeAccess blocks a single access token until its own expiry.
+func (m *Manager) RevokeAccess(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+	return m.store.RevokeAccess(ctx, jti, expiresAt.UTC())
+}
+
+// RevokeFamily ends the login that raw belongs to. Tokens of other users
+// and unknown tokens are ignored, so the result does not reveal whether
+// raw was valid.
+func (m *Manager) RevokeFamily(ctx context.Context, userID primitive.ObjectID, raw string) error {
+	t, err := m.store.GetByHash(ctx, Hash(raw))
+	if err != nil || t == nil || t.UserID != userID {
+		return err
+	}
+	return m.store.RevokeFamily(ctx, t.FamilyID, m.now().UTC())
+}
+
+// RevokeUser logs the user out everywhere: every session and refresh
+// token is revoked and every access token issued so far is rejected.
+func (m *Manager) RevokeUser(ctx context.Context, userID primitive.ObjectID) error {
+	now := m.now().UTC()
+	if err := m.store.SetRevokedBefore(ctx, userID, now); err != nil {
+		return err
+	}
+	return m.store.RevokeUser(ctx, userID, now)
+}
+
+// IsRevoked reports whether an access token was revoked on its own, with
+// its session or by a cutoff for its user. Access tokens carry iat in
+// whole seconds, so a token issued within the same second as the cutoff
+// is rejected too, unless it belongs to a session started after the
+// cutoff.
+func (m *Manager) IsRevoked(ctx context.Context, jti, sid string, userID primitive.ObjectID, issuedAt time.Time) (bool, error) {
+	var started time.Time
+	if sid != "" {
+		s, err := m.activeSession(ctx, sid)
+		if err != nil || s == nil {
+			return err == nil, err
+		}
+		started = s.CreatedAt
+	}
+	if jti != "" {
+		revoked, err := m.store.IsAccessRevoked(ctx, jti)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+	cutoff, err := m.store.RevokedBefore(ctx, userID)
+	if err != nil || cutoff.IsZero() {
+		return false, err
+	}
+	if started.After(cutoff) {
+		return false, nil
+	}
+	return !issuedAt.After(cutoff), nil
+}
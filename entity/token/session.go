@@ -0,0 +1,116 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// touchInterval bounds how often access token use rewrites LastUsedAt,
+// so an active client does not cost a write per request.
+const touchInterval = time.Minute
+
+// Client describes the device a login or refresh came from.
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// Session is one login of a user. Its ID is the refresh token family ID
+// and access tokens name it in their sid claim. ExpiresAt follows the
+// newest refresh token of the family.
+type Session struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	UserAgent  string             `bson:"user_agent" json:"user_agent"`
+	IP         string             `bson:"ip" json:"ip"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt time.Time          `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt  time.Time          `bson:"expires_at" json:"expires_at"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the session can still be used at now.
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// Sessions returns the active sessions of a user, oldest first.
+func (m *Manager) Sessions(ctx context.Context, userID primitive.ObjectID) ([]Session, error) {
+	all, err := m.store.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now().UTC()
+	active := make([]Session, 0, len(all))
+	for _, s := range all {
+		if s.Active(now) {
+			active = append(active, s)
+		}
+	}
+	return active, nil
+}
+
+// RevokeSession ends one session of a user: its refresh tokens stop
+// working and so do the access tokens that name it. It returns
+// ErrSessionNotFound for sessions of other users and inactive ones.
+func (m *Manager) RevokeSession(ctx context.Context, userID, id primitive.ObjectID) error {
+	s, err := m.store.GetSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	now := m.now().UTC()
+	if s == nil || s.UserID != userID || !s.Active(now) {
+		return ErrSessionNotFound
+	}
+	return m.store.RevokeFamily(ctx, id, now)
+}
+
+// touch records a use of the session. Empty client fields and a zero
+// expiresAt keep the stored values.
+func (m *Manager) touch(ctx context.Context, id primitive.ObjectID, client Client, expiresAt time.Time) error {
+	s, err := m.store.GetSession(ctx, id)
+	if err != nil || s == nil {
+		return err
+	}
+	s.LastUsedAt = m.now().UTC()
+	if client.UserAgent != "" {
+		s.UserAgent = client.UserAgent
+	}
+	if client.IP != "" {
+		s.IP = client.IP
+	}
+	if !expiresAt.IsZero() {
+		s.ExpiresAt = expiresAt
+	}
+	return m.store.TouchSession(ctx, s)
+}
+
+// activeSession returns the session an access token names, or nil if it
+// is missing or no longer active, and refreshes its LastUsedAt at most
+// once per touchInterval.
+func (m *Manager) activeSession(ctx context.Context, sid string) (*Session, error) {
+	id, err := primitive.ObjectIDFromHex(sid)
+	if err != nil {
+		return nil, nil
+	}
+	s, err := m.store.GetSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now().UTC()
+	if s == nil || !s.Active(now) {
+		return nil, nil
+	}
+	if now.Sub(s.LastUsedAt) >= touchInterval {
+		s.LastUsedAt = now
+		if err := m.store.TouchSession(ctx, s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,284 @@
+package token
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id         TEXT PRIMARY KEY,
+		hash       TEXT NOT NULL UNIQUE,
+		family_id  TEXT NOT NULL,
+		user_id    TEXT NOT NULL,
+		issued_at  TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at    TIMESTAMP,
+		revoked_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family_id)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_user_idx ON refresh_tokens (user_id)`,
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id           TEXT PRIMARY KEY,
+		user_id      TEXT NOT NULL,
+		user_agent   TEXT NOT NULL,
+		ip           TEXT NOT NULL,
+		created_at   TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP NOT NULL,
+		expires_at   TIMESTAMP NOT NULL,
+		revoked_at   TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id, created_at)`,
+	`CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti        TEXT PRIMARY KEY,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_revocations (
+		user_id        TEXT PRIMARY KEY,
+		revoked_before TIMESTAMP NOT NULL
+	)`,
+}
+
+const (
+	tokenColumns   = `id, hash, family_id, user_id, issued_at, expires_at, used_at, revoked_at`
+	sessionColumns = `id, user_id, user_agent, ip, created_at, last_used_at, expires_at, revoked_at`
+)
+
+type SQLStore struct {
+	dbProvider *db.DBProvider
+	db         *sql.DB
+}
+
+// Verify Interface Compliance
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore creates the token schema if it does not exist yet.
+func NewSQLStore(dp *db.DBProvider) (*SQLStore, error) {
+	for _, stmt := range sqlSchema {
+		if _, err := dp.SQL.ExecContext(dp.Context, stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLStore{
+		dbProvider: dp,
+		db:         dp.SQL,
+	}, nil
+}
+
+func (ts *SQLStore) Create(ctx context.Context, t *RefreshToken) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ts.db.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (`+tokenColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		t.ID.Hex(), t.Hash, t.FamilyID.Hex(), t.UserID.Hex(), t.IssuedAt, t.ExpiresAt, t.UsedAt, t.RevokedAt)
+	return err
+}
+
+func (ts *SQLStore) GetByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	ctx, cancel := ts.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var (
+		t                 RefreshToken
+		id, family, user  string
+		usedAt, revokedAt sql.NullTime
+	)
+	err := ts.db.QueryRowContext(ctx, `SELECT `+tokenColumns+` FROM refresh_tokens WHERE hash = $1`, hash).
+		Scan(&id, &t.Hash, &family, &user, &t.IssuedAt, &t.ExpiresAt, &usedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if t.FamilyID, err = primitive.ObjectIDFromHex(family); err != nil {
+		return nil, err
+	}
+	if t.UserID, err = primitive.ObjectIDFromHex(user); err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+func (ts *SQLStore) MarkUsed(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	res, err := ts.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET used_at = $2 WHERE id = $1 AND used_at IS NULL`, id.Hex(), at)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (ts *SQLStore) RevokeFamily(ctx context.Context, familyID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	if _, err := ts.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL`, familyID.Hex(), at); err != nil {
+		return err
+	}
+	_, err := ts.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`, familyID.Hex(), at)
+	return err
+}
+
+func (ts *SQLStore) RevokeUser(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	if _, err := ts.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`, userID.Hex(), at); err != nil {
+		return err
+	}
+	_, err := ts.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`, userID.Hex(), at)
+	return err
+}
+
+func (ts *SQLStore) CreateSession(ctx context.Context, s *Session) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ts.db.ExecContext(ctx,
+		`INSERT INTO sessions (`+sessionColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		s.ID.Hex(), s.UserID.Hex(), s.UserAgent, s.IP, s.CreatedAt, s.LastUsedAt, s.ExpiresAt, s.RevokedAt)
+	return err
+}
+
+func (ts *SQLStore) GetSession(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	ctx, cancel := ts.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	s, err := scanSession(ts.db.QueryRowContext(ctx,
+		`SELECT `+sessionColumns+` FROM sessions WHERE id = $1`, id.Hex()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+func (ts *SQLStore) ListSessions(ctx context.Context, userID primitive.ObjectID) ([]Session, error) {
+	ctx, cancel := ts.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := ts.db.QueryContext(ctx,
+		`SELECT `+sessionColumns+` FROM sessions WHERE user_id = $1 ORDER BY created_at`, userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+func (ts *SQLStore) TouchSession(ctx context.Context, s *Session) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ts.db.ExecContext(ctx,
+		`UPDATE sessions SET last_used_at = $2, user_agent = $3, ip = $4, expires_at = $5 WHERE id = $1`,
+		s.ID.Hex(), s.LastUsedAt, s.UserAgent, s.IP, s.ExpiresAt)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row rowScanner) (*Session, error) {
+	var (
+		s         Session
+		id, user  string
+		revokedAt sql.NullTime
+	)
+	err := row.Scan(&id, &user, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if s.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if s.UserID, err = primitive.ObjectIDFromHex(user); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		s.RevokedAt = &revokedAt.Time
+	}
+	return &s, nil
+}
+
+func (ts *SQLStore) RevokeAccess(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	// There is no TTL in SQL, so entries past their expiry are swept here.
+	if _, err := ts.db.ExecContext(ctx,
+		`DELETE FROM revoked_tokens WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := ts.db.ExecContext(ctx,
+		`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`, jti, expiresAt)
+	return err
+}
+
+func (ts *SQLStore) IsAccessRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := ts.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var one int
+	err := ts.db.QueryRowContext(ctx, `SELECT 1 FROM revoked_tokens WHERE jti = $1`, jti).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (ts *SQLStore) SetRevokedBefore(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ts.db.ExecContext(ctx,
+		`INSERT INTO user_revocations (user_id, revoked_before) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET revoked_before = excluded.revoked_before
+		WHERE user_revocations.revoked_before < excluded.revoked_before`, userID.Hex(), at)
+	return err
+}
+
+func (ts *SQLStore) RevokedBefore(ctx context.Context, userID primitive.ObjectID) (time.Time, error) {
+	ctx, cancel := ts.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var at time.Time
+	err := ts.db.QueryRowContext(ctx,
+		`SELECT revoked_before FROM user_revocations WHERE user_id = $1`, userID.Hex()).Scan(&at)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return at, err
+}
@@ -0,0 +1,233 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store is the persistence contract for refresh tokens, sessions and
+// access token revocations. GetByHash and GetSession return (nil, nil)
+// when nothing matches and RevokedBefore returns the zero time when the
+// user has no cutoff.
+type Store interface {
+	Create(ctx context.Context, t *RefreshToken) error
+	GetByHash(ctx context.Context, hash string) (*RefreshToken, error)
+	// MarkUsed sets UsedAt unless it is already set and reports whether
+	// this call was the one that set it.
+	MarkUsed(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error)
+	// RevokeFamily revokes the refresh tokens of a family and the session
+	// of the same ID; RevokeUser does so for every family of the user.
+	RevokeFamily(ctx context.Context, familyID primitive.ObjectID, at time.Time) error
+	RevokeUser(ctx context.Context, userID primitive.ObjectID, at time.Time) error
+
+	CreateSession(ctx context.Context, s *Session) error
+	GetSession(ctx context.Context, id primitive.ObjectID) (*Session, error)
+	ListSessions(ctx context.Context, userID primitive.ObjectID) ([]Session, error)
+	// TouchSession stores LastUsedAt, UserAgent, IP and ExpiresAt of s and
+	// leaves RevokedAt alone, so it cannot undo a concurrent revocation.
+	TouchSession(ctx context.Context, s *Session) error
+
+	// RevokeAccess blocks one access token by jti until it expires anyway.
+	RevokeAccess(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessRevoked(ctx context.Context, jti string) (bool, error)
+	// SetRevokedBefore blocks every access token issued to the user
+	// before at.
+	SetRevokedBefore(ctx context.Context, userID primitive.ObjectID, at time.Time) error
+	RevokedBefore(ctx context.Context, userID primitive.ObjectID) (time.Time, error)
+}
+
+// NewStore returns the Store implementation for the provider's driver.
+func NewStore(dp *db.DBProvider) (Store, error) {
+	switch dp.Driver {
+	case db.Mongo:
+		return NewMongoStore(dp), nil
+	case db.SQLite, db.Postgres:
+		return NewSQLStore(dp)
+	case db.Memory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("token: unsupported driver %q", dp.Driver)
+}
+
+type MongoStore struct {
+	dbProvider  *db.DBProvider
+	collection  *mongo.Collection
+	sessions    *mongo.Collection
+	revoked     *mongo.Collection
+	revocations *mongo.Collection
+}
+
+// Verify Interface Compliance
+var _ Store = (*MongoStore)(nil)
+
+func NewMongoStore(dp *db.DBProvider) *MongoStore {
+	return &MongoStore{
+		dbProvider:  dp,
+		collection:  dp.GetCollection(db.RefreshTokens),
+		sessions:    dp.GetCollection(db.Sessions),
+		revoked:     dp.GetCollection(db.RevokedTokens),
+		revocations: dp.GetCollection(db.UserRevocations),
+	}
+}
+
+func (ts *MongoStore) Create(ctx context.Context, t *RefreshToken) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ts.collection.InsertOne(ctx, t)
+	return err
+}
+
+func (ts *MongoStore) GetByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	ctx, cancel := ts.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var t RefreshToken
+	if err := ts.collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&t); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (ts *MongoStore) MarkUsed(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "used_at": bson.M{"$exists": false}}
+	res, err := ts.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"used_at": at}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (ts *MongoStore) RevokeFamily(ctx context.Context, familyID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	filter := bson.M{"family_id": familyID, "revoked_at": bson.M{"$exists": false}}
+	if _, err := ts.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": at}}); err != nil {
+		return err
+	}
+	filter = bson.M{"_id": familyID, "revoked_at": bson.M{"$exists": false}}
+	_, err := ts.sessions.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked_at": at}})
+	return err
+}
+
+func (ts *MongoStore) RevokeUser(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revoked_at": at}}
+	if _, err := ts.collection.UpdateMany(ctx, filter, update); err != nil {
+		return err
+	}
+	_, err := ts.sessions.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (ts *MongoStore) CreateSession(ctx context.Context, s *Session) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ts.sessions.InsertOne(ctx, s)
+	return err
+}
+
+func (ts *MongoStore) GetSession(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	ctx, cancel := ts.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var s Session
+	if err := ts.sessions.FindOne(ctx, bson.M{"_id": id}).Decode(&s); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (ts *MongoStore) ListSessions(ctx context.Context, userID primitive.ObjectID) ([]Session, error) {
+	ctx, cancel := ts.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	cursor, err := ts.sessions.Find(ctx, bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0)
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (ts *MongoStore) TouchSession(ctx context.Context, s *Session) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ts.sessions.UpdateOne(ctx, bson.M{"_id": s.ID}, bson.M{"$set": bson.M{
+		"last_used_at": s.LastUsedAt,
+		"user_agent":   s.UserAgent,
+		"ip":           s.IP,
+		"expires_at":   s.ExpiresAt,
+	}})
+	return err
+}
+
+func (ts *MongoStore) RevokeAccess(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	// The TTL index on expires_at drops the entry once the token would
+	// have expired on its own.
+	_, err := ts.revoked.UpdateOne(ctx, bson.M{"_id": jti},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (ts *MongoStore) IsAccessRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := ts.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	n, err := ts.revoked.CountDocuments(ctx, bson.M{"_id": jti}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+func (ts *MongoStore) SetRevokedBefore(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := ts.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := ts.revocations.UpdateOne(ctx, bson.M{"_id": userID},
+		bson.M{"$max": bson.M{"revoked_before": at}}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (ts *MongoStore) RevokedBefore(ctx context.Context, userID primitive.ObjectID) (time.Time, error) {
+	ctx, cancel := ts.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	var r struct {
+		RevokedBefore time.Time `bson:"revoked_before"`
+	}
+	if err := ts.revocations.FindOne(ctx, bson.M{"_id": userID}).Decode(&r); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return r.RevokedBefore, nil
+}
@@ -0,0 +1,157 @@
+package token
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/config"
+	"github.com/hamed-lohi/user-manage/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// storeBackends opens an empty Store of every backend. MongoDB is only
+// tried when TEST_MONGO_URI points at a server.
+var storeBackends = map[string]func(t *testing.T) Store{
+	"memory": func(t *testing.T) Store {
+		return NewMemoryStore()
+	},
+	"sqlite": func(t *testing.T) Store {
+		dp := db.NewDBProvider(config.Database{
+			Driver: string(db.SQLite),
+			DSN:    "file:" + filepath.Join(t.TempDir(), "tokens.db"),
+		})
+		t.Cleanup(func() { dp.Close(context.Background()) })
+		s, err := NewSQLStore(dp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+	"mongo": func(t *testing.T) Store {
+		uri := os.Getenv("TEST_MONGO_URI")
+		if uri == "" {
+			t.Skip("TEST_MONGO_URI is not set")
+		}
+		dp := db.NewDBProvider(config.Database{
+			Driver:   string(db.Mongo),
+			MongoURI: uri,
+			Name:     "test_tokens_" + primitive.NewObjectID().Hex(),
+		})
+		t.Cleanup(func() {
+			dp.Client.Database(dp.Name).Drop(context.Background())
+			dp.Close(context.Background())
+		})
+		return NewMongoStore(dp)
+	},
+}
+
+func TestStore(t *testing.T) {
+	for name, open := range storeBackends {
+		open := open
+		t.Run(name, func(t *testing.T) {
+			t.Run("MarkUsedOnce", func(t *testing.T) { testMarkUsedOnce(t, open(t)) })
+			t.Run("ReuseRevokesFamily", func(t *testing.T) { testReuseRevokesFamily(t, open(t)) })
+		})
+	}
+}
+
+// testMarkUsedOnce checks the compare-and-set rotation relies on: of any
+// number of concurrent calls, only one marks the token used.
+func testMarkUsedOnce(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	rt := &RefreshToken{
+		ID:        primitive.NewObjectID(),
+		Hash:      Hash("raw"),
+		FamilyID:  primitive.NewObjectID(),
+		UserID:    primitive.NewObjectID(),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	if err := s.Create(ctx, rt); err != nil {
+		t.Fatal(err)
+	}
+
+	const callers = 8
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		wins int
+	)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := s.MarkUsed(ctx, rt.ID, now)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Errorf("%d of %d concurrent MarkUsed calls won, want 1", wins, callers)
+	}
+
+	got, err := s.GetByHash(ctx, rt.Hash)
+	if err != nil || got == nil || got.UsedAt == nil || !got.UsedAt.Equal(now) {
+		t.Errorf("stored token after MarkUsed: %+v, %v", got, err)
+	}
+	if ok, err := s.MarkUsed(ctx, rt.ID, now.Add(time.Second)); ok || err != nil {
+		t.Errorf("MarkUsed of a used token: %t, %v", ok, err)
+	}
+	if ok, err := s.MarkUsed(ctx, primitive.NewObjectID(), now); ok || err != nil {
+		t.Errorf("MarkUsed of an unknown token: %t, %v", ok, err)
+	}
+}
+
+func testReuseRevokesFamily(t *testing.T, s Store) {
+	m := NewManager(s, time.Hour)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	first, issued, err := m.Issue(ctx, userID, Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another login of the same user is not part of the family.
+	other, _, err := m.Issue(ctx, userID, Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := m.Rotate(ctx, first, Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := m.Rotate(ctx, first, Client{}); err != ErrReused {
+		t.Fatalf("replayed token: %v, want %v", err, ErrReused)
+	}
+	if _, _, err := m.Rotate(ctx, second, Client{}); err != ErrInvalid {
+		t.Errorf("successor of a replayed token: %v, want %v", err, ErrInvalid)
+	}
+	sessions, err := m.Sessions(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sess := range sessions {
+		if sess.ID == issued.FamilyID {
+			t.Error("session of the replayed token is still active")
+		}
+	}
+	if len(sessions) != 1 {
+		t.Errorf("%d sessions left, want the other login's", len(sessions))
+	}
+	if _, _, err := m.Rotate(ctx, other, Client{}); err != nil {
+		t.Errorf("other login: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Package token persists opaque refresh tokens and rotates them.
+//
+// Every login starts a token family, recorded as a Session. Each refresh
+// consumes the presented token and issues its successor in the same
+// family; presenting an already consumed token means it leaked, so the
+// whole family and its session are revoked.
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalid = errors.New("invalid or expired refresh token")
+	ErrReused  = errors.New("refresh token reuse detected; all tokens of this login were revoked")
+)
+
+// RefreshToken is the stored form of a refresh token. Only the SHA-256
+// of the raw value is kept, so a database leak does not leak tokens.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Hash      string             `bson:"hash" json:"-"`
+	FamilyID  primitive.ObjectID `bson:"family_id" json:"family_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	IssuedAt  time.Time          `bson:"issued_at" json:"issued_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	RevokedAt *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// Manager issues and rotates refresh tokens on top of a Store.
+type Manager struct {
+	store Store
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+func NewManager(s Store, ttl time.Duration) *Manager {
+	return &Manager{store: s, ttl: ttl, now: time.Now}
+}
+
+// Issue starts a new session for a fresh login and returns the raw token
+// to hand to the client together with its stored record, whose FamilyID
+// is the session ID.
+func (m *Manager) Issue(ctx context.Context, userID primitive.ObjectID, client Client) (string, *RefreshToken, error) {
+	raw, t, err := m.issue(ctx, userID, primitive.NewObjectID())
+	if err != nil {
+		return "", nil, err
+	}
+	s := &Session{
+		ID:         t.FamilyID,
+		UserID:     userID,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		CreatedAt:  t.IssuedAt,
+		LastUsedAt: t.IssuedAt,
+		ExpiresAt:  t.ExpiresAt,
+	}
+	if err := m.store.CreateSession(ctx, s); err != nil {
+		return "", nil, err
+	}
+	return raw, t, nil
+}
+
+// Rotate consumes raw and returns its successor together with the stored
+// record of the successor. A replayed token revokes its family and
+// yields ErrReused.
+func (m *Manager) Rotate(ctx context.Context, raw string, client Client) (string, *RefreshToken, error) {
+	t, err := m.store.GetByHash(ctx, Hash(raw))
+	if err != nil {
+		return "", nil, err
+	}
+	now := m.now().UTC()
+	if t == nil || t.RevokedAt != nil || !now.Before(t.ExpiresAt) {
+		return "", nil, ErrInvalid
+	}
+	if t.UsedAt != nil {
+		return "", nil, m.reused(ctx, t, now)
+	}
+	// MarkUsed is a compare-and-set, so of two concurrent refreshes with
+	// the same token only one wins; the loser is treated as a replay.
+	ok, err := m.store.MarkUsed(ctx, t.ID, now)
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		return "", nil, m.reused(ctx, t, now)
+	}
+	next, nt, err := m.issue(ctx, t.UserID, t.FamilyID)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := m.touch(ctx, nt.FamilyID, client, nt.ExpiresAt); err != nil {
+		return "", nil, err
+	}
+	return next, nt, nil
+}
+
+func (m *Manager) reused(ctx context.Context, t *RefreshToken, now time.Time) error {
+	if err := m.store.RevokeFamily(ctx, t.FamilyID, now); err != nil {
+		return err
+	}
+	return ErrReused
+}
+
+func (m *Manager) issue(ctx context.Context, userID, familyID primitive.ObjectID) (string, *RefreshToken, error) {
+	raw, err := newRaw()
+	if err != nil {
+		return "", nil, err
+	}
+	now := m.now().UTC()
+	t := &RefreshToken{
+		ID:        primitive.NewObjectID(),
+		Hash:      Hash(raw),
+		FamilyID:  familyID,
+		UserID:    userID,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(m.ttl),
+	}
+	if err := m.store.Create(ctx, t); err != nil {
+		return "", nil, err
+	}
+	return raw, t, nil
+}
+
+// Hash is the lookup key stored for a raw token.
+func Hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRaw() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,87 @@
+package user
+
+import (
+	"context"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Authenticator checks the email and password of a login. It returns
+// (nil, nil) when they are not good for any user it knows of, so that the
+// next authenticator of a Chain gets its turn.
+type Authenticator interface {
+	Authenticate(ctx context.Context, email, password string) (*User, error)
+}
+
+// authenticator checks the credentials of Login and of everything else
+// that asks for a password. RegisterHandlers sets it.
+var authenticator Authenticator
+
+// Authenticate returns the user email and password belong to, or nil if
+// they belong to nobody.
+func Authenticate(ctx context.Context, email, password string) (*User, error) {
+	return authenticator.Authenticate(ctx, email, password)
+}
+
+// Chain asks its authenticators in order and takes the first user one of
+// them returns. An authenticator that fails does not stop the ones after
+// it, so an unreachable directory does not lock out local users; its
+// error is returned only when nobody else knows the user either.
+type Chain []Authenticator
+
+// Verify Interface Compliance
+var _ Authenticator = Chain(nil)
+
+func (ch Chain) Authenticate(ctx context.Context, email, password string) (*User, error) {
+	var failed error
+	for _, a := range ch {
+		u, err := a.Authenticate(ctx, email, password)
+		if err != nil {
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+		if u != nil {
+			return u, nil
+		}
+	}
+	return nil, failed
+}
+
+// PasswordAuthenticator checks the password stored with the user.
+type PasswordAuthenticator struct {
+	store Store
+}
+
+// Verify Interface Compliance
+var _ Authenticator = (*PasswordAuthenticator)(nil)
+
+func NewPasswordAuthenticator(s Store) *PasswordAuthenticator {
+	return &PasswordAuthenticator{store: s}
+}
+
+func (pa *PasswordAuthenticator) Authenticate(ctx context.Context, email, password string) (*User, error) {
+	u, err := pa.store.GetByEmail(ctx, email)
+	if err != nil || u == nil {
+		return nil, err
+	}
+	if !u.CheckPassword(password) {
+		return nil, nil
+	}
+	return u, nil
+}
+
+// SecondFactor is a step users who enrolled in it take after their
+// password, such as a one-time code.
+type SecondFactor interface {
+	// Required reports whether u has to take the step.
+	Required(ctx context.Context, u *User) (bool, error)
+	// Challenge answers a login that got u's password right with what
+	// the client needs to take the second step.
+	Challenge(c echo.Context, u *User) error
+}
+
+// secondFactor holds back the logins of users who enrolled in it; nil
+// means there is no second step. RegisterHandlers sets it.
+var secondFactor SecondFactor
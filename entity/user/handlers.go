@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/entity/token"
 	"github.com/hamed-lohi/user-manage/identity"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,13 +12,14 @@ import (
 
 // Login godoc
 // @Summary Login for existing user
-// @Description Login for existing user
+// @Description Login for existing user. Users with two-factor authentication get 202 and a challenge to finish the login with at /users/login/mfa.
 // @ID login
 // @Tags user
 // @Accept  json
 // @Produce  json
 // @Param user body userLoginRequest true "Credentials to use"
 // @Success 200 {object} userResponse
+// @Success 202 {object} mfa.challengeResponse
 // @Failure 400 {object} customerror.Error
 // @Failure 401 {object} customerror.Error
 // @Failure 422 {object} customerror.Error
@@ -29,29 +31,100 @@ func Login(c echo.Context) error {
 	if err := req.bind(c); err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
 	}
-	u, err := store.GetByEmail(req.User.Email)
+	ctx := c.Request().Context()
+	u, err := Authenticate(ctx, req.User.Email, req.User.Password)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
 	}
 	if u == nil {
 		return c.JSON(http.StatusForbidden, customerror.AccessForbidden())
 	}
-	if !u.CheckPassword(req.User.Password) {
-		return c.JSON(http.StatusForbidden, customerror.AccessForbidden())
+	return ContinueLogin(c, u)
+}
+
+// ContinueLogin answers a request that proved u's first factor, such as
+// a password or an upstream sign-in: users who enrolled in a second
+// factor get its challenge, everyone else a new login.
+func ContinueLogin(c echo.Context, u *User) error {
+	if secondFactor != nil {
+		required, err := secondFactor.Required(c.Request().Context(), u)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+		}
+		if required {
+			return secondFactor.Challenge(c, u)
+		}
 	}
+	return CompleteLogin(c, u)
+}
 
-	result := echo.Map{
-		"id":       u.ID,
-		"username": u.Username,
-		"email":    u.Email,
-		"bio":      u.Bio,
-		"roles":    u.Roles,
-		"token":    identity.GenerateJWT(u.ID, u.Roles),
+// CompleteLogin answers a request that authenticated u, whichever way it
+// did, with a new login: the same response Login gives.
+func CompleteLogin(c echo.Context, u *User) error {
+	result, err := newAuthResponse(c, u)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
 	}
 
 	return c.JSON(http.StatusOK, result) // newUserResponse(u, true)
 }
 
+// Logout godoc
+// @Summary Log out
+// @Description Revoke the access token of this request and end its session. A refresh token passed in the body ends the session it belongs to as well.
+// @ID logout
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Param token body logoutRequest false "Refresh token of this login"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/logout [post]
+func Logout(c echo.Context) error {
+	req := &logoutRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	ctx := c.Request().Context()
+	claims := identity.ClaimsFrom(c)
+	if err := refresh.RevokeAccess(ctx, claims.Id, claims.ExpiresTime()); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if sid, err := primitive.ObjectIDFromHex(sessionIDFromToken(c)); err == nil {
+		err = refresh.RevokeSession(ctx, userIDFromToken(c), sid)
+		if err != nil && err != token.ErrSessionNotFound {
+			return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+		}
+	}
+	if req.RefreshToken != "" {
+		if err := refresh.RevokeFamily(ctx, userIDFromToken(c), req.RefreshToken); err != nil {
+			return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+		}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// LogoutAll godoc
+// @Summary Log out everywhere
+// @Description Revoke every access and refresh token issued to the current user so far.
+// @ID logout-all
+// @Tags user
+// @Produce  json
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/logout/all [post]
+func LogoutAll(c echo.Context) error {
+	if err := refresh.RevokeUser(c.Request().Context(), userIDFromToken(c)); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
 // SignUp godoc
 // @Summary Register a new user
 // @Description Register a new user
@@ -72,22 +145,61 @@ func SignUp(c echo.Context) error {
 		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
 	}
 	u.Roles = []identity.Role{identity.Guest}
-	if err := store.Create(&u); err != nil {
+	if err := store.Create(c.Request().Context(), &u); err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
 	}
 
-	result := echo.Map{
-		"id":       u.ID,
-		"username": u.Username,
-		"email":    u.Email,
-		"bio":      u.Bio,
-		"roles":    u.Roles,
-		"token":    identity.GenerateJWT(u.ID, u.Roles),
+	result, err := newAuthResponse(c, &u)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
 	}
 
 	return c.JSON(http.StatusCreated, result) // newUserResponse(&u, true)
 }
 
+// RefreshToken godoc
+// @Summary Refresh the access token
+// @Description Exchange a refresh token for a new access token and a new refresh token. Each refresh token works once; replaying one revokes every token of that login.
+// @ID refresh-token
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Param token body tokenRefreshRequest true "Refresh token from login, sign-up or the previous refresh"
+// @Success 200 {object} tokenResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 422 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Router /users/token/refresh [post]
+func RefreshToken(c echo.Context) error {
+	req := &tokenRefreshRequest{}
+	if err := req.bind(c); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	}
+	ctx := c.Request().Context()
+	raw, t, err := refresh.Rotate(ctx, req.RefreshToken, clientOf(c))
+	switch err {
+	case nil:
+	case token.ErrInvalid, token.ErrReused:
+		return c.JSON(http.StatusUnauthorized, customerror.NewError(err))
+	default:
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+
+	u, err := store.GetByID(ctx, t.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if u == nil {
+		return c.JSON(http.StatusUnauthorized, customerror.NewError(token.ErrInvalid))
+	}
+
+	res, err := newTokenResponse(u, raw, t)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
 // SignUp godoc
 // @Summary Add new user
 // @Description Add new user
@@ -108,7 +220,14 @@ func InsertUser(c echo.Context) error {
 	if err := req.bind(c, &u); err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
 	}
-	if err := store.Create(&u); err != nil {
+	switch err := checkGrantable(c, u.Roles); err {
+	case nil:
+	case ErrRoleNotFound, ErrNotGrantable:
+		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
+	default:
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if err := store.Create(c.Request().Context(), &u); err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
 	}
 
@@ -135,12 +254,13 @@ func InsertUser(c echo.Context) error {
 // @Failure 400 {object} customerror.Error
 // @Failure 401 {object} customerror.Error
 // @Failure 422 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
 // @Failure 404 {object} customerror.Error
 // @Failure 500 {object} customerror.Error
 // @Security ApiKeyAuth
 // @Router /user [put]
 func UpdateProfile(c echo.Context) error {
-	u, err := store.GetByID(userIDFromToken(c))
+	u, err := store.GetByID(c.Request().Context(), userIDFromToken(c))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
 	}
@@ -149,8 +269,8 @@ func UpdateProfile(c echo.Context) error {
 }
 
 // UpdateUser godoc
-// @Summary Update current user
-// @Description Update user information for current user
+// @Summary Update a user
+// @Description Update any user's information. Access is decided by policy: holders of users:update may edit anyone but admins, moderators may edit guests and members of their own org, and only admins may edit admins.
 // @ID update-user
 // @Tags user
 // @Accept  json
@@ -161,6 +281,7 @@ func UpdateProfile(c echo.Context) error {
 // @Failure 400 {object} customerror.Error
 // @Failure 401 {object} customerror.Error
 // @Failure 422 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
 // @Failure 404 {object} customerror.Error
 // @Failure 500 {object} customerror.Error
 // @Security ApiKeyAuth
@@ -171,7 +292,7 @@ func UpdateUser(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
 	}
-	u, err := store.GetByID(objId)
+	u, err := store.GetByID(c.Request().Context(), objId)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
 	}
@@ -184,14 +305,28 @@ func updateUser(c echo.Context, u *User) error {
 	if u == nil {
 		return c.JSON(http.StatusNotFound, customerror.NotFound())
 	}
+	if done, err := RequireAccess(c, identity.UsersUpdate, u); done {
+		return err
+	}
+	password, roles, org := u.Password, u.Roles, u.Org
 	req := newUserUpdateRequest()
 	req.populate(u)
 	if err := req.bind(c, u); err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
 	}
-	if err := store.Update(u); err != nil {
+	if u.Org != org && !identity.HasPermission(c, identity.UsersUpdate) {
+		return c.JSON(http.StatusForbidden, customerror.NewError(ErrOrgChange))
+	}
+	if err := store.Update(c.Request().Context(), u); err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
 	}
+	// Tokens carry the roles they were issued with and were obtained with
+	// the old password, so either change invalidates them.
+	if u.Password != password || !sameRoles(u.Roles, roles) {
+		if err := refresh.RevokeUser(c.Request().Context(), u.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+		}
+	}
 
 	// result := echo.Map{
 	// 	"id":       u.ID,
@@ -206,7 +341,7 @@ func updateUser(c echo.Context, u *User) error {
 
 // DeleteUser godoc
 // @Summary Delete a user
-// @Description Delete a user
+// @Description Delete a user. Requires users:delete; only admins may delete admins.
 // @ID delete-user
 // @Tags user
 // @Accept  json
@@ -214,6 +349,7 @@ func updateUser(c echo.Context, u *User) error {
 // @Param        id   path      string  true  "User ID"
 // @Success 201 {object} userResponse
 // @Failure 400 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
 // @Failure 404 {object} customerror.Error
 // @Failure 500 {object} customerror.Error
 // @Security ApiKeyAuth
@@ -221,12 +357,24 @@ func updateUser(c echo.Context, u *User) error {
 func DeleteUser(c echo.Context) error {
 	//id := []byte(c.Param("id"))
 	objId, _ := primitive.ObjectIDFromHex(c.Param("id"))
-	var u User
-	if err := store.Delete(objId); err != nil {
+	u, err := store.GetByID(c.Request().Context(), objId)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if u == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if done, err := RequireAccess(c, identity.UsersDelete, u); done {
+		return err
+	}
+	if err := store.Delete(c.Request().Context(), objId); err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, customerror.NewError(err))
 	}
+	if err := refresh.RevokeUser(c.Request().Context(), objId); err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
 
-	return c.JSON(http.StatusOK, newUserResponse(&u, false))
+	return c.JSON(http.StatusOK, newUserResponse(u, false))
 }
 
 // CurrentUser godoc
@@ -246,7 +394,7 @@ func DeleteUser(c echo.Context) error {
 // @Router /user/info [get]
 func CurrentUser(c echo.Context) error {
 
-	u, err := store.GetByID(userIDFromToken(c))
+	u, err := store.GetByID(c.Request().Context(), userIDFromToken(c))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
 	}
@@ -273,7 +421,7 @@ func CurrentUser(c echo.Context) error {
 // @Security ApiKeyAuth
 // @Router /user [get]
 func ListUser(c echo.Context) error {
-	users, err := store.GetUserList()
+	users, err := store.GetUserList(c.Request().Context())
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
 	}
@@ -290,6 +438,7 @@ func ListUser(c echo.Context) error {
 		cr.ID = i.ID
 		cr.Bio = i.Bio
 		cr.Roles = i.Roles
+		cr.Org = i.Org
 
 		r.Users = append(r.Users, cr)
 	}
@@ -306,3 +455,17 @@ func userIDFromToken(c echo.Context) primitive.ObjectID {
 	}
 	return objId
 }
+
+func sessionIDFromToken(c echo.Context) string {
+	if claims := identity.ClaimsFrom(c); claims != nil {
+		return claims.SessionID
+	}
+	return ""
+}
+
+func clientOf(c echo.Context) token.Client {
+	return token.Client{
+		UserAgent: c.Request().UserAgent(),
+		IP:        c.RealIP(),
+	}
+}
@@ -0,0 +1,166 @@
+package user
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps users in process memory. It is meant for tests and
+// for running the API without a database; nothing survives a restart.
+// Operations never block, so contexts are only checked for cancellation.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	order []primitive.ObjectID
+	users map[primitive.ObjectID]User
+}
+
+// Verify Interface Compliance
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: make(map[primitive.ObjectID]User),
+	}
+}
+
+func (ms *MemoryStore) GetUserList(ctx context.Context) (*[]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	users := make([]User, 0, len(ms.order))
+	for _, id := range ms.order {
+		users = append(users, copyUser(ms.users[id]))
+	}
+	return &users, nil
+}
+
+func (ms *MemoryStore) GetByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	u, ok := ms.users[id]
+	if !ok {
+		return nil, nil
+	}
+	c := copyUser(u)
+	return &c, nil
+}
+
+func (ms *MemoryStore) GetByEmail(ctx context.Context, e string) (*User, error) {
+	return ms.find(ctx, func(u *User) bool { return strings.EqualFold(u.Email, e) })
+}
+
+func (ms *MemoryStore) GetByUsername(ctx context.Context, username string) (*User, error) {
+	return ms.find(ctx, func(u *User) bool { return strings.EqualFold(u.Username, username) })
+}
+
+func (ms *MemoryStore) find(ctx context.Context, match func(u *User) bool) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, id := range ms.order {
+		u := ms.users[id]
+		if match(&u) {
+			c := copyUser(u)
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (ms *MemoryStore) Create(ctx context.Context, u *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.conflicts(u) {
+		return ErrDuplicate
+	}
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	if _, ok := ms.users[u.ID]; !ok {
+		ms.order = append(ms.order, u.ID)
+	}
+	ms.users[u.ID] = copyUser(*u)
+	return nil
+}
+
+func (ms *MemoryStore) Update(ctx context.Context, u *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	// Like ReplaceOne without upsert, updating a missing user is a no-op.
+	if _, ok := ms.users[u.ID]; !ok {
+		return nil
+	}
+	if ms.conflicts(u) {
+		return ErrDuplicate
+	}
+	ms.users[u.ID] = copyUser(*u)
+	return nil
+}
+
+// conflicts reports whether a user other than u already holds its email
+// or username. Callers must hold ms.mu.
+func (ms *MemoryStore) conflicts(u *User) bool {
+	for id, other := range ms.users {
+		if id == u.ID {
+			continue
+		}
+		if strings.EqualFold(other.Email, u.Email) || strings.EqualFold(other.Username, u.Username) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms *MemoryStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[id]; !ok {
+		return nil
+	}
+	delete(ms.users, id)
+	for i, oid := range ms.order {
+		if oid == id {
+			ms.order = append(ms.order[:i], ms.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// copyUser detaches the pointer and slice fields so callers cannot
+// mutate stored state behind the store's back.
+func copyUser(u User) User {
+	if u.Bio != nil {
+		b := *u.Bio
+		u.Bio = &b
+	}
+	if u.Roles != nil {
+		u.Roles = append(u.Roles[:0:0], u.Roles...)
+	}
+	return u
+}
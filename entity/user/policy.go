@@ -0,0 +1,72 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+)
+
+var ErrOrgChange = errors.New("moving a user to another org requires the users:update permission")
+
+// authorize asks identity.DefaultPolicy whether the requester may apply
+// action to u. The subject's roles come from the token, like its
+// permissions, and the org from the stored user, so a token does not
+// outlive an org change. The target's permissions are resolved from its
+// roles now.
+func authorize(c echo.Context, action identity.Permission, u *User) (bool, error) {
+	ctx := c.Request().Context()
+	s := identity.Subject{ID: userIDFromToken(c)}
+	s.Roles, _ = c.Get("roles").([]identity.Role)
+	s.Permissions, _ = c.Get("permissions").(map[identity.Permission]bool)
+	s.Scopes = identity.ScopesFrom(c)
+	me, err := store.GetByID(ctx, s.ID)
+	if err != nil {
+		return false, err
+	}
+	if me != nil {
+		s.Org = me.Org
+	}
+	r, err := resourceOf(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	d := identity.DefaultPolicy.Evaluate(identity.Request{
+		Subject:  s,
+		Action:   action,
+		Resource: r,
+	})
+	return d.Allowed, nil
+}
+
+func resourceOf(ctx context.Context, u *User) (identity.Resource, error) {
+	perms, err := identity.Roles.Permissions(ctx, u.Roles)
+	if err != nil {
+		return identity.Resource{}, err
+	}
+	return identity.Resource{
+		Kind:        "users",
+		ID:          u.ID,
+		Owner:       u.ID,
+		Org:         u.Org,
+		Roles:       u.Roles,
+		Permissions: perms,
+	}, nil
+}
+
+// RequireAccess answers the request with 403 or 500 unless the requester
+// may apply action to u; done reports whether it did. Other packages
+// acting on a user call it after loading the user.
+func RequireAccess(c echo.Context, action identity.Permission, u *User) (done bool, err error) {
+	ok, err := authorize(c, action, u)
+	if err != nil {
+		return true, c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if !ok {
+		return true, c.JSON(http.StatusForbidden, customerror.AccessForbidden())
+	}
+	return false, nil
+}
@@ -11,6 +11,7 @@ type userUpdateRequest struct {
 		Email    string `json:"email" validate:"email"`
 		Password string `json:"password"`
 		Bio      string `json:"bio"`
+		Org      string `json:"org"`
 		//Image    string `json:"image"`
 	} `json:"user"`
 }
@@ -23,6 +24,7 @@ func (r *userUpdateRequest) populate(u *User) {
 	r.User.Username = u.Username
 	r.User.Email = u.Email
 	r.User.Password = u.Password
+	r.User.Org = u.Org
 	if u.Bio != nil {
 		r.User.Bio = *u.Bio
 	}
@@ -48,6 +50,7 @@ func (r *userUpdateRequest) bind(c echo.Context, u *User) error {
 		u.Password = h
 	}
 	u.Bio = &r.User.Bio
+	u.Org = r.User.Org
 	//u.Image = &r.User.Image
 	return nil
 }
@@ -84,6 +87,7 @@ type userInsertRequest struct {
 		Password string          `json:"password" validate:"required"`
 		Bio      *string         `json:"bio"`
 		Roles    []identity.Role `json:"roles"`
+		Org      string          `json:"org"`
 	} `json:"user"`
 }
 
@@ -98,6 +102,7 @@ func (r *userInsertRequest) bind(c echo.Context, u *User) error {
 	u.Email = r.User.Email
 	u.Bio = r.User.Bio
 	u.Roles = r.User.Roles
+	u.Org = r.User.Org
 	h, err := u.HashPassword(r.User.Password)
 	if err != nil {
 		return err
@@ -122,3 +127,25 @@ func (r *userLoginRequest) bind(c echo.Context) error {
 	}
 	return nil
 }
+
+type tokenRefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+func (r *tokenRefreshRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := c.Validate(r); err != nil {
+		return err
+	}
+	return nil
+}
+
+type logoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (r *logoutRequest) bind(c echo.Context) error {
+	return c.Bind(r)
+}
@@ -1,7 +1,9 @@
 package user
 
 import (
+	"github.com/hamed-lohi/user-manage/entity/token"
 	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -13,6 +15,7 @@ type userResponse struct {
 		Bio      *string            `json:"bio"`
 		Image    *string            `json:"image"`
 		Roles    []identity.Role    `json:"roles"`
+		Org      string             `json:"org"`
 		Token    string             `json:"token"`
 	} `json:"user"`
 }
@@ -24,10 +27,11 @@ func newUserResponse(u *User, hasToken bool) *userResponse {
 	r.User.Email = u.Email
 	r.User.Bio = u.Bio
 	r.User.Roles = u.Roles
+	r.User.Org = u.Org
 
 	//r.User.Image = u.Image
 	if hasToken {
-		r.User.Token = identity.GenerateJWT(u.ID, u.Roles)
+		r.User.Token = identity.GenerateJWT(u.ID, u.Roles, "")
 	}
 
 	return r
@@ -61,3 +65,66 @@ func newUserListResponse(users []User) *userListResponse {
 	}
 	return r
 }
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn is the access token lifetime in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// newTokenResponse fails with identity.ErrNoSigningKey when no key is
+// active to sign the access token.
+func newTokenResponse(u *User, refreshToken string, t *token.RefreshToken) (*tokenResponse, error) {
+	jwt := identity.GenerateJWT(u.ID, u.Roles, t.FamilyID.Hex())
+	if jwt == "" {
+		return nil, identity.ErrNoSigningKey
+	}
+	return &tokenResponse{
+		Token:        jwt,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(identity.TokenLifetime.Seconds()),
+	}, nil
+}
+
+// newAuthResponse is the body of a successful login or sign-up: the user
+// plus an access token and the refresh token of a new session.
+func newAuthResponse(c echo.Context, u *User) (echo.Map, error) {
+	raw, rt, err := refresh.Issue(c.Request().Context(), u.ID, clientOf(c))
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTokenResponse(u, raw, rt)
+	if err != nil {
+		return nil, err
+	}
+	return echo.Map{
+		"id":            u.ID,
+		"username":      u.Username,
+		"email":         u.Email,
+		"bio":           u.Bio,
+		"roles":         u.Roles,
+		"token":         t.Token,
+		"refresh_token": t.RefreshToken,
+		"expires_in":    t.ExpiresIn,
+	}, nil
+}
+
+type sessionResponse struct {
+	token.Session
+	// Current marks the session of the requesting access token.
+	Current bool `json:"current"`
+}
+
+type sessionListResponse struct {
+	Sessions []sessionResponse `json:"sessions"`
+}
+
+func newSessionListResponse(sessions []token.Session, current string) *sessionListResponse {
+	r := new(sessionListResponse)
+	r.Sessions = make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		r.Sessions = append(r.Sessions, sessionResponse{Session: s, Current: s.ID.Hex() == current})
+	}
+	return r
+}
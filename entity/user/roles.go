@@ -0,0 +1,147 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrNotGrantable = errors.New("a role can only be granted or removed by someone holding all of its permissions")
+)
+
+// AssignRole godoc
+// @Summary Assign a role to a user
+// @Description Grant a role to a user. The caller must hold every permission of the role. The user's tokens are revoked so the change applies at the next login.
+// @ID assign-role
+// @Tags role
+// @Produce  json
+// @Param        id   path      string  true  "User ID"
+// @Param        role   path      int  true  "Role ID"
+// @Success 200 {object} userResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/{id}/roles/{role} [put]
+func AssignRole(c echo.Context) error {
+	return changeRole(c, func(u *User, r identity.Role) bool {
+		if hasRole(u.Roles, r) {
+			return false
+		}
+		u.Roles = append(u.Roles, r)
+		return true
+	})
+}
+
+// RemoveRole godoc
+// @Summary Remove a role from a user
+// @Description Take a role away from a user. The caller must hold every permission of the role. The user's tokens are revoked.
+// @ID remove-role
+// @Tags role
+// @Produce  json
+// @Param        id   path      string  true  "User ID"
+// @Param        role   path      int  true  "Role ID"
+// @Success 200 {object} userResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 403 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/{id}/roles/{role} [delete]
+func RemoveRole(c echo.Context) error {
+	return changeRole(c, func(u *User, r identity.Role) bool {
+		kept := u.Roles[:0:0]
+		for _, have := range u.Roles {
+			if have != r {
+				kept = append(kept, have)
+			}
+		}
+		changed := len(kept) != len(u.Roles)
+		u.Roles = kept
+		return changed
+	})
+}
+
+// changeRole loads the user and role of the path, applies change and, if
+// it reports a change, saves the user and revokes their tokens, which
+// carry the old roles.
+func changeRole(c echo.Context, change func(u *User, r identity.Role) bool) error {
+	ctx := c.Request().Context()
+	objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	id, err := strconv.ParseUint(c.Param("role"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, customerror.NewError(ErrRoleNotFound))
+	}
+	r := identity.Role(id)
+	switch err := checkGrantable(c, []identity.Role{r}); err {
+	case nil:
+	case ErrRoleNotFound:
+		return c.JSON(http.StatusNotFound, customerror.NewError(err))
+	case ErrNotGrantable:
+		return c.JSON(http.StatusForbidden, customerror.NewError(err))
+	default:
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+
+	u, err := store.GetByID(ctx, objId)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if u == nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	if change(u, r) {
+		if err := store.Update(ctx, u); err != nil {
+			return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+		}
+		if err := refresh.RevokeUser(ctx, u.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+		}
+	}
+	return c.JSON(http.StatusOK, newUserResponse(u, false))
+}
+
+// checkGrantable returns ErrRoleNotFound if one of ids is not a stored
+// role and ErrNotGrantable if they grant a permission the caller lacks.
+func checkGrantable(c echo.Context, ids []identity.Role) error {
+	ctx := c.Request().Context()
+	for _, id := range ids {
+		r, err := roles.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return ErrRoleNotFound
+		}
+	}
+	perms, err := identity.Roles.Permissions(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for p := range perms {
+		if !identity.HasPermission(c, p) {
+			return ErrNotGrantable
+		}
+	}
+	return nil
+}
+
+func hasRole(roles []identity.Role, r identity.Role) bool {
+	for _, have := range roles {
+		if have == r {
+			return true
+		}
+	}
+	return false
+}
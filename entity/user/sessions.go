@@ -0,0 +1,108 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/hamed-lohi/user-manage/entity/token"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListSessions godoc
+// @Summary List own sessions
+// @Description List the active sessions of the current user. The session of this request is marked current.
+// @ID list-sessions
+// @Tags session
+// @Produce  json
+// @Success 200 {object} sessionListResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/sessions [get]
+func ListSessions(c echo.Context) error {
+	return listSessions(c, userIDFromToken(c))
+}
+
+// RevokeSession godoc
+// @Summary Revoke an own session
+// @Description End one session of the current user; its refresh and access tokens stop working.
+// @ID revoke-session
+// @Tags session
+// @Produce  json
+// @Param        sid   path      string  true  "Session ID"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/sessions/{sid} [delete]
+func RevokeSession(c echo.Context) error {
+	return revokeSession(c, userIDFromToken(c))
+}
+
+// ListUserSessions godoc
+// @Summary List a user's sessions
+// @Description List the active sessions of any user
+// @ID list-user-sessions
+// @Tags session
+// @Produce  json
+// @Param        id   path      string  true  "User ID"
+// @Success 200 {object} sessionListResponse
+// @Failure 401 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/{id}/sessions [get]
+func ListUserSessions(c echo.Context) error {
+	objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	return listSessions(c, objId)
+}
+
+// RevokeUserSession godoc
+// @Summary Revoke a user's session
+// @Description End one session of any user
+// @ID revoke-user-session
+// @Tags session
+// @Produce  json
+// @Param        id   path      string  true  "User ID"
+// @Param        sid   path      string  true  "Session ID"
+// @Success 204
+// @Failure 401 {object} customerror.Error
+// @Failure 404 {object} customerror.Error
+// @Failure 500 {object} customerror.Error
+// @Security ApiKeyAuth
+// @Router /user/{id}/sessions/{sid} [delete]
+func RevokeUserSession(c echo.Context) error {
+	objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	return revokeSession(c, objId)
+}
+
+func listSessions(c echo.Context, userID primitive.ObjectID) error {
+	sessions, err := refresh.Sessions(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	return c.JSON(http.StatusOK, newSessionListResponse(sessions, sessionIDFromToken(c)))
+}
+
+func revokeSession(c echo.Context, userID primitive.ObjectID) error {
+	sid, err := primitive.ObjectIDFromHex(c.Param("sid"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	}
+	switch err := refresh.RevokeSession(c.Request().Context(), userID, sid); err {
+	case nil:
+		return c.NoContent(http.StatusNoContent)
+	case token.ErrSessionNotFound:
+		return c.JSON(http.StatusNotFound, customerror.NotFound())
+	default:
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+}
@@ -0,0 +1,181 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/hamed-lohi/user-manage/db"
+	"github.com/hamed-lohi/user-manage/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlSchema is shared by SQLite and PostgreSQL. IDs keep the ObjectID hex
+// form so they look the same whichever backend issued them, and the
+// unique indexes on lower() make email/username case-insensitive.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id       TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		email    TEXT NOT NULL,
+		password TEXT NOT NULL,
+		bio      TEXT,
+		roles    TEXT NOT NULL DEFAULT '[]',
+		org      TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (lower(email))`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON users (lower(username))`,
+}
+
+const userColumns = `id, username, email, password, bio, roles, org`
+
+type SQLStore struct {
+	dbProvider *db.DBProvider
+	db         *sql.DB
+}
+
+// Verify Interface Compliance
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore creates the users schema if it does not exist yet and adds
+// the columns introduced since.
+func NewSQLStore(dp *db.DBProvider) (*SQLStore, error) {
+	for _, stmt := range sqlSchema {
+		if _, err := dp.SQL.ExecContext(dp.Context, stmt); err != nil {
+			return nil, err
+		}
+	}
+	if err := dp.EnsureColumn(dp.Context, db.Users, "org", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return nil, err
+	}
+	return &SQLStore{
+		dbProvider: dp,
+		db:         dp.SQL,
+	}, nil
+}
+
+func (us *SQLStore) GetUserList(ctx context.Context) (*[]User, error) {
+	ctx, cancel := us.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := us.db.QueryContext(ctx,
+		`SELECT `+userColumns+` FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &users, nil
+}
+
+func (us *SQLStore) GetByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	return us.findOne(ctx, `id = $1`, id.Hex())
+}
+
+func (us *SQLStore) GetByEmail(ctx context.Context, e string) (*User, error) {
+	return us.findOne(ctx, `lower(email) = lower($1)`, e)
+}
+
+func (us *SQLStore) GetByUsername(ctx context.Context, username string) (*User, error) {
+	return us.findOne(ctx, `lower(username) = lower($1)`, username)
+}
+
+func (us *SQLStore) findOne(ctx context.Context, where string, arg interface{}) (*User, error) {
+	ctx, cancel := us.dbProvider.ReadContext(ctx)
+	defer cancel()
+
+	row := us.db.QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE `+where, arg)
+	u, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return u, err
+}
+
+func (us *SQLStore) Create(ctx context.Context, u *User) error {
+	ctx, cancel := us.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	roles, err := json.Marshal(u.Roles)
+	if err != nil {
+		return err
+	}
+	_, err = us.db.ExecContext(ctx,
+		`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		u.ID.Hex(), u.Username, u.Email, u.Password, u.Bio, string(roles), u.Org)
+	return translateSQLError(err)
+}
+
+func (us *SQLStore) Update(ctx context.Context, u *User) error {
+	ctx, cancel := us.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	roles, err := json.Marshal(u.Roles)
+	if err != nil {
+		return err
+	}
+	_, err = us.db.ExecContext(ctx,
+		`UPDATE users SET username = $2, email = $3, password = $4, bio = $5, roles = $6, org = $7 WHERE id = $1`,
+		u.ID.Hex(), u.Username, u.Email, u.Password, u.Bio, string(roles), u.Org)
+	return translateSQLError(err)
+}
+
+func (us *SQLStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := us.dbProvider.WriteContext(ctx)
+	defer cancel()
+
+	_, err := us.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id.Hex())
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	var (
+		u     User
+		id    string
+		bio   sql.NullString
+		roles string
+	)
+	if err := row.Scan(&id, &u.Username, &u.Email, &u.Password, &bio, &roles, &u.Org); err != nil {
+		return nil, err
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	u.ID = oid
+	if bio.Valid {
+		u.Bio = &bio.String
+	}
+	var rs []identity.Role
+	if err := json.Unmarshal([]byte(roles), &rs); err != nil {
+		return nil, err
+	}
+	u.Roles = rs
+	return &u, nil
+}
+
+func translateSQLError(err error) error {
+	if db.IsUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
@@ -1,42 +1,79 @@
 package user
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/hamed-lohi/user-manage/db"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type UserStore struct {
+// ErrDuplicate is returned by Create and Update when another user already
+// has the same email or username.
+var ErrDuplicate = errors.New("a user with this email or username already exists")
+
+// Store is the persistence contract for users.
+// Lookups return (nil, nil) when no user matches. Emails and usernames
+// are unique and compared case-insensitively. Every method honours the
+// cancellation and deadline of ctx.
+type Store interface {
+	GetUserList(ctx context.Context) (*[]User, error)
+	GetByID(ctx context.Context, id primitive.ObjectID) (*User, error)
+	GetByEmail(ctx context.Context, e string) (*User, error)
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	Create(ctx context.Context, u *User) error
+	Update(ctx context.Context, u *User) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// NewStore returns the Store implementation for the provider's driver.
+func NewStore(dp *db.DBProvider) (Store, error) {
+	switch dp.Driver {
+	case db.Mongo:
+		return NewMongoStore(dp), nil
+	case db.SQLite, db.Postgres:
+		return NewSQLStore(dp)
+	case db.Memory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("user: unsupported driver %q", dp.Driver)
+}
+
+type MongoStore struct {
 	dbProvider *db.DBProvider
 	collection *mongo.Collection
 }
 
 // Verify Interface Compliance
-//var _ user.Store = (*UserStore)(nil)
+var _ Store = (*MongoStore)(nil)
 
-func NewStore(dp *db.DBProvider) *UserStore {
+func NewMongoStore(dp *db.DBProvider) *MongoStore {
 	//cli.Database("user_management_db").Collection("users")
-	return &UserStore{
+	return &MongoStore{
 		dbProvider: dp,
 		collection: dp.GetCollection(db.Users),
 	}
 }
 
-func (us *UserStore) GetUserList() (*[]User, error) {
+func (us *MongoStore) GetUserList(ctx context.Context) (*[]User, error) {
+	ctx, cancel := us.dbProvider.ReadContext(ctx)
+	defer cancel()
 
 	coll := us.collection
 	// coll := cli.GetCollection(db.Users)
 
 	var users []User
-	cursor, err := coll.Find(us.dbProvider.Context, bson.D{})
+	cursor, err := coll.Find(ctx, bson.D{})
 	if err != nil {
 		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	for cursor.Next(us.dbProvider.Context) {
+	for cursor.Next(ctx) {
 		//Create a value into which the single document can be decoded
 		var elem User
 		err := cursor.Decode(&elem)
@@ -57,51 +94,73 @@ func (us *UserStore) GetUserList() (*[]User, error) {
 	return &users, nil
 }
 
-func (us *UserStore) GetByID(id primitive.ObjectID) (*User, error) {
-	var m User
-	fmt.Println(id)
-	if err := us.collection.FindOne(us.dbProvider.Context, bson.D{{"_id", id}}).Decode(&m); err != nil {
-		return nil, err
-	}
-	return &m, nil
+func (us *MongoStore) GetByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	return us.findOne(ctx, bson.M{"_id": id})
 }
 
-func (us *UserStore) GetByEmail(e string) (*User, error) {
-	var m User
-	if err := us.collection.FindOne(us.dbProvider.Context, bson.D{{"email", e}}).Decode(&m); err != nil {
-		return nil, err
-	}
-	return &m, nil
+func (us *MongoStore) GetByEmail(ctx context.Context, e string) (*User, error) {
+	return us.findOne(ctx, bson.M{"email": e})
 }
 
-func (us *UserStore) GetByUsername(username string) (*User, error) {
+func (us *MongoStore) GetByUsername(ctx context.Context, username string) (*User, error) {
+	return us.findOne(ctx, bson.M{"username": username})
+}
+
+// findOne queries with the collation of the unique indexes, which makes
+// email/username matches case-insensitive and lets Mongo use the index.
+func (us *MongoStore) findOne(ctx context.Context, filter bson.M) (*User, error) {
+	ctx, cancel := us.dbProvider.ReadContext(ctx)
+	defer cancel()
+
 	var m User
-	if err := us.collection.FindOne(us.dbProvider.Context, bson.D{{"username", username}}).Decode(&m); err != nil {
+	opts := options.FindOne().SetCollation(db.CaseInsensitive)
+	if err := us.collection.FindOne(ctx, filter, opts).Decode(&m); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
 		return nil, err
 	}
 	return &m, nil
 }
 
-func (us *UserStore) Create(u *User) error {
+func (us *MongoStore) Create(ctx context.Context, u *User) error {
+	ctx, cancel := us.dbProvider.WriteContext(ctx)
+	defer cancel()
 
-	if _, err := us.collection.InsertOne(us.dbProvider.Context, u); err != nil {
-		return err
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	if _, err := us.collection.InsertOne(ctx, u); err != nil {
+		return translateMongoError(err)
 	}
 	return nil
 }
 
-func (us *UserStore) Update(u *User) error {
+func (us *MongoStore) Update(ctx context.Context, u *User) error {
+	ctx, cancel := us.dbProvider.WriteContext(ctx)
+	defer cancel()
+
 	filter := bson.M{"_id": u.ID}
-	if _, err := us.collection.ReplaceOne(us.dbProvider.Context, filter, u); err != nil {
-		return err
+	if _, err := us.collection.ReplaceOne(ctx, filter, u); err != nil {
+		return translateMongoError(err)
 	}
 	return nil
 }
 
-func (us *UserStore) Delete(id primitive.ObjectID) error {
+func (us *MongoStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := us.dbProvider.WriteContext(ctx)
+	defer cancel()
+
 	filter := bson.M{"_id": id}
-	if _, err := us.collection.DeleteOne(us.dbProvider.Context, filter); err != nil {
+	if _, err := us.collection.DeleteOne(ctx, filter); err != nil {
 		return err
 	}
 	return nil
 }
+
+func translateMongoError(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
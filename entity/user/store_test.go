@@ -0,0 +1,216 @@
+package user
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/hamed-lohi/user-manage/config"
+	"github.com/hamed-lohi/user-manage/db"
+	"github.com/hamed-lohi/user-manage/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// storeBackends opens an empty Store of every backend that runs without a
+// server.
+var storeBackends = map[string]func(t *testing.T) Store{
+	"memory": func(t *testing.T) Store {
+		return NewMemoryStore()
+	},
+	"sqlite": func(t *testing.T) Store {
+		dp := db.NewDBProvider(config.Database{
+			Driver: string(db.SQLite),
+			DSN:    "file:" + filepath.Join(t.TempDir(), "users.db"),
+		})
+		t.Cleanup(func() { dp.Close(context.Background()) })
+		s, err := NewSQLStore(dp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+}
+
+// TestStore runs the same behaviour suite against every backend, so that
+// they stay interchangeable.
+func TestStore(t *testing.T) {
+	for name, open := range storeBackends {
+		open := open
+		t.Run(name, func(t *testing.T) {
+			t.Run("CreateAndGet", func(t *testing.T) { testCreateAndGet(t, open(t)) })
+			t.Run("NotFound", func(t *testing.T) { testNotFound(t, open(t)) })
+			t.Run("Duplicates", func(t *testing.T) { testDuplicates(t, open(t)) })
+			t.Run("Update", func(t *testing.T) { testUpdate(t, open(t)) })
+			t.Run("ListAndDelete", func(t *testing.T) { testListAndDelete(t, open(t)) })
+			t.Run("Cancelled", func(t *testing.T) { testCancelled(t, open(t)) })
+		})
+	}
+}
+
+func newTestUser(name string) *User {
+	bio := "bio of " + name
+	return &User{
+		Username: name,
+		Email:    name + "@example.com",
+		Password: "hash",
+		Bio:      &bio,
+		Roles:    []identity.Role{identity.Member},
+		Org:      "acme",
+	}
+}
+
+func mustCreate(t *testing.T, s Store, u *User) {
+	t.Helper()
+	if err := s.Create(context.Background(), u); err != nil {
+		t.Fatalf("Create(%s): %v", u.Username, err)
+	}
+}
+
+func testCreateAndGet(t *testing.T, s Store) {
+	ctx := context.Background()
+	u := newTestUser("alice")
+	mustCreate(t, s, u)
+	if u.ID.IsZero() {
+		t.Fatal("Create did not assign an ID")
+	}
+
+	lookups := map[string]func() (*User, error){
+		"GetByID":       func() (*User, error) { return s.GetByID(ctx, u.ID) },
+		"GetByEmail":    func() (*User, error) { return s.GetByEmail(ctx, "ALICE@example.com") },
+		"GetByUsername": func() (*User, error) { return s.GetByUsername(ctx, "Alice") },
+	}
+	for name, get := range lookups {
+		got, err := get()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got == nil {
+			t.Fatalf("%s: user not found", name)
+		}
+		if got.ID != u.ID || got.Username != u.Username || got.Email != u.Email ||
+			got.Password != u.Password || got.Org != u.Org {
+			t.Errorf("%s = %+v, want %+v", name, got, u)
+		}
+		if got.Bio == nil || *got.Bio != *u.Bio {
+			t.Errorf("%s: bio = %v, want %q", name, got.Bio, *u.Bio)
+		}
+		if !sameRoles(got.Roles, u.Roles) {
+			t.Errorf("%s: roles = %v, want %v", name, got.Roles, u.Roles)
+		}
+	}
+
+	// Changing the returned user must not change the stored one.
+	got, _ := s.GetByID(ctx, u.ID)
+	*got.Bio = "changed"
+	got.Roles[0] = identity.Admin
+	again, _ := s.GetByID(ctx, u.ID)
+	if *again.Bio != *u.Bio || again.Roles[0] != identity.Member {
+		t.Errorf("stored user changed through a returned copy: %+v", again)
+	}
+}
+
+func testNotFound(t *testing.T, s Store) {
+	ctx := context.Background()
+	if u, err := s.GetByID(ctx, primitive.NewObjectID()); u != nil || err != nil {
+		t.Errorf("GetByID = %v, %v; want nil, nil", u, err)
+	}
+	if u, err := s.GetByEmail(ctx, "nobody@example.com"); u != nil || err != nil {
+		t.Errorf("GetByEmail = %v, %v; want nil, nil", u, err)
+	}
+	if u, err := s.GetByUsername(ctx, "nobody"); u != nil || err != nil {
+		t.Errorf("GetByUsername = %v, %v; want nil, nil", u, err)
+	}
+}
+
+func testDuplicates(t *testing.T, s Store) {
+	ctx := context.Background()
+	mustCreate(t, s, newTestUser("alice"))
+
+	sameEmail := newTestUser("alice2")
+	sameEmail.Email = "Alice@Example.com"
+	if err := s.Create(ctx, sameEmail); err != ErrDuplicate {
+		t.Errorf("Create with a taken email = %v, want ErrDuplicate", err)
+	}
+	sameName := newTestUser("ALICE")
+	sameName.Email = "other@example.com"
+	if err := s.Create(ctx, sameName); err != ErrDuplicate {
+		t.Errorf("Create with a taken username = %v, want ErrDuplicate", err)
+	}
+}
+
+func testUpdate(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice, bob := newTestUser("alice"), newTestUser("bob")
+	mustCreate(t, s, alice)
+	mustCreate(t, s, bob)
+
+	alice.Username = "alicia"
+	alice.Roles = []identity.Role{identity.Member, identity.Moderator}
+	alice.Bio = nil
+	if err := s.Update(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetByID(ctx, alice.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetByID = %v, %v", got, err)
+	}
+	if got.Username != "alicia" || got.Bio != nil || !sameRoles(got.Roles, alice.Roles) {
+		t.Errorf("after Update: %+v", got)
+	}
+	if u, _ := s.GetByUsername(ctx, "alice"); u != nil {
+		t.Error("the old username still finds the user")
+	}
+
+	bob.Email = "ALICE@example.com"
+	if err := s.Update(ctx, bob); err != ErrDuplicate {
+		t.Errorf("Update to a taken email = %v, want ErrDuplicate", err)
+	}
+	// A user may keep its own email and username.
+	if err := s.Update(ctx, alice); err != nil {
+		t.Errorf("Update without changes = %v", err)
+	}
+}
+
+func testListAndDelete(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice, bob := newTestUser("alice"), newTestUser("bob")
+	mustCreate(t, s, alice)
+	mustCreate(t, s, bob)
+
+	list, err := s.GetUserList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*list) != 2 {
+		t.Fatalf("GetUserList returned %d users, want 2", len(*list))
+	}
+
+	if err := s.Delete(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := s.GetByID(ctx, alice.ID); u != nil || err != nil {
+		t.Errorf("GetByID after Delete = %v, %v; want nil, nil", u, err)
+	}
+	if err := s.Delete(ctx, alice.ID); err != nil {
+		t.Errorf("Delete of a missing user = %v, want nil", err)
+	}
+	list, _ = s.GetUserList(ctx)
+	if len(*list) != 1 || (*list)[0].ID != bob.ID {
+		t.Errorf("GetUserList after Delete = %+v", *list)
+	}
+	// The deleted user's email and username are free again.
+	if err := s.Create(ctx, newTestUser("alice")); err != nil {
+		t.Errorf("Create after Delete = %v", err)
+	}
+}
+
+func testCancelled(t *testing.T, s Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.GetByID(ctx, primitive.NewObjectID()); err == nil {
+		t.Error("GetByID with a cancelled context succeeded")
+	}
+	if err := s.Create(ctx, newTestUser("alice")); err == nil {
+		t.Error("Create with a cancelled context succeeded")
+	}
+}
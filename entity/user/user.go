@@ -1,21 +1,23 @@
 package user
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"log"
-	"os/user"
 
-	"github.com/hamed-lohi/user-manage/db"
+	"github.com/hamed-lohi/user-manage/entity/role"
+	"github.com/hamed-lohi/user-manage/entity/token"
 	"github.com/hamed-lohi/user-manage/identity"
 	"github.com/labstack/echo/v4"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var store *UserStore
+var (
+	store   Store
+	roles   role.Store
+	refresh *token.Manager
+)
 
 type userList struct {
 	Users []User `json:"users"`
@@ -28,6 +30,9 @@ type User struct {
 	Password string             `bson:"password"`
 	Bio      *string            //`bson:"bio"`
 	Roles    []identity.Role    `bson:"roles,omitempty"`
+	// Org is the organisation the user belongs to; policies compare it
+	// between the acting user and the target.
+	Org string `bson:"org,omitempty"`
 	// Image      *string
 }
 
@@ -45,21 +50,43 @@ func (u *User) HashPassword(plain string) (string, error) {
 	return string(h), err
 }
 
+func sameRoles(a, b []identity.Role) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (u *User) CheckPassword(plain string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plain))
 	return err == nil
 }
 
-func RegisterHandlers(v1 *echo.Group, dp *db.DBProvider) {
-
-	store = NewStore(dp)
+// RegisterHandlers mounts the user routes. a checks login credentials;
+// nil means the password stored with the user. sf, if not nil, is asked
+// for after the password.
+func RegisterHandlers(v1 *echo.Group, auth echo.MiddlewareFunc, s Store, rs role.Store, rm *token.Manager, a Authenticator, sf SecondFactor) {
+
+	store = s
+	roles = rs
+	refresh = rm
+	authenticator = a
+	secondFactor = sf
+	if authenticator == nil {
+		authenticator = NewPasswordAuthenticator(s)
+	}
 
 	guestUsers := v1.Group("/users")
 	guestUsers.POST("", SignUp)
 	guestUsers.POST("/login", Login)
+	guestUsers.POST("/token/refresh", RefreshToken)
 
-	jwtMiddleware := identity.JWT(identity.JWTSecret)
-	user := v1.Group("/user", jwtMiddleware)
+	user := v1.Group("/user", auth)
 	// user.Use(middleware.JWTWithConfig(
 	// 	middleware.JWTConfig{
 	// 		Skipper: func(c echo.Context) bool {
@@ -71,42 +98,46 @@ func RegisterHandlers(v1 *echo.Group, dp *db.DBProvider) {
 	// 		SigningKey: utils.JWTSecret,
 	// 	},
 	// ))
-	user.GET("", ListUser, identity.CheckAccessByRole(identity.Admin))
-	user.POST("", InsertUser, identity.CheckAccessByRole(identity.Admin))
-	user.DELETE("/:id", DeleteUser, identity.CheckAccessByRole(identity.Admin))
+	user.GET("", ListUser, identity.RequirePermission(identity.UsersRead))
+	user.POST("", InsertUser, identity.RequirePermission(identity.UsersCreate))
+	// UpdateUser, DeleteUser and UpdateProfile decide per target user
+	// through identity.DefaultPolicy.
+	user.DELETE("/:id", DeleteUser)
 	user.PUT("", UpdateProfile)
-	user.PUT("/:id", UpdateUser, identity.CheckAccessByRole(identity.Admin))
+	user.PUT("/:id", UpdateUser)
 	user.GET("/info", CurrentUser)
+	// Logins and sessions are managed from a login, not with a personal
+	// access token.
+	user.POST("/logout", Logout, identity.RequireLogin())
+	user.POST("/logout/all", LogoutAll, identity.RequireLogin())
+	user.GET("/sessions", ListSessions, identity.RequireLogin())
+	user.DELETE("/sessions/:sid", RevokeSession, identity.RequireLogin())
+	user.GET("/:id/sessions", ListUserSessions, identity.RequirePermission(identity.SessionsRead))
+	user.DELETE("/:id/sessions/:sid", RevokeUserSession, identity.RequirePermission(identity.SessionsRevoke))
+	user.PUT("/:id/roles/:role", AssignRole, identity.RequirePermission(identity.RolesAssign))
+	user.DELETE("/:id/roles/:role", RemoveRole, identity.RequirePermission(identity.RolesAssign))
 }
 
-func Seed(dp *db.DBProvider) {
-
-	//defer cl.Dispose()
-	//coll := dp.GetCollection(Users)
+func Seed(ctx context.Context, s Store) {
 
-	var result user.User
-	err := store.collection.FindOne(dp.Context, bson.M{"username": "Admin"}).Decode(&result) // context.TODO()
+	result, err := s.GetByUsername(ctx, "Admin")
 	if err != nil {
-		// ErrNoDocuments means that the filter did not match any documents in
-		// the collection.
-		if err == mongo.ErrNoDocuments {
-			admin := &User{
-				//ID:       ,
-				Username: "Admin",
-				Email:    "admin@gmail.com",
-				//Password: "aaaa",
-				Bio:   new(string),
-				Roles: []identity.Role{identity.Admin},
-			}
-			admin.SetPassword("aaa")
-			store.collection.InsertOne(dp.Context, admin)
-			return
-		}
 		log.Fatal(err)
 	}
-	fmt.Printf("found document %v", result)
-
-	// if err := cl.Disconnect(context.TODO()); err != nil {
-	// 	panic(err)
-	// }
+	if result == nil {
+		admin := &User{
+			//ID:       ,
+			Username: "Admin",
+			Email:    "admin@gmail.com",
+			//Password: "aaaa",
+			Bio:   new(string),
+			Roles: []identity.Role{identity.Admin},
+		}
+		admin.SetPassword("aaa")
+		if err := s.Create(ctx, admin); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	log.Printf("seed: user %q already exists", result.Username)
 }
@@ -0,0 +1,118 @@
+// Package health serves the liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultTimeout bounds a check that does not set its own Timeout.
+const DefaultTimeout = 2 * time.Second
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is one readiness dependency.
+type Check struct {
+	Name    string
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+var (
+	started  = time.Now()
+	draining int32
+)
+
+// MarkDraining makes /readyz fail so load balancers stop routing new
+// traffic while the server shuts down. /healthz is unaffected.
+func MarkDraining() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+func RegisterHandlers(e *echo.Echo, checks ...Check) {
+	e.GET("/healthz", Liveness)
+	e.GET("/readyz", Readiness(checks...))
+}
+
+// Liveness reports that the process is up and serving HTTP. It never
+// touches dependencies, so a slow database does not get the pod killed.
+func Liveness(c echo.Context) error {
+	return c.JSON(http.StatusOK, echo.Map{
+		"status": StatusOK,
+		"uptime": time.Since(started).Round(time.Second).String(),
+	})
+}
+
+// Readiness runs every check concurrently and answers 503 unless all of
+// them pass.
+func Readiness(checks ...Check) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks)+1)}
+		if atomic.LoadInt32(&draining) == 1 {
+			report.Status = StatusFail
+			report.Checks["shutdown"] = CheckResult{Status: StatusFail, Error: "server is shutting down", Duration: "0s"}
+		}
+
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		for _, chk := range checks {
+			wg.Add(1)
+			go func(chk Check) {
+				defer wg.Done()
+				res := run(c.Request().Context(), chk)
+				mu.Lock()
+				defer mu.Unlock()
+				report.Checks[chk.Name] = res
+				if res.Status != StatusOK {
+					report.Status = StatusFail
+				}
+			}(chk)
+		}
+		wg.Wait()
+
+		code := http.StatusOK
+		if report.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		return c.JSON(code, report)
+	}
+}
+
+func run(ctx context.Context, chk Check) CheckResult {
+	timeout := chk.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := chk.Run(ctx)
+	res := CheckResult{Status: StatusOK, Duration: time.Since(start).String()}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,110 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Claims is the payload of an access token. Subject is the hex user ID,
+// Id the jti and SessionID the session the token was issued for. Tokens
+// issued to OAuth clients name the client and carry the granted scopes
+// as a space-separated list.
+type Claims struct {
+	jwt.StandardClaims
+	Roles     []Role `json:"roles"`
+	SessionID string `json:"sid,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+}
+
+// errClaims marks claims that are missing, malformed or meant for another
+// issuer or audience, as opposed to claims that are only expired or not
+// yet valid.
+type errClaims struct{ reason string }
+
+func (e *errClaims) Error() string { return "invalid jwt claims: " + e.reason }
+
+var errClaimsTime = errors.New("jwt is expired or not valid yet")
+
+// newClaims fills in every registered claim for a token issued now.
+func newClaims(id primitive.ObjectID, roles []Role, opts TokenOptions, now time.Time) *Claims {
+	return &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        primitive.NewObjectID().Hex(),
+			Subject:   id.Hex(),
+			Issuer:    Issuer,
+			Audience:  Audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(TokenLifetime).Unix(),
+		},
+		Roles:     roles,
+		SessionID: opts.SessionID,
+		ClientID:  opts.ClientID,
+		Scope:     strings.Join(opts.Scopes, " "),
+	}
+}
+
+// Valid always passes; the parser skips it and JWTWithConfig calls
+// validate instead, which needs the configured issuer, audience and
+// leeway.
+func (c *Claims) Valid() error { return nil }
+
+// validate checks the registered claims. Times get leeway in both
+// directions to absorb clock skew between issuer and verifier.
+func (c *Claims) validate(config JWTConfig, now time.Time) error {
+	if _, err := c.UserID(); err != nil {
+		return &errClaims{"sub is not a user id"}
+	}
+	if c.Id == "" {
+		return &errClaims{"jti is missing"}
+	}
+	if c.IssuedAt == 0 || c.ExpiresAt == 0 {
+		return &errClaims{"iat and exp are required"}
+	}
+	if c.Issuer != config.Issuer {
+		return &errClaims{fmt.Sprintf("unexpected issuer %q", c.Issuer)}
+	}
+	if c.Audience != config.Audience {
+		return &errClaims{fmt.Sprintf("unexpected audience %q", c.Audience)}
+	}
+	leeway := int64(config.Leeway / time.Second)
+	t := now.Unix()
+	if t > c.ExpiresAt+leeway || t+leeway < c.NotBefore || t+leeway < c.IssuedAt {
+		return errClaimsTime
+	}
+	return nil
+}
+
+// UserID is the subject as an ObjectID.
+func (c *Claims) UserID() (primitive.ObjectID, error) {
+	return primitive.ObjectIDFromHex(c.Subject)
+}
+
+// IssuedTime is iat as a time.Time.
+func (c *Claims) IssuedTime() time.Time {
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// ExpiresTime is exp as a time.Time.
+func (c *Claims) ExpiresTime() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// Scopes splits the scope claim.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// ClaimsFrom returns the claims the JWT middleware stored for the
+// request, or nil outside of it.
+func ClaimsFrom(c echo.Context) *Claims {
+	claims, _ := c.Get("claims").(*Claims)
+	return claims
+}
@@ -0,0 +1,129 @@
+package identity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// JWK is the public half of a key in RFC 7517 form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes every asymmetric key that is not retired, including
+// scheduled ones, so verifiers can cache a key before it starts signing.
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := ks.now()
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		if k.Algorithm == HS256 || k.retired(now) {
+			continue
+		}
+		jwk := JWK{Use: "sig", KeyID: k.ID, Algorithm: k.Algorithm}
+		switch pub := k.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = b64(pub.N.Bytes())
+			jwk.E = b64(intBytes(pub.E))
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = pub.Curve.Params().Name
+			jwk.X = b64(pad(pub.X.Bytes(), size))
+			jwk.Y = b64(pad(pub.Y.Bytes(), size))
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = b64(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// ErrUnsupportedJWK is returned for keys PublicKey cannot use.
+var ErrUnsupportedJWK = errors.New("unsupported or malformed JWK")
+
+// PublicKey decodes a published key, the inverse of KeySet.JWKS. It
+// takes RSA keys, EC keys on P-256 and Ed25519 keys.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrUnsupportedJWK
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, ErrUnsupportedJWK
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, ErrUnsupportedJWK
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrUnsupportedJWK
+		}
+		return pub, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedJWK
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, ErrUnsupportedJWK
+}
+
+// JWKSHandler serves the public keys of Keys at /.well-known/jwks.json.
+func JWKSHandler(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, Keys.JWKS())
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func intBytes(i int) []byte {
+	return big.NewInt(int64(i)).Bytes()
+}
+
+// pad left-pads b with zeros to size, as RFC 7518 requires for EC
+// coordinates.
+func pad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
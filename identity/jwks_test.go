@@ -0,0 +1,173 @@
+package identity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testKeys returns one key of every algorithm. The HS256 one must never
+// show up in the JWKS.
+func testKeys(t *testing.T) (rsaKey, ecKey, edKey, hsKey Key) {
+	t.Helper()
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, dk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Key{ID: "rsa", Algorithm: RS256, PrivateKey: rk, PublicKey: rk.Public()},
+		Key{ID: "ec", Algorithm: ES256, PrivateKey: ek, PublicKey: ek.Public()},
+		Key{ID: "ed", Algorithm: EdDSA, PrivateKey: dk, PublicKey: dk.Public()},
+		Key{ID: "hs", Algorithm: HS256, Secret: []byte("secret")}
+}
+
+func TestAlgorithmMustMatchKey(t *testing.T) {
+	rsaKey, ecKey, edKey, hsKey := testKeys(t)
+	ks, err := NewKeySet(rsaKey, ecKey, edKey, hsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := JWTConfig{Keys: ks}
+
+	for _, k := range []Key{rsaKey, ecKey, edKey, hsKey} {
+		if code := serve(config, signWith(t, k, k.ID)); code != http.StatusOK {
+			t.Errorf("%s token under its own kid: status %d, want 200", k.Algorithm, code)
+		}
+	}
+	// A valid signature of one key presented under the kid of another.
+	if code := serve(config, signWith(t, ecKey, "ed")); code != http.StatusForbidden {
+		t.Errorf("ES256 token under an EdDSA kid: status %d, want 403", code)
+	}
+	if code := serve(config, signWith(t, rsaKey, "hs")); code != http.StatusForbidden {
+		t.Errorf("RS256 token under an HS256 kid: status %d, want 403", code)
+	}
+
+	// Same key type, other algorithm: the library would accept these.
+	for _, tt := range []struct {
+		method jwt.SigningMethod
+		key    Key
+	}{
+		{jwt.SigningMethodRS512, rsaKey},
+		{jwt.SigningMethodPS256, rsaKey},
+		{jwt.SigningMethodHS512, hsKey},
+	} {
+		token := jwt.NewWithClaims(tt.method, newClaims(primitive.NewObjectID(), nil, TokenOptions{}, time.Now()))
+		token.Header["kid"] = tt.key.ID
+		raw, err := token.SignedString(tt.key.signingKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := serve(config, raw); code != http.StatusForbidden {
+			t.Errorf("%s token under the %s key: status %d, want 403", tt.method.Alg(), tt.key.Algorithm, code)
+		}
+	}
+
+	// The classic confusion: HS256 with the published RSA key as secret.
+	der, err := x509.MarshalPKIXPublicKey(rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, secret := range map[string][]byte{
+		"der": der,
+		"pem": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+	} {
+		forged := Key{ID: "rsa", Algorithm: HS256, Secret: secret}
+		if code := serve(config, signWith(t, forged, "rsa")); code != http.StatusForbidden {
+			t.Errorf("HS256 token keyed with the %s public key: status %d, want 403", name, code)
+		}
+	}
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, newClaims(primitive.NewObjectID(), nil, TokenOptions{}, time.Now()))
+	unsigned.Header["kid"] = "rsa"
+	none, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(config, none); code != http.StatusForbidden {
+		t.Errorf("unsigned token: status %d, want 403", code)
+	}
+}
+
+func TestJWKSPublishesOnlyPublicKeys(t *testing.T) {
+	rsaKey, ecKey, edKey, hsKey := testKeys(t)
+	now := time.Now()
+	scheduled := edKey
+	scheduled.ID, scheduled.NotBefore = "scheduled", now.Add(time.Hour)
+	retired := ecKey
+	retired.ID, retired.NotBefore, retired.RetireAt = "retired", now.Add(-2*time.Hour), now.Add(-time.Hour)
+	ks, err := NewKeySet(rsaKey, ecKey, edKey, hsKey, scheduled, retired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := Keys
+	Keys = ks
+	t.Cleanup(func() { Keys = saved })
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := JWKSHandler(e.NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	public := map[string]bool{"kty": true, "use": true, "kid": true, "alg": true, "crv": true, "n": true, "e": true, "x": true, "y": true}
+	for _, k := range raw.Keys {
+		for field := range k {
+			if !public[field] {
+				t.Errorf("key %v publishes %q", k["kid"], field)
+			}
+		}
+	}
+
+	var set JWKS
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Key{"rsa": rsaKey, "ec": ecKey, "ed": edKey, "scheduled": scheduled}
+	if len(set.Keys) != len(want) {
+		t.Errorf("%d keys published, want %d", len(set.Keys), len(want))
+	}
+	for _, jwk := range set.Keys {
+		k, ok := want[jwk.KeyID]
+		if !ok {
+			t.Errorf("key %q published", jwk.KeyID)
+			continue
+		}
+		if jwk.Algorithm != k.Algorithm || jwk.Use != "sig" {
+			t.Errorf("key %q: alg %q, use %q", jwk.KeyID, jwk.Algorithm, jwk.Use)
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			t.Errorf("key %q: %v", jwk.KeyID, err)
+			continue
+		}
+		if !pub.(interface{ Equal(x crypto.PublicKey) bool }).Equal(k.PublicKey) {
+			t.Errorf("key %q does not round-trip", jwk.KeyID)
+		}
+	}
+}
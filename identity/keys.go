@@ -0,0 +1,273 @@
+package identity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Supported signing algorithms. HMAC keys never leave the service; the
+// public half of the others is published as a JWKS.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// DefaultKeyID names the key built from a bare secret. Tokens issued
+// before key IDs existed carry no kid header and verify against it.
+const DefaultKeyID = "default"
+
+var (
+	ErrNoSigningKey = errors.New("no active signing key")
+	ErrUnknownKey   = errors.New("unknown or retired signing key")
+)
+
+// Key is one signing key. A key signs new tokens from NotBefore on,
+// until a newer key takes over, and verifies tokens until RetireAt.
+// Zero times mean "always" and "never" respectively.
+//
+// HS256 keys use Secret. The asymmetric algorithms use PrivateKey to
+// sign; a key with only PublicKey set verifies but never signs, which is
+// how a retiring key can stay valid after its private half is destroyed.
+type Key struct {
+	ID         string
+	Algorithm  string
+	Secret     []byte
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+	NotBefore  time.Time
+	RetireAt   time.Time
+}
+
+func (k Key) method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case RS256:
+		return jwt.SigningMethodRS256
+	case ES256:
+		return jwt.SigningMethodES256
+	case EdDSA:
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodHS256
+}
+
+func (k Key) canSign() bool {
+	if k.Algorithm == HS256 {
+		return true
+	}
+	return k.PrivateKey != nil
+}
+
+// signingKey is what jwt.Token.SignedString expects for the algorithm.
+func (k Key) signingKey() interface{} {
+	if k.Algorithm == HS256 {
+		return k.Secret
+	}
+	return k.PrivateKey
+}
+
+// verificationKey is what jwt.Parse's key func must return.
+func (k Key) verificationKey() interface{} {
+	if k.Algorithm == HS256 {
+		return k.Secret
+	}
+	return k.PublicKey
+}
+
+func (k *Key) normalize() error {
+	switch k.Algorithm {
+	case "":
+		k.Algorithm = HS256
+	case HS256, RS256, ES256, EdDSA:
+	default:
+		return fmt.Errorf("signing key %q: unsupported algorithm %q", k.ID, k.Algorithm)
+	}
+	if k.Algorithm == HS256 {
+		if len(k.Secret) == 0 {
+			return fmt.Errorf("signing key %q has an empty secret", k.ID)
+		}
+		return nil
+	}
+	if k.PublicKey == nil && k.PrivateKey != nil {
+		k.PublicKey = k.PrivateKey.Public()
+	}
+	if k.PublicKey == nil {
+		return fmt.Errorf("signing key %q has no key material", k.ID)
+	}
+	ok := false
+	switch pub := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		ok = k.Algorithm == RS256
+	case *ecdsa.PublicKey:
+		ok = k.Algorithm == ES256 && pub.Curve == elliptic.P256()
+	case ed25519.PublicKey:
+		ok = k.Algorithm == EdDSA
+	}
+	if !ok {
+		return fmt.Errorf("signing key %q: key type %T does not match algorithm %s", k.ID, k.PublicKey, k.Algorithm)
+	}
+	return nil
+}
+
+func (k Key) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+func (k Key) active(now time.Time) bool {
+	return !now.Before(k.NotBefore) && !k.retired(now)
+}
+
+// KeySet holds every key that may sign or verify tokens. Rotation is a
+// schedule: add the next key with a future NotBefore and set RetireAt on
+// the old one to at least NotBefore plus the token lifetime, so tokens
+// signed just before the switch still verify until they expire.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []Key
+	now  func() time.Time
+}
+
+func NewKeySet(keys ...Key) (*KeySet, error) {
+	ks := &KeySet{now: time.Now}
+	if err := ks.Replace(keys...); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// NewSecretKeySet wraps a single secret, the pre-rotation setup.
+func NewSecretKeySet(secret []byte) *KeySet {
+	ks, _ := NewKeySet(Key{ID: DefaultKeyID, Secret: secret})
+	return ks
+}
+
+// Replace swaps the whole key set, e.g. after the key files were
+// reloaded. Tokens signed with a dropped key stop verifying immediately.
+func (ks *KeySet) Replace(keys ...Key) error {
+	keys = append([]Key(nil), keys...)
+	seen := make(map[string]bool, len(keys))
+	for i := range keys {
+		k := &keys[i]
+		if k.ID == "" {
+			return errors.New("signing key without id")
+		}
+		if seen[k.ID] {
+			return fmt.Errorf("duplicate signing key id %q", k.ID)
+		}
+		if err := k.normalize(); err != nil {
+			return err
+		}
+		if !k.RetireAt.IsZero() && !k.RetireAt.After(k.NotBefore) {
+			return fmt.Errorf("signing key %q retires before it becomes active", k.ID)
+		}
+		seen[k.ID] = true
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	return nil
+}
+
+// SigningKey returns the newest active key that can sign: the one with
+// the latest NotBefore that has been reached, ties going to the later
+// entry.
+func (ks *KeySet) SigningKey() (Key, error) {
+	return ks.newestSigner(false)
+}
+
+// PublicSigningKey is SigningKey limited to asymmetric keys, whose
+// signatures anyone can check against the JWKS. ID tokens need one.
+func (ks *KeySet) PublicSigningKey() (Key, error) {
+	return ks.newestSigner(true)
+}
+
+func (ks *KeySet) newestSigner(asymmetric bool) (Key, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := ks.now()
+	var (
+		best  Key
+		found bool
+	)
+	for _, k := range ks.keys {
+		if !k.active(now) || !k.canSign() || (asymmetric && k.Algorithm == HS256) {
+			continue
+		}
+		if !found || !k.NotBefore.Before(best.NotBefore) {
+			best, found = k, true
+		}
+	}
+	if !found {
+		return Key{}, ErrNoSigningKey
+	}
+	return best, nil
+}
+
+// VerificationKey looks a key up by kid. An empty kid means DefaultKeyID.
+func (ks *KeySet) VerificationKey(kid string) (Key, error) {
+	if kid == "" {
+		kid = DefaultKeyID
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := ks.now()
+	for _, k := range ks.keys {
+		if k.ID == kid && !k.retired(now) {
+			return k, nil
+		}
+	}
+	return Key{}, ErrUnknownKey
+}
+
+// ParsePrivateKeyPEM decodes a PEM private key for alg.
+func ParsePrivateKeyPEM(alg string, data []byte) (crypto.Signer, error) {
+	var (
+		key interface{}
+		err error
+	)
+	switch alg {
+	case RS256:
+		key, err = jwt.ParseRSAPrivateKeyFromPEM(data)
+	case ES256:
+		key, err = jwt.ParseECPrivateKeyFromPEM(data)
+	case EdDSA:
+		key, err = jwt.ParseEdPrivateKeyFromPEM(data)
+	default:
+		return nil, fmt.Errorf("algorithm %q does not use key pairs", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%T cannot sign", key)
+	}
+	return signer, nil
+}
+
+// ParsePublicKeyPEM decodes a PEM public key for alg.
+func ParsePublicKeyPEM(alg string, data []byte) (crypto.PublicKey, error) {
+	switch alg {
+	case RS256:
+		return jwt.ParseRSAPublicKeyFromPEM(data)
+	case ES256:
+		return jwt.ParseECPublicKeyFromPEM(data)
+	case EdDSA:
+		return jwt.ParseEdPublicKeyFromPEM(data)
+	}
+	return nil, fmt.Errorf("algorithm %q does not use key pairs", alg)
+}
@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// signWith signs a fresh access token with k under kid, bypassing the
+// key set's choice of signing key.
+func signWith(t *testing.T, k Key, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(k.method(), newClaims(primitive.NewObjectID(), []Role{Member}, TokenOptions{}, time.Now()))
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	raw, err := token.SignedString(k.signingKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestKeyRotation(t *testing.T) {
+	start := time.Now()
+	old := Key{ID: "old", Algorithm: HS256, Secret: []byte("old secret"), RetireAt: start.Add(2 * time.Hour)}
+	next := Key{ID: "next", Algorithm: HS256, Secret: []byte("next secret"), NotBefore: start.Add(time.Hour)}
+	ks, err := NewKeySet(old, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := start
+	ks.now = func() time.Time { return clock }
+	config := JWTConfig{Keys: ks}
+
+	signer := func() string {
+		k, err := ks.SigningKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k.ID
+	}
+	if id := signer(); id != "old" {
+		t.Fatalf("signing key before the switch: %q, want old", id)
+	}
+	oldToken := signWith(t, old, "old")
+
+	clock = start.Add(time.Hour)
+	if id := signer(); id != "next" {
+		t.Errorf("signing key after the switch: %q, want next", id)
+	}
+	if code := serve(config, oldToken); code != http.StatusOK {
+		t.Errorf("token of the retiring key: status %d, want 200", code)
+	}
+
+	clock = start.Add(2 * time.Hour)
+	if code := serve(config, oldToken); code != http.StatusForbidden {
+		t.Errorf("token of the retired key: status %d, want 403", code)
+	}
+	if _, err := ks.VerificationKey("old"); err != ErrUnknownKey {
+		t.Errorf("retired key: %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestRemovedKey(t *testing.T) {
+	old := Key{ID: "old", Algorithm: HS256, Secret: []byte("old secret"), RetireAt: time.Now().Add(time.Hour)}
+	next := Key{ID: "next", Algorithm: HS256, Secret: []byte("next secret")}
+	ks, err := NewKeySet(old, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := JWTConfig{Keys: ks}
+	oldToken := signWith(t, old, "old")
+	if code := serve(config, oldToken); code != http.StatusOK {
+		t.Fatalf("token of a listed key: status %d, want 200", code)
+	}
+	if err := ks.Replace(next); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(config, oldToken); code != http.StatusForbidden {
+		t.Errorf("token of a removed key: status %d, want 403", code)
+	}
+}
+
+func TestUnknownKeyID(t *testing.T) {
+	k := Key{ID: "current", Algorithm: HS256, Secret: []byte("secret")}
+	ks, err := NewKeySet(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := JWTConfig{Keys: ks}
+	// Same secret, so only the kid is wrong.
+	if code := serve(config, signWith(t, k, "elsewhere")); code != http.StatusForbidden {
+		t.Errorf("unknown kid: status %d, want 403", code)
+	}
+	// Without a kid the token is checked against DefaultKeyID, which is
+	// not in this set.
+	if code := serve(config, signWith(t, k, "")); code != http.StatusForbidden {
+		t.Errorf("no kid: status %d, want 403", code)
+	}
+	if code := serve(config, signWith(t, k, "current")); code != http.StatusOK {
+		t.Errorf("known kid: status %d, want 200", code)
+	}
+}
+
+func TestKeySetRejectsBadKeys(t *testing.T) {
+	now := time.Now()
+	tests := map[string][]Key{
+		"no id":           {{Secret: []byte("s")}},
+		"duplicate id":    {{ID: "a", Secret: []byte("s")}, {ID: "a", Secret: []byte("t")}},
+		"empty secret":    {{ID: "a"}},
+		"unknown alg":     {{ID: "a", Algorithm: "none", Secret: []byte("s")}},
+		"no key material": {{ID: "a", Algorithm: RS256}},
+		"retires early":   {{ID: "a", Secret: []byte("s"), NotBefore: now, RetireAt: now}},
+	}
+	for name, keys := range tests {
+		if _, err := NewKeySet(keys...); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
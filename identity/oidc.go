@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scopes of OpenID Connect. They name no permission, so a token limited
+// to them can read the user's claims from /userinfo and nothing else.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// UserInfo is the set of standard OpenID Connect claims about a user that
+// this service knows. Claims that a token's scopes do not cover are left
+// empty.
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// IDClaims is the payload of an ID token. Its audience is the OAuth
+// client, not Audience, so it is never accepted as an access token.
+type IDClaims struct {
+	jwt.StandardClaims
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	AccessTokenHash   string `json:"at_hash,omitempty"`
+	Email             string `json:"email,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// Valid always passes; ID tokens are issued here, not verified.
+func (c *IDClaims) Valid() error { return nil }
+
+// IDTokenOptions are the parts of an ID token that come from the
+// authorization rather than the user.
+type IDTokenOptions struct {
+	ClientID string
+	// Nonce is echoed from the authorization request.
+	Nonce string
+	// AuthTime is when the user signed in.
+	AuthTime time.Time
+	// AccessToken, if set, is bound to the ID token through at_hash.
+	AccessToken string
+}
+
+// GenerateIDToken signs an ID token for info with the newest asymmetric
+// key, since clients verify it against the JWKS. It returns "" if no such
+// key is active.
+func GenerateIDToken(info UserInfo, opts IDTokenOptions) string {
+	key, err := Keys.PublicSigningKey()
+	if err != nil {
+		return ""
+	}
+	now := time.Now()
+	claims := &IDClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        primitive.NewObjectID().Hex(),
+			Subject:   info.Subject,
+			Issuer:    Issuer,
+			Audience:  opts.ClientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(TokenLifetime).Unix(),
+		},
+		Nonce:             opts.Nonce,
+		Email:             info.Email,
+		PreferredUsername: info.PreferredUsername,
+	}
+	if !opts.AuthTime.IsZero() {
+		claims.AuthTime = opts.AuthTime.Unix()
+	}
+	if opts.AccessToken != "" {
+		claims.AccessTokenHash = halfHash(key.Algorithm, opts.AccessToken)
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	t, _ := token.SignedString(key.signingKey())
+	return t
+}
+
+// halfHash is the left half of the hash of s that goes with alg, base64url
+// encoded, as at_hash is defined in OpenID Connect Core section 3.1.3.6.
+func halfHash(alg, s string) string {
+	var h hash.Hash
+	if alg == EdDSA {
+		h = sha512.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(s))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// SigningAlgorithms lists the algorithms of the published keys, for the
+// discovery document.
+func (ks *KeySet) SigningAlgorithms() []string {
+	algs := make([]string, 0)
+	for _, k := range ks.JWKS().Keys {
+		if !containsString(algs, k.Algorithm) {
+			algs = append(algs, k.Algorithm)
+		}
+	}
+	return algs
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Principal is the user an opaque token acts for. Scopes, if not nil,
+// are the only permissions the token may use, whatever its user's roles
+// grant. IssuedAt, if set, is when the token was created; the token is
+// then rejected once its user is logged out everywhere, like an access
+// token of that time.
+type Principal struct {
+	UserID   primitive.ObjectID
+	Roles    []Role
+	Scopes   []Permission
+	IssuedAt time.Time
+}
+
+// TokenAuthenticator verifies opaque tokens, such as personal access
+// tokens, that the JWT middleware recognises by their prefix. It returns
+// (nil, nil) for tokens that are unknown, expired or revoked.
+type TokenAuthenticator interface {
+	Authenticate(ctx context.Context, raw string) (*Principal, error)
+}
+
+var ErrTokenInvalid = echo.NewHTTPError(http.StatusUnauthorized, "invalid, expired or revoked access token")
+
+// NewOpaqueToken returns a random token that reads
+// "<prefix><id>_<secret>", so the record it belongs to can be found
+// without a lookup by hash. Only HashToken of it is meant to be stored.
+func NewOpaqueToken(prefix string, id primitive.ObjectID) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return prefix + id.Hex() + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// ParseOpaqueToken returns the record ID in a token made by
+// NewOpaqueToken with the same prefix.
+func ParseOpaqueToken(prefix, raw string) (primitive.ObjectID, bool) {
+	if !strings.HasPrefix(raw, prefix) {
+		return primitive.NilObjectID, false
+	}
+	rest := raw[len(prefix):]
+	n := len(primitive.NilObjectID.Hex())
+	if len(rest) <= n || rest[n] != '_' {
+		return primitive.NilObjectID, false
+	}
+	id, err := primitive.ObjectIDFromHex(rest[:n])
+	return id, err == nil
+}
+
+// HashToken is the stored form of an opaque token.
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenMatches compares raw with a stored hash in constant time.
+func TokenMatches(raw, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashToken(raw)), []byte(hash)) == 1
+}
+
+// opaqueAuthenticator returns the authenticator of raw's prefix, if any.
+func (config JWTConfig) opaqueAuthenticator(raw string) TokenAuthenticator {
+	for prefix, a := range config.Tokens {
+		if strings.HasPrefix(raw, prefix) {
+			return a
+		}
+	}
+	return nil
+}
+
+// authenticateOpaque fills the request context the way a JWT would, with
+// permissions cut down to the token's scopes. Claims stay unset.
+func authenticateOpaque(c echo.Context, next echo.HandlerFunc, config JWTConfig, a TokenAuthenticator, raw string) error {
+	ctx := c.Request().Context()
+	p, err := a.Authenticate(ctx, raw)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if p == nil {
+		return c.JSON(http.StatusUnauthorized, customerror.NewError(ErrTokenInvalid))
+	}
+	if config.Revocations != nil && !p.IssuedAt.IsZero() {
+		revoked, err := config.Revocations.IsRevoked(ctx, "", "", p.UserID, p.IssuedAt)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+		}
+		if revoked {
+			return c.JSON(http.StatusUnauthorized, customerror.NewError(ErrTokenInvalid))
+		}
+	}
+	perms, err := config.Roles.Permissions(ctx, p.Roles)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+	}
+	if p.Scopes != nil {
+		scopes := make([]string, len(p.Scopes))
+		for i, s := range p.Scopes {
+			scopes[i] = string(s)
+		}
+		c.Set("scopes", restrict(perms, scopes))
+	}
+	c.Set("user", p.UserID)
+	c.Set("roles", p.Roles)
+	c.Set("permissions", perms)
+	return next(c)
+}
+
+// restrict removes from perms every permission that is not among scopes
+// and returns the scopes that name permissions.
+func restrict(perms map[Permission]bool, scopes []string) map[Permission]bool {
+	set := make(map[Permission]bool, len(scopes))
+	for _, s := range scopes {
+		if p := Permission(s); IsPermission(p) {
+			set[p] = true
+		}
+	}
+	for perm := range perms {
+		if !set[perm] {
+			delete(perms, perm)
+		}
+	}
+	return set
+}
+
+// ScopesFrom returns the permission scopes of the token that
+// authenticated the request, or nil if the request is not limited to
+// scopes.
+func ScopesFrom(c echo.Context) map[Permission]bool {
+	scopes, _ := c.Get("scopes").(map[Permission]bool)
+	return scopes
+}
+
+// RequireLogin is a route level middleware for actions that belong to an
+// interactive login, such as logging out or managing sessions and
+// tokens; requests authenticated by an opaque token or by a token issued
+// to an OAuth client get 403. It must run after the JWT middleware.
+func RequireLogin() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if claims := ClaimsFrom(c); claims == nil || claims.ClientID != "" || ScopesFrom(c) != nil {
+				return c.JSON(http.StatusForbidden, customerror.AccessForbidden())
+			}
+			return next(c)
+		}
+	}
+}
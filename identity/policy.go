@@ -0,0 +1,211 @@
+package identity
+
+import (
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Effect is what a matching rule decides.
+type Effect int
+
+const (
+	Allow Effect = iota + 1
+	Deny
+)
+
+// Subject is who is acting, as seen by policy rules. Scopes is nil unless
+// the subject acts through a token limited to them.
+type Subject struct {
+	ID          primitive.ObjectID
+	Roles       []Role
+	Org         string
+	Permissions map[Permission]bool
+	Scopes      map[Permission]bool
+}
+
+// Resource is what is acted on. For a user, Owner is the user itself,
+// Roles are the roles the user holds and Permissions what they grant.
+type Resource struct {
+	Kind        string
+	ID          primitive.ObjectID
+	Owner       primitive.ObjectID
+	Org         string
+	Roles       []Role
+	Permissions map[Permission]bool
+}
+
+// Request is one question to a Policy: may Subject do Action to Resource?
+type Request struct {
+	Subject  Subject
+	Action   Permission
+	Resource Resource
+}
+
+// Rule applies to the listed actions, or to every action if none are
+// listed, and matches when When returns true.
+type Rule struct {
+	Name    string
+	Effect  Effect
+	Actions []Permission
+	When    func(r Request) bool
+}
+
+// Decision is the outcome of one evaluation. Rule names the rule that
+// decided; it is empty when no rule matched and access was denied by
+// default.
+type Decision struct {
+	Time     time.Time
+	Subject  primitive.ObjectID
+	Action   Permission
+	Resource string
+	Allowed  bool
+	Rule     string
+}
+
+// Fields renders d for structured logging; loggers add the time.
+func (d Decision) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"subject":  d.Subject.Hex(),
+		"action":   d.Action,
+		"resource": d.Resource,
+		"allowed":  d.Allowed,
+		"rule":     d.Rule,
+	}
+}
+
+// Policy evaluates rules with deny overrides: any matching Deny rule
+// denies, otherwise any matching Allow rule allows, otherwise access is
+// denied. Every decision is passed to Log.
+type Policy struct {
+	Rules []Rule
+	Log   func(Decision)
+}
+
+// Evaluate decides r and logs the decision.
+func (p *Policy) Evaluate(r Request) Decision {
+	d := Decision{
+		Time:     time.Now().UTC(),
+		Subject:  r.Subject.ID,
+		Action:   r.Action,
+		Resource: r.Resource.Kind + "/" + r.Resource.ID.Hex(),
+	}
+	allow := ""
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(r.Action) || !rule.When(r) {
+			continue
+		}
+		if rule.Effect == Deny {
+			allow = ""
+			d.Rule = rule.Name
+			break
+		}
+		if allow == "" {
+			allow = rule.Name
+		}
+	}
+	if allow != "" {
+		d.Allowed, d.Rule = true, allow
+	}
+	if p.Log != nil {
+		p.Log(d)
+	}
+	return d
+}
+
+func (rule Rule) appliesTo(action Permission) bool {
+	if len(rule.Actions) == 0 {
+		return true
+	}
+	for _, a := range rule.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPolicy governs access to users. Permissions from RBAC still
+// grant their action everywhere; the other rules add ownership and
+// organisation on top, keep users out of reach of anyone holding fewer
+// permissions and keep scoped tokens within their scopes.
+var DefaultPolicy = &Policy{
+	Rules: []Rule{
+		{
+			// A scoped token cannot act outside its scopes, not even on
+			// its own user.
+			Name:   "token-scope",
+			Effect: Deny,
+			When: func(r Request) bool {
+				return r.Subject.Scopes != nil && !r.Subject.Scopes[r.Action]
+			},
+		},
+		{
+			// Nobody may change a user who holds a permission they lack,
+			// whatever the roles granting it are called.
+			Name:    "protect-privileged",
+			Effect:  Deny,
+			Actions: []Permission{UsersUpdate, UsersDelete, MFAReset},
+			When: func(r Request) bool {
+				return !covers(r.Subject.Permissions, r.Resource.Permissions)
+			},
+		},
+		{
+			Name:    "self-service",
+			Effect:  Allow,
+			Actions: []Permission{UsersRead, UsersUpdate},
+			When: func(r Request) bool {
+				return r.Subject.ID == r.Resource.Owner
+			},
+		},
+		{
+			Name:   "permission",
+			Effect: Allow,
+			When: func(r Request) bool {
+				return r.Subject.Permissions[r.Action]
+			},
+		},
+		{
+			Name:    "moderate-own-org",
+			Effect:  Allow,
+			Actions: []Permission{UsersUpdate},
+			When: func(r Request) bool {
+				return hasAnyRole(r.Subject.Roles, Moderator) &&
+					r.Subject.Org != "" && r.Subject.Org == r.Resource.Org &&
+					outranks(r.Subject.Permissions, r.Resource.Permissions)
+			},
+		},
+	},
+	Log: func(d Decision) {
+		log.Printf("policy: subject=%s action=%s resource=%s allowed=%t rule=%q",
+			d.Subject.Hex(), d.Action, d.Resource, d.Allowed, d.Rule)
+	},
+}
+
+// covers reports whether have grants every permission in want.
+func covers(have, want map[Permission]bool) bool {
+	for p, ok := range want {
+		if ok && !have[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// outranks reports whether have grants every permission in want and at
+// least one more.
+func outranks(have, want map[Permission]bool) bool {
+	return covers(have, want) && !covers(want, have)
+}
+
+func hasAnyRole(roles []Role, want ...Role) bool {
+	for _, r := range roles {
+		for _, w := range want {
+			if r == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/hamed-lohi/user-manage/customerror"
+	"github.com/labstack/echo/v4"
+)
+
+// Permission names one action on one kind of resource as
+// "resource:action".
+type Permission string
+
+const (
+	UsersRead   Permission = "users:read"
+	UsersCreate Permission = "users:create"
+	UsersUpdate Permission = "users:update"
+	UsersDelete Permission = "users:delete"
+	// SessionsRead and SessionsRevoke apply to the sessions of any user;
+	// everyone may list and revoke their own.
+	SessionsRead   Permission = "sessions:read"
+	SessionsRevoke Permission = "sessions:revoke"
+	RolesRead      Permission = "roles:read"
+	RolesWrite     Permission = "roles:write"
+	// RolesAssign allows granting and removing roles of users.
+	RolesAssign Permission = "roles:assign"
+	// ServiceAccountsRead and ServiceAccountsWrite cover service accounts
+	// and their API keys.
+	ServiceAccountsRead  Permission = "service_accounts:read"
+	ServiceAccountsWrite Permission = "service_accounts:write"
+	// ClientsRead and ClientsWrite cover registered OAuth clients.
+	ClientsRead  Permission = "clients:read"
+	ClientsWrite Permission = "clients:write"
+	// MFAReset allows turning off the second factor of other users.
+	MFAReset Permission = "mfa:reset"
+)
+
+// AllPermissions is every permission the API checks. Roles may only be
+// defined in terms of these.
+var AllPermissions = []Permission{
+	UsersRead, UsersCreate, UsersUpdate, UsersDelete,
+	SessionsRead, SessionsRevoke,
+	RolesRead, RolesWrite, RolesAssign,
+	ServiceAccountsRead, ServiceAccountsWrite,
+	ClientsRead, ClientsWrite,
+	MFAReset,
+}
+
+// IsPermission reports whether p is in AllPermissions.
+func IsPermission(p Permission) bool {
+	for _, known := range AllPermissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleDefinition is a role as shipped with the code.
+type RoleDefinition struct {
+	ID          Role
+	Name        string
+	Permissions []Permission
+}
+
+// BuiltinRoles are the roles every deployment starts with. Each role is
+// the set of permissions it grants; roles are independent of each other,
+// so a role that should include another lists its permissions too.
+// Admin always grants every permission, whatever is stored for it.
+var BuiltinRoles = []RoleDefinition{
+	{ID: Guest, Name: "Guest", Permissions: []Permission{}},
+	{ID: Member, Name: "Member", Permissions: []Permission{}},
+	{ID: Moderator, Name: "Moderator", Permissions: []Permission{UsersRead, SessionsRead, RolesRead}},
+	{ID: Admin, Name: "Admin", Permissions: AllPermissions},
+}
+
+// RoleResolver returns the permissions that roles grant right now, so
+// changes to a role apply to tokens that were issued before them.
+type RoleResolver interface {
+	Permissions(ctx context.Context, roles []Role) (map[Permission]bool, error)
+}
+
+// Roles resolves permissions in the JWT middleware. It is replaced at
+// startup by a resolver backed by the role store.
+var Roles RoleResolver = builtinResolver{}
+
+type builtinResolver struct{}
+
+func (builtinResolver) Permissions(ctx context.Context, roles []Role) (map[Permission]bool, error) {
+	defs := make(map[Role][]Permission, len(BuiltinRoles))
+	for _, d := range BuiltinRoles {
+		defs[d.ID] = d.Permissions
+	}
+	return Grant(roles, defs), nil
+}
+
+// Grant returns the union of the permissions defs assigns to roles.
+// Unknown roles grant nothing and Admin grants everything.
+func Grant(roles []Role, defs map[Role][]Permission) map[Permission]bool {
+	perms := make(map[Permission]bool)
+	for _, r := range roles {
+		granted := defs[r]
+		if r == Admin {
+			granted = AllPermissions
+		}
+		for _, p := range granted {
+			perms[p] = true
+		}
+	}
+	return perms
+}
+
+// SyncGroupRoles brings roles in line with a user's groups at an upstream
+// authority. Roles that groupRoles maps one of groups to are added; roles
+// it maps only other groups to are taken away; roles it does not mention
+// are kept. A user left without roles is a Guest. removed tells whether a
+// role was taken away, after which the user's other logins should end.
+func SyncGroupRoles(roles []Role, groupRoles map[string]Role, groups []string) (synced []Role, changed, removed bool) {
+	if len(groupRoles) == 0 {
+		return roles, false, false
+	}
+	managed := make(map[Role]bool, len(groupRoles))
+	for _, r := range groupRoles {
+		managed[r] = true
+	}
+	want := make(map[Role]bool)
+	for _, g := range groups {
+		if r, ok := groupRoles[g]; ok {
+			want[r] = true
+		}
+	}
+
+	synced = make([]Role, 0, len(roles)+len(want))
+	for _, r := range roles {
+		switch {
+		case !managed[r]:
+			synced = append(synced, r)
+		case want[r]:
+			synced = append(synced, r)
+			delete(want, r)
+		default:
+			removed = true
+		}
+	}
+	added := make([]Role, 0, len(want))
+	for r := range want {
+		added = append(added, r)
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	synced = append(synced, added...)
+	if len(synced) == 0 {
+		synced = append(synced, Guest)
+	}
+	return synced, removed || len(synced) != len(roles), removed
+}
+
+// HasPermission reports whether the request's token grants every
+// permission in perms. It is false outside of the JWT middleware.
+func HasPermission(c echo.Context, perms ...Permission) bool {
+	granted, ok := c.Get("permissions").(map[Permission]bool)
+	if !ok {
+		return false
+	}
+	for _, p := range perms {
+		if !granted[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// RequirePermission is a route level middleware that lets the request
+// through only if the roles of its token grant every permission listed.
+// It must run after the JWT middleware.
+func RequirePermission(perms ...Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !HasPermission(c, perms...) {
+				return c.JSON(http.StatusForbidden, customerror.AccessForbidden())
+			}
+			return next(c)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package identity
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
@@ -24,40 +25,116 @@ const (
 
 type (
 	JWTConfig struct {
-		Skipper    Skipper
-		SigningKey interface{}
+		Skipper Skipper
+		Keys    *KeySet
+		// Issuer, Audience and Leeway default to the package settings
+		// when left zero.
+		Issuer   string
+		Audience string
+		Leeway   time.Duration
+		// Revocations, if set, is asked about every otherwise valid token.
+		Revocations RevocationChecker
+		// Roles defaults to the package resolver when nil.
+		Roles RoleResolver
+		// Tokens maps a prefix to the authenticator of the opaque tokens
+		// that start with it; other tokens are parsed as JWTs.
+		Tokens map[string]TokenAuthenticator
+		// KeyHeader, if set, names a header that may carry an opaque token,
+		// such as an API key, in place of the Authorization header.
+		KeyHeader string
+	}
+	// RevocationChecker reports whether a token was revoked before its
+	// expiry, e.g. by logout or a password change.
+	RevocationChecker interface {
+		IsRevoked(ctx context.Context, jti, sid string, userID primitive.ObjectID, issuedAt time.Time) (bool, error)
 	}
 	Skipper      func(c echo.Context) bool
 	jwtExtractor func(echo.Context) (string, error)
 )
 
+// APIKeyHeader is the header API keys are sent in.
+const APIKeyHeader = "X-API-Key"
+
 var (
 	ErrJWTMissing = echo.NewHTTPError(http.StatusUnauthorized, "missing or malformed jwt")
 	ErrJWTInvalid = echo.NewHTTPError(http.StatusForbidden, "invalid or expired jwt")
+	ErrJWTClaims  = echo.NewHTTPError(http.StatusUnauthorized, "malformed jwt claims")
+	ErrJWTRevoked = echo.NewHTTPError(http.StatusUnauthorized, "jwt has been revoked")
+)
+
+// These are set from the auth configuration at startup.
+var (
+	Keys          = NewSecretKeySet([]byte("!-!SECRET!-!"))
+	TokenLifetime = 24 * time.Hour
+	Issuer        = "user-manage"
+	Audience      = "user-manage"
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat.
+	Leeway = 30 * time.Second
 )
 
-var JWTSecret = []byte("!-!SECRET!-!")
+// GenerateJWT signs a token with the newest active key and names that key
+// in the kid header. Each token gets a unique jti so it can be revoked on
+// its own; sid, if not empty, names the session the token belongs to. It
+// returns "" if no key is active.
+func GenerateJWT(id primitive.ObjectID, roles []Role, sid string) string {
+	return GenerateJWTWithOptions(id, roles, TokenOptions{SessionID: sid})
+}
 
-func GenerateJWT(id primitive.ObjectID, roles []Role) string {
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["id"] = id
-	claims["roles"] = roles
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
-	t, _ := token.SignedString(JWTSecret)
+// TokenOptions are the optional claims of an access token.
+type TokenOptions struct {
+	SessionID string
+	// ClientID names the OAuth client the token was issued to.
+	ClientID string
+	// Scopes, if not empty, limit the token to the permissions among
+	// them; other scopes are carried along for the client's use.
+	Scopes []string
+}
+
+// GenerateJWTWithOptions is GenerateJWT with every optional claim.
+func GenerateJWTWithOptions(id primitive.ObjectID, roles []Role, opts TokenOptions) string {
+	key, err := Keys.SigningKey()
+	if err != nil {
+		return ""
+	}
+	token := jwt.NewWithClaims(key.method(), newClaims(id, roles, opts, time.Now()))
+	token.Header["kid"] = key.ID
+	t, _ := token.SignedString(key.signingKey())
 	return t
 }
 
-func JWT(key interface{}) echo.MiddlewareFunc {
+func JWT(keys *KeySet) echo.MiddlewareFunc {
 	c := JWTConfig{}
-	c.SigningKey = key
+	c.Keys = keys
 	return JWTWithConfig(c)
 }
 
 func JWTWithConfig(config JWTConfig) echo.MiddlewareFunc {
-	extractor := jwtFromHeader("Authorization", "Token")
+	if config.Issuer == "" {
+		config.Issuer = Issuer
+	}
+	if config.Audience == "" {
+		config.Audience = Audience
+	}
+	if config.Leeway == 0 {
+		config.Leeway = Leeway
+	}
+	if config.Roles == nil {
+		config.Roles = Roles
+	}
+	// Claims are checked by Claims.validate, which knows the config.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	extractor := jwtFromHeader("Authorization", "Token", "Bearer")
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			if config.KeyHeader != "" {
+				if key := c.Request().Header.Get(config.KeyHeader); key != "" {
+					a := config.opaqueAuthenticator(key)
+					if a == nil {
+						return c.JSON(http.StatusUnauthorized, customerror.NewError(ErrTokenInvalid))
+					}
+					return authenticateOpaque(c, next, config, a, key)
+				}
+			}
 			auth, err := extractor(c)
 			if err != nil {
 				if config.Skipper != nil {
@@ -67,60 +144,76 @@ func JWTWithConfig(config JWTConfig) echo.MiddlewareFunc {
 				}
 				return c.JSON(http.StatusUnauthorized, customerror.NewError(err))
 			}
-			token, err := jwt.Parse(auth, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			if a := config.opaqueAuthenticator(auth); a != nil {
+				return authenticateOpaque(c, next, config, a, auth)
+			}
+			claims := &Claims{}
+			_, err = parser.ParseWithClaims(auth, claims, func(token *jwt.Token) (interface{}, error) {
+				kid, _ := token.Header["kid"].(string)
+				key, err := config.Keys.VerificationKey(kid)
+				if err != nil {
+					return nil, err
+				}
+				// The key, not the token, decides the algorithm; this rules
+				// out alg confusion such as HS256 signed with a public key.
+				if token.Method.Alg() != key.Algorithm {
 					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 				}
-				return config.SigningKey, nil
+				return key.verificationKey(), nil
 			})
 			if err != nil {
+				// Undecodable claims, e.g. a sub that is not a string, are
+				// reported as malformed rather than as a bad signature.
+				if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorMalformed != 0 {
+					return c.JSON(http.StatusUnauthorized, customerror.NewError(ErrJWTClaims))
+				}
+				return c.JSON(http.StatusForbidden, customerror.NewError(ErrJWTInvalid))
+			}
+			if err := claims.validate(config, time.Now()); err != nil {
+				if _, ok := err.(*errClaims); ok {
+					return c.JSON(http.StatusUnauthorized, customerror.NewError(err))
+				}
 				return c.JSON(http.StatusForbidden, customerror.NewError(ErrJWTInvalid))
 			}
-			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-				userID, _ := primitive.ObjectIDFromHex(claims["id"].(string))
-				userRoles := claims["roles"].([]interface{})
-				c.Set("user", userID)
-				c.Set("roles", userRoles)
-				return next(c)
+			userID, _ := claims.UserID()
+			if config.Revocations != nil {
+				revoked, err := config.Revocations.IsRevoked(c.Request().Context(), claims.Id, claims.SessionID, userID, claims.IssuedTime())
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
+				}
+				if revoked {
+					return c.JSON(http.StatusUnauthorized, customerror.NewError(ErrJWTRevoked))
+				}
+			}
+			perms, err := config.Roles.Permissions(c.Request().Context(), claims.Roles)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, customerror.NewError(err))
 			}
-			return c.JSON(http.StatusForbidden, customerror.NewError(ErrJWTInvalid))
+			if claims.Scope != "" {
+				c.Set("scopes", restrict(perms, claims.Scopes()))
+			}
+			c.Set("claims", claims)
+			c.Set("user", userID)
+			c.Set("roles", claims.Roles)
+			c.Set("permissions", perms)
+			return next(c)
 		}
 	}
 }
 
 // jwtFromHeader returns a `jwtExtractor` that extracts token from the request header.
-func jwtFromHeader(header string, authScheme string) jwtExtractor {
+// Any of authSchemes is accepted; OAuth clients send "Bearer".
+func jwtFromHeader(header string, authSchemes ...string) jwtExtractor {
 	return func(c echo.Context) (string, error) {
 		auth := c.Request().Header.Get(header)
 
-		l := len(authScheme)
-		if len(auth) > l+1 && auth[:l] == authScheme {
-			return auth[l+1:], nil
-		}
-		return "", ErrJWTMissing
-	}
-}
-
-// Route level middleware
-func CheckAccessByRole(r Role) echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-
-			//fmt.Println(c.Get("roles"))
-			roles, ok := c.Get("roles").([]interface{})
-
-			if !ok {
-				return c.JSON(http.StatusForbidden, "not access")
+		for _, authScheme := range authSchemes {
+			l := len(authScheme)
+			if len(auth) > l+1 && auth[:l] == authScheme && auth[l] == ' ' {
+				return auth[l+1:], nil
 			}
-
-			for _, rol := range roles {
-				if Role(rol.(float64)) >= r {
-					return next(c)
-				}
-			}
-
-			return c.JSON(http.StatusForbidden, "not access")
 		}
+		return "", ErrJWTMissing
 	}
 }
 
@@ -0,0 +1,119 @@
+package identity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testConfig = JWTConfig{Issuer: "issuer", Audience: "audience", Leeway: 30 * time.Second}
+
+func TestClaimsValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	valid := func() *Claims {
+		return &Claims{StandardClaims: jwt.StandardClaims{
+			Id:        "jti",
+			Subject:   primitive.NewObjectID().Hex(),
+			Issuer:    "issuer",
+			Audience:  "audience",
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		}}
+	}
+	sec := func(n int64) int64 { return now.Unix() + n }
+
+	tests := []struct {
+		name   string
+		modify func(c *Claims)
+		// want is nil, errClaimsTime or any *errClaims.
+		want error
+	}{
+		{"valid", func(c *Claims) {}, nil},
+		{"other issuer", func(c *Claims) { c.Issuer = "other" }, &errClaims{}},
+		{"no issuer", func(c *Claims) { c.Issuer = "" }, &errClaims{}},
+		{"other audience", func(c *Claims) { c.Audience = "other" }, &errClaims{}},
+		{"no audience", func(c *Claims) { c.Audience = "" }, &errClaims{}},
+		{"malformed sub", func(c *Claims) { c.Subject = "alice" }, &errClaims{}},
+		{"no sub", func(c *Claims) { c.Subject = "" }, &errClaims{}},
+		{"no jti", func(c *Claims) { c.Id = "" }, &errClaims{}},
+		{"no iat", func(c *Claims) { c.IssuedAt = 0 }, &errClaims{}},
+		{"no exp", func(c *Claims) { c.ExpiresAt = 0 }, &errClaims{}},
+		{"expired within leeway", func(c *Claims) { c.ExpiresAt = sec(-30) }, nil},
+		{"expired past leeway", func(c *Claims) { c.ExpiresAt = sec(-31) }, errClaimsTime},
+		{"nbf within leeway", func(c *Claims) { c.NotBefore = sec(30) }, nil},
+		{"nbf past leeway", func(c *Claims) { c.NotBefore = sec(31) }, errClaimsTime},
+		{"iat within leeway", func(c *Claims) { c.IssuedAt = sec(30) }, nil},
+		{"iat past leeway", func(c *Claims) { c.IssuedAt = sec(31) }, errClaimsTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			err := c.validate(testConfig, now)
+			switch tt.want.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+			case *errClaims:
+				if _, ok := err.(*errClaims); !ok {
+					t.Errorf("got %v, want invalid claims", err)
+				}
+			default:
+				if err != tt.want {
+					t.Errorf("got %v, want %v", err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestMalformedSubject checks that a signed token whose sub is no user id
+// is refused as malformed, whether it is a string or not.
+func TestMalformedSubject(t *testing.T) {
+	keys := NewSecretKeySet([]byte("secret"))
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"jti": "jti",
+		"iss": testConfig.Issuer,
+		"aud": testConfig.Audience,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for name, sub := range map[string]interface{}{"string": "alice", "number": 42} {
+		t.Run(name, func(t *testing.T) {
+			claims["sub"] = sub
+			raw, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := testConfig
+			config.Keys = keys
+			if code := serve(config, raw); code != http.StatusUnauthorized {
+				t.Errorf("status %d, want %d", code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+// serve sends a request with raw as bearer token through the middleware
+// and returns the status.
+func serve(config JWTConfig, raw string) int {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+raw)
+	rec := httptest.NewRecorder()
+	h := JWTWithConfig(config)(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	if err := h(e.NewContext(req, rec)); err != nil {
+		e.HTTPErrorHandler(err, e.NewContext(req, rec))
+	}
+	return rec.Code
+}
@@ -0,0 +1,426 @@
+package initialize
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/config"
+	"github.com/hamed-lohi/user-manage/identity"
+	"github.com/labstack/echo/v4"
+)
+
+// newTestAPI builds the whole API on the memory store.
+func newTestAPI(t *testing.T) *echo.Echo {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Database.Driver = "memory"
+	cfg.Server.LogLevel = "OFF"
+	cfg.Auth.JWTSecret = "test secret"
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	e, _ := NewServer(cfg)
+	return e
+}
+
+// call sends a JSON request and decodes the JSON response into out, if
+// given. It returns the status code.
+func call(t *testing.T, e *echo.Echo, method, path, token string, body, out interface{}) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if out != nil && rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, path, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+type credentials struct {
+	User map[string]string `json:"user"`
+}
+
+func login(t *testing.T, e *echo.Echo, email, password string) string {
+	t.Helper()
+	var res struct {
+		Token string `json:"token"`
+	}
+	body := credentials{User: map[string]string{"email": email, "password": password}}
+	if code := call(t, e, http.MethodPost, "/api/users/login", "", body, &res); code != http.StatusOK {
+		t.Fatalf("login %s: status %d", email, code)
+	}
+	return res.Token
+}
+
+// signUp registers name with the password "secret" and returns its ID.
+func signUp(t *testing.T, e *echo.Echo, name string) string {
+	t.Helper()
+	body := credentials{User: map[string]string{
+		"username": name, "email": name + "@example.com", "password": "secret",
+	}}
+	var res struct {
+		ID string `json:"id"`
+	}
+	if code := call(t, e, http.MethodPost, "/api/users", "", body, &res); code != http.StatusCreated {
+		t.Fatalf("sign up %s: status %d", name, code)
+	}
+	return res.ID
+}
+
+// grantRole has admin create a role with perms and assign it to user.
+func grantRole(t *testing.T, e *echo.Echo, admin, user, name string, perms ...identity.Permission) {
+	t.Helper()
+	body := map[string]interface{}{"role": map[string]interface{}{"name": name, "permissions": perms}}
+	var created struct {
+		Role struct {
+			ID identity.Role `json:"id"`
+		} `json:"role"`
+	}
+	if code := call(t, e, http.MethodPost, "/api/roles", admin, body, &created); code != http.StatusCreated {
+		t.Fatalf("create role %s: status %d", name, code)
+	}
+	if code := call(t, e, http.MethodPut, fmt.Sprintf("/api/user/%s/roles/%d", user, created.Role.ID), admin, nil, nil); code != http.StatusOK {
+		t.Fatalf("assign role %s: status %d", name, code)
+	}
+}
+
+func TestAPIOnMemoryStore(t *testing.T) {
+	e := newTestAPI(t)
+
+	signup := credentials{User: map[string]string{
+		"username": "alice", "email": "alice@example.com", "password": "secret",
+	}}
+	if code := call(t, e, http.MethodPost, "/api/users", "", signup, nil); code != http.StatusCreated {
+		t.Fatalf("sign up: status %d", code)
+	}
+	if code := call(t, e, http.MethodPost, "/api/users", "", signup, nil); code == http.StatusCreated {
+		t.Fatal("signing up twice with the same email succeeded")
+	}
+
+	wrong := credentials{User: map[string]string{"email": "alice@example.com", "password": "nope"}}
+	if code := call(t, e, http.MethodPost, "/api/users/login", "", wrong, nil); code != http.StatusForbidden {
+		t.Errorf("login with a wrong password: status %d, want 403", code)
+	}
+
+	token := login(t, e, "alice@example.com", "secret")
+	var info struct {
+		User struct {
+			Email string `json:"email"`
+		} `json:"user"`
+	}
+	if code := call(t, e, http.MethodGet, "/api/user/info", token, nil, &info); code != http.StatusOK || info.User.Email != "alice@example.com" {
+		t.Errorf("info: status %d, email %q", code, info.User.Email)
+	}
+	if code := call(t, e, http.MethodGet, "/api/user", token, nil, nil); code != http.StatusForbidden {
+		t.Errorf("member listing users: status %d, want 403", code)
+	}
+
+	// The seeded admin may list users.
+	admin := login(t, e, "admin@gmail.com", "aaa")
+	var list struct {
+		Users []json.RawMessage `json:"users"`
+	}
+	if code := call(t, e, http.MethodGet, "/api/user", admin, nil, &list); code != http.StatusOK || len(list.Users) != 2 {
+		t.Errorf("admin listing users: status %d, %d users", code, len(list.Users))
+	}
+
+	if code := call(t, e, http.MethodPost, "/api/user/logout", token, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("logout: status %d", code)
+	}
+	if code := call(t, e, http.MethodGet, "/api/user/info", token, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("info after logout: status %d, want 401", code)
+	}
+}
+
+func TestRoleChangeRevokesTokens(t *testing.T) {
+	e := newTestAPI(t)
+
+	alice := signUp(t, e, "alice")
+	old := login(t, e, "alice@example.com", "secret")
+
+	admin := login(t, e, "admin@gmail.com", "aaa")
+	if code := call(t, e, http.MethodPut, fmt.Sprintf("/api/user/%s/roles/%d", alice, identity.Moderator), admin, nil, nil); code != http.StatusOK {
+		t.Fatalf("assign role: status %d", code)
+	}
+	if code := call(t, e, http.MethodGet, "/api/user/info", old, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("token from before the role change: status %d, want 401", code)
+	}
+	// A login within the same second as the cutoff is not caught by it.
+	fresh := login(t, e, "alice@example.com", "secret")
+	if code := call(t, e, http.MethodGet, "/api/user/info", fresh, nil, nil); code != http.StatusOK {
+		t.Errorf("token from after the role change: status %d, want 200", code)
+	}
+}
+
+func TestNoActiveSigningKey(t *testing.T) {
+	e := newTestAPI(t)
+	signUp(t, e, "alice")
+	var res struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	body := credentials{User: map[string]string{"email": "alice@example.com", "password": "secret"}}
+	if code := call(t, e, http.MethodPost, "/api/users/login", "", body, &res); code != http.StatusOK {
+		t.Fatalf("login: status %d", code)
+	}
+
+	// The only key signs from tomorrow on.
+	keys, err := identity.NewKeySet(identity.Key{
+		ID: "later", Algorithm: identity.HS256, Secret: []byte("later"), NotBefore: time.Now().Add(24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := identity.Keys
+	identity.Keys = keys
+	t.Cleanup(func() { identity.Keys = saved })
+
+	if code := call(t, e, http.MethodPost, "/api/users/login", "", body, nil); code != http.StatusInternalServerError {
+		t.Errorf("login: status %d, want 500", code)
+	}
+	refresh := map[string]string{"refresh_token": res.RefreshToken}
+	if code := call(t, e, http.MethodPost, "/api/users/token/refresh", "", refresh, nil); code != http.StatusInternalServerError {
+		t.Errorf("refresh: status %d, want 500", code)
+	}
+}
+
+func TestPasswordChangeRevokesPersonalAccessTokens(t *testing.T) {
+	e := newTestAPI(t)
+	alice := signUp(t, e, "alice")
+	token := login(t, e, "alice@example.com", "secret")
+
+	createPAT := func() string {
+		t.Helper()
+		body := map[string]interface{}{"token": map[string]interface{}{"name": "script", "scopes": []identity.Permission{identity.UsersRead}}}
+		var res struct {
+			Value string `json:"value"`
+		}
+		if code := call(t, e, http.MethodPost, "/api/user/tokens", token, body, &res); code != http.StatusCreated {
+			t.Fatalf("create token: status %d", code)
+		}
+		return res.Value
+	}
+	pat := createPAT()
+	if code := call(t, e, http.MethodGet, "/api/user/info", pat, nil, nil); code != http.StatusOK {
+		t.Fatalf("info with a personal access token: status %d", code)
+	}
+
+	change := credentials{User: map[string]string{"password": "changed"}}
+	if code := call(t, e, http.MethodPut, "/api/user/"+alice, token, change, nil); code != http.StatusOK {
+		t.Fatalf("change password: status %d", code)
+	}
+	if code := call(t, e, http.MethodGet, "/api/user/info", pat, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("personal access token from before the password change: status %d, want 401", code)
+	}
+	token = login(t, e, "alice@example.com", "changed")
+	if code := call(t, e, http.MethodGet, "/api/user/info", createPAT(), nil, nil); code != http.StatusOK {
+		t.Errorf("personal access token from after the password change: status %d, want 200", code)
+	}
+}
+
+func TestCustomRoleCannotReachStrongerUsers(t *testing.T) {
+	e := newTestAPI(t)
+	admin := login(t, e, "admin@gmail.com", "aaa")
+	var me struct {
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	call(t, e, http.MethodGet, "/api/user/info", admin, nil, &me)
+
+	// Roles that are not called Admin or Moderator but may change users
+	// and roles.
+	alice, bob, carol := signUp(t, e, "alice"), signUp(t, e, "bob"), signUp(t, e, "carol")
+	grantRole(t, e, admin, alice, "Helpdesk", identity.UsersUpdate, identity.UsersDelete, identity.MFAReset)
+	grantRole(t, e, admin, carol, "Role editor", identity.RolesWrite)
+	token := login(t, e, "alice@example.com", "secret")
+
+	update := credentials{User: map[string]string{"bio": "changed"}}
+	for _, target := range []string{me.User.ID, carol} {
+		for _, req := range []struct{ method, path string }{
+			{http.MethodPut, "/api/user/" + target},
+			{http.MethodDelete, "/api/user/" + target + "/mfa"},
+			{http.MethodDelete, "/api/user/" + target},
+		} {
+			if code := call(t, e, req.method, req.path, token, update, nil); code != http.StatusForbidden {
+				t.Errorf("%s %s: status %d, want 403", req.method, req.path, code)
+			}
+		}
+	}
+	// Users holding nothing alice lacks stay within reach.
+	if code := call(t, e, http.MethodPut, "/api/user/"+bob, token, update, nil); code != http.StatusOK {
+		t.Errorf("update a guest: status %d, want 200", code)
+	}
+	if code := call(t, e, http.MethodDelete, "/api/user/"+bob+"/mfa", token, nil, nil); code != http.StatusNoContent {
+		t.Errorf("reset a guest's second factor: status %d, want 204", code)
+	}
+}
+
+func TestServiceAccountNeedsControl(t *testing.T) {
+	e := newTestAPI(t)
+	admin := login(t, e, "admin@gmail.com", "aaa")
+
+	account := map[string]interface{}{"account": map[string]interface{}{
+		"name": "deployer", "roles": []identity.Role{identity.Admin},
+	}}
+	var created struct {
+		Account struct {
+			ID string `json:"id"`
+		} `json:"account"`
+	}
+	if code := call(t, e, http.MethodPost, "/api/service-accounts", admin, account, &created); code != http.StatusCreated {
+		t.Fatalf("create account: status %d", code)
+	}
+	path := "/api/service-accounts/" + created.Account.ID
+
+	// A user who may manage service accounts but does not own this one
+	// and lacks its permissions.
+	grantRole(t, e, admin, signUp(t, e, "alice"), "Operator",
+		identity.ServiceAccountsRead, identity.ServiceAccountsWrite, identity.ClientsWrite)
+	token := login(t, e, "alice@example.com", "secret")
+
+	// Nor link it to an OAuth client, which could then act for it.
+	client := map[string]interface{}{"client": map[string]interface{}{
+		"name": "tool", "confidential": true, "grant_types": []string{"client_credentials"},
+		"scopes": []string{"users:read"}, "service_account_id": created.Account.ID,
+	}}
+	if code := call(t, e, http.MethodPost, "/api/oauth/clients", token, client, nil); code != http.StatusForbidden {
+		t.Errorf("link the account to a client: status %d, want 403", code)
+	}
+
+	rename := map[string]interface{}{"account": map[string]interface{}{
+		"name": "mine now", "roles": []identity.Role{identity.Admin},
+	}}
+	for _, req := range []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPut, path, rename},
+		{http.MethodPost, path + "/keys", nil},
+		{http.MethodPost, path + "/keys/rotate", nil},
+		{http.MethodDelete, path, nil},
+	} {
+		if code := call(t, e, req.method, req.path, token, req.body, nil); code != http.StatusForbidden {
+			t.Errorf("%s %s by a non-owner: status %d, want 403", req.method, req.path, code)
+		}
+	}
+
+	// Not even the owner trades a scoped token for an API key.
+	pat := map[string]interface{}{"token": map[string]interface{}{
+		"name": "ci", "scopes": []identity.Permission{identity.ServiceAccountsWrite},
+	}}
+	var issued struct {
+		Value string `json:"value"`
+	}
+	if code := call(t, e, http.MethodPost, "/api/user/tokens", admin, pat, &issued); code != http.StatusCreated {
+		t.Fatalf("create token: status %d", code)
+	}
+	if code := call(t, e, http.MethodPost, path+"/keys", issued.Value, nil, nil); code != http.StatusForbidden {
+		t.Errorf("create key with a personal access token: status %d, want 403", code)
+	}
+	if code := call(t, e, http.MethodPost, path+"/keys", admin, nil, nil); code != http.StatusCreated {
+		t.Errorf("create key as the owner: status %d, want 201", code)
+	}
+}
+
+// clientToken has admin link a new service account called name, with
+// roles, to a client and returns the account's path and a
+// client_credentials token.
+func clientToken(t *testing.T, e *echo.Echo, admin, name string, roles ...identity.Role) (string, string) {
+	t.Helper()
+	account := map[string]interface{}{"account": map[string]interface{}{"name": name, "roles": roles}}
+	var created struct {
+		Account struct {
+			ID string `json:"id"`
+		} `json:"account"`
+	}
+	if code := call(t, e, http.MethodPost, "/api/service-accounts", admin, account, &created); code != http.StatusCreated {
+		t.Fatalf("create account: status %d", code)
+	}
+	client := map[string]interface{}{"client": map[string]interface{}{
+		"name": name, "confidential": true, "grant_types": []string{"client_credentials"},
+		"scopes": []string{"users:read"}, "service_account_id": created.Account.ID,
+	}}
+	var registered struct {
+		Client struct {
+			ID string `json:"client_id"`
+		} `json:"client"`
+		Secret string `json:"client_secret"`
+	}
+	if code := call(t, e, http.MethodPost, "/api/oauth/clients", admin, client, &registered); code != http.StatusCreated {
+		t.Fatalf("create client: status %d", code)
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}, "scope": {"users:read"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.SetBasicAuth(registered.Client.ID, registered.Secret)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	var issued struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &issued); err != nil || issued.AccessToken == "" {
+		t.Fatalf("client_credentials: status %d: %s", rec.Code, rec.Body)
+	}
+	return "/api/service-accounts/" + created.Account.ID, issued.AccessToken
+}
+
+func TestServiceAccountChangesEndItsTokens(t *testing.T) {
+	e := newTestAPI(t)
+	admin := login(t, e, "admin@gmail.com", "aaa")
+
+	for _, tc := range []struct {
+		name, method string
+		body         interface{}
+	}{
+		{"fewer roles", http.MethodPut, map[string]interface{}{"account": map[string]interface{}{
+			"name": "fewer roles", "roles": []identity.Role{identity.Member},
+		}}},
+		{"disabled", http.MethodPut, map[string]interface{}{"account": map[string]interface{}{
+			"name": "disabled", "roles": []identity.Role{identity.Moderator}, "disabled": true,
+		}}},
+		{"deleted", http.MethodDelete, nil},
+	} {
+		path, token := clientToken(t, e, admin, tc.name, identity.Moderator)
+		if code := call(t, e, http.MethodGet, "/api/user", token, nil, nil); code != http.StatusOK {
+			t.Fatalf("%s: list users before: status %d", tc.name, code)
+		}
+		if code := call(t, e, tc.method, path, admin, tc.body, nil); code/100 != 2 {
+			t.Fatalf("%s: change account: status %d", tc.name, code)
+		}
+		if code := call(t, e, http.MethodGet, "/api/user", token, nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("%s: list users after: status %d, want 401", tc.name, code)
+		}
+	}
+
+	// Renaming the account leaves its tokens alone.
+	path, token := clientToken(t, e, admin, "deployer", identity.Moderator)
+	rename := map[string]interface{}{"account": map[string]interface{}{
+		"name": "renamed", "roles": []identity.Role{identity.Moderator},
+	}}
+	if code := call(t, e, http.MethodPut, path, admin, rename, nil); code != http.StatusOK {
+		t.Fatalf("rename account: status %d", code)
+	}
+	if code := call(t, e, http.MethodGet, "/api/user", token, nil, nil); code != http.StatusOK {
+		t.Errorf("list users after a rename: status %d, want 200", code)
+	}
+}
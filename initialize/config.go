@@ -0,0 +1,18 @@
+package initialize
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hamed-lohi/user-manage/config"
+)
+
+// DumpConfig implements the `config` command: it prints the effective
+// configuration as YAML with secrets masked.
+func DumpConfig(cfg *config.Config) {
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(string(out))
+}
@@ -0,0 +1,66 @@
+package initialize
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hamed-lohi/user-manage/config"
+	"github.com/hamed-lohi/user-manage/entity/directory"
+	"github.com/hamed-lohi/user-manage/entity/token"
+	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/identity"
+)
+
+// newAuthenticator builds the chain login credentials are checked with:
+// the password stored with the user, then the directory of auth.ldap if
+// there is one.
+func newAuthenticator(cfg *config.LDAP, us user.Store, rm *token.Manager) (user.Authenticator, error) {
+	chain := user.Chain{user.NewPasswordAuthenticator(us)}
+	if cfg == nil {
+		return chain, nil
+	}
+
+	d := directory.Directory{
+		URL:               cfg.URL,
+		StartTLS:          cfg.StartTLS,
+		BindDN:            cfg.BindDN,
+		BindPassword:      cfg.BindPassword,
+		BaseDN:            cfg.BaseDN,
+		UserFilter:        cfg.UserFilter,
+		UsernameAttribute: cfg.UsernameAttribute,
+		EmailAttribute:    cfg.EmailAttribute,
+		BioAttribute:      cfg.BioAttribute,
+		GroupsAttribute:   cfg.GroupsAttribute,
+		GroupBaseDN:       cfg.GroupBaseDN,
+		GroupFilter:       cfg.GroupFilter,
+		GroupRoles:        make(map[string]identity.Role, len(cfg.GroupRoles)),
+		CreateUsers:       cfg.CreateUsers,
+		Timeout:           cfg.Timeout,
+	}
+	for group, role := range cfg.GroupRoles {
+		d.GroupRoles[group] = identity.Role(role)
+	}
+	if cfg.BindPasswordFile != "" {
+		data, err := ioutil.ReadFile(cfg.BindPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: %w", err)
+		}
+		d.BindPassword = string(bytes.TrimSpace(data))
+	}
+	if cfg.CAFile != "" {
+		data, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("ldap: ca_file holds no PEM certificates")
+		}
+		d.TLSConfig = &tls.Config{RootCAs: pool}
+	}
+	return append(chain, directory.NewAuthenticator(d, us, rm)), nil
+}
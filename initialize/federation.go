@@ -0,0 +1,47 @@
+package initialize
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hamed-lohi/user-manage/config"
+	"github.com/hamed-lohi/user-manage/entity/federation"
+	"github.com/hamed-lohi/user-manage/identity"
+)
+
+// newProviders builds the upstream identity providers from
+// auth.providers, reading client secret files as needed.
+func newProviders(cfg []config.Provider) ([]*federation.Provider, error) {
+	providers := make([]*federation.Provider, 0, len(cfg))
+	for _, p := range cfg {
+		provider := &federation.Provider{
+			ID:           p.ID,
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+			GroupsClaim:  p.GroupsClaim,
+			GroupRoles:   make(map[string]identity.Role, len(p.GroupRoles)),
+			CreateUsers:  p.CreateUsers,
+			TrustEmail:   p.TrustEmail,
+		}
+		if provider.Name == "" {
+			provider.Name = p.ID
+		}
+		for group, role := range p.GroupRoles {
+			provider.GroupRoles[group] = identity.Role(role)
+		}
+		if p.ClientSecretFile != "" {
+			data, err := ioutil.ReadFile(p.ClientSecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("identity provider %q: %w", p.ID, err)
+			}
+			provider.ClientSecret = string(bytes.TrimSpace(data))
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
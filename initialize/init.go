@@ -1,11 +1,34 @@
 package initialize
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hamed-lohi/user-manage/audit"
+	"github.com/hamed-lohi/user-manage/config"
 	"github.com/hamed-lohi/user-manage/db"
+	"github.com/hamed-lohi/user-manage/entity/federation"
+	"github.com/hamed-lohi/user-manage/entity/mfa"
+	"github.com/hamed-lohi/user-manage/entity/oauth"
+	"github.com/hamed-lohi/user-manage/entity/passkey"
+	"github.com/hamed-lohi/user-manage/entity/pat"
+	"github.com/hamed-lohi/user-manage/entity/role"
+	"github.com/hamed-lohi/user-manage/entity/serviceaccount"
+	"github.com/hamed-lohi/user-manage/entity/token"
 	"github.com/hamed-lohi/user-manage/entity/user"
+	"github.com/hamed-lohi/user-manage/health"
+	"github.com/hamed-lohi/user-manage/identity"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"gopkg.in/go-playground/validator.v9"
 
 	echoSwagger "github.com/swaggo/echo-swagger"
@@ -25,23 +48,72 @@ func (v *Validator) Validate(i interface{}) error {
 	return v.validator.Struct(i)
 }
 
-func NewEcho() *echo.Echo {
+// roleCacheTTL bounds how long another instance's role edits take to
+// apply here.
+const roleCacheTTL = 30 * time.Second
+
+var logLevels = map[string]log.Lvl{
+	"DEBUG": log.DEBUG,
+	"INFO":  log.INFO,
+	"WARN":  log.WARN,
+	"ERROR": log.ERROR,
+	"OFF":   log.OFF,
+}
+
+func NewEcho(cfg config.Server) *echo.Echo {
 	e := echo.New()
-	e.Logger.SetLevel(log.DEBUG)
+	e.Logger.SetLevel(logLevels[strings.ToUpper(cfg.LogLevel)])
 	e.Pre(middleware.RemoveTrailingSlash())
 	e.Use(middleware.Logger())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+		AllowOrigins: cfg.CORSOrigins,
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, identity.APIKeyHeader},
 		AllowMethods: []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
 	}))
 	e.Validator = NewValidator()
 	return e
 }
 
-func InitializeWebServer() {
+func InitializeWebServer(cfg *config.Config) {
+
+	e, dp := NewServer(cfg)
 
-	e := NewEcho()
+	go func() {
+		if err := e.Start(cfg.Server.Address); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	shutdown(e, dp, sig, cfg.Server.ShutdownTimeout)
+}
+
+// NewServer connects to the database and builds the whole API on a new
+// Echo instance without starting it. With the memory driver it needs
+// nothing else, which is how tests run the API.
+func NewServer(cfg *config.Config) (*echo.Echo, *db.DBProvider) {
+
+	e := NewEcho(cfg.Server)
+	if len(cfg.Auth.Keys) == 0 && (cfg.Auth.JWTSecret == "" || cfg.Auth.JWTSecret == config.DefaultJWTSecret) {
+		e.Logger.Warn("auth.jwt_secret is the built-in development secret; set JWT_SECRET in production")
+	}
+	keys, err := newKeySet(cfg.Auth)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	identity.Keys = keys
+	identity.TokenLifetime = cfg.Auth.TokenLifetime
+	identity.Issuer = cfg.Auth.Issuer
+	identity.Audience = cfg.Auth.Audience
+	identity.Leeway = cfg.Auth.Leeway
+	identity.DefaultPolicy.Log = func(d identity.Decision) {
+		e.Logger.Infoj(log.JSON(d.Fields()))
+	}
+	audit.Log = func(ev audit.Event) {
+		e.Logger.Infoj(log.JSON(ev.Fields()))
+	}
 
 	// // Group level middleware
 	// g := e.Group("/admin")
@@ -53,23 +125,171 @@ func InitializeWebServer() {
 	// }))
 
 	e.GET("/swagger/*", echoSwagger.WrapHandler) //
+	e.GET("/.well-known/jwks.json", identity.JWKSHandler)
+	if cfg.Auth.OIDC {
+		e.GET("/.well-known/openid-configuration", oauth.Discovery)
+	}
 
 	v1 := e.Group("/api")
 
-	dp := db.NewDBProvider()
+	dp := db.NewDBProvider(cfg.Database)
+	if err := migrateOnStart(dp, cfg.Database); err != nil {
+		e.Logger.Fatal(err)
+	}
+	us, err := user.NewStore(dp)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	ts, err := token.NewStore(dp)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	rm := token.NewManager(ts, cfg.Auth.RefreshTokenLifetime)
+	rs, err := role.NewStore(dp)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	role.Seed(dp.Context, rs)
+	resolver := role.NewResolver(rs, roleCacheTTL)
+	identity.Roles = resolver
+	ps, err := pat.NewStore(dp)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	pm := pat.NewManager(ps, userRoles(us))
+	ss, err := serviceaccount.NewStore(dp)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	sm := serviceaccount.NewManager(ss)
+	cs, err := oauth.NewStore(dp)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	fs, err := federation.NewStore(dp)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	providers, err := newProviders(cfg.Auth.Providers)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	authn, err := newAuthenticator(cfg.Auth.LDAP, us, rm)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	ms, err := mfa.NewStore(dp)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	pkm, err := newPasskeys(cfg.Auth.WebAuthn, dp, us)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	var methods []mfa.Method
+	if pkm != nil && cfg.Auth.WebAuthn.SecondFactor {
+		methods = append(methods, pkm)
+	}
+	mm := mfa.NewManager(ms, us, cfg.Auth.Issuer, methods...)
 
-	//us := store.NewUserStore(dp)
 	//h := handler.NewHandler(us)
 	//h.Register(v1)
 
-	registerHandlers(v1, dp)
-	user.Seed(dp)
-	e.Logger.Fatal(e.Start("127.0.0.1:8585"))
+	registerHandlers(v1, us, rs, resolver, rm, pm, ss, sm, cs, fs, providers, authn, mm, pkm, cfg.Auth.OIDC)
+	health.RegisterHandlers(e, healthChecks(dp)...)
+	user.Seed(dp.Context, us)
+
+	return e, dp
+}
+
+// shutdown stops accepting connections, waits for in-flight requests to
+// finish within timeout and then releases the database, again within
+// timeout.
+func shutdown(e *echo.Echo, dp *db.DBProvider, sig os.Signal, timeout time.Duration) {
+	health.MarkDraining()
+	e.Logger.Infof("received %s, draining in-flight requests (timeout %s)", sig, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := e.Shutdown(ctx); err != nil {
+		e.Logger.Errorf("drain did not finish: %v; closing remaining connections", err)
+		e.Close()
+	} else {
+		e.Logger.Info("http server stopped")
+	}
 
+	// The drain may have used up ctx; the database gets its own deadline.
+	e.Logger.Info("disconnecting database")
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), timeout)
+	defer closeCancel()
+	if err := dp.Close(closeCtx); err != nil {
+		e.Logger.Errorf("closing database: %v", err)
+		return
+	}
+	e.Logger.Info("shutdown complete")
+}
+
+// healthChecks lists the dependencies /readyz reports on.
+func healthChecks(dp *db.DBProvider) []health.Check {
+	checks := []health.Check{
+		{Name: "database", Run: dp.Ping},
+	}
+	if dp.Driver == db.Mongo {
+		checks = append(checks, health.Check{
+			Name: "migrations",
+			Run: func(ctx context.Context) error {
+				m, err := db.NewMigrator(dp)
+				if err != nil {
+					return err
+				}
+				pending, err := m.Pending(ctx)
+				if err != nil {
+					return err
+				}
+				if pending > 0 {
+					return fmt.Errorf("%d pending migration(s)", pending)
+				}
+				return nil
+			},
+		})
+	}
+	return checks
 }
 
-func registerHandlers(v1 *echo.Group, dp *db.DBProvider) {
-	user.RegisterHandlers(v1, dp)
+func registerHandlers(v1 *echo.Group, us user.Store, rs role.Store, resolver *role.Resolver, rm *token.Manager, pm *pat.Manager, ss serviceaccount.Store, sm *serviceaccount.Manager, cs oauth.Store, fs federation.Store, providers []*federation.Provider, authn user.Authenticator, mm *mfa.Manager, pkm *passkey.Manager, oidc bool) {
+	// One middleware takes JWTs, personal access tokens and API keys
+	// alike and fills in the same context values for each.
+	auth := identity.JWTWithConfig(identity.JWTConfig{
+		Keys:        identity.Keys,
+		Revocations: rm,
+		Tokens: map[string]identity.TokenAuthenticator{
+			pat.Prefix:            pm,
+			serviceaccount.Prefix: sm,
+		},
+		KeyHeader: identity.APIKeyHeader,
+	})
+	user.RegisterHandlers(v1, auth, us, rs, rm, authn, mm)
+	role.RegisterHandlers(v1, auth, rs, resolver)
+	pat.RegisterHandlers(v1, auth, pm)
+	serviceaccount.RegisterHandlers(v1, auth, ss, rs, sm, rm)
+	oauth.RegisterHandlers(v1, auth, cs, us, ss, mm, oidc)
+	federation.RegisterHandlers(v1, fs, us, rm, providers, user.ContinueLogin)
+	mfa.RegisterHandlers(v1, auth, mm, user.CompleteLogin)
+	if pkm != nil {
+		passkey.RegisterHandlers(v1, auth, pkm, mm, user.CompleteLogin)
+	}
 	// product.RegisterHandlers(v1, dp)
 
 }
+
+// userRoles looks up the current roles of a personal access token's user,
+// so a token follows role changes and dies with its user.
+func userRoles(us user.Store) pat.RoleLookup {
+	return func(ctx context.Context, id primitive.ObjectID) ([]identity.Role, bool, error) {
+		u, err := us.GetByID(ctx, id)
+		if err != nil || u == nil {
+			return nil, false, err
+		}
+		return u.Roles, true, nil
+	}
+}
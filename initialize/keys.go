@@ -0,0 +1,68 @@
+package initialize
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hamed-lohi/user-manage/config"
+	"github.com/hamed-lohi/user-manage/identity"
+)
+
+// newKeySet builds the token signing keys from auth.keys, reading secret
+// and PEM files as needed, or falls back to the single auth.jwt_secret,
+// and with auth.dev_secret to the built-in one.
+func newKeySet(cfg config.Auth) (*identity.KeySet, error) {
+	if len(cfg.Keys) == 0 {
+		if cfg.JWTSecret == "" && cfg.DevSecret {
+			cfg.JWTSecret = config.DefaultJWTSecret
+		}
+		return identity.NewSecretKeySet([]byte(cfg.JWTSecret)), nil
+	}
+
+	keys := make([]identity.Key, 0, len(cfg.Keys))
+	for _, k := range cfg.Keys {
+		key, err := signingKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", k.ID, err)
+		}
+		keys = append(keys, key)
+	}
+	ks, err := identity.NewKeySet(keys...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ks.SigningKey(); err != nil {
+		return nil, fmt.Errorf("auth.keys: %w", err)
+	}
+	return ks, nil
+}
+
+func signingKey(k config.SigningKey) (identity.Key, error) {
+	key := identity.Key{
+		ID:        k.ID,
+		Algorithm: k.Algorithm,
+		Secret:    []byte(k.Secret),
+		NotBefore: k.NotBefore,
+		RetireAt:  k.RetireAt,
+	}
+	var err error
+	switch {
+	case k.SecretFile != "":
+		var data []byte
+		if data, err = ioutil.ReadFile(k.SecretFile); err == nil {
+			key.Secret = bytes.TrimSpace(data)
+		}
+	case k.PrivateKeyFile != "":
+		var data []byte
+		if data, err = ioutil.ReadFile(k.PrivateKeyFile); err == nil {
+			key.PrivateKey, err = identity.ParsePrivateKeyPEM(k.Algorithm, data)
+		}
+	case k.PublicKeyFile != "":
+		var data []byte
+		if data, err = ioutil.ReadFile(k.PublicKeyFile); err == nil {
+			key.PublicKey, err = identity.ParsePublicKeyPEM(k.Algorithm, data)
+		}
+	}
+	return key, err
+}
@@ -0,0 +1,70 @@
+package initialize
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/hamed-lohi/user-manage/config"
+	"github.com/hamed-lohi/user-manage/db"
+)
+
+// Migrate implements the `migrate` command:
+//
+//	migrate [up]     apply every pending migration
+//	migrate down [n] revert the last n migrations (default 1)
+//	migrate status   list migrations and when they were applied
+func Migrate(cfg *config.Config, args []string) {
+	dp := db.NewDBProvider(cfg.Database)
+	defer dp.Close(context.Background())
+	m, err := db.NewMigrator(dp)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		err = m.Up(dp.Context)
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				log.Fatalf("migrate down: invalid step count %q", args[1])
+			}
+		}
+		err = m.Down(dp.Context, n)
+	case "status":
+		var status []db.MigrationStatus
+		status, err = m.Status(dp.Context)
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-19s  %s\n", s.Version, applied, s.Description)
+		}
+	default:
+		log.Fatalf("migrate: unknown command %q (want up, down or status)", cmd)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// migrateOnStart applies pending Mongo migrations before the server starts
+// unless database.migrate_on_start is off.
+func migrateOnStart(dp *db.DBProvider, cfg config.Database) error {
+	if dp.Driver != db.Mongo || !cfg.MigrateOnStart {
+		return nil
+	}
+	m, err := db.NewMigrator(dp)
+	if err != nil {
+		return err
+	}
+	return m.Up(dp.Context)
+}
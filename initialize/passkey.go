@@ -0,0 +1,29 @@
+package initialize
+
+import (
+	"github.com/hamed-lohi/user-manage/config"
+	"github.com/hamed-lohi/user-manage/db"
+	"github.com/hamed-lohi/user-manage/entity/passkey"
+	"github.com/hamed-lohi/user-manage/entity/user"
+)
+
+// newPasskeys builds the passkey manager of auth.webauthn, or returns nil
+// if passkeys are not configured.
+func newPasskeys(cfg *config.WebAuthn, dp *db.DBProvider, us user.Store) (*passkey.Manager, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	ps, err := passkey.NewStore(dp)
+	if err != nil {
+		return nil, err
+	}
+	rp := passkey.RelyingParty{
+		ID:      cfg.RPID,
+		Name:    cfg.RPName,
+		Origins: cfg.Origins,
+	}
+	if rp.Name == "" {
+		rp.Name = cfg.RPID
+	}
+	return passkey.NewManager(rp, ps, us), nil
+}
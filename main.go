@@ -1,6 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hamed-lohi/user-manage/config"
 	_ "github.com/hamed-lohi/user-manage/docs" // docs is generated by Swag CLI, you have to import it.
 	"github.com/hamed-lohi/user-manage/initialize"
 )
@@ -21,8 +27,32 @@ import (
 // @in header
 // @name Authorization
 
+// Usage: user-manage [serve|migrate|config] [flags] [args]
+//
+// Without a command the server is started. Run any command with -h to
+// list the configuration flags.
 func main() {
 
-	initialize.InitializeWebServer()
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	cfg, rest, err := config.Load(cmd, args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch cmd {
+	case "serve":
+		initialize.InitializeWebServer(cfg)
+	case "migrate":
+		initialize.Migrate(cfg, rest)
+	case "config":
+		initialize.DumpConfig(cfg)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q (want serve, migrate or config)\n", cmd)
+		os.Exit(2)
+	}
 
 }